@@ -9,12 +9,22 @@
 // Metrics are exposed publicly at /metrics, and logs are written to stderr in
 // human-readable format, and to stdout in JSON format.
 //
-// A private HTTP debug server is also started on a random port on localhost. It
-// serves the net/http/pprof endpoints, as well as /debug/logson and
-// /debug/logsoff which enable and disable debug logging, respectively.
+// A private HTTP debug server is also started, by default on a random port on
+// localhost (see [Config.DebugListen]). It serves the net/http/pprof endpoints,
+// as well as:
+//
+//   - /debug/logson and /debug/logsoff, which enable and disable debug
+//     logging, respectively;
+//   - /debug/loglevel, which reports the log level, or sets it if called with
+//     a level parameter, like /debug/loglevel?level=warn;
+//   - /debug/state, which dumps the in-memory state of each log as JSON;
+//   - /debug/pause and /debug/resume, which stop and restart the publication
+//     of new checkpoints by every log, or only by the one named by the log
+//     parameter, like /debug/pause?log=rome2025h1.
 package main
 
 import (
+	"bytes"
 	"context"
 	"crypto/ed25519"
 	"crypto/elliptic"
@@ -23,21 +33,29 @@ import (
 	"crypto/x509"
 	_ "embed"
 	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"encoding/pem"
 	"flag"
+	"fmt"
 	"io"
 	"log/slog"
 	"net"
 	"net/http"
 	_ "net/http/pprof"
+	"net/netip"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"slices"
 	"strings"
+	"syscall"
 	"text/template"
 	"time"
 
 	"filippo.io/keygen"
 	"filippo.io/sunlight/internal/ctlog"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/google/certificate-transparency-go/x509util"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/collectors"
@@ -47,7 +65,6 @@ import (
 	"golang.org/x/crypto/hkdf"
 	"golang.org/x/net/http2"
 	"golang.org/x/net/http2/h2c"
-	"golang.org/x/sync/errgroup"
 	"gopkg.in/yaml.v3"
 )
 
@@ -55,6 +72,11 @@ type Config struct {
 	// Listen is the address to listen on, e.g. ":443".
 	Listen string
 
+	// DebugListen is the address of the private debug server, either a
+	// loopback address like "localhost:6060", or "unix:" followed by the path of
+	// a Unix socket. Defaults to a random port on localhost. Optional.
+	DebugListen string
+
 	// ACME is the configuration for the ACME client. Optional. If missing,
 	// Sunlight will listen for plain HTTP or h2c.
 	ACME struct {
@@ -117,6 +139,49 @@ type Config struct {
 		Endpoint string
 	}
 
+	// Lease configures sequencing leases, which ensure only one instance of
+	// Sunlight at a time sequences each log. Without them, concurrent
+	// instances can't split a log, but they will fail each other's rounds.
+	// Optional.
+	Lease struct {
+		// Directory is a local directory where leases are held with flock(2)
+		// locks, for instances sharing a host or filesystem.
+		Directory string
+
+		// DynamoDB, if true, holds the leases in the DynamoDB table, which
+		// must be set.
+		DynamoDB bool
+
+		// Duration is the validity of a lease, renewed at every sequencing
+		// round, as a Go duration like "30s". Defaults to 30s.
+		Duration string
+	}
+
+	// AccessLog configures logging of every HTTP request. Optional.
+	AccessLog struct {
+		// Format is "json" to log to stdout in JSON, or "text" to log to
+		// stderr in text format, like the main logs. If empty, requests are
+		// not logged.
+		Format string
+
+		// SampleRate is the fraction of non-submission requests that are
+		// logged, like 0.01. Submissions are always logged. Defaults to 1.
+		SampleRate float64
+	}
+
+	// Health configures the /healthz endpoint. Optional.
+	Health struct {
+		// MaxFailures is the number of consecutive failed sequencing rounds
+		// of any log after which the server is reported unhealthy.
+		// Defaults to 5. A negative value disables the check.
+		MaxFailures int
+
+		// MaxStaleness is the maximum time since the last successful
+		// sequencing round of any log, as a Go duration like "30s".
+		// Defaults to 30s. A negative value like "-1s" disables the check.
+		MaxStaleness string
+	}
+
 	Logs []LogConfig
 }
 
@@ -141,7 +206,9 @@ type LogConfig struct {
 	// without "/ct/v1" suffix.
 	HTTPPrefix string
 
-	// Roots is the path to the accepted roots as a PEM file.
+	// Roots is the path to the accepted roots as a PEM file, or to a directory
+	// of PEM files with a .pem extension. Duplicate roots are ignored.
+	// The roots are reloaded when they change, and on SIGHUP.
 	Roots string
 
 	// Seed is the path to a file containing a secret seed from which the log's
@@ -164,15 +231,190 @@ type LogConfig struct {
 	// If provided, the loaded private Key is required to match it. Optional.
 	PublicKey string
 
-	// Cache is the path to the SQLite deduplication cache file.
+	// Cache is the path to the SQLite deduplication cache file. It also holds
+	// the leaf hash index used by get-proof-by-hash, which is rebuilt from
+	// object storage if missing.
 	Cache string
 
+	// Spool is the path to a local file where accepted submissions are
+	// recorded until they are sequenced, so that they are logged after a
+	// crash or restart. It should be on the same persistent disk as Cache.
+	// Optional.
+	Spool string
+
 	// PoolSize is the maximum number of chains pending in the sequencing pool.
 	// Since the pool is sequenced every second, it works as a qps limit. If the
 	// pool is full, add-chain requests will be rejected with a 503. Zero means
 	// no limit.
 	PoolSize int
 
+	// PoolMaxBytes is the maximum total size in bytes of the entries pending
+	// in the sequencing pool, including their encoding overhead and, for
+	// precertificates, their pre_certificate. If the pool is full, add-chain
+	// requests will be rejected with a 503. Zero means no limit.
+	PoolMaxBytes int
+
+	// PoolFlushBytes, if not zero, causes the sequencing pool to be sequenced
+	// as soon as its entries reach this total size in bytes, measured like
+	// PoolMaxBytes, without waiting for the next period.
+	PoolFlushBytes int
+
+	// PoolFlushEntries, if not zero, causes the sequencing pool to be
+	// sequenced as soon as it holds this many entries, like PoolFlushBytes.
+	PoolFlushEntries int
+
+	// RoundRetries is the number of times the entries of a sequencing round
+	// that failed before committing the tree are retried in the next rounds,
+	// before the add-chain requests waiting for them fail. Zero means no
+	// retries.
+	RoundRetries int
+
+	// EvictAfterFailures, if not zero, is the number of failed sequencing
+	// rounds caused by the same entry after which it is dropped from the pool,
+	// and its add-chain request fails, so that it doesn't block the others.
+	EvictAfterFailures int
+
+	// RejectWhilePaused causes add-chain requests to be rejected with a 503
+	// while sequencing is paused from the debug server, instead of pooling
+	// them until it's resumed.
+	RejectWhilePaused bool
+
+	// MaxGetEntries is the maximum number of entries returned by a get-entries
+	// request. Larger ranges are truncated. Defaults to 256.
+	MaxGetEntries int
+
+	// MaxBodySize, MaxChainLength, MaxCertificateSize, and MaxChainSize limit
+	// the size in bytes of add-[pre-]chain requests, the number of
+	// certificates in a submitted chain, the size in bytes of each
+	// certificate, and their total size in bytes.
+	// Default to 131072, 10, 65536, and 131072.
+	MaxBodySize        int64
+	MaxChainLength     int
+	MaxCertificateSize int
+	MaxChainSize       int
+
+	// RejectExpired causes submissions to be rejected if the leaf is expired.
+	// RejectNotYetValid causes them to be rejected if the leaf NotBefore is
+	// more than NotBeforeSkew (a duration like "24h", the default) in the
+	// future. Both default to false.
+	RejectExpired     bool
+	RejectNotYetValid bool
+	NotBeforeSkew     string
+
+	// RejectExpiredIntermediates causes submissions to be rejected if any
+	// intermediate or root in the chain is expired or not yet valid.
+	// Defaults to false, accepting them like most CT logs do.
+	RejectExpiredIntermediates bool
+
+	// RateLimit is the number of add-[pre-]chain requests per second allowed
+	// from each client IP (or IPv6 /64), with bursts of up to RateLimitBurst.
+	// Requests over the limit are rejected with 429. Defaults to no limit.
+	RateLimit      float64
+	RateLimitBurst int
+
+	// IssuerQuotas limit the rate of add-[pre-]chain requests per issuer.
+	// Each entry has a KeyHash, the hex-encoded SHA-256 hash of the issuer's
+	// SubjectPublicKeyInfo, a sustained Rate in requests per second, and a
+	// Burst size. Requests over quota are rejected with 429. Optional.
+	IssuerQuotas []struct {
+		KeyHash string
+		Rate    float64
+		Burst   int
+	}
+
+	// DefaultIssuerQuota, if Rate is not zero, is a quota shared by all
+	// issuers not listed in IssuerQuotas. Optional.
+	DefaultIssuerQuota struct {
+		Rate  float64
+		Burst int
+	}
+
+	// StrictChains disables completing submitted chains that are missing
+	// intermediates, or have the wrong ones, with intermediates from
+	// previously accepted chains. Defaults to false.
+	StrictChains bool
+
+	// PartialTileGC enables deleting partial tiles from the bucket once they
+	// are superseded by wider or full ones. If PartialTileGCDryRun is true,
+	// they are only logged. Defaults to false.
+	PartialTileGC       bool
+	PartialTileGCDryRun bool
+
+	// StagingGC enables deleting the staging bundles of each round from the
+	// bucket once its checkpoint is published, and deleting leftovers of
+	// crashed or failed rounds at startup. Otherwise, they should be expired
+	// with a lifecycle rule on the staging/ prefix.
+	StagingGC bool
+
+	// UploadConcurrency is the maximum number of concurrent tile uploads to
+	// the bucket while sequencing. Defaults to 16.
+	UploadConcurrency int
+
+	// MaxClockSkew is how far, as a duration like "2s" (the default), the
+	// clock can go backwards compared to the latest checkpoint before
+	// sequencing stops. Optional.
+	MaxClockSkew string
+
+	// SequenceTimeout is how long, as a duration like "5s" (the default), a
+	// sequencing round can take, plus SequenceTimeoutPerTile for each tile it
+	// uploads. Optional.
+	SequenceTimeout        string
+	SequenceTimeoutPerTile string
+
+	// NewerTiles is what to do at startup if the bucket has data tiles past
+	// the checkpoint in the lock backend, which means a committed checkpoint
+	// was lost, for example to a restore from backup. One of "fail",
+	// "roll-forward" (sign a checkpoint including them), or "ignore" (delete
+	// them, breaking any SCTs for their entries). Defaults to "fail".
+	NewerTiles string
+
+	// SubmissionURL is the base URL of the submission endpoints of the log,
+	// with a trailing slash, as served by the /metadata endpoint for CT log
+	// list submissions. Defaults to "https://" + Name + "/". Optional.
+	SubmissionURL string
+
+	// MonitoringURL is the base URL of the monitoring endpoints of the log,
+	// with a trailing slash, as served by the /metadata endpoint. If the
+	// tiles are served directly from the bucket, it should be its public URL.
+	// Defaults to SubmissionURL. Optional.
+	MonitoringURL string
+
+	// MMD is the Maximum Merge Delay served by the /metadata endpoint, as a
+	// duration like "24h" (the default). Optional.
+	MMD string
+
+	// CreateMissingLogConfig creates the signed log configuration object of
+	// logs created before it was introduced. Without it, such logs fail to
+	// load. Optional.
+	CreateMissingLogConfig bool
+
+	// Witnesses are sent every new checkpoint, per c2sp.org/tlog-witness,
+	// and the cosignatures they return are added to the checkpoint in the
+	// bucket. Optional.
+	Witnesses []WitnessConfig
+
+	// WitnessQuorum is the number of cosignatures to collect within
+	// WitnessTimeout, a duration like "5s" (the default). Defaults to one. If
+	// fewer are collected, the checkpoint is published anyway, unless
+	// RequireWitnessQuorum is true.
+	WitnessQuorum        int
+	WitnessTimeout       string
+	RequireWitnessQuorum bool
+
+	// DeniedIssuers is a list of hex-encoded SHA-256 hashes of the
+	// SubjectPublicKeyInfo of CA certificates. Chains that include any of them
+	// are rejected. Optional.
+	DeniedIssuers []string
+
+	// MinRSAKeySize, if not zero, is the minimum size in bits of RSA keys of
+	// submitted leaves. Optional.
+	MinRSAKeySize int
+
+	// TrustedProxies is a list of CIDR prefixes, like "10.0.0.0/8", of
+	// reverse proxies whose X-Forwarded-For header is used to determine the
+	// client IP for RateLimit. Optional.
+	TrustedProxies []string
+
 	// S3Region is the AWS region for the S3 bucket.
 	S3Region string
 
@@ -189,6 +431,91 @@ type LogConfig struct {
 	// going to be treated like a directory in many tools using S3.
 	S3KeyPrefix string
 
+	// S3ObjectLockRetention, if set, is a duration like "8760h" for which full
+	// tiles and issuers are protected with S3 Object Lock, in the mode set by
+	// S3ObjectLockMode ("GOVERNANCE", the default, or "COMPLIANCE"). The
+	// bucket must have Object Lock enabled. Optional.
+	S3ObjectLockRetention string
+	S3ObjectLockMode      string
+
+	// S3ConditionalWrites causes full tiles to be uploaded with
+	// If-None-Match: *, so that the sequencer stops if a tile was already
+	// uploaded with different contents by another instance. The S3 service
+	// must support conditional writes. Optional.
+	S3ConditionalWrites bool
+
+	// GCSBucket is the name of a Google Cloud Storage bucket where tiles,
+	// checkpoints, and issuers are stored, instead of in S3, using the GCS
+	// JSON API and Application Default Credentials. Like S3Bucket, it must be
+	// dedicated to this log. Full tiles are uploaded with a DoesNotExist
+	// precondition, like with S3ConditionalWrites. Optional.
+	GCSBucket string
+
+	// GCSKeyPrefix is a prefix on all object names written to GCSBucket.
+	// Optional.
+	GCSKeyPrefix string
+
+	// LocalDirectory is a directory where tiles, checkpoints, and issuers are
+	// stored as files, instead of in S3. It must already exist. Optional.
+	//
+	// This is meant for small and development deployments, where the
+	// directory can be served by a regular HTTP server. It can't be set at
+	// the same time as S3Bucket.
+	LocalDirectory string
+
+	// SQLiteFile is the path to a SQLite database where tiles, checkpoints,
+	// and issuers are stored, instead of in S3. It's created if it doesn't
+	// exist. Optional.
+	//
+	// This is meant for tests, demos, and small private logs, where a single
+	// file is easier to back up and move around. The latest checkpoint is
+	// still stored in the global Checkpoints, ETagS3, or DynamoDB backend. It
+	// can't be set at the same time as S3Bucket or LocalDirectory.
+	SQLiteFile string
+
+	// BackendWriteRate and BackendReadRate are the maximum requests per
+	// second made to the backend to write and to read objects, and
+	// BackendWriteConcurrency and BackendReadConcurrency the maximum number
+	// of requests in flight. Requests over these limits wait. They are meant
+	// to stay below the rates at which object storage starts throttling.
+	// Optional, unlimited if zero.
+	BackendWriteRate        float64
+	BackendWriteConcurrency int64
+	BackendReadRate         float64
+	BackendReadConcurrency  int64
+
+	// MirrorDirectory is a directory where every object is also written, as a
+	// second replica of the backend, for example as a local disk archive. It
+	// must already exist. Uploads fail unless they succeed on both. Optional.
+	MirrorDirectory string
+
+	// Compress lists the classes of objects stored zstd-compressed in the
+	// backend: "data_tile", "staging", and/or "issuer". Objects stored
+	// compressed are always read back correctly, even after their class is
+	// removed from the list. Data tiles must not be compressed if they are
+	// served to clients directly from the bucket. Optional.
+	Compress []string
+
+	// TileCacheSize is the size in bytes of an in-memory cache of full tiles
+	// fetched from the backend, such as to serve proofs and get-entries.
+	// Optional, disabled if zero.
+	TileCacheSize int64
+
+	// TileDiskCache is a directory where full tiles fetched from the backend
+	// are cached across restarts, up to TileDiskCacheSize bytes. Optional.
+	TileDiskCache     string
+	TileDiskCacheSize int64
+
+	// DisableTileVerification disables checking tiles fetched from the backend
+	// for corruption against the tiles one level up. Defaults to false.
+	DisableTileVerification bool
+
+	// UnverifiedRangeProofs computes proofs from ranges of the hash tiles,
+	// where the backend can fetch them, instead of from whole tiles verified
+	// against the tree head. A corrupted tile then causes invalid proofs to be
+	// served. Defaults to false.
+	UnverifiedRangeProofs bool
+
 	// NotAfterStart is the start of the validity range for certificates
 	// accepted by this log instance, as and RFC 3339 date.
 	NotAfterStart string
@@ -198,6 +525,16 @@ type LogConfig struct {
 	NotAfterLimit string
 }
 
+type WitnessConfig struct {
+	// VerifierKey is the cosignature/v1 verifier key of the witness, in note
+	// verifier key format, like "example.com/witness+1a2b3c4d+BL...".
+	VerifierKey string
+
+	// URL is the prefix of the witness API, to which "/add-checkpoint" is
+	// appended, like "https://example.com/witness".
+	URL string
+}
+
 type homepageLog struct {
 	// Fields from LogConfig, we don't embed the whole struct to avoid
 	// accidentally exposing sensitive fields.
@@ -241,16 +578,17 @@ func main() {
 		logLevel.Set(slog.LevelInfo)
 		w.WriteHeader(http.StatusOK)
 	})
-	go func() {
-		ln, err := net.Listen("tcp", "localhost:")
-		if err != nil {
-			logger.Error("failed to start debug server", "err", err)
-		} else {
-			logger.Info("debug server listening", "addr", ln.Addr())
-			err := http.Serve(ln, nil)
-			logger.Error("debug server exited", "err", err)
+	http.HandleFunc("/debug/loglevel", func(w http.ResponseWriter, r *http.Request) {
+		if l := r.FormValue("level"); l != "" {
+			var level slog.Level
+			if err := level.UnmarshalText([]byte(l)); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			logLevel.Set(level)
 		}
-	}()
+		fmt.Fprintln(w, logLevel.Level())
+	})
 
 	yml, err := os.ReadFile(*configFlag)
 	if err != nil {
@@ -261,6 +599,18 @@ func main() {
 		fatalError(logger, "failed to parse config file", "err", err)
 	}
 
+	// The debug server serves http.DefaultServeMux, which must never be
+	// mounted on the public listener.
+	debugLn, err := listenDebug(c.DebugListen)
+	if err != nil {
+		fatalError(logger, "failed to start debug server", "err", err)
+	}
+	go func() {
+		logger.Info("debug server listening", "addr", debugLn.Addr())
+		err := http.Serve(debugLn, nil)
+		logger.Error("debug server exited", "err", err)
+	}()
+
 	mux := http.NewServeMux()
 	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
@@ -276,10 +626,11 @@ func main() {
 	}))
 	sunlightMetrics := prometheus.WrapRegistererWithPrefix("sunlight_", metrics)
 
-	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
 	var db ctlog.LockBackend
+	var dynamoDB *ctlog.DynamoDBBackend
 	switch {
 	case c.Checkpoints != "" && c.DynamoDB.Table != "" ||
 		c.Checkpoints != "" && c.ETagS3.Bucket != "" ||
@@ -301,7 +652,7 @@ func main() {
 			fatalError(logger, "failed to create DynamoDB backend", "err", err)
 		}
 		sunlightMetrics.MustRegister(b.Metrics()...)
-		db = b
+		db, dynamoDB = b, b
 
 	case c.ETagS3.Bucket != "":
 		b, err := ctlog.NewETagBackend(ctx,
@@ -316,9 +667,54 @@ func main() {
 		fatalError(logger, "neither Checkpoints nor DynamoDB are set, one must be used")
 	}
 
-	sequencerGroup, sequencerContext := errgroup.WithContext(ctx)
+	var lease ctlog.LeaseBackend
+	switch {
+	case c.Lease.Directory != "" && c.Lease.DynamoDB:
+		fatalError(logger, "only one of Lease.Directory or Lease.DynamoDB can be set at the same time")
+	case c.Lease.Directory != "":
+		b, err := ctlog.NewFileLeaseBackend(c.Lease.Directory)
+		if err != nil {
+			fatalError(logger, "failed to create file lease backend", "err", err)
+		}
+		lease = b
+	case c.Lease.DynamoDB:
+		if dynamoDB == nil {
+			fatalError(logger, "Lease.DynamoDB requires DynamoDB to be set")
+		}
+		lease = dynamoDB
+	}
+	var leaseDuration time.Duration
+	if c.Lease.Duration != "" {
+		d, err := time.ParseDuration(c.Lease.Duration)
+		if err != nil {
+			fatalError(logger, "failed to parse Lease.Duration", "err", err)
+		}
+		leaseDuration = d
+	}
+
+	server := &ctlog.Server{Log: logger, HealthMaxFailures: c.Health.MaxFailures}
+	if c.Health.MaxStaleness != "" {
+		d, err := time.ParseDuration(c.Health.MaxStaleness)
+		if err != nil {
+			fatalError(logger, "failed to parse Health.MaxStaleness", "err", err)
+		}
+		server.HealthMaxStaleness = d
+	}
+
+	var accessLogHandler slog.Handler
+	switch c.AccessLog.Format {
+	case "":
+	case "json":
+		accessLogHandler = slog.NewJSONHandler(os.Stdout, nil)
+	case "text":
+		accessLogHandler = slog.NewTextHandler(os.Stderr, nil)
+	default:
+		fatalError(logger, "unknown AccessLog.Format", "format", c.AccessLog.Format)
+	}
 
 	var logList []homepageLog
+	debugLogs := make(map[string]*ctlog.Log)
+	var watchedRoots []*rootsWatch
 	for _, lc := range c.Logs {
 		if lc.Name == "" || lc.ShortName == "" {
 			fatalError(logger, "missing name or short name for log")
@@ -327,13 +723,86 @@ func main() {
 			slog.String("log", lc.ShortName),
 		}))
 
-		b, err := ctlog.NewS3Backend(ctx, lc.S3Region, lc.S3Bucket, lc.S3Endpoint, lc.S3KeyPrefix, logger)
+		var accessLog *slog.Logger
+		if accessLogHandler != nil {
+			accessLog = slog.New(accessLogHandler.WithAttrs([]slog.Attr{
+				slog.String("log", lc.ShortName),
+			}))
+		}
+
+		var b ctlog.Backend
+		switch {
+		case lc.LocalDirectory != "" && lc.S3Bucket != "" ||
+			lc.SQLiteFile != "" && lc.S3Bucket != "" ||
+			lc.SQLiteFile != "" && lc.LocalDirectory != "" ||
+			lc.GCSBucket != "" && (lc.S3Bucket != "" || lc.LocalDirectory != "" || lc.SQLiteFile != ""):
+			fatalError(logger, "only one of S3Bucket, GCSBucket, LocalDirectory, or SQLiteFile can be set at the same time")
+		case lc.GCSBucket != "":
+			b, err = ctlog.NewGCSBackend(ctx, lc.GCSBucket, lc.GCSKeyPrefix, logger)
+		case lc.LocalDirectory != "":
+			b, err = ctlog.NewLocalBackend(ctx, lc.LocalDirectory, logger)
+		case lc.SQLiteFile != "":
+			b, err = ctlog.NewSQLiteObjectBackend(ctx, lc.SQLiteFile, logger)
+		default:
+			var s3b *ctlog.S3Backend
+			s3b, err = ctlog.NewS3Backend(ctx, lc.S3Region, lc.S3Bucket, lc.S3Endpoint, lc.S3KeyPrefix, logger)
+			if err == nil && lc.S3ObjectLockRetention != "" {
+				s3b.ObjectLockRetention, err = time.ParseDuration(lc.S3ObjectLockRetention)
+				if err != nil {
+					fatalError(logger, "failed to parse S3ObjectLockRetention", "err", err)
+				}
+				s3b.ObjectLockMode = types.ObjectLockMode(lc.S3ObjectLockMode)
+				if s3b.ObjectLockMode != "" && !slices.Contains(s3b.ObjectLockMode.Values(), s3b.ObjectLockMode) {
+					fatalError(logger, "invalid S3ObjectLockMode", "mode", lc.S3ObjectLockMode)
+				}
+			}
+			if err == nil {
+				s3b.ConditionalWrites = lc.S3ConditionalWrites
+			}
+			b = s3b
+		}
 		if err != nil {
 			fatalError(logger, "failed to create backend", "err", err)
 		}
+		if lc.BackendWriteRate > 0 || lc.BackendWriteConcurrency > 0 ||
+			lc.BackendReadRate > 0 || lc.BackendReadConcurrency > 0 {
+			b = ctlog.NewThrottleBackend(b,
+				ctlog.ThrottleBudget{Rate: lc.BackendWriteRate, Concurrency: lc.BackendWriteConcurrency},
+				ctlog.ThrottleBudget{Rate: lc.BackendReadRate, Concurrency: lc.BackendReadConcurrency})
+		}
+		if lc.MirrorDirectory != "" {
+			mirror, err := ctlog.NewLocalBackend(ctx, lc.MirrorDirectory, logger)
+			if err != nil {
+				fatalError(logger, "failed to create mirror backend", "err", err)
+			}
+			b, err = ctlog.NewMirrorBackend(b, []ctlog.Backend{mirror}, 0, logger)
+			if err != nil {
+				fatalError(logger, "failed to create mirror backend", "err", err)
+			}
+		}
+		b, err = ctlog.NewCompressBackend(b, lc.Compress)
+		if err != nil {
+			fatalError(logger, "invalid Compress setting", "err", err)
+		}
+		b = ctlog.NewMetricsBackend(b)
+		if lc.TileDiskCache != "" {
+			if lc.TileDiskCacheSize <= 0 {
+				fatalError(logger, "TileDiskCacheSize must be set with TileDiskCache")
+			}
+			b, err = ctlog.NewDiskCacheBackend(b, lc.TileDiskCache, lc.TileDiskCacheSize, logger)
+			if err != nil {
+				fatalError(logger, "failed to create disk cache", "err", err)
+			}
+		}
+		if lc.TileCacheSize > 0 {
+			b = ctlog.NewTileCacheBackend(b, lc.TileCacheSize)
+		}
+		if !lc.DisableTileVerification {
+			b = ctlog.NewVerifyingBackend(b)
+		}
 
-		r := x509util.NewPEMCertPool()
-		if err := r.AppendCertsFromPEMFile(lc.Roots); err != nil {
+		r, err := loadRoots(lc.Roots)
+		if err != nil {
 			fatalError(logger, "failed to load roots", "err", err)
 		}
 
@@ -388,18 +857,173 @@ func main() {
 			fatalError(logger, "failed to parse NotAfterLimit", "err", err)
 		}
 
+		var notBeforeSkew time.Duration
+		if lc.NotBeforeSkew != "" {
+			notBeforeSkew, err = time.ParseDuration(lc.NotBeforeSkew)
+			if err != nil {
+				fatalError(logger, "failed to parse NotBeforeSkew", "err", err)
+			}
+		}
+
+		var maxClockSkew time.Duration
+		if lc.MaxClockSkew != "" {
+			maxClockSkew, err = time.ParseDuration(lc.MaxClockSkew)
+			if err != nil {
+				fatalError(logger, "failed to parse MaxClockSkew", "err", err)
+			}
+		}
+
+		var sequenceTimeout, sequenceTimeoutPerTile time.Duration
+		if lc.SequenceTimeout != "" {
+			sequenceTimeout, err = time.ParseDuration(lc.SequenceTimeout)
+			if err != nil {
+				fatalError(logger, "failed to parse SequenceTimeout", "err", err)
+			}
+		}
+		if lc.SequenceTimeoutPerTile != "" {
+			sequenceTimeoutPerTile, err = time.ParseDuration(lc.SequenceTimeoutPerTile)
+			if err != nil {
+				fatalError(logger, "failed to parse SequenceTimeoutPerTile", "err", err)
+			}
+		}
+
+		var mmd time.Duration
+		if lc.MMD != "" {
+			mmd, err = time.ParseDuration(lc.MMD)
+			if err != nil {
+				fatalError(logger, "failed to parse MMD", "err", err)
+			}
+		}
+
+		var witnesses []ctlog.Witness
+		for _, wc := range lc.Witnesses {
+			w, err := parseWitness(wc)
+			if err != nil {
+				fatalError(logger, "failed to parse Witnesses", "err", err)
+			}
+			witnesses = append(witnesses, w)
+		}
+		var witnessTimeout time.Duration
+		if lc.WitnessTimeout != "" {
+			witnessTimeout, err = time.ParseDuration(lc.WitnessTimeout)
+			if err != nil {
+				fatalError(logger, "failed to parse WitnessTimeout", "err", err)
+			}
+		}
+
+		var trustedProxies []netip.Prefix
+		for _, p := range lc.TrustedProxies {
+			prefix, err := netip.ParsePrefix(p)
+			if err != nil {
+				fatalError(logger, "failed to parse TrustedProxies", "err", err)
+			}
+			trustedProxies = append(trustedProxies, prefix)
+		}
+
+		issuerQuotas := make(map[[32]byte]ctlog.Quota)
+		for _, q := range lc.IssuerQuotas {
+			h, err := hex.DecodeString(q.KeyHash)
+			if err != nil || len(h) != sha256.Size {
+				fatalError(logger, "invalid IssuerQuotas key hash", "keyHash", q.KeyHash)
+			}
+			if q.Rate <= 0 {
+				fatalError(logger, "IssuerQuotas rate must be positive", "keyHash", q.KeyHash, "rate", q.Rate)
+			}
+			issuerQuotas[[32]byte(h)] = ctlog.Quota{Rate: q.Rate, Burst: q.Burst}
+		}
+		var defaultIssuerQuota *ctlog.Quota
+		if lc.DefaultIssuerQuota.Rate < 0 {
+			fatalError(logger, "DefaultIssuerQuota rate must not be negative", "rate", lc.DefaultIssuerQuota.Rate)
+		}
+		if lc.DefaultIssuerQuota.Rate > 0 {
+			defaultIssuerQuota = &ctlog.Quota{Rate: lc.DefaultIssuerQuota.Rate, Burst: lc.DefaultIssuerQuota.Burst}
+		}
+
+		var policies []ctlog.SubmissionPolicy
+		if lc.MinRSAKeySize > 0 {
+			policies = append(policies, ctlog.MinRSAKeySize(lc.MinRSAKeySize))
+		}
+		if len(lc.DeniedIssuers) > 0 {
+			denylist := make(ctlog.IssuerDenylist)
+			for _, kh := range lc.DeniedIssuers {
+				h, err := hex.DecodeString(kh)
+				if err != nil || len(h) != sha256.Size {
+					fatalError(logger, "invalid DeniedIssuers key hash", "keyHash", kh)
+				}
+				denylist[[32]byte(h)] = true
+			}
+			policies = append(policies, denylist)
+		}
+
+		var newerTiles ctlog.NewerTilesPolicy
+		switch lc.NewerTiles {
+		case "", "fail":
+			newerTiles = ctlog.NewerTilesFail
+		case "roll-forward":
+			newerTiles = ctlog.NewerTilesRollForward
+		case "ignore":
+			newerTiles = ctlog.NewerTilesIgnore
+		default:
+			fatalError(logger, "invalid NewerTiles policy", "policy", lc.NewerTiles)
+		}
+
 		cc := &ctlog.Config{
-			Name:          lc.Name,
-			Key:           k,
-			WitnessKey:    wk,
-			Cache:         lc.Cache,
-			PoolSize:      lc.PoolSize,
-			Backend:       b,
-			Lock:          db,
-			Log:           logger,
-			Roots:         r,
-			NotAfterStart: notAfterStart,
-			NotAfterLimit: notAfterLimit,
+			Name:                       lc.Name,
+			Key:                        k,
+			WitnessKey:                 wk,
+			Cache:                      lc.Cache,
+			Spool:                      lc.Spool,
+			PoolSize:                   lc.PoolSize,
+			PoolMaxBytes:               lc.PoolMaxBytes,
+			PoolFlushBytes:             lc.PoolFlushBytes,
+			PoolFlushEntries:           lc.PoolFlushEntries,
+			RoundRetries:               lc.RoundRetries,
+			EvictAfterFailures:         lc.EvictAfterFailures,
+			RejectWhilePaused:          lc.RejectWhilePaused,
+			MaxGetEntries:              lc.MaxGetEntries,
+			MaxBodySize:                lc.MaxBodySize,
+			MaxChainLength:             lc.MaxChainLength,
+			MaxCertificateSize:         lc.MaxCertificateSize,
+			MaxChainSize:               lc.MaxChainSize,
+			RejectExpiredIntermediates: lc.RejectExpiredIntermediates,
+			RejectExpired:              lc.RejectExpired,
+			RejectNotYetValid:          lc.RejectNotYetValid,
+			NotBeforeSkew:              notBeforeSkew,
+			RateLimit:                  lc.RateLimit,
+			RateLimitBurst:             lc.RateLimitBurst,
+			IssuerQuotas:               issuerQuotas,
+			DefaultIssuerQuota:         defaultIssuerQuota,
+			Policies:                   policies,
+			StrictChains:               lc.StrictChains,
+			TrustedProxies:             trustedProxies,
+			PartialTileGC:              lc.PartialTileGC,
+			PartialTileGCDryRun:        lc.PartialTileGCDryRun,
+			StagingGC:                  lc.StagingGC,
+			UploadConcurrency:          lc.UploadConcurrency,
+			MaxClockSkew:               maxClockSkew,
+			SequenceTimeout:            sequenceTimeout,
+			SequenceTimeoutPerTile:     sequenceTimeoutPerTile,
+			NewerTiles:                 newerTiles,
+			UnverifiedRangeProofs:      lc.UnverifiedRangeProofs,
+			CreateMissingLogConfig:     lc.CreateMissingLogConfig,
+			SubmissionURL:              lc.SubmissionURL,
+			MonitoringURL:              lc.MonitoringURL,
+			MMD:                        mmd,
+			Witnesses:                  witnesses,
+			WitnessQuorum:              lc.WitnessQuorum,
+			WitnessTimeout:             witnessTimeout,
+			RequireWitnessQuorum:       lc.RequireWitnessQuorum,
+			Registerer:                 prometheus.WrapRegistererWith(prometheus.Labels{"log": lc.ShortName}, sunlightMetrics),
+			AccessLog:                  accessLog,
+			AccessLogSampleRate:        c.AccessLog.SampleRate,
+			Backend:                    b,
+			Lock:                       db,
+			Lease:                      lease,
+			LeaseDuration:              leaseDuration,
+			Log:                        logger,
+			Roots:                      r,
+			NotAfterStart:              notAfterStart,
+			NotAfterLimit:              notAfterLimit,
 		}
 
 		if time.Now().Format(time.DateOnly) == lc.Inception {
@@ -415,17 +1039,12 @@ func main() {
 		if err != nil {
 			fatalError(logger, "failed to load log", "err", err)
 		}
-		defer l.CloseCache()
-
-		sequencerGroup.Go(func() error {
-			return l.RunSequencer(sequencerContext, 1*time.Second)
+		server.AddLog(lc.HTTPPrefix, l)
+		debugLogs[lc.ShortName] = l
+		watchedRoots = append(watchedRoots, &rootsWatch{
+			name: lc.ShortName, path: lc.Roots, log: l, fingerprint: rootsFingerprint(r),
 		})
 
-		mux.Handle(lc.HTTPPrefix+"/", http.StripPrefix(lc.HTTPPrefix, l.Handler()))
-
-		prometheus.WrapRegistererWith(prometheus.Labels{"log": lc.ShortName}, sunlightMetrics).
-			MustRegister(l.Metrics()...)
-
 		pkix, err := x509.MarshalPKIXPublicKey(&k.PublicKey)
 		if err != nil {
 			fatalError(logger, "failed to marshal public key for display", "err", err)
@@ -443,6 +1062,45 @@ func main() {
 		})
 	}
 
+	go watchRoots(ctx, logger, watchedRoots)
+
+	http.HandleFunc("/debug/state", func(w http.ResponseWriter, r *http.Request) {
+		state := make(map[string]ctlog.DebugState)
+		for name, l := range debugLogs {
+			state[name] = l.DebugState()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(state); err != nil {
+			logger.Debug("failed to write debug state", "err", err)
+		}
+	})
+
+	pauseHandler := func(pause bool) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			name := r.FormValue("log")
+			if _, ok := debugLogs[name]; name != "" && !ok {
+				http.Error(w, "unknown log", http.StatusNotFound)
+				return
+			}
+			for n, l := range debugLogs {
+				if name != "" && n != name {
+					continue
+				}
+				if pause {
+					l.Pause()
+				} else {
+					l.Resume()
+				}
+				fmt.Fprintf(w, "%s: paused=%v\n", n, l.Paused())
+			}
+		}
+	}
+	http.HandleFunc("/debug/pause", pauseHandler(true))
+	http.HandleFunc("/debug/resume", pauseHandler(false))
+
+	mux.Handle("/", server.Handler())
 	mux.HandleFunc("/{$}", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "text/html")
 		if err := homeTmpl.Execute(w, logList); err != nil {
@@ -498,32 +1156,145 @@ func main() {
 		s.TLSConfig = m.TLSConfig()
 	} else {
 		s.Handler = h2c.NewHandler(s.Handler, &http2.Server{})
-		s.Handler = http.MaxBytesHandler(s.Handler, 128*1024)
 	}
 
-	go func() {
-		if s.TLSConfig != nil {
-			err := s.ListenAndServeTLS("", "")
-			logger.Error("ListenAndServeTLS error", "err", err)
-		} else {
-			err := s.ListenAndServe()
-			logger.Error("ListenAndServe error", "err", err)
-		}
-		stop()
-	}()
+	if err := server.ListenAndServe(ctx, s); err != nil {
+		fatalError(logger, "server error", "err", err)
+	}
+	logger.Info("shut down cleanly")
+}
+
+// rootsPollInterval is how often watchRoots checks for changes to the roots.
+const rootsPollInterval = 30 * time.Second
 
-	sequencerGroup.Wait()
+type rootsWatch struct {
+	name        string
+	path        string
+	log         *ctlog.Log
+	fingerprint [32]byte
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	if err := s.Shutdown(ctx); err != nil {
-		logger.Error("Shutdown error", "err", err)
+// watchRoots reloads the accepted roots of each log from its Roots path on
+// SIGHUP, or when they change. A reload that fails, or that would leave a log
+// with no roots, is logged and otherwise ignored.
+func watchRoots(ctx context.Context, logger *slog.Logger, logs []*rootsWatch) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	ticker := time.NewTicker(rootsPollInterval)
+	defer ticker.Stop()
+	for {
+		var force bool
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			force = true
+		case <-ticker.C:
+		}
+		for _, w := range logs {
+			r, err := loadRoots(w.path)
+			if err != nil {
+				logger.Error("failed to reload roots", "log", w.name, "err", err)
+				continue
+			}
+			fp := rootsFingerprint(r)
+			if !force && fp == w.fingerprint {
+				continue
+			}
+			if err := w.log.SetRoots(r.RawCertificates()); err != nil {
+				logger.Error("failed to reload roots", "log", w.name, "err", err)
+				continue
+			}
+			w.fingerprint = fp
+		}
 	}
+}
 
-	os.Exit(1)
+// rootsFingerprint returns a hash of the set of certificates in r.
+func rootsFingerprint(r *x509util.PEMCertPool) [32]byte {
+	var certs [][]byte
+	for _, c := range r.RawCertificates() {
+		certs = append(certs, c.Raw)
+	}
+	slices.SortFunc(certs, bytes.Compare)
+	h := sha256.New()
+	for _, c := range certs {
+		h.Write(c)
+	}
+	return [32]byte(h.Sum(nil))
+}
+
+// loadRoots loads a PEM file, or all the .pem files in a directory.
+func loadRoots(path string) (*x509util.PEMCertPool, error) {
+	r := x509util.NewPEMCertPool()
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		if err := r.AppendCertsFromPEMFile(path); err != nil {
+			return nil, err
+		}
+		return r, nil
+	}
+	files, err := filepath.Glob(filepath.Join(path, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if err := r.AppendCertsFromPEMFile(f); err != nil {
+			return nil, fmt.Errorf("%s: %w", f, err)
+		}
+	}
+	if len(r.RawCertificates()) == 0 {
+		return nil, fmt.Errorf("no roots found in %s", path)
+	}
+	return r, nil
+}
+
+// listenDebug listens on addr, which must be a loopback address or "unix:"
+// followed by a socket path. If addr is empty, it listens on a random port on
+// localhost.
+func listenDebug(addr string) (net.Listener, error) {
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		return net.Listen("unix", path)
+	}
+	if addr == "" {
+		addr = "localhost:"
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if ip, err := netip.ParseAddr(host); host != "localhost" && (err != nil || !ip.IsLoopback()) {
+		return nil, fmt.Errorf("debug server address %q is not a loopback address", addr)
+	}
+	return net.Listen("tcp", addr)
 }
 
 func fatalError(logger *slog.Logger, msg string, args ...any) {
 	logger.Error(msg, args...)
 	os.Exit(1)
 }
+
+// parseWitness parses the note verifier key of a witness, which must be of
+// the cosignature/v1 type (0x04) per c2sp.org/tlog-cosignature.
+func parseWitness(wc WitnessConfig) (ctlog.Witness, error) {
+	name, rest, ok := strings.Cut(wc.VerifierKey, "+")
+	if !ok {
+		return ctlog.Witness{}, fmt.Errorf("malformed verifier key %q", wc.VerifierKey)
+	}
+	_, key64, ok := strings.Cut(rest, "+")
+	if !ok {
+		return ctlog.Witness{}, fmt.Errorf("malformed verifier key %q", wc.VerifierKey)
+	}
+	key, err := base64.StdEncoding.DecodeString(key64)
+	if err != nil || len(key) != 1+ed25519.PublicKeySize || key[0] != 0x04 {
+		return ctlog.Witness{}, fmt.Errorf("verifier key %q is not a cosignature/v1 key", wc.VerifierKey)
+	}
+	if wc.URL == "" {
+		return ctlog.Witness{}, fmt.Errorf("missing URL for witness %q", name)
+	}
+	return ctlog.Witness{Name: name, Key: ed25519.PublicKey(key[1:]), URL: wc.URL}, nil
+}
@@ -0,0 +1,104 @@
+package ctlog
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	mathrand "math/rand/v2"
+	"net/http"
+	"time"
+)
+
+type accessLogKey struct{}
+
+// accessLogRecord collects attributes added by handlers with addAccessLogAttrs.
+// It's only used by the goroutine serving the request.
+type accessLogRecord struct {
+	attrs []slog.Attr
+}
+
+// addAccessLogAttrs adds attributes to the access log record of the request
+// that ctx belongs to, if any.
+func addAccessLogAttrs(ctx context.Context, attrs ...slog.Attr) {
+	if rec, ok := ctx.Value(accessLogKey{}).(*accessLogRecord); ok {
+		rec.attrs = append(rec.attrs, attrs...)
+	}
+}
+
+// accessLog logs requests to endpoint to Config.AccessLog, if set.
+//
+// Submissions are always logged, other requests are sampled according to
+// Config.AccessLogSampleRate.
+func (l *Log) accessLog(endpoint string, h http.Handler) http.Handler {
+	if l.c.AccessLog == nil {
+		return h
+	}
+	rate := l.c.AccessLogSampleRate
+	if endpoint == "add-chain" || endpoint == "add-pre-chain" || rate <= 0 {
+		rate = 1
+	}
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if rate < 1 && mathrand.Float64() >= rate {
+			h.ServeHTTP(rw, r)
+			return
+		}
+
+		start := time.Now()
+		rec := &accessLogRecord{}
+		ctx := context.WithValue(r.Context(), accessLogKey{}, rec)
+		sw := &statusWriter{ResponseWriter: rw, status: http.StatusOK}
+		h.ServeHTTP(sw, r.WithContext(ctx))
+
+		attrs := []slog.Attr{
+			slog.String("request_id", newRequestID()),
+			slog.String("endpoint", endpoint),
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.RequestURI()),
+			slog.Int("status", sw.status),
+			slog.Int64("bytes", sw.bytes),
+			slog.Duration("duration", time.Since(start)),
+		}
+		if addr, err := clientIP(r, l.c.TrustedProxies); err == nil {
+			attrs = append(attrs, slog.String("client_ip", addr.String()))
+		}
+		if rate < 1 {
+			attrs = append(attrs, slog.Float64("sample_rate", rate))
+		}
+		attrs = append(attrs, rec.attrs...)
+		l.c.AccessLog.LogAttrs(ctx, slog.LevelInfo, "http request", attrs...)
+	})
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// statusWriter records the status code and size of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	bytes       int64
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.status = code
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,208 @@
+package ctlog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"filippo.io/sunlight"
+	"golang.org/x/mod/sumdb/tlog"
+	"golang.org/x/sync/errgroup"
+)
+
+// LoadLogOptions are optional settings for LoadLogWithOptions.
+type LoadLogOptions struct {
+	// FullAudit makes LoadLogWithOptions check the whole tree against the
+	// checkpoint, not just the right edge. Every entry in the data tiles must
+	// hash to its level 0 hash, and every full hash tile must hash to the
+	// corresponding hash one level up, up to the right edge.
+	//
+	// This fetches every tile of the log, tileFetchConcurrency at a time,
+	// and keeps only that many in memory. If the audit fails, LoadLogWithOptions
+	// returns an *AuditError for the first inconsistent tile.
+	FullAudit bool
+
+	// AuditFrom is the index of the entry to start the audit from, to resume
+	// an interrupted one. It is rounded down to the start of its data tile.
+	AuditFrom int64
+
+	// AuditProgress, if not nil, is called as the audit progresses with the
+	// index of the first entry not audited yet, which can be used as AuditFrom
+	// to resume the audit, and the tree size.
+	AuditProgress func(next, size int64)
+}
+
+// AuditError is returned, wrapped, by LoadLogWithOptions if a full audit finds
+// an inconsistent tile. It wraps ErrCorruptTile.
+type AuditError struct {
+	// Tile is the first inconsistent tile.
+	Tile tlog.Tile
+	// Index is the index of the first inconsistent entry, for data tiles, or
+	// the index of the first entry covered by Tile, for hash tiles.
+	Index int64
+
+	Err error
+}
+
+func (e *AuditError) Error() string {
+	return fmt.Sprintf("audit failed at entry %d, tile %s: %v", e.Index, sunlight.TilePath(e.Tile), e.Err)
+}
+
+func (e *AuditError) Unwrap() error { return e.Err }
+
+// auditTree checks every tile of tree, starting from the entry at index from.
+//
+// The hash tiles above level 0 are checked first, since they are a small
+// fraction of the total, so that progress can be reported as an entry index.
+func auditTree(ctx context.Context, config *Config, tree tlog.Tree, opts *LoadLogOptions) error {
+	from := max(opts.AuditFrom, 0)
+	if from > tree.N {
+		return fmt.Errorf("audit start %d is past the tree size %d", from, tree.N)
+	}
+	levels := 0
+	for tree.N>>(sunlight.TileHeight*(levels+1)) > 0 {
+		levels++
+	}
+	config.Log.InfoContext(ctx, "starting full tree audit", "size", tree.N, "from", from)
+	for level := levels; level >= 0; level-- {
+		if err := auditLevel(ctx, config, tree, level, from, opts.AuditProgress); err != nil {
+			return err
+		}
+	}
+	config.Log.InfoContext(ctx, "full tree audit completed", "size", tree.N, "from", from)
+	return nil
+}
+
+// auditLevel checks the tiles at level of tree covering the entries from index
+// from onwards, tileFetchConcurrency at a time.
+func auditLevel(ctx context.Context, config *Config, tree tlog.Tree, level int, from int64, progress func(next, size int64)) error {
+	// Each tile at this level covers 2^(TileHeight*(level+1)) entries.
+	shift := sunlight.TileHeight * (level + 1)
+	hashes := tree.N >> (sunlight.TileHeight * level)
+	tiles := (hashes + sunlight.TileWidth - 1) / sunlight.TileWidth
+	tileAt := func(level int, n int64, hashes int64) tlog.Tile {
+		w := min(sunlight.TileWidth, hashes-n*sunlight.TileWidth)
+		return tlog.Tile{H: sunlight.TileHeight, L: level, N: n, W: int(w)}
+	}
+
+	for start := from >> shift; start < tiles; start += tileFetchConcurrency {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		end := min(start+tileFetchConcurrency, tiles)
+
+		// Fetch the parents of the full tiles in this batch, which are at most
+		// two, since the batch is smaller than a tile.
+		parentHashes := tree.N >> (sunlight.TileHeight * (level + 1))
+		parents := make(map[int64]tileWithBytes)
+		for n := start; n < end; n++ {
+			if n*sunlight.TileWidth+sunlight.TileWidth > hashes {
+				break
+			}
+			if _, ok := parents[n/sunlight.TileWidth]; ok {
+				continue
+			}
+			parent := tileWithBytes{Tile: tileAt(level+1, n/sunlight.TileWidth, parentHashes)}
+			var err error
+			parent.B, err = config.Backend.Fetch(ctx, parent.Path())
+			if err != nil {
+				return fmt.Errorf("couldn't fetch tile %v: %w", parent.Tile, err)
+			}
+			if len(parent.B) != parent.W*tlog.HashSize {
+				return &AuditError{Tile: parent.Tile, Index: parent.N << (shift + sunlight.TileHeight),
+					Err: fmt.Errorf("tile has %d bytes: %w", len(parent.B), ErrCorruptTile)}
+			}
+			parents[parent.N] = parent
+		}
+
+		errs := make([]error, end-start)
+		g, gctx := errgroup.WithContext(ctx)
+		for n := start; n < end; n++ {
+			g.Go(func() error {
+				t := tileAt(level, n, hashes)
+				err := auditTile(gctx, config, t, parents[n/sunlight.TileWidth])
+				if auditErr := (*AuditError)(nil); errors.As(err, &auditErr) {
+					// Keep going, to report the first inconsistent tile.
+					errs[n-start] = err
+					return nil
+				}
+				return err
+			})
+		}
+		if err := g.Wait(); err != nil {
+			return err
+		}
+		for _, err := range errs {
+			if err != nil {
+				return err
+			}
+		}
+
+		if level == 0 && progress != nil {
+			progress(min(end<<sunlight.TileHeight, tree.N), tree.N)
+		}
+	}
+	return nil
+}
+
+// auditTile checks the hash tile t against its data tile, if at level 0, and
+// against the parent tile, if it's full.
+func auditTile(ctx context.Context, config *Config, t tlog.Tile, parent tileWithBytes) error {
+	first := t.N << (sunlight.TileHeight * (t.L + 1))
+	hashTile := tileWithBytes{Tile: t}
+	var err error
+	hashTile.B, err = config.Backend.Fetch(ctx, hashTile.Path())
+	if err != nil {
+		return fmt.Errorf("couldn't fetch tile %v: %w", t, err)
+	}
+	if len(hashTile.B) != t.W*tlog.HashSize {
+		return &AuditError{Tile: t, Index: first,
+			Err: fmt.Errorf("tile has %d bytes: %w", len(hashTile.B), ErrCorruptTile)}
+	}
+
+	if t.L == 0 {
+		dataTile := tileWithBytes{Tile: t}
+		dataTile.L = -1
+		dataTile.B, err = config.Backend.Fetch(ctx, dataTile.Path())
+		if err != nil {
+			return fmt.Errorf("couldn't fetch tile %v: %w", dataTile.Tile, err)
+		}
+		b := dataTile.B
+		for i := first; i < first+int64(t.W); i++ {
+			e, rest, err := sunlight.ReadTileLeaf(b)
+			if err != nil {
+				return &AuditError{Tile: dataTile.Tile, Index: i,
+					Err: fmt.Errorf("invalid entry: %w (%w)", ErrCorruptTile, err)}
+			}
+			b = rest
+			got := tlog.RecordHash(e.MerkleTreeLeaf())
+			exp, err := tlog.HashFromTile(t, hashTile.B, tlog.StoredHashIndex(0, i))
+			if err != nil {
+				return fmt.Errorf("couldn't extract hash for leaf %d: %w", i, err)
+			}
+			if got != exp {
+				return &AuditError{Tile: dataTile.Tile, Index: i,
+					Err: fmt.Errorf("entry hashes to %v, level 0 hash is %v: %w", got, exp, ErrCorruptTile)}
+			}
+		}
+		if len(b) != 0 {
+			return &AuditError{Tile: dataTile.Tile, Index: first + int64(t.W),
+				Err: fmt.Errorf("%d trailing bytes: %w", len(b), ErrCorruptTile)}
+		}
+	}
+
+	// Partial tiles are at the right edge, which LoadLog checks against the
+	// checkpoint.
+	if t.W != sunlight.TileWidth {
+		return nil
+	}
+	exp, err := tlog.HashFromTile(parent.Tile, parent.B, tlog.StoredHashIndex(parent.L*parent.H, t.N))
+	if err != nil {
+		return fmt.Errorf("couldn't extract hash for tile %v: %w", t, err)
+	}
+	if got := tileRootHash(hashTile.B); got != exp {
+		return &AuditError{Tile: t, Index: first,
+			Err: fmt.Errorf("tile hashes to %v, parent hash is %v: %w", got, exp, ErrCorruptTile)}
+	}
+	return nil
+}
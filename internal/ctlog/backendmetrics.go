@@ -0,0 +1,157 @@
+package ctlog
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// MetricsBackend is a Backend that records metrics about the calls to another
+// Backend, by method and class of key.
+type MetricsBackend struct {
+	b        Backend
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	bytes    *prometheus.CounterVec
+}
+
+func NewMetricsBackend(b Backend) *MetricsBackend {
+	return &MetricsBackend{
+		b: b,
+		requests: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "backend_requests_total",
+				Help: "Backend calls, by method, key class, and result.",
+			},
+			[]string{"method", "class", "result"},
+		),
+		duration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "backend_request_duration_seconds",
+				Help:    "Backend call latencies in seconds, by method and key class.",
+				Buckets: []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+			},
+			[]string{"method", "class"},
+		),
+		bytes: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "backend_bytes_total",
+				Help: "Bytes uploaded or fetched by successful backend calls, by method and key class.",
+			},
+			[]string{"method", "class"},
+		),
+	}
+}
+
+var _ ListDeleteBackend = &MetricsBackend{}
+var _ PingBackend = &MetricsBackend{}
+var _ RangeBackend = &MetricsBackend{}
+var _ CreateOnlyBackend = &MetricsBackend{}
+var _ BatchBackend = &MetricsBackend{}
+
+// keyClass returns a low-cardinality label for a Backend key.
+func keyClass(key string) string {
+	switch {
+	case key == "checkpoint":
+		return "checkpoint"
+	case strings.HasPrefix(key, "tile/data/"):
+		return "data_tile"
+	case strings.HasPrefix(key, "tile/"):
+		return "hash_tile"
+	case strings.HasPrefix(key, "issuer/"):
+		return "issuer"
+	case strings.HasPrefix(key, "staging/"):
+		return "staging"
+	default:
+		return "other"
+	}
+}
+
+func (m *MetricsBackend) observe(method, key string, start time.Time, size int, err error) {
+	m.count(method, key, size, err)
+	m.duration.WithLabelValues(method, keyClass(key)).Observe(time.Since(start).Seconds())
+}
+
+func (m *MetricsBackend) count(method, key string, size int, err error) {
+	class := keyClass(key)
+	result := "ok"
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		result = "not_found"
+	case err != nil:
+		result = "error"
+	default:
+		m.bytes.WithLabelValues(method, class).Add(float64(size))
+	}
+	m.requests.WithLabelValues(method, class, result).Inc()
+}
+
+func (m *MetricsBackend) Upload(ctx context.Context, key string, data []byte, opts *UploadOptions) error {
+	start := time.Now()
+	err := m.b.Upload(ctx, key, data, opts)
+	m.observe("upload", key, start, len(data), err)
+	return err
+}
+
+// BatchUpload records each object as a call by key class, and the duration of
+// the whole batch with the "batch" key class.
+func (m *MetricsBackend) BatchUpload(ctx context.Context, objects []Object) error {
+	start := time.Now()
+	err := batchUpload(ctx, m.b, objects)
+	for _, o := range objects {
+		m.count("batch_upload", o.Key, len(o.Data), err)
+	}
+	m.duration.WithLabelValues("batch_upload", "batch").Observe(time.Since(start).Seconds())
+	return err
+}
+
+func (m *MetricsBackend) Fetch(ctx context.Context, key string) ([]byte, error) {
+	start := time.Now()
+	data, err := m.b.Fetch(ctx, key)
+	m.observe("fetch", key, start, len(data), err)
+	return data, err
+}
+
+func (m *MetricsBackend) FetchRange(ctx context.Context, key string, offset, length int64) ([]byte, error) {
+	start := time.Now()
+	data, err := fetchRange(ctx, m.b, key, offset, length)
+	m.observe("fetch_range", key, start, len(data), err)
+	return data, err
+}
+
+func (m *MetricsBackend) SupportsRanges(key string) bool {
+	return supportsRanges(m.b, key)
+}
+
+func (m *MetricsBackend) List(ctx context.Context, prefix string) ([]string, error) {
+	start := time.Now()
+	keys, err := listObjects(ctx, m.b, prefix)
+	m.observe("list", prefix, start, 0, err)
+	return keys, err
+}
+
+func (m *MetricsBackend) Delete(ctx context.Context, key string) error {
+	start := time.Now()
+	err := deleteObject(ctx, m.b, key)
+	m.observe("delete", key, start, 0, err)
+	return err
+}
+
+func (m *MetricsBackend) Ping(ctx context.Context) error {
+	start := time.Now()
+	err := pingBackend(ctx, m.b)
+	m.observe("ping", "", start, 0, err)
+	return err
+}
+
+func (m *MetricsBackend) SupportsCreateOnly() bool {
+	return supportsCreateOnly(m.b)
+}
+
+func (m *MetricsBackend) Metrics() []prometheus.Collector {
+	return append([]prometheus.Collector{m.requests, m.duration, m.bytes}, m.b.Metrics()...)
+}
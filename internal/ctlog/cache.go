@@ -1,10 +1,16 @@
 package ctlog
 
 import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"fmt"
+
 	"crawshaw.io/sqlite"
 	"crawshaw.io/sqlite/sqlitex"
 	"filippo.io/sunlight"
 	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/mod/sumdb/tlog"
 )
 
 func initCache(path string) (readConn, writeConn *sqlite.Conn, err error) {
@@ -26,6 +32,30 @@ func initCache(path string) (readConn, writeConn *sqlite.Conn, err error) {
 		writeConn.Close()
 		return nil, nil, err
 	}
+	if err := sqlitex.ExecTransient(writeConn, `
+		CREATE TABLE IF NOT EXISTS leaf_hashes (
+			hash BLOB PRIMARY KEY,
+			leaf_index INTEGER
+		) WITHOUT ROWID;`, nil); err != nil {
+		writeConn.Close()
+		return nil, nil, err
+	}
+	if err := sqlitex.ExecTransient(writeConn, `
+		CREATE TABLE IF NOT EXISTS leaf_hashes_next (
+			next INTEGER NOT NULL
+		);`, nil); err != nil {
+		writeConn.Close()
+		return nil, nil, err
+	}
+	if err := sqlitex.ExecTransient(writeConn, `
+		CREATE TABLE IF NOT EXISTS tbs_links (
+			tbs_hash BLOB PRIMARY KEY,
+			precert_index INTEGER,
+			final_index INTEGER
+		) WITHOUT ROWID;`, nil); err != nil {
+		writeConn.Close()
+		return nil, nil, err
+	}
 	readConn, err = sqlite.OpenConn(path, 0)
 	if err != nil {
 		writeConn.Close()
@@ -34,7 +64,25 @@ func initCache(path string) (readConn, writeConn *sqlite.Conn, err error) {
 	return readConn, writeConn, nil
 }
 
+// CloseCache closes the cache databases and the spool of a Log whose
+// sequencer is not running, typically in tests. It does nothing after Close,
+// which already closed them.
 func (l *Log) CloseCache() error {
+	if l.closed.Load() {
+		return nil
+	}
+	return l.closeCache()
+}
+
+func (l *Log) closeCache() error {
+	if l.spool != nil {
+		if err := l.spool.close(); err != nil {
+			return err
+		}
+	}
+	if err := l.leafHashes.Close(); err != nil {
+		return err
+	}
 	if err := l.cacheRead.Close(); err != nil {
 		return err
 	}
@@ -58,6 +106,11 @@ func (l *Log) cacheGet(leaf *PendingLogEntry) (*sunlight.LogEntry, error) {
 
 func (l *Log) cachePut(entries []*sunlight.LogEntry) (err error) {
 	defer prometheus.NewTimer(l.m.CachePutDuration).ObserveDuration()
+	if testingOnlyFailCachePut != nil {
+		if err := testingOnlyFailCachePut(); err != nil {
+			return err
+		}
+	}
 	defer sqlitex.Save(l.cacheWrite)(&err)
 	for _, se := range entries {
 		h := computeCacheHash(se.Certificate, se.IsPrecert, se.IssuerKeyHash)
@@ -66,6 +119,176 @@ func (l *Log) cachePut(entries []*sunlight.LogEntry) (err error) {
 		if err != nil {
 			return err
 		}
+		// Leaves are sequenced in order, so OR IGNORE keeps the lowest index.
+		lh := tlog.RecordHash(se.MerkleTreeLeaf())
+		err = sqlitex.Exec(l.cacheWrite, "INSERT OR IGNORE INTO leaf_hashes (hash, leaf_index) VALUES (?, ?)",
+			nil, lh[:], se.LeafIndex)
+		if err != nil {
+			return err
+		}
+		// Like above, keep the lowest index of each type. The link is written
+		// in the same transaction as the dedup record of the second entry.
+		if th, ok := tbsHash(se); ok {
+			column := "final_index"
+			if se.IsPrecert {
+				column = "precert_index"
+			}
+			err = sqlitex.Exec(l.cacheWrite, "INSERT INTO tbs_links (tbs_hash, "+column+") VALUES (?, ?) "+
+				"ON CONFLICT (tbs_hash) DO UPDATE SET "+column+" = COALESCE("+column+", excluded."+column+")",
+				nil, th[:], se.LeafIndex)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	// Only move the high-water mark if it was at the start of this batch, so
+	// that the leaves of a failed cachePut are left for backfillLeafHashes.
+	first, last := entries[0].LeafIndex, entries[len(entries)-1].LeafIndex
+	return sqlitex.Exec(l.cacheWrite, "UPDATE leaf_hashes_next SET next = ? WHERE next = ?",
+		nil, last+1, first)
+}
+
+// leafHashIndex returns the lowest index of the leaf with Merkle leaf hash h,
+// or -1 if it's not in the leaf_hashes table.
+func (l *Log) leafHashIndex(ctx context.Context, h tlog.Hash) (int64, error) {
+	conn := l.leafHashes.Get(ctx)
+	if conn == nil && l.closed.Load() {
+		return 0, ErrLogClosed
+	} else if conn == nil {
+		return 0, ctx.Err()
+	}
+	defer l.leafHashes.Put(conn)
+	idx := int64(-1)
+	err := sqlitex.Exec(conn, "SELECT leaf_index FROM leaf_hashes WHERE hash = ?",
+		func(stmt *sqlite.Stmt) error {
+			idx = stmt.GetInt64("leaf_index")
+			return nil
+		}, h[:])
+	if err != nil {
+		return 0, err
+	}
+	return idx, nil
+}
+
+// tbsHash returns the SHA-256 hash of the TBSCertificate shared by a
+// precertificate and its final certificate: the logged one for a
+// precertificate, and the one without the SCT list extension for a final
+// certificate. It returns false if the certificate can't be parsed.
+func tbsHash(e *sunlight.LogEntry) ([32]byte, bool) {
+	if e.IsPrecert {
+		return sha256.Sum256(e.Certificate), true
+	}
+	cert, err := x509.ParseCertificate(e.Certificate)
+	if err != nil {
+		return [32]byte{}, false
+	}
+	tbs, err := sunlight.TBSFromFinalCertificate(cert, nil)
+	if err != nil {
+		return [32]byte{}, false
+	}
+	return sha256.Sum256(tbs), true
+}
+
+// FinalForPrecert returns the lowest indexes of the precertificate and final
+// certificate entries whose TBSCertificate (RFC 6962, Section 3.2) hashes to h,
+// or -1 if there is no such entry.
+//
+// Only entries sequenced since the log started recording links are found.
+func (l *Log) FinalForPrecert(ctx context.Context, h [32]byte) (precertIndex, finalIndex int64, err error) {
+	conn := l.leafHashes.Get(ctx)
+	if conn == nil && l.closed.Load() {
+		return 0, 0, ErrLogClosed
+	} else if conn == nil {
+		return 0, 0, ctx.Err()
+	}
+	defer l.leafHashes.Put(conn)
+	precertIndex, finalIndex = -1, -1
+	err = sqlitex.Exec(conn, "SELECT precert_index, final_index FROM tbs_links WHERE tbs_hash = ?",
+		func(stmt *sqlite.Stmt) error {
+			if stmt.ColumnType(0) != sqlite.SQLITE_NULL {
+				precertIndex = stmt.ColumnInt64(0)
+			}
+			if stmt.ColumnType(1) != sqlite.SQLITE_NULL {
+				finalIndex = stmt.ColumnInt64(1)
+			}
+			return nil
+		}, h[:])
+	if err != nil {
+		return 0, 0, err
+	}
+	return precertIndex, finalIndex, nil
+}
+
+// backfillLeafHashes adds the leaves of a tree of size n that are missing from
+// the leaf_hashes table, reading them from the data tiles in the backend. They
+// are also added to the deduplication cache, if missing, so that spooled
+// entries that were already sequenced are not replayed.
+//
+// Entries are missing if the process crashed between committing a checkpoint
+// and updating the cache, if a cachePut failed, or if the cache predates the
+// leaf_hashes table. The leaf_hashes_next table holds the index below which
+// there are none, which cachePut only moves forward in the same transaction as
+// a batch that starts at it, so a failed batch is not hidden by a later one.
+func backfillLeafHashes(ctx context.Context, config *Config, conn *sqlite.Conn, n int64) (err error) {
+	defer sqlitex.Save(conn)(&err)
+	next, ok := int64(0), false
+	if err := sqlitex.Exec(conn, "SELECT next FROM leaf_hashes_next",
+		func(stmt *sqlite.Stmt) error {
+			next, ok = stmt.ColumnInt64(0), true
+			return nil
+		}); err != nil {
+		return err
+	}
+	if !ok {
+		// The cache predates leaf_hashes_next, so start from the first hole.
+		// Leaf indexes are not indexed, but this only happens once.
+		if err := sqlitex.Exec(conn, `SELECT CASE
+			WHEN EXISTS (SELECT 1 FROM leaf_hashes WHERE leaf_index = 0)
+			THEN (SELECT MIN(leaf_index) + 1 FROM leaf_hashes
+				WHERE leaf_index + 1 NOT IN (SELECT leaf_index FROM leaf_hashes))
+			ELSE 0 END`,
+			func(stmt *sqlite.Stmt) error {
+				next = stmt.ColumnInt64(0)
+				return nil
+			}); err != nil {
+			return err
+		}
+		if err := sqlitex.Exec(conn, "INSERT INTO leaf_hashes_next (next) VALUES (?)", nil, next); err != nil {
+			return err
+		}
+	}
+	if next >= n {
+		return nil
+	}
+	config.Log.InfoContext(ctx, "indexing missing leaf hashes", "start", next, "size", n)
+
+	for next < n {
+		tile := dataTileForTree(n, next/sunlight.TileWidth)
+		b, err := config.Backend.Fetch(ctx, sunlight.TilePath(tile))
+		if err != nil {
+			return fmt.Errorf("couldn't fetch data tile %v: %w", tile, err)
+		}
+		entries, err := parseDataTile(tile, b)
+		if err != nil {
+			return err
+		}
+		start := tile.N * sunlight.TileWidth
+		for _, e := range entries[next-start:] {
+			h := tlog.RecordHash(e.MerkleTreeLeaf())
+			if err := sqlitex.Exec(conn, "INSERT OR IGNORE INTO leaf_hashes (hash, leaf_index) VALUES (?, ?)",
+				nil, h[:], e.LeafIndex); err != nil {
+				return err
+			}
+			ch := computeCacheHash(e.Certificate, e.IsPrecert, e.IssuerKeyHash)
+			if err := sqlitex.Exec(conn, "INSERT OR IGNORE INTO cache (key, timestamp, leaf_index) VALUES (?, ?, ?)",
+				nil, ch[:], e.Timestamp, e.LeafIndex); err != nil {
+				return err
+			}
+		}
+		next = start + int64(tile.W)
 	}
-	return nil
+	return sqlitex.Exec(conn, "UPDATE leaf_hashes_next SET next = ?", nil, n)
 }
@@ -0,0 +1,390 @@
+package ctlog
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"slices"
+	"strings"
+	"time"
+
+	ct "github.com/google/certificate-transparency-go"
+	"github.com/google/certificate-transparency-go/trillian/ctfe"
+	"github.com/google/certificate-transparency-go/x509"
+	"github.com/google/certificate-transparency-go/x509util"
+	lru "github.com/hashicorp/golang-lru/v2"
+	"golang.org/x/crypto/cryptobyte"
+	cryptobyte_asn1 "golang.org/x/crypto/cryptobyte/asn1"
+)
+
+// parseSubmission extracts the DER certificates from an add-[pre-]chain request
+// body, according to its Content-Type.
+//
+// application/json (or any unrecognized type, for compatibility) is the RFC
+// 6962 request. application/pem-certificate-chain is a PEM bundle, and
+// application/pkix-cert is one or more concatenated DER certificates. A
+// multipart body can contain any number of parts of the latter two types.
+//
+// The second return value is true if the certificates might not be in the
+// order required by RFC 6962, and need to be sorted with orderChain.
+func parseSubmission(contentType string, body []byte) ([][]byte, bool, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = "application/json"
+	}
+
+	switch {
+	case mediaType == "application/pem-certificate-chain":
+		chain, err := parsePEMChain(body)
+		return chain, true, err
+	case mediaType == "application/pkix-cert":
+		chain, err := parseDERChain(body)
+		return chain, true, err
+	case strings.HasPrefix(mediaType, "multipart/"):
+		var chain [][]byte
+		mr := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, false, withReason(reasonMalformed, fmtErrorf("failed to parse multipart request: %w", err))
+			}
+			b, err := io.ReadAll(part)
+			if err != nil {
+				return nil, false, withReason(reasonMalformed, fmtErrorf("failed to parse multipart request: %w", err))
+			}
+			var certs [][]byte
+			switch pt, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type")); pt {
+			case "application/pem-certificate-chain":
+				certs, err = parsePEMChain(b)
+			case "application/pkix-cert":
+				certs, err = parseDERChain(b)
+			default:
+				err = withReason(reasonMalformed, fmtErrorf("unsupported multipart part type %q", part.Header.Get("Content-Type")))
+			}
+			if err != nil {
+				return nil, false, err
+			}
+			chain = append(chain, certs...)
+		}
+		return chain, true, nil
+	default:
+		var req struct {
+			Chain [][]byte
+		}
+		if err := json.Unmarshal(body, &req); err != nil {
+			return nil, false, withReason(reasonMalformed, fmtErrorf("failed to parse request: %w", err))
+		}
+		return req.Chain, false, nil
+	}
+}
+
+func parsePEMChain(b []byte) ([][]byte, error) {
+	var chain [][]byte
+	for {
+		var block *pem.Block
+		block, b = pem.Decode(b)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			return nil, withReason(reasonMalformed, fmtErrorf("unexpected PEM block type %q", block.Type))
+		}
+		chain = append(chain, block.Bytes)
+	}
+	if len(bytes.TrimSpace(b)) != 0 {
+		return nil, withReason(reasonMalformed, fmtErrorf("trailing data after PEM certificates"))
+	}
+	return chain, nil
+}
+
+func parseDERChain(b []byte) ([][]byte, error) {
+	var chain [][]byte
+	s := cryptobyte.String(b)
+	for !s.Empty() {
+		var cert cryptobyte.String
+		if !s.ReadASN1Element(&cert, cryptobyte_asn1.SEQUENCE) {
+			return nil, withReason(reasonMalformed, fmtErrorf("malformed DER certificate"))
+		}
+		chain = append(chain, cert)
+	}
+	return chain, nil
+}
+
+// orderChain sorts certificates so that the first is the leaf and each one
+// is followed by its issuer, as required by RFC 6962.
+//
+// The leaf is the only certificate that didn't issue any of the others. Every
+// certificate must be part of the resulting chain.
+func orderChain(rawChain [][]byte) ([][]byte, error) {
+	certs := make([]*x509.Certificate, len(rawChain))
+	for i, c := range rawChain {
+		cert, err := x509.ParseCertificate(c)
+		if x509.IsFatal(err) {
+			return nil, withCertDetails(i, nil, withReason(reasonCertParse,
+				fmtErrorf("failed to parse certificate: certificate %d: %w", i, err)))
+		}
+		certs[i] = cert
+	}
+	issued := func(issuer, cert *x509.Certificate) bool {
+		return issuer != cert && bytes.Equal(issuer.RawSubject, cert.RawIssuer)
+	}
+
+	leaf := -1
+	for i, c := range certs {
+		isIssuer := false
+		for _, other := range certs {
+			if issued(c, other) {
+				isIssuer = true
+				break
+			}
+		}
+		if isIssuer {
+			continue
+		}
+		if leaf != -1 {
+			return nil, withReason(reasonChainInvalid, fmtErrorf("invalid chain: multiple leaf certificates"))
+		}
+		leaf = i
+	}
+	if leaf == -1 {
+		return nil, withReason(reasonChainInvalid, fmtErrorf("invalid chain: no leaf certificate"))
+	}
+
+	used := make([]bool, len(certs))
+	used[leaf] = true
+	ordered := [][]byte{rawChain[leaf]}
+	for current := certs[leaf]; ; {
+		next := -1
+		for i, c := range certs {
+			if !used[i] && issued(c, current) {
+				next = i
+				break
+			}
+		}
+		if next == -1 {
+			break
+		}
+		used[next] = true
+		ordered = append(ordered, rawChain[next])
+		current = certs[next]
+	}
+	if len(ordered) != len(rawChain) {
+		return nil, withReason(reasonChainInvalid, fmtErrorf("invalid chain: certificates not part of the leaf's chain"))
+	}
+	return ordered, nil
+}
+
+// verifiedChains is the number of verified chains cached by verifyChain.
+const verifiedChains = 1024
+
+// verifiedChain is a chainCache entry. It's only valid while roots is the
+// current accepted roots pool.
+type verifiedChain struct {
+	roots *x509util.PEMCertPool
+	chain []*x509.Certificate
+}
+
+func newChainCache() *lru.Cache[[32]byte, verifiedChain] {
+	c, err := lru.New[[32]byte, verifiedChain](verifiedChains)
+	if err != nil {
+		panic(err) // only returned for a non-positive size
+	}
+	return c
+}
+
+// chainFingerprint returns a hash that uniquely identifies a sequence of
+// certificates, and whether it's subject to orderChain.
+func chainFingerprint(rawChain [][]byte, needsOrder bool) [32]byte {
+	h := sha256.New()
+	if needsOrder {
+		h.Write([]byte{1})
+	} else {
+		h.Write([]byte{0})
+	}
+	for _, c := range rawChain {
+		h.Write(binary.BigEndian.AppendUint32(nil, uint32(len(c))))
+		h.Write(c)
+	}
+	return [32]byte(h.Sum(nil))
+}
+
+// verifyChain checks that rawChain is a valid chain from an acceptable leaf
+// to one of the configured roots, and returns the verified chain, which
+// always ends in a root. If needsOrder is true, rawChain is first sorted with
+// orderChain.
+//
+// Successful results are cached by chain fingerprint, so that resubmissions
+// don't repeat the signature verifications. Checks that depend on the current
+// time are applied after the cache.
+func (l *Log) verifyChain(rawChain [][]byte, needsOrder bool) ([]*x509.Certificate, error) {
+	fp := chainFingerprint(rawChain, needsOrder)
+	roots := l.roots.Load()
+	cached, ok := l.chainCache.Get(fp)
+	chain := cached.chain
+	if !ok || cached.roots != roots {
+		var err error
+		chain, err = l.verifyChainUncached(rawChain, needsOrder, roots)
+		if err != nil {
+			return nil, err
+		}
+		l.chainCache.Add(fp, verifiedChain{roots: roots, chain: chain})
+	}
+
+	now := time.UnixMilli(timeNowUnixMilli(l.c))
+	if leaf := chain[0]; l.c.RejectExpired && now.After(leaf.NotAfter) {
+		return nil, withCertDetails(0, leaf, withReason(reasonCertExpired,
+			fmtErrorf("certificate expired: NotAfter %v is before the current time %v", leaf.NotAfter, now)))
+	} else if skew := l.notBeforeSkew(); l.c.RejectNotYetValid && leaf.NotBefore.After(now.Add(skew)) {
+		return nil, withCertDetails(0, leaf, withReason(reasonCertNotYetValid,
+			fmtErrorf("certificate not yet valid: NotBefore %v is more than %v after the current time %v", leaf.NotBefore, skew, now)))
+	}
+	if l.c.RejectExpiredIntermediates {
+		for i, c := range chain[1:] {
+			if now.Before(c.NotBefore) || now.After(c.NotAfter) {
+				return nil, withCertDetails(i+1, c, withReason(reasonChainExpired,
+					fmtErrorf("invalid chain: %q is not valid at the current time", c.Subject.String())))
+			}
+		}
+	}
+	return chain, nil
+}
+
+func (l *Log) verifyChainUncached(rawChain [][]byte, needsOrder bool, roots *x509util.PEMCertPool) ([]*x509.Certificate, error) {
+	if needsOrder {
+		var err error
+		if rawChain, err = orderChain(rawChain); err != nil {
+			return nil, err
+		}
+	}
+
+	// ValidateChain parses the chain again, but doing it here first lets us
+	// tell apart the reasons for rejection.
+	var last *x509.Certificate
+	for i, c := range rawChain {
+		cert, err := x509.ParseCertificate(c)
+		if x509.IsFatal(err) {
+			return nil, withCertDetails(i, nil, withReason(reasonCertParse,
+				fmtErrorf("failed to parse certificate: certificate %d: %w", i, err)))
+		}
+		if i == 0 && (cert.NotAfter.Before(l.c.NotAfterStart) || !cert.NotAfter.Before(l.c.NotAfterLimit)) {
+			return nil, withCertDetails(0, cert, withReason(reasonCertNotAfter,
+				fmtErrorf("certificate NotAfter out of range: %v is not in [%v, %v)", cert.NotAfter, l.c.NotAfterStart, l.c.NotAfterLimit)))
+		}
+		last = cert
+	}
+
+	chain, err := l.validateChain(rawChain, roots)
+	if unknownAuthorityErr := (x509.UnknownAuthorityError{}); errors.As(err, &unknownAuthorityErr) {
+		if completed := l.completeChain(rawChain, roots); completed != nil {
+			chain, err = completed, nil
+		}
+	}
+	if unknownAuthorityErr := (x509.UnknownAuthorityError{}); errors.As(err, &unknownAuthorityErr) {
+		return nil, withCertDetails(len(rawChain)-1, last,
+			withReason(reasonRootUnknown, fmtErrorf("invalid chain: %w", err)))
+	} else if err != nil {
+		return nil, withReason(reasonChainInvalid, fmtErrorf("invalid chain: %w", err))
+	}
+
+	if err := checkIssuerSignatures(chain); err != nil {
+		return nil, withReason(reasonSignatureInvalid, fmtErrorf("invalid chain: %w", err))
+	}
+
+	for i, c := range chain {
+		if i > 0 && i < len(chain)-1 && len(c.SubjectKeyId) > 0 {
+			l.intermediates.Add(string(c.SubjectKeyId), c.Raw)
+		}
+	}
+	return chain, nil
+}
+
+func (l *Log) validateChain(rawChain [][]byte, roots *x509util.PEMCertPool) ([]*x509.Certificate, error) {
+	// ValidateChain uses Certificate.Verify with the configured roots, ignoring
+	// validity periods (which are checked above for the leaf, and optionally
+	// by verifyChain for the intermediates), name constraints, and EKU
+	// chaining, except that the leaf must have the serverAuth EKU.
+	return ctfe.ValidateChain(rawChain, ctfe.NewCertValidationOpts(roots, time.Time{}, false, false, &l.c.NotAfterStart, &l.c.NotAfterLimit, false, []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}))
+}
+
+// knownIntermediates is the number of intermediates from verified chains
+// remembered for completeChain.
+const knownIntermediates = 4096
+
+func newIntermediatesCache() *lru.Cache[string, []byte] {
+	c, err := lru.New[string, []byte](knownIntermediates)
+	if err != nil {
+		panic(err) // only returned for a non-positive size
+	}
+	return c
+}
+
+// completeChain attempts to build a valid chain for rawChain[0] using the
+// intermediates of previously verified chains, looked up by the authority key
+// identifier of the last certificate. It first tries to extend rawChain, in
+// case intermediates are missing, and then to build a chain from the leaf
+// alone, in case the submitted ones are wrong. It returns nil on failure, or if
+// Config.StrictChains is set.
+func (l *Log) completeChain(rawChain [][]byte, roots *x509util.PEMCertPool) []*x509.Certificate {
+	if l.c.StrictChains {
+		return nil
+	}
+	for _, start := range [][][]byte{rawChain, rawChain[:1]} {
+		candidate := slices.Clone(start)
+		for len(candidate) < l.maxChainLength() {
+			last, err := x509.ParseCertificate(candidate[len(candidate)-1])
+			if x509.IsFatal(err) || len(last.AuthorityKeyId) == 0 {
+				break
+			}
+			issuer, ok := l.intermediates.Get(string(last.AuthorityKeyId))
+			if !ok || slices.ContainsFunc(candidate, func(c []byte) bool { return bytes.Equal(c, issuer) }) {
+				break
+			}
+			candidate = append(candidate, issuer)
+			if chain, err := l.validateChain(candidate, roots); err == nil {
+				return chain
+			}
+		}
+	}
+	return nil
+}
+
+func (l *Log) notBeforeSkew() time.Duration {
+	if l.c.NotBeforeSkew > 0 {
+		return l.c.NotBeforeSkew
+	}
+	return 24 * time.Hour
+}
+
+// checkIssuerSignatures checks that chain[0] is signed by chain[1] and, if the
+// latter is a Precertificate Signing Certificate, that it is signed by
+// chain[2], whose key is then the one logged as the issuer key hash.
+//
+// Certificate.Verify already checked these signatures, but this ensures that
+// the logged issuer is the actual signer regardless of how the chain was
+// built, for example by completeChain.
+func checkIssuerSignatures(chain []*x509.Certificate) error {
+	if len(chain) < 2 {
+		return nil
+	}
+	if err := chain[0].CheckSignatureFrom(chain[1]); err != nil {
+		return withCertDetails(0, chain[0], fmt.Errorf("leaf is not signed by its issuer: %w", err))
+	}
+	if ct.IsPreIssuer(chain[1]) {
+		if len(chain) < 3 {
+			return withCertDetails(1, chain[1], errors.New("missing precertificate signing certificate issuer"))
+		}
+		if err := chain[1].CheckSignatureFrom(chain[2]); err != nil {
+			return withCertDetails(1, chain[1], fmt.Errorf("precertificate signing certificate is not signed by its issuer: %w", err))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package ctlog
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+)
+
+// minCompressSize is the size below which responses are not worth compressing.
+const minCompressSize = 1024
+
+// compressedTiles is the number of compressed full data tiles kept in memory.
+const compressedTiles = 256
+
+// writeCompressible writes body to rw, gzip-compressed if the client accepts
+// it and body is large enough for it to be worth it. If immutableKey is not
+// empty, the compressed body is cached under that key.
+//
+// No zstd encoding is offered, because there is no zstd implementation in the
+// standard library and gzip is universally supported by clients.
+func (l *Log) writeCompressible(rw http.ResponseWriter, r *http.Request, body []byte, immutableKey string) error {
+	rw.Header().Add("Vary", "Accept-Encoding")
+	if len(body) < minCompressSize || !acceptsGzip(r) {
+		_, err := rw.Write(body)
+		return err
+	}
+
+	var gz []byte
+	if immutableKey != "" {
+		gz, _ = l.gzipCache.Get(immutableKey)
+	}
+	if gz == nil && immutableKey != "" {
+		gz = gzipBytes(body, gzip.BestCompression)
+		l.gzipCache.Add(immutableKey, gz)
+	} else if gz == nil {
+		gz = gzipBytes(body, gzip.BestSpeed)
+	}
+
+	rw.Header().Set("Content-Encoding", "gzip")
+	rw.Header().Set("Content-Length", strconv.Itoa(len(gz)))
+	_, err := rw.Write(gz)
+	return err
+}
+
+func newGzipCache() *lru.Cache[string, []byte] {
+	c, err := lru.New[string, []byte](compressedTiles)
+	if err != nil {
+		panic(err) // only returned for a non-positive size
+	}
+	return c
+}
+
+func gzipBytes(b []byte, level int) []byte {
+	buf := &bytes.Buffer{}
+	w, err := gzip.NewWriterLevel(buf, level)
+	if err != nil {
+		panic(err) // only returned for invalid levels
+	}
+	w.Write(b) // bytes.Buffer writes don't fail
+	w.Close()
+	return buf.Bytes()
+}
+
+// acceptsGzip returns whether the Accept-Encoding header of r allows gzip.
+//
+// As in RFC 9110, Section 12.5.3, an explicit gzip coding takes precedence
+// over the "*" wildcard, whatever their order, and a q value of 0 means "not
+// acceptable".
+func acceptsGzip(r *http.Request) bool {
+	gzipQ, wildcardQ := -1.0, -1.0
+	for _, h := range r.Header.Values("Accept-Encoding") {
+		for _, coding := range strings.Split(h, ",") {
+			name, params, _ := strings.Cut(coding, ";")
+			switch strings.ToLower(strings.TrimSpace(name)) {
+			case "gzip", "x-gzip":
+				gzipQ = max(gzipQ, qValue(params))
+			case "*":
+				wildcardQ = max(wildcardQ, qValue(params))
+			}
+		}
+	}
+	if gzipQ >= 0 {
+		return gzipQ > 0
+	}
+	return wildcardQ > 0
+}
+
+// qValue returns the weight in the parameters of an Accept-Encoding coding,
+// which defaults to 1. Invalid weights are treated as 0.
+func qValue(params string) float64 {
+	for _, p := range strings.Split(params, ";") {
+		name, value, _ := strings.Cut(p, "=")
+		if !strings.EqualFold(strings.TrimSpace(name), "q") {
+			continue
+		}
+		q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || q < 0 || q > 1 {
+			return 0
+		}
+		return q
+	}
+	return 1
+}
@@ -17,23 +17,30 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log/slog"
 	"maps"
 	mathrand "math/rand/v2"
+	"net/netip"
+	"os"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"crawshaw.io/sqlite"
+	"crawshaw.io/sqlite/sqlitex"
 	"filippo.io/sunlight"
 	"filippo.io/sunlight/internal/rfc6979"
 	ct "github.com/google/certificate-transparency-go"
 	"github.com/google/certificate-transparency-go/x509util"
+	lru "github.com/hashicorp/golang-lru/v2"
 	"github.com/prometheus/client_golang/prometheus"
 	"golang.org/x/crypto/cryptobyte"
 	"golang.org/x/mod/sumdb/note"
 	"golang.org/x/mod/sumdb/tlog"
 	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/singleflight"
 )
 
 type Log struct {
@@ -41,11 +48,29 @@ type Log struct {
 	logID [sha256.Size]byte
 	m     metrics
 
-	// tree, edgeTiles, lockCheckpoint, and cacheWrite are owned by sequencePool.
+	// logConfig is the log configuration from object storage, checked
+	// against c by LoadLog.
+	logConfig logConfig
+
+	// tree, edgeTiles, lockCheckpoint, lease, and cacheWrite are owned by
+	// sequencePool.
 	tree           treeWithTimestamp
 	lockCheckpoint LockedCheckpoint
+	// lease is the sequencing lease, if Config.Lease is set.
+	lease *Lease
 	// edgeTiles is a map from level to the right-most tile of that level.
 	edgeTiles map[int]tileWithBytes
+	// dataTileBuf is the buffer backing the partial edge data tile, with spare
+	// capacity for the next rounds to append to it in place. The bytes within
+	// the length of edgeTiles[-1] are shared with readers of the state, and are
+	// never modified. Owned by sequencePool.
+	dataTileBuf []byte
+	// publishedCheckpoint is the checkpoint last known to be in object
+	// storage, and unconfirmedCheckpoint is the last one whose upload failed
+	// without a way to tell whether it was persisted, if any. See
+	// uploadCheckpoint. Both are owned by sequencePool.
+	publishedCheckpoint   []byte
+	unconfirmedCheckpoint []byte
 	// cacheWrite is used to update the deduplication cache at the end of each
 	// sequencing batch, before inSequencing and currentPool are rotated.
 	cacheWrite *sqlite.Conn
@@ -63,11 +88,118 @@ type Log struct {
 	inSequencing map[cacheHash]waitEntryFunc
 	// cacheRead is used to check the deduplication cache under poolMu.
 	cacheRead *sqlite.Conn
-
-	// issuers is a cache of issuers that have been uploaded or checked since
-	// the log started. There might be more in the backend.
+	// spool records the entries of currentPool and of the pool being
+	// sequenced, if Config.Spool is set. It's appended to and rotated under
+	// poolMu.
+	spool *spool
+	// shuttingDown is set under poolMu by Shutdown, after which
+	// addLeafToPool rejects submissions. It's also read without poolMu, to
+	// fail fast. shutdown is closed at the same time, to stop RunSequencer.
+	shuttingDown atomic.Bool
+	shutdown     chan struct{}
+	// paused is set by Pause under seqMu, and cleared by Resume. While it's
+	// set, sequence returns without sequencing the pool.
+	paused atomic.Bool
+	// flush is signaled by addLeafToPool, without blocking, when the current
+	// pool reaches Config.PoolFlushEntries or Config.PoolFlushBytes, to make
+	// RunSequencer start a round early. It's buffered, so multiple signals
+	// before the round starts coalesce into one.
+	flush chan struct{}
+	// sequencerDone is set under poolMu when RunSequencer starts, and closed
+	// when it returns. sequencerErr is the fatal error it returned, if any.
+	sequencerDone chan struct{}
+	sequencerErr  error
+
+	// seqMu serializes calls to sequence, from RunSequencer and Shutdown.
+	seqMu sync.Mutex
+
+	// closed is set by Close once the sequencer stopped, right before closing
+	// the cache databases. closeMu serializes calls to Close.
+	closeMu sync.Mutex
+	closed  atomic.Bool
+
+	// witnesses are the clients of Config.Witnesses, used by the sequencer.
+	witnesses []*witnessClient
+
+	// leafHashes is used by HTTP handlers to look up leaf indexes by Merkle
+	// leaf hash. It's only ever used to read.
+	leafHashes *sqlitex.Pool
+
+	// issuers is a cache of issuers that have been uploaded, checked, or
+	// fetched since the log started, by fingerprint. There might be more in
+	// the backend.
 	issuersMu sync.RWMutex
-	issuers   map[[32]byte]bool
+	issuers   map[[32]byte][]byte
+
+	// seqFailures is the number of consecutive failed sequencing rounds, and
+	// seqLastSuccess is the time of the last successful one since LoadLog, or
+	// zero. They are used for health checks.
+	seqFailures    atomic.Int64
+	seqLastSuccess atomic.Int64
+
+	// seqPeriod is the period passed to RunSequencer, used to suggest when to
+	// retry submissions rejected because the pool is full.
+	seqPeriod atomic.Int64
+
+	// seqPhase is the current phase of the sequencing round, as set by
+	// phaseTimer, for timeout errors. It's owned by the sequencer.
+	seqPhase string
+
+	// roots is the accepted roots pool, initially Config.Roots, which can be
+	// replaced with SetRoots.
+	roots atomic.Pointer[x509util.PEMCertPool]
+
+	// chainCache holds verified chains, by chainFingerprint.
+	chainCache *lru.Cache[[32]byte, verifiedChain]
+
+	// intermediates holds the raw intermediates of verified chains, by
+	// subject key identifier, for chain completion.
+	intermediates *lru.Cache[string, []byte]
+
+	// gzipCache holds compressed full data tiles, by path.
+	gzipCache *lru.Cache[string, []byte]
+
+	// limiter enforces Config.RateLimit, if set.
+	limiter *rateLimiter
+
+	// issuerQuotas and defaultIssuerQuota enforce Config.IssuerQuotas and
+	// Config.DefaultIssuerQuota.
+	issuerQuotas       map[[32]byte]*issuerQuota
+	defaultIssuerQuota *issuerQuota
+
+	// probe caches the result of the last backend health probe.
+	probeMu   sync.Mutex
+	probeTime time.Time
+	probeErr  error
+
+	// state is a snapshot of tree and edgeTiles, updated by sequencePool once
+	// their tiles and checkpoint are uploaded to object storage. Unlike them,
+	// it's safe to access concurrently, and it's what TreeSize, RootHash,
+	// CheckpointTime, and Checkpoint read.
+	state atomic.Pointer[logState]
+}
+
+// logState is an immutable snapshot of the sequenced tree.
+type logState struct {
+	tree treeWithTimestamp
+	// edgeTiles must not be modified, sequencePool clones it every round.
+	edgeTiles map[int]tileWithBytes
+	// checkpoint is the signed note committed to the lock backend.
+	checkpoint []byte
+	// published is checkpoint as uploaded to object storage, with any witness
+	// cosignatures, and is what is served to clients.
+	published []byte
+	// treeHeadSignature is the RFC 6962 TreeHeadSignature of tree.
+	treeHeadSignature []byte
+}
+
+func newLogState(c *Config, tree treeWithTimestamp, edgeTiles map[int]tileWithBytes, checkpoint []byte) (*logState, error) {
+	sig, err := treeHeadSignature(c, checkpoint)
+	if err != nil {
+		return nil, err
+	}
+	return &logState{tree: tree, edgeTiles: edgeTiles,
+		checkpoint: checkpoint, published: checkpoint, treeHeadSignature: sig}, nil
 }
 
 type treeWithTimestamp struct {
@@ -102,11 +234,242 @@ type Config struct {
 	Roots         *x509util.PEMCertPool
 	NotAfterStart time.Time
 	NotAfterLimit time.Time
+
+	// PoolMaxBytes, if not zero, limits the total size of the current pool,
+	// like PoolSize limits its number of entries. The size of an entry is that
+	// of both its Merkle tree leaf and data tile leaf, which include the
+	// certificate and, for precertificates, the pre_certificate. Submissions
+	// over either limit are rejected with ErrPoolFull.
+	PoolMaxBytes int
+
+	// PoolFlushBytes, if not zero, makes RunSequencer sequence the current
+	// pool early, without waiting for the next period, once its size reaches
+	// PoolFlushBytes. The size is measured like for PoolMaxBytes, which should
+	// be larger, so that submissions are rejected only if flushing doesn't
+	// keep up. Early rounds are not started while backing off after failures.
+	PoolFlushBytes int
+
+	// PoolFlushEntries, if not zero, is like PoolFlushBytes, but for the number
+	// of entries in the current pool, and should not be larger than PoolSize.
+	PoolFlushEntries int
+
+	// RoundRetries is the number of times the entries of a failed sequencing
+	// round are requeued at the front of the next pool before their wait
+	// functions return the error. Rounds are retried only if they failed
+	// before committing the tree, not fatally, and not while shutting down.
+	// If zero, the entries of failed rounds are not retried.
+	RoundRetries int
+
+	// EvictAfterFailures, if not zero, is the number of failed rounds
+	// attributable to the same entry, because it failed while being added to
+	// the tree, after which it is evicted from the pool, and its wait function
+	// returns an error. Until then, and after it's evicted, the rest of the
+	// pool is requeued like for RoundRetries, even if it ran out of retries.
+	EvictAfterFailures int
+
+	// RejectWhilePaused causes submissions to be rejected with ErrPaused while
+	// sequencing is paused with Log.Pause. Otherwise, they are pooled up to
+	// PoolSize and PoolMaxBytes, and sequenced after Log.Resume.
+	RejectWhilePaused bool
+
+	// RejectExpired causes submissions to be rejected if the leaf NotAfter is
+	// in the past. RejectNotYetValid causes them to be rejected if the leaf
+	// NotBefore is more than NotBeforeSkew in the future. NotBeforeSkew
+	// defaults to 24 hours.
+	RejectExpired     bool
+	RejectNotYetValid bool
+	NotBeforeSkew     time.Duration
+
+	// RejectExpiredIntermediates causes submissions to be rejected if any
+	// certificate in the chain other than the leaf is not currently valid.
+	RejectExpiredIntermediates bool
+
+	// Policies are applied in order to verified submissions, which are
+	// rejected if any of them returns an error.
+	Policies []SubmissionPolicy
+
+	// StrictChains disables the completion of chains with missing or wrong
+	// intermediates, using intermediates from previously accepted chains.
+	StrictChains bool
+
+	// MaxGetEntries is the maximum number of entries returned by get-entries.
+	// Zero means sunlight.TileWidth.
+	MaxGetEntries int
+
+	// MaxBodySize, MaxChainLength, MaxCertificateSize, and MaxChainSize limit
+	// the size of add-[pre-]chain requests, the number of certificates in
+	// them, the size of each certificate, and the total size of the decoded
+	// certificates. Zero means 128KiB, 10, 64KiB, and 128KiB respectively.
+	MaxBodySize        int64
+	MaxChainLength     int
+	MaxCertificateSize int
+	MaxChainSize       int
+
+	// RateLimit is the number of add-chain and add-pre-chain requests per
+	// second allowed from each client address, with bursts of up to
+	// RateLimitBurst. Zero disables rate limiting.
+	RateLimit      float64
+	RateLimitBurst int
+
+	// IssuerQuotas limit the rate of add-[pre-]chain requests for chains
+	// issued by specific CAs, keyed by the SHA-256 hash of the issuer's
+	// SubjectPublicKeyInfo. For precertificates issued by a precertificate
+	// signing certificate, that's the CA that issued the latter.
+	//
+	// DefaultIssuerQuota, if not nil, is shared by all other issuers.
+	IssuerQuotas       map[[32]byte]Quota
+	DefaultIssuerQuota *Quota
+
+	// TrustedProxies are the peer addresses allowed to set X-Forwarded-For
+	// for the purpose of rate limiting and access logging.
+	TrustedProxies []netip.Prefix
+
+	// AccessLog, if not nil, receives a record for each HTTP request. Records
+	// for submissions that were added to a pool have a "pool" attribute, which
+	// is also set on the record logged if sequencing that pool fails.
+	//
+	// AccessLogSampleRate is the fraction of non-submission requests that are
+	// logged. Zero means all of them. Submissions are always logged.
+	AccessLog           *slog.Logger
+	AccessLogSampleRate float64
+
+	// PartialTileGC causes partial tiles superseded by the edge of the tree to
+	// be deleted from the Backend after each successful sequencing round.
+	// The Backend must implement ListDeleteBackend. If PartialTileGCDryRun is
+	// set, the tiles that would be deleted are only logged.
+	PartialTileGC       bool
+	PartialTileGCDryRun bool
+
+	// StagingGC causes staging bundles to be deleted from the Backend once
+	// they are not needed for recovery: after the checkpoint of their round
+	// is published, and, by LoadLog, for leftover rounds older than the lock
+	// checkpoint, which were either applied or never committed. Otherwise,
+	// they are left for the operator to expire, for example with a bucket
+	// lifecycle rule. The Backend must implement ListDeleteBackend.
+	StagingGC bool
+
+	// Lease, if not nil, is used to ensure only one instance at a time
+	// sequences the log. LoadLog acquires the lease on behalf of LeaseHolder,
+	// and fails if it's held by another instance. The lease is renewed before
+	// publishing each checkpoint, and the sequencer stops if it was lost.
+	// LeaseHolder defaults to the hostname and process ID, and LeaseDuration
+	// to 30s. It must be longer than the sequencing period.
+	Lease         LeaseBackend
+	LeaseHolder   string
+	LeaseDuration time.Duration
+
+	// Spool, if not empty, is the path of a local file where submissions are
+	// durably recorded before being added to the pool, and until their pool
+	// is sequenced. LoadLog adds the entries left behind by a crash to the
+	// first pool, except those that were already sequenced.
+	Spool string
+
+	// Registerer, if not nil, is used by LoadLog to register the metrics of
+	// the Log, including those of the Backend, like the ones returned by
+	// Log.Metrics. It can be wrapped to add labels, like the log name.
+	Registerer prometheus.Registerer
+
+	// UploadConcurrency is the maximum number of concurrent tile uploads of
+	// a sequencing round, for Backends that don't implement BatchBackend.
+	// Defaults to 16.
+	UploadConcurrency int
+
+	// MaxClockSkew is how far the clock can go backwards, compared to the
+	// timestamp of the latest tree head, before sequencing fails. Within it,
+	// rounds are timestamped one millisecond after the latest tree head.
+	// Defaults to 2s.
+	MaxClockSkew time.Duration
+
+	// SequenceTimeout is the time a sequencing round can take, including the
+	// uploads of its tiles and checkpoint. SequenceTimeoutPerTile is added to
+	// it for each tile the round uploads, once they are known, so that large
+	// rounds get proportionally more time. SequenceTimeout defaults to 5s.
+	SequenceTimeout        time.Duration
+	SequenceTimeoutPerTile time.Duration
+
+	// Clock, if not nil, returns the current time in milliseconds since the
+	// epoch. It's used to timestamp checkpoints and SCTs, and to check the
+	// validity period of submissions. Defaults to the system clock.
+	Clock func() int64
+
+	// Witnesses are submitted every checkpoint after it's committed, and their
+	// cosignatures are appended to the checkpoint uploaded to object storage.
+	// The one in the lock backend is not cosigned.
+	//
+	// WitnessQuorum is the number of cosignatures to collect within
+	// WitnessTimeout, which default to one and five seconds. If fewer are
+	// collected, the failure is logged and counted in the metrics, and the
+	// checkpoint is published anyway, unless RequireWitnessQuorum is set, in
+	// which case the round fails, and the next one tries again with its own
+	// checkpoint, which includes the entries of the failed round.
+	Witnesses            []Witness
+	WitnessQuorum        int
+	WitnessTimeout       time.Duration
+	RequireWitnessQuorum bool
+
+	// UnverifiedRangeProofs makes proofs be computed from ranges of the hash
+	// tiles, if the Backend can read them without reading whole tiles,
+	// instead of from whole tiles verified against the tree head. It reduces
+	// the bytes fetched to serve proofs, but a corrupted hash tile causes
+	// invalid proofs to be served, instead of errors.
+	UnverifiedRangeProofs bool
+
+	// NewerTiles is what LoadLog does if it finds data tiles past the tree
+	// size of the lock checkpoint. See NewerTilesPolicy.
+	NewerTiles NewerTilesPolicy
+
+	// SubmissionURL is the base URL of the submission endpoints of the log,
+	// with a trailing slash and without the "ct/v1/" suffix, as published in
+	// CT log lists. It's only used by Log.Metadata. If empty, it defaults to
+	// "https://" + Name + "/".
+	SubmissionURL string
+
+	// MonitoringURL is the base URL of the monitoring endpoints of the log,
+	// such as checkpoint and tiles, with a trailing slash, as published in CT
+	// log lists. It's only used by Log.Metadata. If empty, it defaults to
+	// SubmissionURL, since Log.Handler serves them too.
+	MonitoringURL string
+
+	// MMD is the Maximum Merge Delay of the log, as published in CT log lists.
+	// It's only used by Log.Metadata. If zero, it defaults to 24 hours.
+	MMD time.Duration
+
+	// CreateMissingLogConfig makes LoadLog create the signed log configuration
+	// object if it's missing from object storage, for logs created before
+	// CreateLog started writing it. Otherwise, LoadLog fails without it.
+	//
+	// The log configuration records Name, NotAfterStart, NotAfterLimit, and
+	// the tile height, and LoadLog fails if they don't match it.
+	CreateMissingLogConfig bool
 }
 
 var ErrLogExists = errors.New("checkpoint already exist, refusing to initialize log")
 
+// CreateLog initializes a new log, by committing an empty tree head to the lock
+// backend and uploading it to object storage.
+//
+// It returns ErrLogExists if the lock backend already has a checkpoint for the
+// log, and fails if object storage already has a checkpoint, or if it can't
+// check. If the Backend supports UploadOptions.CreateOnly, the checkpoint and
+// the log configuration are uploaded with it, so a log created concurrently
+// is not overwritten either. An existing log configuration that matches config
+// is kept.
 func CreateLog(ctx context.Context, config *Config) error {
+	return createLog(ctx, config, false)
+}
+
+// ForceCreateLog is like CreateLog, but overwrites the checkpoint and the log
+// configuration in object storage, if any. It still returns ErrLogExists if the lock backend has a
+// checkpoint for the log.
+//
+// This destroys the public history of whatever log was in object storage, and
+// is only meant to recreate a log whose lock checkpoint was deliberately
+// deleted, such as a test log.
+func ForceCreateLog(ctx context.Context, config *Config) error {
+	return createLog(ctx, config, true)
+}
+
+func createLog(ctx context.Context, config *Config, force bool) error {
 	logID, err := logIDFromKey(config.Key)
 	if err != nil {
 		return fmt.Errorf("couldn't compute log ID: %w", err)
@@ -115,8 +478,18 @@ func CreateLog(ctx context.Context, config *Config) error {
 	if _, err := config.Lock.Fetch(ctx, logID); err == nil {
 		return ErrLogExists
 	}
-	if _, err := config.Backend.Fetch(ctx, "checkpoint"); err == nil {
-		return fmt.Errorf("checkpoint missing from database but present in object storage")
+	if !force {
+		_, err := config.Backend.Fetch(ctx, "checkpoint")
+		if err == nil {
+			return fmt.Errorf("checkpoint missing from database but present in object storage")
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("couldn't check for a checkpoint in object storage: %w", err)
+		}
+	}
+
+	if err := uploadLogConfig(ctx, config, force); err != nil {
+		return err
 	}
 
 	cacheRead, cacheWrite, err := initCache(config.Cache)
@@ -130,7 +503,7 @@ func CreateLog(ctx context.Context, config *Config) error {
 		return fmt.Errorf("couldn't close cache database: %w", err)
 	}
 
-	timestamp := timeNowUnixMilli()
+	timestamp := timeNowUnixMilli(config)
 	tree, err := hashTreeHead(0, nil, timestamp)
 	if err != nil {
 		return fmt.Errorf("couldn't compute empty tree head: %w", err)
@@ -143,7 +516,16 @@ func CreateLog(ctx context.Context, config *Config) error {
 	if err := config.Lock.Create(ctx, logID, checkpoint); err != nil {
 		return fmt.Errorf("couldn't create checkpoint in lock database: %w", err)
 	}
-	if err := config.Backend.Upload(ctx, "checkpoint", checkpoint, optsCheckpoint); err != nil {
+	opts := optsCheckpoint
+	if !force && supportsCreateOnly(config.Backend) {
+		o := *optsCheckpoint
+		o.CreateOnly = true
+		opts = &o
+	}
+	if err := config.Backend.Upload(ctx, "checkpoint", checkpoint, opts); errors.Is(err, ErrObjectExists) {
+		return fmt.Errorf("checkpoint appeared in object storage while creating the log, "+
+			"the lock database now has an empty checkpoint for it: %w", err)
+	} else if err != nil {
 		return fmt.Errorf("couldn't upload checkpoint: %w", err)
 	}
 
@@ -152,6 +534,159 @@ func CreateLog(ctx context.Context, config *Config) error {
 	return nil
 }
 
+// fetchEdgeTiles fetches the tiles on the right edge of tree, including the
+// data tile, and verifies them against the tree hash. It also returns the keys
+// it fetched, even on error.
+//
+// The hash tiles are fetched concurrently with each other and with the data
+// tile, so that startup takes a couple round-trips regardless of tree height.
+//
+// If override is not nil, it's used instead of fetching its tile, and verified
+// like the fetched tiles.
+func fetchEdgeTiles(ctx context.Context, config *Config, tree tlog.Tree, override *tileWithBytes) (edgeTiles map[int]tileWithBytes, fetched []string, err error) {
+	edgeTiles = make(map[int]tileWithBytes)
+	if tree.N == 0 {
+		return edgeTiles, nil, nil
+	}
+	var fetchedMu sync.Mutex
+	fetch := func(ctx context.Context, key string) ([]byte, error) {
+		if override != nil && key == override.Path() {
+			return override.B, nil
+		}
+		fetchedMu.Lock()
+		fetched = append(fetched, key)
+		fetchedMu.Unlock()
+		return config.Backend.Fetch(ctx, key)
+	}
+
+	// The right-most data tile has the same index and width as the level 0
+	// tile of the last leaf.
+	dataTile := tileWithBytes{Tile: tlog.TileForIndex(sunlight.TileHeight, tlog.StoredHashIndex(0, tree.N-1))}
+	dataTile.L = -1
+
+	g, gctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		// Fetch the right-most edge tiles by reading the last leaf.
+		// TileHashReader will fetch and verify the right tiles as a
+		// side-effect.
+		if _, err := tlog.TileHashReader(tree, &tileReader{
+			ctx:   gctx,
+			fetch: fetch,
+			saveTiles: func(tiles []tlog.Tile, data [][]byte) {
+				for i, tile := range tiles {
+					if t, ok := edgeTiles[tile.L]; !ok || t.N < tile.N || (t.N == tile.N && t.W < tile.W) {
+						edgeTiles[tile.L] = tileWithBytes{tile, data[i]}
+					}
+				}
+			}}).ReadHashes([]int64{tlog.StoredHashIndex(0, tree.N-1)}); err != nil {
+			return fmt.Errorf("couldn't fetch right edge tiles: %w", err)
+		}
+		return nil
+	})
+	g.Go(func() error {
+		b, err := fetch(gctx, dataTile.Path())
+		if err != nil {
+			return fmt.Errorf("couldn't fetch right edge data tile: %w", err)
+		}
+		dataTile.B = b
+		return nil
+	})
+	if err := g.Wait(); err != nil {
+		return nil, fetched, err
+	}
+	edgeTiles[-1] = dataTile
+
+	// Verify the data tile against the level 0 tile.
+	if err := verifyDataTile(dataTile, edgeTiles[0]); err != nil {
+		return nil, fetched, err
+	}
+	return edgeTiles, fetched, nil
+}
+
+// loadEdgeTiles is like fetchEdgeTiles, but if the tiles fail verification and
+// the Backend has a cache, it retries once without the cached tiles.
+func loadEdgeTiles(ctx context.Context, config *Config, tree tlog.Tree) (map[int]tileWithBytes, error) {
+	edgeTiles, fetched, err := fetchEdgeTiles(ctx, config, tree, nil)
+	if ci, ok := config.Backend.(cacheInvalidator); ok && err != nil && ctx.Err() == nil {
+		// A cached tile might be corrupted. Discard the ones that were used,
+		// and try again from the underlying backend.
+		config.Log.WarnContext(ctx, "edge tiles failed verification, retrying without cache",
+			"tiles", fetched, "err", err)
+		ci.Invalidate(fetched...)
+		edgeTiles, _, err = fetchEdgeTiles(ctx, config, tree, nil)
+	}
+	return edgeTiles, err
+}
+
+// repairEdgeTiles is like fetchEdgeTiles, but recomputes the level 0 tile on
+// the right edge from the data tile, for when it's missing or corrupted in
+// object storage. The recomputed tile is verified against the tree hash like
+// a fetched one, and then uploaded to replace the one in object storage.
+func repairEdgeTiles(ctx context.Context, config *Config, tree tlog.Tree) (map[int]tileWithBytes, error) {
+	tile := tlog.TileForIndex(sunlight.TileHeight, tlog.StoredHashIndex(0, tree.N-1))
+	dataTile := tile
+	dataTile.L = -1
+	b, err := config.Backend.Fetch(ctx, sunlight.TilePath(dataTile))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't fetch right edge data tile: %w", err)
+	}
+	hashes := make([]byte, 0, tile.W*tlog.HashSize)
+	for i := range tile.W {
+		e, rest, err := sunlight.ReadTileLeaf(b)
+		if err != nil {
+			return nil, fmt.Errorf("invalid data tile %v: %w", dataTile, err)
+		}
+		b = rest
+		if idx := tile.N*sunlight.TileWidth + int64(i); e.LeafIndex != idx {
+			return nil, fmt.Errorf("data tile %v has leaf index %d at index %d", dataTile, e.LeafIndex, idx)
+		}
+		h := tlog.RecordHash(e.MerkleTreeLeaf())
+		hashes = append(hashes, h[:]...)
+	}
+	repaired := &tileWithBytes{tile, hashes}
+
+	edgeTiles, _, err := fetchEdgeTiles(ctx, config, tree, repaired)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := optsPartialHashTile
+	if tile.W == sunlight.TileWidth {
+		opts = optsHashTile
+	}
+	if err := config.Backend.Upload(ctx, repaired.Path(), repaired.B, opts); err != nil {
+		// The tile is in the edge tiles, so it's not needed until the next
+		// round, which will upload a wider one anyway, unless it's full.
+		config.Log.WarnContext(ctx, "couldn't upload recomputed edge tile",
+			"tile", repaired.Path(), "err", err)
+	}
+	return edgeTiles, nil
+}
+
+// verifyDataTile checks that the entries in dataTile hash to the corresponding
+// hashes in hashTile, the level 0 tile with the same index and width.
+func verifyDataTile(dataTile, hashTile tileWithBytes) error {
+	b := dataTile.B
+	start := sunlight.TileWidth * dataTile.N
+	for i := start; i < start+int64(dataTile.W); i++ {
+		e, rest, err := sunlight.ReadTileLeaf(b)
+		if err != nil {
+			return fmt.Errorf("invalid data tile %v: %w", dataTile.Tile, err)
+		}
+		b = rest
+
+		got := tlog.RecordHash(e.MerkleTreeLeaf())
+		exp, err := tlog.HashFromTile(hashTile.Tile, hashTile.B, tlog.StoredHashIndex(0, i))
+		if err != nil {
+			return fmt.Errorf("couldn't extract hash for leaf %d: %w", i, err)
+		}
+		if got != exp {
+			return fmt.Errorf("tile leaf entry %d hashes to %v, level 0 hash is %v", i, got, exp)
+		}
+	}
+	return nil
+}
+
 func logIDFromKey(key *ecdsa.PrivateKey) ([sha256.Size]byte, error) {
 	pkix, err := x509.MarshalPKIXPublicKey(key.Public())
 	if err != nil {
@@ -169,16 +704,51 @@ func hashTreeHead(n int64, r tlog.HashReader, t int64) (treeWithTimestamp, error
 }
 
 func LoadLog(ctx context.Context, config *Config) (*Log, error) {
+	return LoadLogWithOptions(ctx, config, nil)
+}
+
+// LoadLogWithOptions is like LoadLog, with optional settings. opts may be nil.
+func LoadLogWithOptions(ctx context.Context, config *Config, opts *LoadLogOptions) (l *Log, err error) {
+	if _, ok := config.Backend.(ListDeleteBackend); config.PartialTileGC && !ok {
+		return nil, errors.New("PartialTileGC requires a Backend that implements ListDeleteBackend")
+	}
+	for h, q := range config.IssuerQuotas {
+		if !(q.Rate > 0) {
+			return nil, fmt.Errorf("IssuerQuotas rate for %x is %v, must be positive", h, q.Rate)
+		}
+	}
+	if q := config.DefaultIssuerQuota; q != nil && !(q.Rate > 0) {
+		return nil, fmt.Errorf("DefaultIssuerQuota rate is %v, must be positive", q.Rate)
+	}
+
 	logID, err := logIDFromKey(config.Key)
 	if err != nil {
 		return nil, fmt.Errorf("couldn't compute log ID: %w", err)
 	}
 
+	// Acquire the lease before anything else, so that another instance can't
+	// be loading or sequencing the log concurrently.
+	var lease *Lease
+	if config.Lease != nil {
+		lease, err = config.Lease.Acquire(ctx, logID, leaseHolder(config), leaseDuration(config))
+		if err != nil {
+			return nil, fmt.Errorf("couldn't acquire sequencing lease: %w", err)
+		}
+		config.Log.InfoContext(ctx, "acquired sequencing lease",
+			"holder", lease.Holder, "token", lease.Token, "expiry", lease.Expiry)
+		defer func() {
+			if err != nil {
+				config.Lease.Release(context.WithoutCancel(ctx), lease)
+			}
+		}()
+	}
+
 	// Load the checkpoint from the lock database. If we crashed during
 	// serialization, the one in the lock database is going to be the latest.
 	lock, err := config.Lock.Fetch(ctx, logID)
 	if err != nil {
-		return nil, fmt.Errorf("couldn't fetch checkpoint from lock database: %w", err)
+		return nil, fmt.Errorf("couldn't fetch checkpoint for log ID %s from lock database: %w",
+			base64.StdEncoding.EncodeToString(logID[:]), err)
 	}
 	config.Log.DebugContext(ctx, "loaded checkpoint", "checkpoint", lock.Bytes())
 	c, timestamp, err := openCheckpoint(config, lock.Bytes())
@@ -197,11 +767,20 @@ func LoadLog(ctx context.Context, config *Config) (*Log, error) {
 	if err != nil {
 		return nil, fmt.Errorf("couldn't open checkpoint from object storage: %w", err)
 	}
+	logConfig, err := checkLogConfig(ctx, config)
+	if err != nil {
+		return nil, err
+	}
 	switch {
 	case c1.N == c.N && c1.Hash != c.Hash:
 		return nil, fmt.Errorf("checkpoint hash mismatch: %x != %x", c1.Hash, c.Hash)
-	case c1.N > c.N:
+	case c1.N > c.N && config.NewerTiles == NewerTilesFail:
 		return nil, fmt.Errorf("checkpoint in object storage is newer than lock checkpoint: %d > %d", c1.N, c.N)
+	case c1.N > c.N:
+		// A committed checkpoint was lost, and the tiles past it are
+		// reconciled below according to config.NewerTiles.
+		config.Log.WarnContext(ctx, "checkpoint in object storage is newer than lock checkpoint",
+			"size", c.N, "published_size", c1.N, "policy", config.NewerTiles)
 	case c1.N < c.N:
 		// It's possible that we crashed between committing a new checkpoint to
 		// the lock backend and uploading it to the object storage backend.
@@ -212,125 +791,266 @@ func LoadLog(ctx context.Context, config *Config) (*Log, error) {
 		if err != nil {
 			return nil, fmt.Errorf("couldn't fetch staged uploads: %w", err)
 		}
-		if err := applyStagedUploads(ctx, config, stagedUploads); err != nil {
+		if err := applyStagedUploads(ctx, config, stagedUploads, nil); err != nil {
 			return nil, fmt.Errorf("couldn't apply staged uploads: %w", err)
 		}
 	}
 
+	if config.StagingGC {
+		// Failures are not fatal, since the bundles are only garbage.
+		if err := gcStagingBundles(ctx, config, c.Tree); err != nil {
+			config.Log.WarnContext(ctx, "staging bundle garbage collection failed", "err", err)
+		}
+	}
+
 	cacheRead, cacheWrite, err := initCache(config.Cache)
 	if err != nil {
 		return nil, fmt.Errorf("couldn't initialize cache database: %w", err)
 	}
+	defer func() {
+		if err != nil {
+			cacheRead.Close()
+			cacheWrite.Close()
+		}
+	}()
 
 	// Fetch the tiles on the right edge, and verify them against the checkpoint.
-	edgeTiles := make(map[int]tileWithBytes)
-	if c.N > 0 {
-		// Fetch the right-most edge tiles by reading the last leaf.
-		// TileHashReader will fetch and verify the right tiles as a
-		// side-effect.
-		if _, err := tlog.TileHashReader(c.Tree, &tileReader{
-			fetch: func(key string) ([]byte, error) {
-				return config.Backend.Fetch(ctx, key)
-			},
-			saveTiles: func(tiles []tlog.Tile, data [][]byte) {
-				for i, tile := range tiles {
-					if t, ok := edgeTiles[tile.L]; !ok || t.N < tile.N || (t.N == tile.N && t.W < tile.W) {
-						edgeTiles[tile.L] = tileWithBytes{tile, data[i]}
-					}
-				}
-			}}).ReadHashes([]int64{tlog.StoredHashIndex(0, c.N-1)}); err != nil {
-			return nil, fmt.Errorf("couldn't fetch right edge tiles: %w", err)
+	edgeTiles, err := loadEdgeTiles(ctx, config, c.Tree)
+	var repairedEdgeTile bool
+	if err != nil && ctx.Err() == nil {
+		// The level 0 edge tile might be missing, or overwritten by a
+		// narrower one, after a crash or a manual cleanup. It's just the
+		// hashes of the entries in the data tile, so it can be recomputed.
+		var repairErr error
+		edgeTiles, repairErr = repairEdgeTiles(ctx, config, c.Tree)
+		if repairErr != nil {
+			return nil, fmt.Errorf("%w (couldn't recompute the level 0 edge tile from the data tile: %w)", err, repairErr)
 		}
+		config.Log.ErrorContext(ctx, "recomputed the level 0 edge tile from the data tile, "+
+			"object storage was missing tiles or had corrupted ones", "size", c.N, "err", err)
+		repairedEdgeTile, err = true, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range edgeTiles {
+		config.Log.DebugContext(ctx, "edge tile", "tile", t)
+	}
 
-		// Fetch the right-most data tile.
-		dataTile := edgeTiles[0]
-		dataTile.L = -1
-		dataTile.B, err = config.Backend.Fetch(ctx, dataTile.Path())
-		if err != nil {
-			return nil, fmt.Errorf("couldn't fetch right edge data tile: %w", err)
+	if opts != nil && opts.FullAudit {
+		if err := auditTree(ctx, config, c.Tree, opts); err != nil {
+			return nil, fmt.Errorf("full tree audit failed: %w", err)
 		}
-		edgeTiles[-1] = dataTile
-
-		// Verify the data tile against the level 0 tile.
-		b := edgeTiles[-1].B
-		start := sunlight.TileWidth * dataTile.N
-		for i := start; i < start+int64(dataTile.W); i++ {
-			e, rest, err := sunlight.ReadTileLeaf(b)
-			if err != nil {
-				return nil, fmt.Errorf("invalid data tile %v: %w", dataTile.Tile, err)
-			}
-			b = rest
+	}
 
-			got := tlog.RecordHash(e.MerkleTreeLeaf())
-			exp, err := tlog.HashFromTile(edgeTiles[0].Tile, edgeTiles[0].B, tlog.StoredHashIndex(0, i))
-			if err != nil {
-				return nil, fmt.Errorf("couldn't extract hash for leaf %d: %w", i, err)
-			}
-			if got != exp {
-				return nil, fmt.Errorf("tile leaf entry %d hashes to %v, level 0 hash is %v", i, got, exp)
+	newerTiles, err := findNewerDataTiles(ctx, config, c.Tree)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't check for tiles past the checkpoint: %w", err)
+	}
+	if len(newerTiles) > 0 {
+		config.Log.WarnContext(ctx, "found data tiles past the lock checkpoint",
+			"size", c.N, "tiles_size", newerTilesSize(newerTiles), "policy", config.NewerTiles)
+		switch config.NewerTiles {
+		case NewerTilesRollForward:
+			// Done below, once the Log can commit checkpoints.
+		case NewerTilesIgnore:
+			if err := deleteNewerTiles(ctx, config, c.Tree, newerTiles); err != nil {
+				return nil, fmt.Errorf("couldn't delete tiles past the checkpoint: %w", err)
 			}
+			newerTiles = nil
+		default:
+			return nil, fmt.Errorf("found data tiles past the lock checkpoint size %d, up to size %d: "+
+				"a committed checkpoint might have been lost, see Config.NewerTiles",
+				c.N, newerTilesSize(newerTiles))
 		}
 	}
-	for _, t := range edgeTiles {
-		config.Log.DebugContext(ctx, "edge tile", "tile", t)
+
+	if err := backfillLeafHashes(ctx, config, cacheWrite, c.N); err != nil {
+		return nil, fmt.Errorf("couldn't index leaf hashes: %w", err)
+	}
+	leafHashes, err := sqlitex.Open(config.Cache, 0, 4)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open cache database: %w", err)
 	}
+	defer func() {
+		if err != nil {
+			leafHashes.Close()
+		}
+	}()
 
 	config.Log.InfoContext(ctx, "loaded log", "logID", base64.StdEncoding.EncodeToString(logID[:]),
-		"size", c.N, "timestamp", timestamp)
+		"size", c.N, "timestamp", timestamp, "create_only_tiles", supportsCreateOnly(config.Backend))
 
-	m := initMetrics()
+	tree := treeWithTimestamp{c.Tree, timestamp}
+	state, err := newLogState(config, tree, edgeTiles, lock.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("couldn't extract tree head signature: %w", err)
+	}
+	if c1.Tree == c.Tree {
+		// Serve the checkpoint in object storage, which might be cosigned.
+		state.published = sth
+	}
+
+	m := initMetrics(func() float64 { return l.treeAge() })
 	m.TreeSize.Set(float64(c.N))
-	m.TreeTime.Set(float64(timestamp))
+	if repairedEdgeTile {
+		m.TreeRepairedTiles.Inc()
+	}
+	m.TreeTime.Set(float64(timestamp) / 1000)
 	m.ConfigRoots.Set(float64(len(config.Roots.RawCertificates())))
 	m.ConfigStart.Set(float64(config.NotAfterStart.Unix()))
 	m.ConfigEnd.Set(float64(config.NotAfterLimit.Unix()))
 
-	return &Log{
+	l = &Log{
 		c:              config,
 		logID:          logID,
 		m:              m,
-		tree:           treeWithTimestamp{c.Tree, timestamp},
+		logConfig:      logConfig,
+		tree:           tree,
 		lockCheckpoint: lock,
+		lease:          lease,
 		edgeTiles:      edgeTiles,
 		cacheRead:      cacheRead,
+		leafHashes:     leafHashes,
 		currentPool:    newPool(),
+		shutdown:       make(chan struct{}),
+		flush:          make(chan struct{}, 1),
 		cacheWrite:     cacheWrite,
-		issuers:        make(map[[32]byte]bool),
-	}, nil
+		issuers:        make(map[[32]byte][]byte),
+		gzipCache:      newGzipCache(),
+		chainCache:     newChainCache(),
+		intermediates:  newIntermediatesCache(),
+	}
+	if config.RateLimit > 0 {
+		l.limiter = newRateLimiter(config.RateLimit, config.RateLimitBurst)
+	}
+	l.issuerQuotas = make(map[[32]byte]*issuerQuota)
+	for h, q := range config.IssuerQuotas {
+		l.issuerQuotas[h] = newIssuerQuota(q)
+	}
+	if config.DefaultIssuerQuota != nil {
+		l.defaultIssuerQuota = newIssuerQuota(*config.DefaultIssuerQuota)
+	}
+	for _, w := range config.Witnesses {
+		l.witnesses = append(l.witnesses, &witnessClient{w: w})
+	}
+	l.roots.Store(config.Roots)
+	l.state.Store(state)
+	// If the staged tiles were just applied, the checkpoint in object storage
+	// is still an older one, until the next round.
+	l.publishedCheckpoint = sth
+
+	if len(newerTiles) > 0 {
+		if err := l.rollForward(ctx, newerTiles, c1.Tree); err != nil {
+			return nil, fmt.Errorf("couldn't roll forward to the tiles past the checkpoint: %w", err)
+		}
+	}
+
+	if config.Spool != "" {
+		s, entries, err := openSpool(config.Spool)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't open spool: %w", err)
+		}
+		if err := l.replaySpool(ctx, entries); err != nil {
+			s.close()
+			return nil, fmt.Errorf("couldn't replay spool: %w", err)
+		}
+		l.spool = s
+	}
+
+	if config.Registerer != nil {
+		if err := registerMetrics(config.Registerer, l.Metrics()); err != nil {
+			if l.spool != nil {
+				l.spool.close()
+			}
+			return nil, fmt.Errorf("couldn't register metrics: %w", err)
+		}
+	}
+
+	return l, nil
+}
+
+// replaySpool adds the entries left in the spool by a previous process to the
+// current pool, unless they were already sequenced. They were accepted before
+// the crash, so they are not subject to the pool limits.
+func (l *Log) replaySpool(ctx context.Context, entries []*PendingLogEntry) error {
+	var replayed, deduplicated int
+	for _, leaf := range entries {
+		h := computeCacheHash(leaf.Certificate, leaf.IsPrecert, leaf.IssuerKeyHash)
+		if _, ok := l.currentPool.byHash[h]; ok {
+			continue
+		}
+		if se, err := l.cacheGet(leaf); err != nil {
+			return fmt.Errorf("deduplication cache get failed: %w", err)
+		} else if se != nil {
+			deduplicated++
+			continue
+		}
+		l.currentPool.add(leaf, h)
+		replayed++
+	}
+	l.observePool()
+	if len(entries) > 0 {
+		l.c.Log.InfoContext(ctx, "replayed spooled entries", "replayed", replayed,
+			"deduplicated", deduplicated, "pool", l.currentPool.id)
+	}
+	return nil
 }
 
 func openCheckpoint(config *Config, b []byte) (sunlight.Checkpoint, int64, error) {
-	v1, err := sunlight.NewRFC6962Verifier(config.Name, config.Key.Public())
+	return openCheckpointWithKeys(config, config.Key.Public(), config.WitnessKey.Public().(ed25519.PublicKey), b)
+}
+
+// openCheckpointWithKeys is like openCheckpoint, but with the public keys of
+// the log. If witnessKey is nil, only the RFC 6962 signature is required.
+func openCheckpointWithKeys(config *Config, key crypto.PublicKey, witnessKey ed25519.PublicKey, b []byte) (sunlight.Checkpoint, int64, error) {
+	v1, err := sunlight.NewRFC6962Verifier(config.Name, key)
 	if err != nil {
 		return sunlight.Checkpoint{}, 0, fmt.Errorf("couldn't construct verifier: %w", err)
 	}
-	vk, err := note.NewEd25519VerifierKey(config.Name, config.WitnessKey.Public().(ed25519.PublicKey))
-	if err != nil {
-		return sunlight.Checkpoint{}, 0, fmt.Errorf("couldn't construct verifier key: %w", err)
-	}
-	v2, err := note.NewVerifier(vk)
-	if err != nil {
-		return sunlight.Checkpoint{}, 0, fmt.Errorf("couldn't construct Ed25519 verifier: %w", err)
+	verifiers := note.VerifierList(v1)
+	var v2 note.Verifier
+	if witnessKey != nil {
+		vk, err := note.NewEd25519VerifierKey(config.Name, witnessKey)
+		if err != nil {
+			return sunlight.Checkpoint{}, 0, fmt.Errorf("couldn't construct verifier key: %w", err)
+		}
+		v2, err = note.NewVerifier(vk)
+		if err != nil {
+			return sunlight.Checkpoint{}, 0, fmt.Errorf("couldn't construct Ed25519 verifier: %w", err)
+		}
+		verifiers = note.VerifierList(v1, v2)
 	}
-	n, err := note.Open(b, note.VerifierList(v1, v2))
+	n, err := note.Open(b, verifiers)
 	if err != nil {
+		if origin, _, _ := bytes.Cut(b, []byte("\n")); string(origin) != config.Name {
+			return sunlight.Checkpoint{}, 0, fmt.Errorf("checkpoint is for log %q, not %q, is the backend of a different log?", origin, config.Name)
+		}
+		if unverified := (*note.UnverifiedNoteError)(nil); errors.As(err, &unverified) {
+			if err := checkpointKeyMismatch(config.Name, unverified.Note.UnverifiedSigs, v1, v2); err != nil {
+				return sunlight.Checkpoint{}, 0, err
+			}
+		}
 		return sunlight.Checkpoint{}, 0, fmt.Errorf("couldn't verify checkpoint signature: %w", err)
 	}
 	var timestamp int64
 	var v1Found, v2Found bool
 	for _, sig := range n.Sigs {
-		switch sig.Hash {
-		case v1.KeyHash():
+		switch {
+		case sig.Hash == v1.KeyHash():
 			v1Found = true
 			timestamp, err = sunlight.RFC6962SignatureTimestamp(sig)
 			if err != nil {
 				return sunlight.Checkpoint{}, 0, fmt.Errorf("couldn't extract timestamp: %w", err)
 			}
-		case v2.KeyHash():
+		case v2 != nil && sig.Hash == v2.KeyHash():
 			v2Found = true
 		}
 	}
-	if !v1Found || !v2Found {
+	if !v1Found || v2 != nil && !v2Found {
+		if err := checkpointKeyMismatch(config.Name, n.UnverifiedSigs, v1, v2); err != nil {
+			return sunlight.Checkpoint{}, 0, err
+		}
 		return sunlight.Checkpoint{}, 0, errors.New("missing verifier signature")
 	}
 	c, err := sunlight.ParseCheckpoint(n.Text)
@@ -338,7 +1058,8 @@ func openCheckpoint(config *Config, b []byte) (sunlight.Checkpoint, int64, error
 		return sunlight.Checkpoint{}, 0, fmt.Errorf("couldn't parse checkpoint: %w", err)
 	}
 
-	if now := timeNowUnixMilli(); now < timestamp {
+	// The latest checkpoint might be ahead of the clock by up to MaxClockSkew.
+	if now := timeNowUnixMilli(config); now < timestamp-maxClockSkew(config).Milliseconds() {
 		return sunlight.Checkpoint{}, 0, fmt.Errorf("current time %d is before checkpoint time %d", now, timestamp)
 	}
 	if c.Origin != config.Name {
@@ -351,19 +1072,59 @@ func openCheckpoint(config *Config, b []byte) (sunlight.Checkpoint, int64, error
 	return c, timestamp, nil
 }
 
-var timeNowUnixMilli = func() int64 { return time.Now().UnixMilli() }
+// checkpointKeyMismatch returns an error describing the unverified signatures
+// by the log among sigs, if any, which were made with a different key than v1
+// (the RFC 6962 key) or v2 (the Ed25519 witness key, if not nil), most likely
+// because the checkpoint is from a different log with the same name.
+func checkpointKeyMismatch(name string, sigs []note.Signature, v1, v2 note.Verifier) error {
+	var witnessErr error
+	for _, sig := range sigs {
+		if sig.Name != name || isGreaseSignature(sig) {
+			continue
+		}
+		b, err := base64.StdEncoding.DecodeString(sig.Base64)
+		if err != nil || len(b) < 4 {
+			continue
+		}
+		// A RFC6962NoteSignature is a timestamp followed by a
+		// DigitallySigned with SHA-256.
+		s := cryptobyte.String(b[4:])
+		var hashAlg, sigAlg uint8
+		var signature cryptobyte.String
+		if s.Skip(8) && s.ReadUint8(&hashAlg) && hashAlg == 4 && s.ReadUint8(&sigAlg) &&
+			s.ReadUint16LengthPrefixed(&signature) && s.Empty() {
+			if sig.Hash != v1.KeyHash() {
+				return fmt.Errorf("checkpoint was signed by key ID %08x, provided key has ID %08x", sig.Hash, v1.KeyHash())
+			}
+			continue
+		}
+		if v2 != nil && len(b) == 4+ed25519.SignatureSize && sig.Hash != v2.KeyHash() && witnessErr == nil {
+			witnessErr = fmt.Errorf("checkpoint was signed by witness key ID %08x, provided witness key has ID %08x", sig.Hash, v2.KeyHash())
+		}
+	}
+	return witnessErr
+}
+
+func timeNowUnixMilli(c *Config) int64 {
+	if c.Clock != nil {
+		return c.Clock()
+	}
+	return time.Now().UnixMilli()
+}
 
 // Backend is a strongly consistent object storage.
 //
 // It is dedicated to a single log instance.
 type Backend interface {
 	// Upload is expected to retry transient errors, and only return an error
-	// for unrecoverable errors. When Upload returns, the object must be fully
-	// persisted. Upload can be called concurrently. opts may be nil.
+	// for unrecoverable errors (see RetryBackend). When Upload returns, the
+	// object must be fully persisted. Upload can be called concurrently. opts
+	// may be nil.
 	Upload(ctx context.Context, key string, data []byte, opts *UploadOptions) error
 
 	// Fetch can be called concurrently. It's expected to decompress any data
-	// uploaded with UploadOptions.Compress true.
+	// uploaded with UploadOptions.Compress true. If the object doesn't exist,
+	// the returned error must wrap fs.ErrNotExist.
 	Fetch(ctx context.Context, key string) ([]byte, error)
 
 	// Metrics returns the metrics to register for this log. The metrics should
@@ -371,6 +1132,78 @@ type Backend interface {
 	Metrics() []prometheus.Collector
 }
 
+// A ListDeleteBackend is a Backend that can also enumerate and remove objects.
+// It's optional, and only required by Config.PartialTileGC.
+type ListDeleteBackend interface {
+	Backend
+
+	// List returns the keys of all objects that start with prefix, in
+	// lexicographic order.
+	List(ctx context.Context, prefix string) ([]string, error)
+
+	// Delete removes the object at key. Deleting a missing object is not an
+	// error.
+	Delete(ctx context.Context, key string) error
+}
+
+// A BatchBackend is a Backend that can upload multiple objects in a single
+// call, more efficiently than with separate Upload calls. It's optional.
+type BatchBackend interface {
+	Backend
+
+	// BatchUpload uploads all objects, with the same semantics as Upload.
+	// When it returns nil, all objects must be fully persisted. If it returns
+	// an error, any subset of them might have been persisted.
+	BatchUpload(ctx context.Context, objects []Object) error
+}
+
+// A PingBackend is a Backend that can cheaply check that its storage is
+// reachable and writable. It's optional, and used by the health checks.
+type PingBackend interface {
+	Backend
+
+	// Ping returns an error if the Backend can't currently serve or persist
+	// objects. It must not modify any log objects.
+	Ping(ctx context.Context) error
+}
+
+// A RangeBackend is a Backend that can fetch part of an object, to serve
+// proofs and single entries without fetching whole tiles. It's optional.
+type RangeBackend interface {
+	Backend
+
+	// FetchRange returns up to length bytes of the object at key, starting at
+	// offset. It returns fewer bytes only if the object ends before
+	// offset+length, and none if it ends before offset. If the object can't be
+	// read partially, for example because it's stored compressed, it may
+	// instead return all the bytes from offset to the end of the object.
+	FetchRange(ctx context.Context, key string, offset, length int64) ([]byte, error)
+
+	// SupportsRanges reports whether FetchRange of key reads only the
+	// requested range from storage. If false, FetchRange still works, but
+	// reads the whole object.
+	SupportsRanges(key string) bool
+}
+
+// A CreateOnlyBackend is a Backend that can make uploads conditional on the
+// object not existing yet. It's optional, and used to detect another instance
+// sequencing the same log, by uploading full tiles with
+// UploadOptions.CreateOnly.
+type CreateOnlyBackend interface {
+	Backend
+
+	// SupportsCreateOnly reports whether Upload and BatchUpload honor
+	// UploadOptions.CreateOnly. If false, CreateOnly is ignored.
+	SupportsCreateOnly() bool
+}
+
+// An Object is an upload in a BatchBackend.BatchUpload call.
+type Object struct {
+	Key  string
+	Data []byte
+	Opts *UploadOptions
+}
+
 // UploadOptions are used as part of the Backend.Upload method, and are
 // marshaled to JSON and stored in the staging bundles.
 type UploadOptions struct {
@@ -382,15 +1215,34 @@ type UploadOptions struct {
 	// before uploading if possible.
 	Compress bool
 
-	// Immutable is true if the data is never updated after being uploaded.
+	// Immutable is true if the data is never updated or deleted after being
+	// uploaded. Backends may use it to apply retention policies.
 	Immutable bool
+
+	// CreateOnly is true if the upload must fail with an error wrapping
+	// ErrObjectExists if the object already exists, without replacing it.
+	// It's only set if the Backend is a CreateOnlyBackend that supports it.
+	CreateOnly bool
+
+	// CacheControl is the Cache-Control header to serve the object with, if
+	// the backend serves objects over HTTP. If empty, it defaults to a long
+	// caching policy if Immutable is true, and to no header otherwise.
+	CacheControl string
 }
 
+const cacheControlImmutable = "public, max-age=604800, immutable"
+const cacheControlShort = "public, max-age=5"
+
 var optsHashTile = &UploadOptions{Immutable: true}
+var optsPartialHashTile = &UploadOptions{CacheControl: cacheControlShort}
 var optsDataTile = &UploadOptions{Compress: true, Immutable: true}
+var optsHashTileCreateOnly = &UploadOptions{Immutable: true, CreateOnly: true}
+var optsDataTileCreateOnly = &UploadOptions{Compress: true, Immutable: true, CreateOnly: true}
+var optsPartialDataTile = &UploadOptions{Compress: true, CacheControl: cacheControlShort}
 var optsStaging = &UploadOptions{Compress: true}
 var optsIssuer = &UploadOptions{ContentType: "application/pkix-cert", Immutable: true}
-var optsCheckpoint = &UploadOptions{ContentType: "text/plain; charset=utf-8"}
+var optsCheckpoint = &UploadOptions{ContentType: "text/plain; charset=utf-8",
+	CacheControl: cacheControlShort}
 
 // A LockBackend is a database that supports compare-and-swap operations.
 //
@@ -417,34 +1269,189 @@ type LockBackend interface {
 	// should be collected by the application, not by the Log.
 }
 
+// A LeaseBackend grants exclusive expiring leases, used to ensure only one
+// instance at a time loads and sequences a log. Like LockBackend, it's meant to
+// be shared across logs.
+type LeaseBackend interface {
+	// Acquire obtains the lease for logID on behalf of holder, valid for ttl.
+	// If another holder has a lease that didn't expire, it returns an error
+	// wrapping ErrLeaseHeld.
+	Acquire(ctx context.Context, logID [sha256.Size]byte, holder string, ttl time.Duration) (*Lease, error)
+
+	// Renew extends lease to ttl from now. If the lease was acquired by
+	// another holder in the meantime, it returns an error wrapping
+	// ErrLeaseLost.
+	Renew(ctx context.Context, lease *Lease, ttl time.Duration) (*Lease, error)
+
+	// Release gives up lease, if it's still held.
+	Release(ctx context.Context, lease *Lease) error
+}
+
+// A Lease is held by a single holder at a time.
+type Lease struct {
+	LogID  [sha256.Size]byte
+	Holder string
+	// Token is a fencing token, which increases every time the lease is
+	// acquired by any holder.
+	Token  int64
+	Expiry time.Time
+}
+
+var ErrLeaseHeld = errors.New("lease is held by another instance")
+var ErrLeaseLost = errors.New("lease was acquired by another instance")
+
 // A LockedCheckpoint is a checkpoint, along with the backend-specific
 // information necessary to perform a compare-and-swap operation.
 type LockedCheckpoint interface {
 	Bytes() []byte
 }
 
+// tileFetchConcurrency is the maximum number of concurrent fetches of a
+// tileReader.ReadTiles call.
+const tileFetchConcurrency = 16
+
+// tileReader is a tlog.TileReader that fetches tiles with fetch. Since
+// tlog.TileReader methods don't take a context, the context is stored in the
+// tileReader, and checked before each fetch.
+//
+// ReadTiles fetches its tiles concurrently, and fetches each path once, even
+// if it's requested repeatedly or by concurrent calls.
 type tileReader struct {
-	fetch     func(key string) ([]byte, error)
+	ctx       context.Context
+	fetch     func(ctx context.Context, key string) ([]byte, error)
 	saveTiles func(tiles []tlog.Tile, data [][]byte)
+
+	group singleflight.Group
 }
 
 func (r *tileReader) Height() int {
 	return sunlight.TileHeight
 }
 
-func (r *tileReader) ReadTiles(tiles []tlog.Tile) (data [][]byte, err error) {
-	for _, t := range tiles {
-		b, err := r.fetch(sunlight.TilePath(t))
-		if err != nil {
-			return nil, err
+func (r *tileReader) ReadTiles(tiles []tlog.Tile) ([][]byte, error) {
+	data := make([][]byte, len(tiles))
+	first := make(map[string]int)
+	g, ctx := errgroup.WithContext(r.ctx)
+	g.SetLimit(tileFetchConcurrency)
+	for i, t := range tiles {
+		if _, ok := first[sunlight.TilePath(t)]; ok {
+			continue
 		}
-		data = append(data, b)
+		first[sunlight.TilePath(t)] = i
+		g.Go(func() error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			path := sunlight.TilePath(t)
+			b, err, _ := r.group.Do(path, func() (any, error) {
+				return r.fetch(ctx, path)
+			})
+			if err != nil {
+				return err
+			}
+			data[i] = b.([]byte)
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	for i, t := range tiles {
+		data[i] = data[first[sunlight.TilePath(t)]]
 	}
 	return data, nil
 }
 
 func (r *tileReader) SaveTiles(tiles []tlog.Tile, data [][]byte) { r.saveTiles(tiles, data) }
 
+// stateHashReader returns a HashReader for the tree in state, which can read
+// any hash in the tree. Edge tiles are served from state, and other tiles are
+// fetched from the backend and verified against the tree head.
+func (l *Log) stateHashReader(ctx context.Context, state *logState) tlog.HashReader {
+	return tlog.TileHashReader(state.tree.Tree, &tileReader{
+		ctx: ctx,
+		fetch: func(ctx context.Context, key string) ([]byte, error) {
+			for level, t := range state.edgeTiles {
+				if level >= 0 && t.Path() == key {
+					return t.B, nil
+				}
+			}
+			return l.c.Backend.Fetch(ctx, key)
+		},
+		saveTiles: func(tiles []tlog.Tile, data [][]byte) {},
+	})
+}
+
+// readEntries returns the entries from start (inclusive) to end (exclusive)
+// of the tree in state, reading them from the data tiles. The right edge data
+// tile is served from state, and other tiles are fetched from the backend. A
+// single entry is read with readEntryRange, if the backend can read ranges of
+// data tiles.
+func (l *Log) readEntries(ctx context.Context, state *logState, start, end int64) ([]*sunlight.LogEntry, error) {
+	if start < 0 || start > end || end > state.tree.N {
+		return nil, fmt.Errorf("invalid range [%d, %d) for tree size %d", start, end, state.tree.N)
+	}
+	var entries []*sunlight.LogEntry
+	for n := start / sunlight.TileWidth; n*sunlight.TileWidth < end; n++ {
+		tile := dataTileForTree(state.tree.N, n)
+		if end-start == 1 && tile != state.edgeTiles[-1].Tile &&
+			supportsRanges(l.c.Backend, sunlight.TilePath(tile)) {
+			e, err := l.readEntryRange(ctx, tile, start)
+			if err != nil {
+				return nil, err
+			}
+			return []*sunlight.LogEntry{e}, nil
+		}
+		var b []byte
+		if t, ok := state.edgeTiles[-1]; ok && t.Tile == tile {
+			b = t.B
+		} else {
+			var err error
+			b, err = l.c.Backend.Fetch(ctx, sunlight.TilePath(tile))
+			if err != nil {
+				return nil, fmtErrorf("couldn't fetch data tile %v: %w", tile, err)
+			}
+		}
+		tileEntries, err := parseDataTile(tile, b)
+		if err != nil {
+			return nil, err
+		}
+		first := n * sunlight.TileWidth
+		lo, hi := max(start-first, 0), min(end-first, int64(tile.W))
+		entries = append(entries, tileEntries[lo:hi]...)
+	}
+	return entries, nil
+}
+
+// dataTileForTree returns the n-th data tile of a tree of the given size, which
+// might be partial.
+func dataTileForTree(size, n int64) tlog.Tile {
+	return tlog.Tile{H: sunlight.TileHeight, L: -1, N: n,
+		W: int(min(sunlight.TileWidth, size-n*sunlight.TileWidth))}
+}
+
+// parseDataTile parses all the entries in a data tile, and checks them against
+// the expected tile coordinates.
+func parseDataTile(tile tlog.Tile, b []byte) ([]*sunlight.LogEntry, error) {
+	entries := make([]*sunlight.LogEntry, 0, tile.W)
+	start := tile.N * sunlight.TileWidth
+	for i := start; i < start+int64(tile.W); i++ {
+		e, rest, err := sunlight.ReadTileLeaf(b)
+		if err != nil {
+			return nil, fmt.Errorf("invalid data tile %v: %w", tile, err)
+		}
+		if e.LeafIndex != i {
+			return nil, fmt.Errorf("invalid data tile %v: leaf %d has index %d", tile, i, e.LeafIndex)
+		}
+		entries = append(entries, e)
+		b = rest
+	}
+	if len(b) != 0 {
+		return nil, fmt.Errorf("invalid data tile %v: trailing data", tile)
+	}
+	return entries, nil
+}
+
 // PendingLogEntry is a subset of sunlight.LogEntry that was not yet sequenced,
 // so doesn't have an index or timestamp.
 type PendingLogEntry struct {
@@ -471,6 +1478,24 @@ func (e *PendingLogEntry) asLogEntry(idx, timestamp int64) *sunlight.LogEntry {
 	}
 }
 
+// encodedSize returns the total size of the Merkle tree leaf and of the data
+// tile leaf of e, which are both built while sequencing its pool, with a
+// leaf_index extension.
+func (e *PendingLogEntry) encodedSize() int {
+	// TimestampedEntry, with extensions<0..2^16-1> holding a leaf_index.
+	const extensions = 2 + 1 + 2 + 5
+	entry := 8 + 2 + 3 + len(e.Certificate) + extensions
+	if e.IsPrecert {
+		entry += sha256.Size // issuer_key_hash
+	}
+	merkleLeaf := 1 + 1 + entry // version, leaf_type
+	tileLeaf := entry + 2 + sha256.Size*len(e.Issuers)
+	if e.IsPrecert {
+		tileLeaf += 3 + len(e.PreCertificate)
+	}
+	return merkleLeaf + tileLeaf
+}
+
 type cacheHash [16]byte // birthday bound of 2⁴⁸ entries with collision chance 2⁻³²
 
 func computeCacheHash(Certificate []byte, IsPrecert bool, IssuerKeyHash [32]byte) cacheHash {
@@ -492,14 +1517,34 @@ func computeCacheHash(Certificate []byte, IsPrecert bool, IssuerKeyHash [32]byte
 }
 
 type pool struct {
+	// id identifies the pool in logs.
+	id uint64
+
 	pendingLeaves []*PendingLogEntry
 	byHash        map[cacheHash]waitEntryFunc
+	// pendingBytes is the total encodedSize of pendingLeaves.
+	pendingBytes int
 
 	// done is closed when the pool has been sequenced and
 	// the results below are ready.
 	done chan struct{}
 
+	// err is the error of a failed round, returned to all waiters, once the
+	// pool ran out of Config.RoundRetries. Submitters get an error for which
+	// they can retry, since no SCTs were returned. After a fatal error the
+	// entries might have been committed anyway, in which case a retry can
+	// result in a duplicate entry.
 	err error
+	// retries is the number of failed rounds after which the entries of the
+	// pool were requeued by requeuePool.
+	retries int
+	// failures is the number of failed rounds attributed to each entry, by
+	// cache hash, carried over by requeuePool.
+	failures map[cacheHash]int
+	// forward, if not nil, holds the wait functions of the entries that were
+	// moved to a requeued pool, by their cache hash. The wait functions of the
+	// entries missing from it return err.
+	forward map[cacheHash]waitEntryFunc
 	// firstLeafIndex is the 0-based index of pendingLeaves[0] in the tree, and
 	// every following entry is sequenced contiguously.
 	firstLeafIndex int64
@@ -511,20 +1556,59 @@ type pool struct {
 
 type waitEntryFunc func(ctx context.Context) (*sunlight.LogEntry, error)
 
+// poolIDs is the source of pool.id values.
+var poolIDs atomic.Uint64
+
 func newPool() *pool {
 	return &pool{
+		id:     poolIDs.Add(1),
 		done:   make(chan struct{}),
 		byHash: make(map[cacheHash]waitEntryFunc),
 	}
 }
 
-var errPoolFull = fmtErrorf("rate limited")
+// ErrPoolFull is returned by the wait function of a submission that was
+// rejected because the current pool reached Config.PoolSize or
+// Config.PoolMaxBytes.
+var ErrPoolFull = fmtErrorf("rate limited")
+
+// ErrPaused is returned by the wait function of a submission that was
+// rejected because sequencing is paused and Config.RejectWhilePaused is set.
+var ErrPaused = fmtErrorf("log is paused")
+
+// ErrShuttingDown is returned by the wait function of a submission that was
+// rejected because Shutdown was called.
+var ErrShuttingDown = fmtErrorf("log is shutting down")
+
+// ErrLogClosed is returned by the wait function of a submission, and by the
+// methods that read the cache databases, after Close.
+var ErrLogClosed = fmtErrorf("log is closed")
 
 // addLeafToPool adds leaf to the current pool, unless it is found in a
 // deduplication cache. It returns a function that will wait until the pool is
 // sequenced and return the sequenced leaf, as well as the source of the
 // sequenced leaf (pool or cache if deduplicated, sequencer otherwise).
+//
+// The leaf's issuers are persisted to the backend before the leaf is added to
+// the pool, so they are available before any data tile referencing them. If
+// Config.Spool is set, the leaf is also persisted to the spool.
 func (l *Log) addLeafToPool(ctx context.Context, leaf *PendingLogEntry) (f waitEntryFunc, source string) {
+	if l.closed.Load() {
+		return func(ctx context.Context) (*sunlight.LogEntry, error) {
+			return nil, ErrLogClosed
+		}, "closed"
+	}
+	if l.shuttingDown.Load() {
+		return func(ctx context.Context) (*sunlight.LogEntry, error) {
+			return nil, ErrShuttingDown
+		}, "shutdown"
+	}
+	if l.c.RejectWhilePaused && l.paused.Load() {
+		return func(ctx context.Context) (*sunlight.LogEntry, error) {
+			return nil, ErrPaused
+		}, "paused"
+	}
+
 	// We could marginally more efficiently do uploadIssuer after checking the
 	// caches, but it's simpler for the the block below to be under a single
 	// poolMu lock, and uploadIssuer goes to the network so we don't want to
@@ -538,37 +1622,107 @@ func (l *Log) addLeafToPool(ctx context.Context, leaf *PendingLogEntry) (f waitE
 		}
 	}
 
+	f, source, spooled := l.addLeafToCurrentPool(ctx, leaf)
+	if spooled != nil {
+		// Sync outside of poolMu, so that concurrent submissions share syncs.
+		// If this fails the entry might still get sequenced, like if any
+		// other error happened after adding it to the pool.
+		if err := spooled.sync(); err != nil {
+			l.c.Log.ErrorContext(ctx, "failed to sync spool", "err", err)
+			return func(ctx context.Context) (*sunlight.LogEntry, error) {
+				return nil, fmtErrorf("failed to sync spool: %w", err)
+			}, "spool"
+		}
+	}
+	return f, source
+}
+
+// addLeafToCurrentPool is the part of addLeafToPool that runs under poolMu. If
+// the leaf was added to the spool, it returns the spool file to sync.
+func (l *Log) addLeafToCurrentPool(ctx context.Context, leaf *PendingLogEntry) (f waitEntryFunc, source string, spooled *spoolFile) {
 	l.poolMu.Lock()
 	defer l.poolMu.Unlock()
+	if l.shuttingDown.Load() {
+		return func(ctx context.Context) (*sunlight.LogEntry, error) {
+			return nil, ErrShuttingDown
+		}, "shutdown", nil
+	}
+	if l.c.RejectWhilePaused && l.paused.Load() {
+		return func(ctx context.Context) (*sunlight.LogEntry, error) {
+			return nil, ErrPaused
+		}, "paused", nil
+	}
 	p := l.currentPool
 	h := computeCacheHash(leaf.Certificate, leaf.IsPrecert, leaf.IssuerKeyHash)
 	if f, ok := p.byHash[h]; ok {
-		return f, "pool"
+		addAccessLogAttrs(ctx, slog.Uint64("pool", p.id))
+		return f, "pool", nil
 	}
 	if f, ok := l.inSequencing[h]; ok {
-		return f, "pool"
+		return f, "pool", nil
 	}
 	if leaf, err := l.cacheGet(leaf); err != nil {
 		return func(ctx context.Context) (*sunlight.LogEntry, error) {
 			return nil, fmtErrorf("deduplication cache get failed: %w", err)
-		}, "cache"
+		}, "cache", nil
 	} else if leaf != nil {
 		return func(ctx context.Context) (*sunlight.LogEntry, error) {
 			return leaf, nil
-		}, "cache"
+		}, "cache", nil
 	}
 	n := len(p.pendingLeaves)
-	if l.c.PoolSize > 0 && n >= l.c.PoolSize {
+	size := leaf.encodedSize()
+	if l.c.PoolSize > 0 && n >= l.c.PoolSize ||
+		l.c.PoolMaxBytes > 0 && p.pendingBytes+size > l.c.PoolMaxBytes {
+		l.m.AddChainPoolFull.Inc()
 		return func(ctx context.Context) (*sunlight.LogEntry, error) {
-			return nil, errPoolFull
-		}, "ratelimit"
+			return nil, ErrPoolFull
+		}, "ratelimit", nil
+	}
+	if l.spool != nil {
+		sf, err := l.spool.append(leaf)
+		if err != nil {
+			l.c.Log.ErrorContext(ctx, "failed to spool entry", "err", err)
+			return func(ctx context.Context) (*sunlight.LogEntry, error) {
+				return nil, fmtErrorf("failed to spool entry: %w", err)
+			}, "spool", nil
+		}
+		spooled = sf
+	}
+	f = p.add(leaf, h)
+	l.observePool()
+	if l.c.PoolFlushBytes > 0 && p.pendingBytes >= l.c.PoolFlushBytes ||
+		l.c.PoolFlushEntries > 0 && len(p.pendingLeaves) >= l.c.PoolFlushEntries {
+		select {
+		case l.flush <- struct{}{}:
+		default:
+		}
 	}
+	addAccessLogAttrs(ctx, slog.Uint64("pool", p.id))
+	return f, "sequencer", spooled
+}
+
+// observePool updates the pool occupancy gauges. It must be called under poolMu
+// whenever the current pool changes.
+func (l *Log) observePool() {
+	l.m.PoolEntries.Set(float64(len(l.currentPool.pendingLeaves)))
+	l.m.PoolBytes.Set(float64(l.currentPool.pendingBytes))
+}
+
+// add appends leaf, which has cache hash h, to p, and returns a function that
+// waits for p to be sequenced.
+func (p *pool) add(leaf *PendingLogEntry, h cacheHash) waitEntryFunc {
+	n := len(p.pendingLeaves)
 	p.pendingLeaves = append(p.pendingLeaves, leaf)
-	f = func(ctx context.Context) (*sunlight.LogEntry, error) {
+	p.pendingBytes += leaf.encodedSize()
+	f := func(ctx context.Context) (*sunlight.LogEntry, error) {
 		select {
 		case <-ctx.Done():
 			return nil, fmtErrorf("context canceled while waiting for sequencing: %w", ctx.Err())
 		case <-p.done:
+			if f, ok := p.forward[h]; ok {
+				return f(ctx)
+			}
 			if p.err != nil {
 				return nil, p.err
 			}
@@ -580,14 +1734,14 @@ func (l *Log) addLeafToPool(ctx context.Context, leaf *PendingLogEntry) (f waitE
 		}
 	}
 	p.byHash[h] = f
-	return f, "sequencer"
+	return f
 }
 
 func (l *Log) uploadIssuer(ctx context.Context, issuer []byte) error {
 	fingerprint := sha256.Sum256(issuer)
 
 	l.issuersMu.RLock()
-	found := l.issuers[fingerprint]
+	_, found := l.issuers[fingerprint]
 	l.issuersMu.RUnlock()
 	if found {
 		return nil
@@ -596,97 +1750,498 @@ func (l *Log) uploadIssuer(ctx context.Context, issuer []byte) error {
 	l.issuersMu.Lock()
 	defer l.issuersMu.Unlock()
 
-	if l.issuers[fingerprint] {
-		return nil
+	if _, found := l.issuers[fingerprint]; found {
+		return nil
+	}
+
+	path := fmt.Sprintf("issuer/%x", fingerprint)
+	l.c.Log.InfoContext(ctx, "observed new issuer", "path", path)
+
+	// First we try to download and check the issuer from the backend.
+	// If it's not there, we upload it.
+
+	old, err := l.c.Backend.Fetch(ctx, path)
+	if err != nil {
+		upErr := l.c.Backend.Upload(ctx, path, issuer, optsIssuer)
+		l.c.Log.InfoContext(ctx, "uploaded issuer", "path", path, "err", upErr, "fetchErr", err, "size", len(issuer))
+		if upErr != nil {
+			return fmtErrorf("upload error: %w; fetch error: %v", upErr, err)
+		}
+	} else {
+		if !bytes.Equal(old, issuer) {
+			return fmtErrorf("invalid existing issuer: %x", old)
+		}
+	}
+
+	l.issuers[fingerprint] = issuer
+	l.m.Issuers.Set(float64(len(l.issuers)))
+	return nil
+}
+
+// issuer returns the issuer with the given fingerprint, fetching it from the
+// backend if it's not in l.issuers.
+func (l *Log) issuer(ctx context.Context, fingerprint [32]byte) ([]byte, error) {
+	l.issuersMu.RLock()
+	issuer, found := l.issuers[fingerprint]
+	l.issuersMu.RUnlock()
+	if found {
+		return issuer, nil
+	}
+
+	path := fmt.Sprintf("issuer/%x", fingerprint)
+	issuer, err := l.c.Backend.Fetch(ctx, path)
+	if err != nil {
+		return nil, fmtErrorf("couldn't fetch issuer %q: %w", path, err)
+	}
+	if sha256.Sum256(issuer) != fingerprint {
+		return nil, fmtErrorf("invalid issuer %q: %x", path, issuer)
+	}
+
+	l.issuersMu.Lock()
+	defer l.issuersMu.Unlock()
+	l.issuers[fingerprint] = issuer
+	l.m.Issuers.Set(float64(len(l.issuers)))
+	return issuer, nil
+}
+
+// RunSequencer sequences the pending pool every period, until ctx is canceled
+// or a fatal error occurs. If period is zero or negative, it defaults to one
+// second.
+//
+// Rounds never overlap: if a round takes longer than period, the missed ticks
+// are skipped, and the next round starts at the following tick. After a failed
+// round, the period is doubled for every consecutive failure, up to
+// maxSequencerBackoff.
+//
+// If Config.PoolFlushEntries or Config.PoolFlushBytes is set, a round also
+// starts as soon as the pending pool reaches either, unless the sequencer is
+// backing off. The period is the longest a quiet pool waits.
+//
+// Errors that leave the log in a known state, such as a failed upload of the
+// staged tiles, are delivered to the submissions of the failed pool, logged,
+// counted in the metrics, and retried by the next round. Errors after which the
+// state of the log is unknown, for example because the lock checkpoint might or
+// might not have been replaced, or because another instance took over, are
+// fatal: they are returned, and the Log must be reloaded with LoadLog.
+//
+// When ctx is canceled, the pending pool, if not empty, is sequenced one last
+// time, and ctx.Err() is returned, unless that final round fails fatally. The
+// same happens when Shutdown is called, except that nil is returned.
+//
+// RunSequencer must be called at most once.
+func (l *Log) RunSequencer(ctx context.Context, period time.Duration) (err error) {
+	if period <= 0 {
+		period = defaultSequencerPeriod
+	}
+	l.seqPeriod.Store(int64(period))
+
+	l.poolMu.Lock()
+	if l.shuttingDown.Load() {
+		l.poolMu.Unlock()
+		return ErrShuttingDown
+	}
+	done := make(chan struct{})
+	l.sequencerDone = done
+	l.poolMu.Unlock()
+
+	// If the sequencer stops, return errors for all pending and future leaves.
+	defer func() {
+		l.poolMu.Lock()
+		defer l.poolMu.Unlock()
+		l.currentPool.err = err
+		if err == nil {
+			l.currentPool.err = ErrShuttingDown
+		}
+		close(l.currentPool.done)
+		if errors.Is(err, errFatal) {
+			l.sequencerErr = err
+		}
+		close(done)
+	}()
+
+	// Release the lease, so that another instance can take over promptly.
+	defer func() {
+		if l.lease == nil {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), sequenceTimeout(l.c))
+		defer cancel()
+		if err := l.c.Lease.Release(ctx, l.lease); err != nil {
+			l.c.Log.WarnContext(ctx, "failed to release sequencing lease", "err", err)
+		}
+	}()
+
+	probeCtx, stopProbe := context.WithCancel(ctx)
+	defer stopProbe()
+	go l.runBackendProbe(probeCtx)
+
+	// Randomly stagger the sequencers to avoid conflicting for resources.
+	if !testing.Testing() {
+		time.Sleep(time.Duration(mathrand.Int64N(int64(period))))
+	}
+
+	t := time.NewTimer(period)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if err := l.flushPool(context.WithoutCancel(ctx)); err != nil {
+				return err
+			}
+			l.c.Log.InfoContext(ctx, "sequencer stopped")
+			return ctx.Err()
+		case <-l.shutdown:
+			if err := l.flushPool(context.WithoutCancel(ctx)); err != nil {
+				return err
+			}
+			l.c.Log.InfoContext(ctx, "sequencer shut down")
+			return nil
+		case <-l.flush:
+			if l.seqFailures.Load() > 0 {
+				continue
+			}
+		case <-t.C:
+		}
+		start := time.Now()
+		if err := l.sequence(ctx); err != nil {
+			l.c.Log.ErrorContext(ctx, "fatal sequencing error", "err", err)
+			return err
+		}
+		t.Reset(sequencerDelay(period, l.seqFailures.Load(), time.Since(start)))
+	}
+}
+
+const (
+	defaultSequencerPeriod = 1 * time.Second
+	maxSequencerBackoff    = 1 * time.Minute
+)
+
+// sequencerDelay returns how long to wait before the next round, after one
+// that took elapsed and left failures consecutive failed rounds.
+//
+// The delay is aligned to the interval, so that a round that overran skips the
+// ticks it missed instead of being followed immediately by another one.
+func sequencerDelay(period time.Duration, failures int64, elapsed time.Duration) time.Duration {
+	interval := period
+	if failures > 0 {
+		interval = period << min(failures, 16)
+		interval = max(min(interval, maxSequencerBackoff), period)
+	}
+	return interval - elapsed%interval
+}
+
+// flushPool sequences the submissions accepted so far, if any, rather than
+// failing them, and returns only fatal errors.
+func (l *Log) flushPool(ctx context.Context) error {
+	if l.pendingLeaves() == 0 {
+		return nil
+	}
+	if err := l.sequence(ctx); err != nil {
+		l.c.Log.ErrorContext(ctx, "fatal sequencing error", "err", err)
+		return err
+	}
+	return nil
+}
+
+// Shutdown stops accepting submissions, which fail with ErrShuttingDown from
+// then on, and sequences the pending ones. If RunSequencer is running, it
+// runs the final round and returns nil, and Shutdown waits for it.
+//
+// Shutdown returns once the final round completed and its tiles and
+// checkpoint are uploaded, or when ctx is done. It returns an error only if
+// the sequencer failed fatally. Submissions in a round that failed otherwise
+// get the error from their wait function, as usual.
+//
+// If sequencing is paused, the pending submissions are not sequenced, and fail
+// with ErrShuttingDown. If Config.Spool is set, the next LoadLog sequences them.
+func (l *Log) Shutdown(ctx context.Context) error {
+	l.poolMu.Lock()
+	if !l.shuttingDown.Swap(true) {
+		close(l.shutdown)
+	}
+	done := l.sequencerDone
+	l.poolMu.Unlock()
+
+	if done == nil {
+		if l.paused.Load() {
+			l.poolMu.Lock()
+			p := l.currentPool
+			l.currentPool = newPool()
+			l.observePool()
+			l.poolMu.Unlock()
+			p.err = ErrShuttingDown
+			close(p.done)
+			return nil
+		}
+		return l.flushPool(ctx)
+	}
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return fmtErrorf("timed out waiting for the final sequencing round: %w", ctx.Err())
+	}
+	l.poolMu.Lock()
+	defer l.poolMu.Unlock()
+	return l.sequencerErr
+}
+
+// Close shuts down the log like Shutdown, and then closes its cache databases
+// and spool, so that the Log can be discarded. Afterwards, submissions fail
+// with ErrLogClosed. TreeSize, RootHash, CheckpointTime, and Checkpoint keep
+// returning the last published tree.
+//
+// If ctx is done before the sequencer stops, Close returns an error and leaves
+// the Log open, since the sequencer might still be using it, and it can be
+// called again. Otherwise, the Log is closed even if the error from Shutdown
+// is returned. Calling Close on a closed Log does nothing and returns nil.
+func (l *Log) Close(ctx context.Context) error {
+	l.closeMu.Lock()
+	defer l.closeMu.Unlock()
+	if l.closed.Load() {
+		return nil
+	}
+	err := l.Shutdown(ctx)
+	if err != nil && ctx.Err() != nil {
+		return err
+	}
+	l.closed.Store(true)
+	return errors.Join(err, l.closeCache())
+}
+
+// pendingLeaves returns the number of entries in the current pool.
+func (l *Log) pendingLeaves() int {
+	l.poolMu.Lock()
+	defer l.poolMu.Unlock()
+	return len(l.currentPool.pendingLeaves)
+}
+
+func sequenceTimeout(c *Config) time.Duration {
+	if c.SequenceTimeout > 0 {
+		return c.SequenceTimeout
 	}
+	return 5 * time.Second
+}
 
-	path := fmt.Sprintf("issuer/%x", fingerprint)
-	l.c.Log.InfoContext(ctx, "observed new issuer", "path", path)
+// errSequenceTimeout is the cause of the context of a round that ran out of
+// Config.SequenceTimeout.
+var errSequenceTimeout = errors.New("sequencing round timed out")
 
-	// First we try to download and check the issuer from the backend.
-	// If it's not there, we upload it.
+var errFatal = errors.New("fatal sequencing error")
 
-	old, err := l.c.Backend.Fetch(ctx, path)
-	if err != nil {
-		upErr := l.c.Backend.Upload(ctx, path, issuer, optsIssuer)
-		l.c.Log.InfoContext(ctx, "uploaded issuer", "path", path, "err", upErr, "fetchErr", err, "size", len(issuer))
-		if upErr != nil {
-			return fmtErrorf("upload error: %w; fetch error: %v", upErr, err)
-		}
-	} else {
-		if !bytes.Equal(old, issuer) {
-			return fmtErrorf("invalid existing issuer: %x", old)
-		}
+// Pause stops sequencing until Resume is called, waiting for the current
+// round, if any, to complete. While paused, no tiles or checkpoints are
+// uploaded or signed, and the rounds of RunSequencer are skipped, including
+// the final one. Submissions are pooled, or rejected if
+// Config.RejectWhilePaused is set. The issuers of pooled submissions are still
+// uploaded, since they are not part of the tree.
+//
+// Pause is meant for incident response, to stop publishing new checkpoints
+// while keeping the process, and its in-memory state, around.
+func (l *Log) Pause() {
+	l.seqMu.Lock()
+	defer l.seqMu.Unlock()
+	if !l.paused.Swap(true) {
+		l.m.SeqPaused.Set(1)
+		l.c.Log.Warn("sequencing paused")
 	}
+}
 
-	l.issuers[fingerprint] = true
-	l.m.Issuers.Set(float64(len(l.issuers)))
-	return nil
+// Resume undoes Pause. If RunSequencer is running, it sequences the pool
+// accumulated while paused right away.
+func (l *Log) Resume() {
+	if !l.paused.Swap(false) {
+		return
+	}
+	l.m.SeqPaused.Set(0)
+	l.c.Log.Info("sequencing resumed")
+	select {
+	case l.flush <- struct{}{}:
+	default:
+	}
 }
 
-func (l *Log) RunSequencer(ctx context.Context, period time.Duration) (err error) {
-	// If the sequencer stops, return errors for all pending and future leaves.
-	defer func() {
-		l.poolMu.Lock()
-		defer l.poolMu.Unlock()
-		l.currentPool.err = err
-		close(l.currentPool.done)
-	}()
+// Paused reports whether sequencing is paused by Pause.
+func (l *Log) Paused() bool {
+	return l.paused.Load()
+}
 
-	// Randomly stagger the sequencers to avoid conflicting for resources.
-	if !testing.Testing() {
-		time.Sleep(time.Duration(mathrand.Int64N(int64(period))))
-	}
+// TreeSize returns the size of the latest tree whose tiles and checkpoint are
+// in object storage.
+//
+// TreeSize, RootHash, CheckpointTime, and Checkpoint read the snapshot of the
+// tree published at the end of each round, so they are safe to call
+// concurrently with RunSequencer, but separate calls might observe different
+// rounds. Checkpoint returns a consistent view of all of them.
+func (l *Log) TreeSize() int64 {
+	return l.state.Load().tree.N
+}
 
-	t := time.NewTicker(period)
-	defer t.Stop()
-	for {
-		select {
-		case <-ctx.Done():
-			l.c.Log.InfoContext(ctx, "sequencer stopped")
-			return ctx.Err()
-		case <-t.C:
-			if err := l.sequence(ctx); err != nil {
-				l.c.Log.ErrorContext(ctx, "fatal sequencing error", "err", err)
-				return err
-			}
-		}
-	}
+// RootHash returns the root hash of the tree returned by TreeSize.
+func (l *Log) RootHash() tlog.Hash {
+	return l.state.Load().tree.Hash
 }
 
-const sequenceTimeout = 5 * time.Second
+// CheckpointTime returns the timestamp of the tree returned by TreeSize.
+func (l *Log) CheckpointTime() time.Time {
+	return time.UnixMilli(l.state.Load().tree.Time)
+}
 
-var errFatal = errors.New("fatal sequencing error")
+// Checkpoint returns the signed checkpoint of the tree returned by TreeSize,
+// as last published to object storage, including any witness cosignatures.
+func (l *Log) Checkpoint() []byte {
+	return bytes.Clone(l.state.Load().published)
+}
 
 func (l *Log) sequence(ctx context.Context) error {
+	l.seqMu.Lock()
+	defer l.seqMu.Unlock()
+	if l.closed.Load() {
+		return ErrLogClosed
+	}
+	if l.paused.Load() {
+		return nil
+	}
+
 	l.poolMu.Lock()
+	var spooled *spoolFile
+	// An empty pool has an empty spool, which doesn't need rotating.
+	if l.spool != nil && len(l.currentPool.pendingLeaves) > 0 {
+		var err error
+		spooled, err = l.spool.rotate()
+		if err != nil {
+			l.poolMu.Unlock()
+			return fmt.Errorf("%w: couldn't rotate spool: %w", errFatal, err)
+		}
+	}
 	p := l.currentPool
 	l.currentPool = newPool()
 	l.inSequencing = p.byHash
+	l.observePool()
 	l.poolMu.Unlock()
 
 	err := l.sequencePool(ctx, p)
 
 	// Once sequencePool returns, the entries are either in the deduplication
-	// cache or finalized with an error. In the latter case, we don't want
-	// a resubmit to deduplicate against the failed sequencing.
+	// cache, finalized with an error, or back in the current pool. In the
+	// second case, we don't want a resubmit to deduplicate against the failed
+	// sequencing.
 	l.poolMu.Lock()
 	l.inSequencing = nil
 	l.poolMu.Unlock()
 
+	// Rejected entries don't need to be replayed either, since their
+	// submitters got an error, and requeued ones were spooled again. After a
+	// fatal error, the next LoadLog will replay the entries, and skip the ones
+	// that made it into the tree.
+	if spooled != nil && err == nil {
+		if err := l.spool.done(spooled); err != nil {
+			l.c.Log.WarnContext(ctx, "failed to remove spool", "err", err)
+		}
+	}
+
 	return err
 }
 
+// requeuePool replaces the current pool with a new one that holds the entries
+// of p, whose round failed without committing the tree, followed by those of
+// the current pool, in the same order, so that they are sequenced by the next
+// round. The wait functions of both pools are forwarded to the new one, except
+// for evicted, if not nil, whose wait function returns p.err.
+//
+// If Config.Spool is set, the entries of p are appended to the current spool
+// generation, since the one they were in is removed by sequence. If that
+// fails, requeuePool returns false and p must fail.
+func (l *Log) requeuePool(ctx context.Context, p *pool, evicted *PendingLogEntry) bool {
+	l.poolMu.Lock()
+	defer l.poolMu.Unlock()
+	if l.spool != nil {
+		var spooled *spoolFile
+		for _, leaf := range p.pendingLeaves {
+			if leaf == evicted {
+				continue
+			}
+			sf, err := l.spool.append(leaf)
+			if err != nil {
+				l.c.Log.ErrorContext(ctx, "failed to spool requeued entries", "err", err)
+				return false
+			}
+			spooled = sf
+		}
+		if spooled != nil {
+			if err := spooled.sync(); err != nil {
+				l.c.Log.ErrorContext(ctx, "failed to sync spool", "err", err)
+				return false
+			}
+		}
+	}
+	cur := l.currentPool
+	q := newPool()
+	q.retries = p.retries + 1
+	q.failures = p.failures
+	for _, from := range []*pool{p, cur} {
+		from.forward = make(map[cacheHash]waitEntryFunc, len(from.pendingLeaves))
+		for _, leaf := range from.pendingLeaves {
+			if leaf == evicted {
+				continue
+			}
+			h := computeCacheHash(leaf.Certificate, leaf.IsPrecert, leaf.IssuerKeyHash)
+			from.forward[h] = q.add(leaf, h)
+		}
+	}
+	close(cur.done)
+	l.currentPool = q
+	l.observePool()
+	return true
+}
+
 func (l *Log) sequencePool(ctx context.Context, p *pool) (err error) {
 	oldSize := l.tree.N
+	start := time.Now()
+	// culprit is the entry that was being added to the tree if that failed.
+	var culprit *PendingLogEntry
 	defer prometheus.NewTimer(l.m.SeqDuration).ObserveDuration()
 	defer func() {
+		var requeued bool
+		if err != nil && errors.Is(context.Cause(ctx), errSequenceTimeout) {
+			err = fmtErrorf("round timed out after %v in the %s phase: %w",
+				time.Since(start).Round(time.Millisecond), l.seqPhase, err)
+		}
 		if err != nil {
+			var evicted *PendingLogEntry
+			if culprit != nil && l.c.EvictAfterFailures > 0 {
+				h := computeCacheHash(culprit.Certificate, culprit.IsPrecert, culprit.IssuerKeyHash)
+				if p.failures == nil {
+					p.failures = make(map[cacheHash]int)
+				}
+				p.failures[h]++
+				if p.failures[h] >= l.c.EvictAfterFailures {
+					delete(p.failures, h)
+					evicted = culprit
+				}
+			}
+			// Only a round that didn't commit the tree can be retried, or its
+			// entries would be sequenced twice. Failures attributed to an
+			// entry are retried until it's evicted.
+			attributed := culprit != nil && l.c.EvictAfterFailures > 0
+			if !errors.Is(err, errFatal) && l.tree.N == oldSize && !l.shuttingDown.Load() &&
+				(p.retries < l.c.RoundRetries || attributed) {
+				requeued = l.requeuePool(ctx, p, evicted)
+			}
+			if requeued && evicted != nil {
+				err = fmtErrorf("entry evicted after %d failed rounds: %w", l.c.EvictAfterFailures, err)
+				l.m.SeqEvictions.Inc()
+			}
 			p.err = err
 			l.c.Log.ErrorContext(ctx, "pool sequencing failed", "old_tree_size", oldSize,
-				"entries", len(p.pendingLeaves), "err", err)
+				"entries", len(p.pendingLeaves), "pool", p.id, "requeued", requeued, "err", err)
+			if l.c.AccessLog != nil {
+				l.c.AccessLog.ErrorContext(ctx, "pool sequencing failed",
+					"entries", len(p.pendingLeaves), "pool", p.id, "err", err)
+			}
 			l.m.SeqCount.With(prometheus.Labels{"error": errorCategory(err)}).Inc()
 
+			l.seqFailures.Add(1)
+
 			// Non-fatal errors are delivered to the requests waiting on this
 			// pool, but do not break the sequencer loop.
 			if !errors.Is(err, errFatal) {
@@ -694,34 +2249,103 @@ func (l *Log) sequencePool(ctx context.Context, p *pool) (err error) {
 			}
 		} else {
 			l.m.SeqCount.With(prometheus.Labels{"error": ""}).Inc()
+			l.seqFailures.Store(0)
+			l.seqLastSuccess.Store(time.Now().UnixNano())
 		}
 		l.m.SeqPoolSize.Observe(float64(len(p.pendingLeaves)))
 
 		close(p.done)
 	}()
 
-	start := time.Now()
-	ctx, cancel := context.WithTimeout(ctx, sequenceTimeout)
+	// The deadline is extended by Config.SequenceTimeoutPerTile once the
+	// tiles to upload are known, by replacing ctx with a child of parent.
+	parent := ctx
+	timeout := sequenceTimeout(l.c)
+	ctx, cancel := context.WithDeadlineCause(parent, start.Add(timeout), errSequenceTimeout)
 	defer cancel()
 
-	timestamp := timeNowUnixMilli()
-	if timestamp <= l.tree.Time {
-		return fmt.Errorf("%w: time did not progress! %d -> %d", errFatal, l.tree.Time, timestamp)
+	now := timeNowUnixMilli(l.c)
+	timestamp, stepped, err := roundTimestamp(now, l.tree.Time, maxClockSkew(l.c))
+	if err != nil {
+		return fmt.Errorf("%w: %w", errFatal, err)
+	}
+	if stepped {
+		// Clocks can be stepped backwards, for example by NTP. The tree head
+		// only needs to be newer than the previous one and the SCTs it covers.
+		l.c.Log.WarnContext(ctx, "clock went backwards, using the previous tree head timestamp",
+			"tree_time", l.tree.Time, "now", now)
+		l.m.SeqClockSkew.Inc()
 	}
 
+	hashing := l.phaseTimer("hash")
 	var tileUploads []*uploadAction
 	edgeTiles := maps.Clone(l.edgeTiles)
+	// Load the current partial data tile, if any, and append to it in place
+	// if it's still backed by dataTileBuf. Appending only writes past the
+	// published bytes, and edgeTiles only gets slices capped at their length.
 	var dataTile []byte
-	// Load the current partial data tile, if any.
 	if t, ok := edgeTiles[-1]; ok && t.W < sunlight.TileWidth {
-		dataTile = bytes.Clone(t.B)
+		if buf := l.dataTileBuf; cap(buf) >= len(t.B) && len(t.B) > 0 && &buf[:1][0] == &t.B[0] {
+			dataTile = buf[:len(t.B)]
+		} else {
+			dataTile = append(make([]byte, 0, 2*len(t.B)), t.B...)
+		}
+	} else if len(l.dataTileBuf) == 0 {
+		// A buffer left empty by a full tile was never published.
+		dataTile = l.dataTileBuf
+	}
+	// Full tiles are never overwritten, so if the Backend supports it, upload
+	// them only if they don't exist yet, to detect a concurrent sequencer.
+	dataTileOpts, hashTileOpts := optsDataTile, optsHashTile
+	if supportsCreateOnly(l.c.Backend) {
+		dataTileOpts, hashTileOpts = optsDataTileCreateOnly, optsHashTileCreateOnly
 	}
 	newHashes := make(map[int64]tlog.Hash)
 	hashReader := l.hashReader(newHashes)
 	n := l.tree.N
+
+	// Full hash tiles don't change once their last leaf is hashed, so they are
+	// read in the background while the loop hashes the rest of the round.
+	// newHashesMu serializes the loop's writes to newHashes with those reads.
+	var newHashesMu sync.RWMutex
+	lockedHashReader := tlog.HashReaderFunc(func(indexes []int64) ([]tlog.Hash, error) {
+		newHashesMu.RLock()
+		defer newHashesMu.RUnlock()
+		return hashReader(indexes)
+	})
+	fullTiles := make(chan tlog.Tile, 16)
+	fullTilesDone := make(chan struct{})
+	fullTileData := make(map[tlog.Tile][]byte)
+	var fullTileErr error
+	go func() {
+		defer close(fullTilesDone)
+		for tile := range fullTiles {
+			if fullTileErr != nil || testingOnlySequentialTiles {
+				continue
+			}
+			data, err := tlog.ReadTileData(tile, lockedHashReader)
+			if err != nil {
+				fullTileErr = fmtErrorf("couldn't generate tile %v: %w", tile, err)
+				continue
+			}
+			fullTileData[tile] = data
+		}
+	}()
+	waitFullTiles := sync.OnceFunc(func() {
+		close(fullTiles)
+		<-fullTilesDone
+	})
+	defer waitFullTiles()
+
 	var sequencedLeaves []*sunlight.LogEntry
-	for _, leaf := range p.pendingLeaves {
-		leaf := leaf.asLogEntry(n, timestamp)
+	for _, pending := range p.pendingLeaves {
+		if testingOnlyFailLeaf != nil {
+			if err := testingOnlyFailLeaf(pending); err != nil {
+				culprit = pending
+				return fmtErrorf("couldn't serialize leaf %d: %w", n, err)
+			}
+		}
+		leaf := pending.asLogEntry(n, timestamp)
 		sequencedLeaves = append(sequencedLeaves, leaf)
 		oldTileSize := len(dataTile)
 		dataTile = sunlight.AppendTileLeaf(dataTile, leaf)
@@ -732,12 +2356,15 @@ func (l *Log) sequencePool(ctx context.Context, p *pool) (err error) {
 		// the new tiles).
 		hashes, err := tlog.StoredHashes(n, leaf.MerkleTreeLeaf(), hashReader)
 		if err != nil {
+			culprit = pending
 			return fmtErrorf("couldn't compute new hashes for leaf %d: %w", n, err)
 		}
+		newHashesMu.Lock()
 		for i, h := range hashes {
 			id := tlog.StoredHashIndex(0, n) + int64(i)
 			newHashes[id] = h
 		}
+		newHashesMu.Unlock()
 
 		n++
 
@@ -745,34 +2372,55 @@ func (l *Log) sequencePool(ctx context.Context, p *pool) (err error) {
 		if n%sunlight.TileWidth == 0 {
 			tile := tlog.TileForIndex(sunlight.TileHeight, tlog.StoredHashIndex(0, n-1))
 			tile.L = -1
+			dataTile = dataTile[:len(dataTile):len(dataTile)]
 			edgeTiles[-1] = tileWithBytes{tile, dataTile}
 			l.c.Log.DebugContext(ctx, "staging full data tile",
 				"tree_size", n, "tile", tile, "size", len(dataTile))
 			l.m.SeqDataTileSize.Observe(float64(len(dataTile)))
 			tileUploads = append(tileUploads, &uploadAction{
-				sunlight.TilePath(tile), dataTile, optsDataTile})
-			dataTile = nil
+				sunlight.TilePath(tile), dataTile, dataTileOpts})
+			// The next data tile is likely to have a similar size.
+			dataTile = make([]byte, 0, len(dataTile))
+
+			// The level 0 hash tile, and any higher level tile that ends
+			// at the same leaf, are now full.
+			for level, width := 0, int64(sunlight.TileWidth); n%width == 0; level++ {
+				fullTiles <- tlog.Tile{H: sunlight.TileHeight, L: level,
+					N: n/width - 1, W: sunlight.TileWidth}
+				width *= sunlight.TileWidth
+			}
 		}
 	}
+	waitFullTiles()
+	if fullTileErr != nil {
+		return fullTileErr
+	}
 
 	// Stage leftover partial data tile, if any.
 	if n != l.tree.N && n%sunlight.TileWidth != 0 {
 		tile := tlog.TileForIndex(sunlight.TileHeight, tlog.StoredHashIndex(0, n-1))
 		tile.L = -1
-		edgeTiles[-1] = tileWithBytes{tile, dataTile}
+		partial := dataTile[:len(dataTile):len(dataTile)]
+		edgeTiles[-1] = tileWithBytes{tile, partial}
 		l.c.Log.DebugContext(ctx, "staging partial data tile",
-			"tree_size", n, "tile", tile, "size", len(dataTile))
-		l.m.SeqDataTileSize.Observe(float64(len(dataTile)))
+			"tree_size", n, "tile", tile, "size", len(partial))
+		l.m.SeqDataTileSize.Observe(float64(len(partial)))
 		tileUploads = append(tileUploads, &uploadAction{
-			sunlight.TilePath(tile), dataTile, optsDataTile})
+			sunlight.TilePath(tile), partial, optsPartialDataTile})
 	}
+	// Even if the round fails, the next one can append to the same buffer,
+	// overwriting the bytes past the published edge data tile.
+	l.dataTileBuf = dataTile
 
-	// Produce and stage new tree tiles.
+	// Produce and stage new tree tiles, in order, reusing the full ones.
 	tiles := tlog.NewTiles(sunlight.TileHeight, l.tree.N, n)
 	for _, tile := range tiles {
-		data, err := tlog.ReadTileData(tile, hashReader)
-		if err != nil {
-			return fmtErrorf("couldn't generate tile %v: %w", tile, err)
+		data, ok := fullTileData[tile]
+		if !ok {
+			data, err = tlog.ReadTileData(tile, hashReader)
+			if err != nil {
+				return fmtErrorf("couldn't generate tile %v: %w", tile, err)
+			}
 		}
 		// Assuming NewTilesForSize produces tiles in order, this tile should
 		// always be further right than the one in edgeTiles, but double check.
@@ -781,8 +2429,19 @@ func (l *Log) sequencePool(ctx context.Context, p *pool) (err error) {
 		}
 		l.c.Log.DebugContext(ctx, "staging tree tile", "old_tree_size", oldSize,
 			"tree_size", n, "tile", tile, "size", len(data))
+		opts := hashTileOpts
+		if tile.W < sunlight.TileWidth {
+			// Partial tiles are superseded, and might be garbage collected.
+			opts = optsPartialHashTile
+		}
 		tileUploads = append(tileUploads, &uploadAction{
-			sunlight.TilePath(tile), data, optsHashTile})
+			sunlight.TilePath(tile), data, opts})
+	}
+
+	if perTile := l.c.SequenceTimeoutPerTile; perTile > 0 {
+		deadline := start.Add(timeout + time.Duration(len(tileUploads))*perTile)
+		ctx, cancel = context.WithDeadlineCause(parent, deadline, errSequenceTimeout)
+		defer cancel()
 	}
 
 	if testingOnlyPauseSequencing != nil {
@@ -793,7 +2452,68 @@ func (l *Log) sequencePool(ctx context.Context, p *pool) (err error) {
 	if err != nil {
 		return fmtErrorf("couldn't compute tree head: %w", err)
 	}
+	hashing.ObserveDuration()
+
+	if err := l.commitTree(ctx, tree, edgeTiles, tileUploads); err != nil {
+		return err
+	}
+	p.timestamp = timestamp
+	p.firstLeafIndex = oldSize
+
+	// At this point if the cache put fails, there's no reason to return errors
+	// to users. The only consequence of cache false negatives are duplicated
+	// leaves anyway. In fact, an error might cause the clients to resumbit,
+	// producing more cache false negatives and duplicates.
+	if err := l.cachePut(sequencedLeaves); err != nil {
+		l.c.Log.ErrorContext(ctx, "cache put failed",
+			"tree_size", tree.N, "entries", n-oldSize, "err", err)
+		l.m.CachePutErrors.Inc()
+	}
+
+	if l.c.PartialTileGC {
+		// Failures are not returned, since the pool was sequenced anyway, and
+		// the next round will try again.
+		if err := l.gcPartialTiles(ctx, edgeTiles); err != nil {
+			l.c.Log.WarnContext(ctx, "partial tile garbage collection failed",
+				"tree_size", tree.N, "err", err)
+			l.m.GCErrors.Inc()
+		}
+	}
+	if l.c.StagingGC {
+		// The checkpoint in object storage now matches the lock checkpoint,
+		// so LoadLog won't need to apply this bundle.
+		if err := deleteObject(ctx, l.c.Backend, stagingPath(tree.Tree)); err != nil {
+			l.c.Log.WarnContext(ctx, "staging bundle garbage collection failed",
+				"tree_size", tree.N, "err", err)
+			l.m.GCErrors.Inc()
+		}
+	}
+
+	for _, t := range edgeTiles {
+		l.c.Log.DebugContext(ctx, "edge tile", "tile", t)
+	}
+	l.c.Log.Info("sequenced pool",
+		"tree_size", tree.N, "entries", n-oldSize,
+		"tiles", len(tileUploads), "timestamp", timestamp,
+		"elapsed", time.Since(start))
+	l.m.SeqTiles.Add(float64(len(tileUploads)))
+	for _, u := range tileUploads {
+		l.m.SeqTileBytes.Add(float64(len(u.data)))
+	}
+	l.m.TreeSize.Set(float64(tree.N))
+	l.m.TreeTime.Set(float64(timestamp) / 1000)
+
+	return nil
+}
 
+// commitTree publishes tree, whose right-most tiles are edgeTiles, after
+// uploading tileUploads, the tiles it adds to the current tree, to a staging
+// bundle, and committing its checkpoint to the lock backend.
+//
+// If it returns a fatal error, the state of the lock backend or of object
+// storage is unknown, and LoadLog will need to reconcile them.
+func (l *Log) commitTree(ctx context.Context, tree treeWithTimestamp, edgeTiles map[int]tileWithBytes, tileUploads []*uploadAction) error {
+	oldSize := l.tree.N
 	// Upload tiles to staging, where they can be recovered by LoadLog if we
 	// crash right after updating the lock database. See also
 	// https://github.com/FiloSottile/sunlight/issues/11.
@@ -803,15 +2523,31 @@ func (l *Log) sequencePool(ctx context.Context, p *pool) (err error) {
 	}
 	stagingPath := stagingPath(tree.Tree)
 	l.c.Log.DebugContext(ctx, "uploading staged tiles", "old_tree_size", oldSize,
-		"tree_size", n, "path", stagingPath, "size", len(stagedUploads))
+		"tree_size", tree.N, "path", stagingPath, "size", len(stagedUploads))
+	staging := l.phaseTimer("stage")
 	if err := l.c.Backend.Upload(ctx, stagingPath, stagedUploads, optsStaging); err != nil {
 		return fmtErrorf("couldn't upload staged tiles: %w", err)
 	}
+	staging.ObserveDuration()
 
+	signing := l.phaseTimer("sign")
 	checkpoint, err := signTreeHead(l.c, tree)
 	if err != nil {
 		return fmtErrorf("couldn't sign checkpoint: %w", err)
 	}
+	signing.ObserveDuration()
+
+	locking := l.phaseTimer("lock")
+	if l.lease != nil {
+		lease, err := l.c.Lease.Renew(ctx, l.lease, leaseDuration(l.c))
+		if errors.Is(err, ErrLeaseLost) {
+			return fmt.Errorf("%w: lost the sequencing lease: %w", errFatal, err)
+		}
+		if err != nil {
+			return fmtErrorf("couldn't renew sequencing lease: %w", err)
+		}
+		l.lease = lease
+	}
 	l.c.Log.DebugContext(ctx, "uploading checkpoint", "size", len(checkpoint))
 	newLock, err := l.c.Lock.Replace(ctx, l.lockCheckpoint, checkpoint)
 	if err != nil {
@@ -820,58 +2556,153 @@ func (l *Log) sequencePool(ctx context.Context, p *pool) (err error) {
 		// to a good state after restart.
 		return fmt.Errorf("%w: couldn't upload checkpoint to database: %w", errFatal, err)
 	}
+	locking.ObserveDuration()
 
-	// At this point the pool is fully serialized: new entries were persisted to
+	// At this point the tree is fully serialized: new entries were persisted to
 	// object storage (in staging) and the checkpoint was committed to the
 	// database. If we were to crash after this, recovery would be clean from
 	// database and object storage.
-	p.timestamp = timestamp
-	p.firstLeafIndex = l.tree.N
+	state, err := newLogState(l.c, tree, edgeTiles, checkpoint)
+	if err != nil {
+		// This can't really happen, since we just produced the checkpoint, but
+		// we can't continue without updating the state.
+		return fmtErrorf("%w: couldn't extract tree head signature: %w", errFatal, err)
+	}
 	l.tree = tree
 	l.lockCheckpoint = newLock
 	l.edgeTiles = edgeTiles
 
 	// Use applyStagedUploads instead of going over tileUploads directly, to
 	// exercise the same code path as LoadLog.
-	if err := applyStagedUploads(ctx, l.c, stagedUploads); err != nil {
+	uploading := l.phaseTimer("upload")
+	if err := applyStagedUploads(ctx, l.c, stagedUploads, l.m.UploadsInFlight); err != nil {
 		// This is also fatal, since we can't continue leaving behind missing
 		// tiles. The next run of sequence would not upload them again, while
 		// LoadLog will retry uploading them from the staging bundle.
 		return fmtErrorf("%w: couldn't upload a tile: %w", errFatal, err)
 	}
+	uploading.ObserveDuration()
+
+	witnessing := l.phaseTimer("witness")
+	checkpoint, err = l.cosignCheckpoint(ctx, state, checkpoint)
+	if err != nil {
+		// Like a failed checkpoint upload below, this leaves the committed
+		// tree unpublished until the next round.
+		return fmtErrorf("couldn't collect witness cosignatures: %w", err)
+	}
+	witnessing.ObserveDuration()
 
-	if err := l.c.Backend.Upload(ctx, "checkpoint", checkpoint, optsCheckpoint); err != nil {
+	publishing := l.phaseTimer("checkpoint")
+	if err := l.uploadCheckpoint(ctx, checkpoint); err != nil {
 		// Return an error so we don't produce SCTs that, although safely
 		// serialized, wouldn't be part of a publicly visible tree.
-		return fmtErrorf("couldn't upload checkpoint to object storage: %w", err)
+		return err
 	}
+	publishing.ObserveDuration()
 
-	// At this point if the cache put fails, there's no reason to return errors
-	// to users. The only consequence of cache false negatives are duplicated
-	// leaves anyway. In fact, an error might cause the clients to resumbit,
-	// producing more cache false negatives and duplicates.
-	if err := l.cachePut(sequencedLeaves); err != nil {
-		l.c.Log.ErrorContext(ctx, "cache put failed",
-			"tree_size", tree.N, "entries", n-oldSize, "err", err)
-		l.m.CachePutErrors.Inc()
-	}
+	// Only publish the new state once all its tiles are in object storage, so
+	// that readers of the state can fetch any tile of the tree.
+	state.published = checkpoint
+	l.state.Store(state)
+	return nil
+}
 
-	for _, t := range edgeTiles {
-		l.c.Log.DebugContext(ctx, "edge tile", "tile", t)
-	}
-	l.c.Log.Info("sequenced pool",
-		"tree_size", tree.N, "entries", n-oldSize,
-		"tiles", len(tileUploads), "timestamp", timestamp,
-		"elapsed", time.Since(start))
-	l.m.SeqTiles.Add(float64(len(tileUploads)))
-	l.m.TreeSize.Set(float64(tree.N))
-	l.m.TreeTime.Set(float64(timestamp) / 1000)
+const (
+	checkpointUploadAttempts = 3
+	checkpointUploadBackoff  = 50 * time.Millisecond
+)
 
+// uploadCheckpoint uploads checkpoint to object storage, after it was
+// committed to the lock backend.
+//
+// If the upload fails, it fetches the checkpoint from object storage to find
+// out whether the upload was persisted anyway, for example by a timeout after
+// commit, in which case it succeeds. If object storage still has the previous
+// checkpoint, the upload is retried with backoff, and then a non-fatal error is
+// returned. If it has a checkpoint that this Log never uploaded, some other
+// process is writing to the same storage, and a fatal error is returned.
+func (l *Log) uploadCheckpoint(ctx context.Context, checkpoint []byte) error {
+	backoff := checkpointUploadBackoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = l.c.Backend.Upload(ctx, "checkpoint", checkpoint, optsCheckpoint)
+		if err == nil {
+			break
+		}
+		l.c.Log.WarnContext(ctx, "checkpoint upload failed, checking object storage",
+			"attempt", attempt, "err", err)
+		if ci, ok := l.c.Backend.(cacheInvalidator); ok {
+			ci.Invalidate("checkpoint")
+		}
+		current, fetchErr := l.c.Backend.Fetch(ctx, "checkpoint")
+		switch {
+		case fetchErr != nil:
+			// We can't tell, so the next round will have to accept either.
+			l.unconfirmedCheckpoint = checkpoint
+		case bytes.Equal(current, checkpoint):
+			l.c.Log.InfoContext(ctx, "checkpoint upload failed but was persisted")
+			err = nil
+		case bytes.Equal(current, l.publishedCheckpoint),
+			l.unconfirmedCheckpoint != nil && bytes.Equal(current, l.unconfirmedCheckpoint):
+			l.publishedCheckpoint, l.unconfirmedCheckpoint = current, nil
+		default:
+			return fmt.Errorf("%w: checkpoint in object storage is neither the previous nor the new one: %w",
+				errFatal, err)
+		}
+		if err == nil || attempt == checkpointUploadAttempts {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return fmtErrorf("couldn't upload checkpoint to object storage: %w", err)
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+	if err != nil {
+		return fmtErrorf("couldn't upload checkpoint to object storage: %w", err)
+	}
+	l.publishedCheckpoint, l.unconfirmedCheckpoint = checkpoint, nil
 	return nil
 }
 
 var testingOnlyPauseSequencing func()
 
+// testingOnlyFailLeaf, if not nil, is called for each entry added to the tree,
+// and fails the round if it returns an error.
+var testingOnlyFailLeaf func(*PendingLogEntry) error
+
+// testingOnlyFailCachePut, if not nil, is called by cachePut, which fails
+// without writing anything if it returns an error.
+var testingOnlyFailCachePut func() error
+
+// testingOnlySequentialTiles, if true, makes sequencePool read all hash tiles
+// after hashing the whole round, instead of reading full ones in the
+// background.
+var testingOnlySequentialTiles bool
+
+func leaseHolder(c *Config) string {
+	if c.LeaseHolder != "" {
+		return c.LeaseHolder
+	}
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%s/%d", hostname, os.Getpid())
+}
+
+func leaseDuration(c *Config) time.Duration {
+	if c.LeaseDuration > 0 {
+		return c.LeaseDuration
+	}
+	return 30 * time.Second
+}
+
+func maxClockSkew(c *Config) time.Duration {
+	if c.MaxClockSkew > 0 {
+		return c.MaxClockSkew
+	}
+	return 2 * time.Second
+}
+
 type uploadAction struct {
 	key  string
 	data []byte
@@ -903,8 +2734,8 @@ func marshalStagedUploads(uploads []*uploadAction) ([]byte, error) {
 	return buffer.Bytes(), nil
 }
 
-func applyStagedUploads(ctx context.Context, config *Config, stagedUploads []byte) error {
-	g, gctx := errgroup.WithContext(ctx)
+func applyStagedUploads(ctx context.Context, config *Config, stagedUploads []byte, inFlight prometheus.Gauge) error {
+	var objects []Object
 	reader := tar.NewReader(bytes.NewReader(stagedUploads))
 	for {
 		header, err := reader.Next()
@@ -927,13 +2758,78 @@ func applyStagedUploads(ctx context.Context, config *Config, stagedUploads []byt
 		if err != nil {
 			return fmtErrorf("error reading tar data: %w", err)
 		}
+		objects = append(objects, Object{Key: key, Data: data, Opts: opts})
+	}
+	ctx = withUploadLimit(ctx, uploadConcurrency(config), inFlight)
+	err := batchUpload(ctx, config.Backend, objects)
+	if errors.Is(err, ErrObjectExists) {
+		// Some full tiles were already uploaded, which is expected if a
+		// previous attempt was interrupted. Check they are the same.
+		err = uploadCheckingExisting(ctx, config.Backend, objects)
+	}
+	return err
+}
+
+// batchUpload uploads objects with b.BatchUpload if b implements
+// BatchBackend, or with concurrent Upload calls otherwise, up to the limit
+// set by withUploadLimit.
+func batchUpload(ctx context.Context, b Backend, objects []Object) error {
+	if bb, ok := b.(BatchBackend); ok {
+		return bb.BatchUpload(ctx, objects)
+	}
+	lim := uploadLimitFromContext(ctx)
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(lim.limit)
+	for _, o := range objects {
 		g.Go(func() error {
-			return config.Backend.Upload(gctx, key, data, opts)
+			defer lim.track()()
+			return b.Upload(gctx, o.Key, o.Data, o.Opts)
 		})
 	}
 	return g.Wait()
 }
 
+const defaultUploadConcurrency = 16
+
+func uploadConcurrency(c *Config) int {
+	if c.UploadConcurrency > 0 {
+		return c.UploadConcurrency
+	}
+	return defaultUploadConcurrency
+}
+
+// uploadLimit is carried in the context of batchUpload, so that it applies
+// to the concurrent Upload calls even below Backend decorators, which forward
+// BatchUpload to batchUpload.
+type uploadLimit struct {
+	limit    int
+	inFlight prometheus.Gauge // nil if not tracked
+}
+
+type uploadLimitKey struct{}
+
+// withUploadLimit returns a context that limits the concurrent Upload calls of
+// batchUpload to limit, and tracks them in inFlight, if not nil.
+func withUploadLimit(ctx context.Context, limit int, inFlight prometheus.Gauge) context.Context {
+	return context.WithValue(ctx, uploadLimitKey{}, uploadLimit{limit, inFlight})
+}
+
+func uploadLimitFromContext(ctx context.Context) uploadLimit {
+	if lim, ok := ctx.Value(uploadLimitKey{}).(uploadLimit); ok {
+		return lim
+	}
+	return uploadLimit{limit: defaultUploadConcurrency}
+}
+
+// track records an upload in flight, until the returned function is called.
+func (lim uploadLimit) track() func() {
+	if lim.inFlight == nil {
+		return func() {}
+	}
+	lim.inFlight.Inc()
+	return lim.inFlight.Dec
+}
+
 func stagingPath(tree tlog.Tree) string {
 	// Encode size in three digit chunks like [sunlight.TilePath].
 	n := tree.N
@@ -1006,6 +2902,35 @@ func signTreeHead(c *Config, tree treeWithTimestamp) (checkpoint []byte, err err
 	return signedNote, nil
 }
 
+// treeHeadSignature extracts the RFC 6962 TreeHeadSignature from a checkpoint
+// produced by signTreeHead.
+func treeHeadSignature(c *Config, checkpoint []byte) ([]byte, error) {
+	v, err := sunlight.NewRFC6962Verifier(c.Name, c.Key.Public())
+	if err != nil {
+		return nil, fmt.Errorf("couldn't construct verifier: %w", err)
+	}
+	n, err := note.Open(checkpoint, note.VerifierList(v))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't verify checkpoint signature: %w", err)
+	}
+	for _, sig := range n.Sigs {
+		if sig.Hash != v.KeyHash() {
+			continue
+		}
+		b, err := base64.StdEncoding.DecodeString(sig.Base64)
+		if err != nil {
+			return nil, err
+		}
+		// The note signature is the key hash followed by a RFC6962NoteSignature.
+		s := cryptobyte.String(b)
+		if !s.Skip(4 /* key hash */) || !s.Skip(8 /* timestamp */) || s.Empty() {
+			return nil, errors.New("malformed RFC6962NoteSignature")
+		}
+		return s, nil
+	}
+	return nil, errors.New("missing RFC 6962 signature")
+}
+
 type injectedSigner struct {
 	v   note.Verifier
 	sig []byte
@@ -1059,6 +2984,18 @@ func greaseSignatures(name string) []note.Signature {
 	return signatures
 }
 
+// isGreaseSignature reports whether sig might have been added by
+// greaseSignatures under the name of the log.
+func isGreaseSignature(sig note.Signature) bool {
+	for i := range 100 {
+		h := sha256.Sum256(append([]byte("grease\n"), byte(i)))
+		if sig.Hash == binary.BigEndian.Uint32(h[:]) {
+			return true
+		}
+	}
+	return false
+}
+
 // newEd25519Signer can be removed once note.NewEd25519SignerKey is added.
 func newEd25519Signer(name string, key ed25519.PrivateKey) (note.Signer, error) {
 	vk, err := note.NewEd25519VerifierKey(name, key.Public().(ed25519.PublicKey))
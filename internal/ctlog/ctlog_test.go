@@ -2,6 +2,7 @@ package ctlog_test
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"crypto/ecdsa"
 	"crypto/ed25519"
@@ -10,27 +11,57 @@ import (
 	"crypto/sha256"
 	"crypto/x509"
 	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"math"
 	mathrand "math/rand"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
 	"reflect"
+	"regexp"
 	"slices"
 	"strconv"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 
 	"filippo.io/sunlight"
 	"filippo.io/sunlight/internal/ctlog"
+	"filippo.io/sunlight/internal/ctlog/ctlogtest"
+	"github.com/fsouza/fake-gcs-server/fakestorage"
 	ct "github.com/google/certificate-transparency-go"
+	"github.com/google/certificate-transparency-go/client"
+	"github.com/google/certificate-transparency-go/jsonclient"
 	"github.com/google/certificate-transparency-go/tls"
+	ctx509 "github.com/google/certificate-transparency-go/x509"
+	"github.com/prometheus/client_golang/prometheus"
+	merkleproof "github.com/transparency-dev/merkle/proof"
+	"github.com/transparency-dev/merkle/rfc6962"
+	"golang.org/x/mod/sumdb/note"
+	"golang.org/x/mod/sumdb/tlog"
+	"golang.org/x/sync/errgroup"
 )
 
 var globalTime = time.Now().UnixMilli()
 
 func monotonicTime() int64 { return atomic.AddInt64(&globalTime, 1) }
 
-func init() { ctlog.SetTimeNowUnixMilli(monotonicTime) }
-
 var longFlag = flag.Bool("long", false, "run especially slow tests")
 
 func TestSequenceOneLeaf(t *testing.T) {
@@ -189,6 +220,7 @@ func TestSequenceUploadPaths(t *testing.T) {
 		"issuer/6b23c0d5f35d1b11f9b683f0b0a617355deb11277d91ae091d399c655b87940d",
 		"issuer/81365bbc90b5b3991c762eebada7c6d84d1e39a0a1d648cb4fe5a9890b089da8",
 		"issuer/df7e70e5021544f4834bbee64a9e3789febc4be81470df629cad6ddb03320a5c",
+		"log-config",
 		"staging/261/0a4f1a4119ca89dc90a612834c0da004f5d1b04a5aad89b88df26a904e4a4f0f",
 		"staging/527/0c3e2c4127196a1a5abb8c6d94d3607a92b510e01004607b910eb0c7ba27f710",
 		"tile/0/000",
@@ -292,16 +324,33 @@ func testDuplicates(t *testing.T, addWithSeed func(*testing.T, *TestLog, int64)
 		t.Errorf("got timestamp %d, expected %d", e22.Timestamp, e21.Timestamp)
 	}
 
-	// A failed sequencing immediately allows resubmission (i.e., the failed
-	// entry in the inSequencing pool is not picked up).
+	// A failed sequencing is reported to both duplicates in the byHash pool,
+	// and immediately allows resubmission (i.e., the failed entry in the
+	// inSequencing pool is not picked up).
 
 	tl.Config.Backend.(*MemoryBackend).UploadCallback = failStagingButPersist
 	addCertificateExpectFailureWithSeed(t, tl, 3)
+	addCertificateExpectFailureWithSeed(t, tl, 3)
 	fatalIfErr(t, tl.Log.Sequence())
 
 	tl.Config.Backend.(*MemoryBackend).UploadCallback = nil
 	addCertificateWithSeed(t, tl, 3)
 	fatalIfErr(t, tl.Log.Sequence())
+
+	// A duplicate from the cache, after a restart.
+
+	tl = ReloadLog(t, tl)
+	wait04 := addWithSeed(t, tl, 0)
+	fatalIfErr(t, tl.Log.Sequence())
+	e04, err := wait04(context.Background())
+	fatalIfErr(t, err)
+
+	if e04.LeafIndex != e01.LeafIndex {
+		t.Errorf("got leaf index %d, expected %d", e04.LeafIndex, e01.LeafIndex)
+	}
+	if e04.Timestamp != e01.Timestamp {
+		t.Errorf("got timestamp %d, expected %d", e04.Timestamp, e01.Timestamp)
+	}
 }
 
 func TestReloadLog(t *testing.T) {
@@ -334,6 +383,349 @@ func testReloadLog(t *testing.T, add func(*testing.T, *TestLog) func(context.Con
 	}
 }
 
+func TestReloadLogMixedEntries(t *testing.T) {
+	// LoadLog verifies the right edge data tile against the level 0 tile, so
+	// it needs to parse both entry types, wherever they are in the tile.
+	tl := NewEmptyTestLog(t)
+	tl.Quiet()
+	// isPrecert picks the type of entry i: precerts at the start and end of
+	// the full tile, and a mix in between.
+	isPrecert := func(i int64) bool {
+		return i == 0 || i == tileWidth-1 || i%3 == 1
+	}
+	var n int64
+	for _, size := range []int64{1, 2, tileWidth / 2, tileWidth - 1, tileWidth, tileWidth + 1, tileWidth + 3} {
+		for ; n < size; n++ {
+			if isPrecert(n) {
+				addPreCertificate(t, tl)
+			} else {
+				addCertificate(t, tl)
+			}
+		}
+		fatalIfErr(t, tl.Log.Sequence())
+		tl.CheckLog(n)
+
+		tl = ReloadLog(t, tl)
+		fatalIfErr(t, tl.Log.Sequence())
+		tl.CheckLog(n)
+	}
+	for _, tile := range []tlog.Tile{
+		{H: sunlight.TileHeight, L: -1, N: 0, W: tileWidth},
+		{H: sunlight.TileHeight, L: -1, N: 1, W: int(n - tileWidth)},
+	} {
+		b, err := tl.Config.Backend.Fetch(context.Background(), sunlight.TilePath(tile))
+		fatalIfErr(t, err)
+		for i := range tile.W {
+			e, rest, err := sunlight.ReadTileLeaf(b)
+			fatalIfErr(t, err)
+			b = rest
+			if e.IsPrecert != isPrecert(e.LeafIndex) {
+				t.Errorf("entry %d: got IsPrecert %v, expected %v", e.LeafIndex, e.IsPrecert, isPrecert(e.LeafIndex))
+			}
+			if idx := tile.N*tileWidth + int64(i); e.LeafIndex != idx {
+				t.Errorf("got leaf index %d, expected %d", e.LeafIndex, idx)
+			}
+		}
+	}
+}
+
+func TestReloadLogConcurrentFetches(t *testing.T) {
+	tl := NewEmptyTestLog(t)
+	for range tileWidth + 5 {
+		addCertificate(t, tl)
+	}
+	fatalIfErr(t, tl.Log.Sequence())
+
+	// The right edge is a partial level 0 tile, a level 1 tile, and a partial
+	// data tile, which should all be fetched at the same time.
+	fb := NewFaultBackend(tl.Config.Backend)
+	fb.Delay = 50 * time.Millisecond
+	tl.Config.Backend = fb
+	tl = ReloadLog(t, tl)
+	fetches, maxConcurrent := fb.Fetches(), fb.MaxConcurrentFetches()
+	fb.Heal()
+	tl.CheckLog(tileWidth + 5)
+
+	if maxConcurrent < 3 {
+		t.Errorf("got at most %d concurrent fetches, expected at least 3", maxConcurrent)
+	}
+	seen := make(map[string]bool)
+	for _, key := range fetches {
+		if strings.HasPrefix(key, "tile/") && seen[key] {
+			t.Errorf("tile %q fetched more than once", key)
+		}
+		seen[key] = true
+	}
+	for _, key := range []string{"tile/0/001.p/5", "tile/1/000.p/1", "tile/data/001.p/5"} {
+		if !seen[key] {
+			t.Errorf("tile %q not fetched, got %v", key, fetches)
+		}
+	}
+}
+
+func TestTreeAccessors(t *testing.T) {
+	tl := NewEmptyTestLog(t)
+	check := func(size int64) {
+		t.Helper()
+		ts := tl.CheckLog(size)
+		c := tl.Checkpoint()
+		if got := tl.Log.TreeSize(); got != size {
+			t.Errorf("TreeSize: got %d, expected %d", got, size)
+		}
+		if got := tl.Log.RootHash(); got != c.Hash {
+			t.Errorf("RootHash: got %v, expected %v", got, c.Hash)
+		}
+		if got := tl.Log.CheckpointTime().UnixMilli(); got != ts {
+			t.Errorf("CheckpointTime: got %d, expected %d", got, ts)
+		}
+		sth, err := tl.Config.Backend.Fetch(context.Background(), "checkpoint")
+		fatalIfErr(t, err)
+		if got := tl.Log.Checkpoint(); !bytes.Equal(got, sth) {
+			t.Errorf("Checkpoint: got %q, expected %q", got, sth)
+		}
+	}
+	check(0)
+	addCertificate(t, tl)
+	fatalIfErr(t, tl.Log.Sequence())
+	check(1)
+	tl = ReloadLog(t, tl)
+	check(1)
+
+	// The accessors don't race with the sequencer, and never go backwards.
+	v, err := sunlight.NewRFC6962Verifier("example.com/TestLog", tl.Config.Key.Public())
+	fatalIfErr(t, err)
+	done := make(chan struct{})
+	errc := make(chan error, 1)
+	go func() {
+		var size int64
+		for {
+			select {
+			case <-done:
+				errc <- nil
+				return
+			default:
+			}
+			n, err := note.Open(tl.Log.Checkpoint(), note.VerifierList(v))
+			if err != nil {
+				errc <- err
+				return
+			}
+			c, err := sunlight.ParseCheckpoint(n.Text)
+			if err != nil {
+				errc <- err
+				return
+			}
+			if c.N < size || tl.Log.TreeSize() < c.N {
+				errc <- fmt.Errorf("tree went backwards: %d, then %d, then %d", size, c.N, tl.Log.TreeSize())
+				return
+			}
+			size = c.N
+		}
+	}()
+	for range 20 {
+		addCertificate(t, tl)
+		fatalIfErr(t, tl.Log.Sequence())
+	}
+	close(done)
+	fatalIfErr(t, <-errc)
+	check(21)
+}
+
+func TestFullAudit(t *testing.T) {
+	// newLog returns a log with full data tiles and a partial one. The seeds
+	// are distinct, since math/rand only has 2³¹ different sources, which
+	// makes a duplicate among random ones likely for the larger logs.
+	newLog := func(t *testing.T, full int) (*TestLog, *MemoryBackend) {
+		tl := NewEmptyTestLog(t)
+		tl.Quiet()
+		var seed int64
+		for range full {
+			for range tileWidth {
+				seed++
+				addCertificateWithSeed(t, tl, seed)
+			}
+			fatalIfErr(t, tl.Log.Sequence())
+		}
+		for range 5 {
+			seed++
+			addCertificateWithSeed(t, tl, seed)
+		}
+		fatalIfErr(t, tl.Log.Sequence())
+		tl.CheckLog(int64(full*tileWidth + 5))
+		return tl, tl.Config.Backend.(*MemoryBackend)
+	}
+	load := func(t *testing.T, tl *TestLog, opts *ctlog.LoadLogOptions) error {
+		l, err := ctlog.LoadLogWithOptions(context.Background(), tl.Config, opts)
+		if err == nil {
+			fatalIfErr(t, l.CloseCache())
+		}
+		return err
+	}
+
+	t.Run("Consistent", func(t *testing.T) {
+		tl, _ := newLog(t, 3)
+		var progress []int64
+		fatalIfErr(t, load(t, tl, &ctlog.LoadLogOptions{FullAudit: true,
+			AuditProgress: func(next, size int64) {
+				if size != 3*tileWidth+5 {
+					t.Errorf("got size %d", size)
+				}
+				progress = append(progress, next)
+			}}))
+		if len(progress) == 0 || progress[len(progress)-1] != 3*tileWidth+5 {
+			t.Errorf("got progress %v", progress)
+		}
+	})
+	for _, tc := range []struct {
+		name  string
+		key   string
+		tile  string
+		index int64
+	}{
+		{"DataTile", "tile/data/001", "tile/data/001", tileWidth},
+		{"HashTile", "tile/0/002", "tile/data/002", 2 * tileWidth},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tl, mb := newLog(t, 3)
+			mb.Corrupt(tc.key)
+			// LoadLog only checks the right edge.
+			fatalIfErr(t, load(t, tl, nil))
+
+			err := load(t, tl, &ctlog.LoadLogOptions{FullAudit: true})
+			var auditErr *ctlog.AuditError
+			if !errors.As(err, &auditErr) {
+				t.Fatalf("got %v, expected an AuditError", err)
+			}
+			if !errors.Is(err, ctlog.ErrCorruptTile) {
+				t.Errorf("got %v, expected ErrCorruptTile", err)
+			}
+			if got := sunlight.TilePath(auditErr.Tile); got != tc.tile || auditErr.Index != tc.index {
+				t.Errorf("got tile %s and index %d, expected %s and %d", got, auditErr.Index, tc.tile, tc.index)
+			}
+
+			// Resuming past the corruption succeeds, without fetching the
+			// data tiles before it.
+			before := mb.Fetches("tile/data/000")
+			fatalIfErr(t, load(t, tl, &ctlog.LoadLogOptions{FullAudit: true, AuditFrom: tc.index + tileWidth}))
+			if mb.Fetches("tile/data/000") != before {
+				t.Error("resumed audit fetched the first data tile")
+			}
+		})
+	}
+	t.Run("Cancel", func(t *testing.T) {
+		// More data tiles than are fetched at a time.
+		tl, mb := newLog(t, 40)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		before := mb.Fetches("tile/data/039")
+		var calls int
+		_, err := ctlog.LoadLogWithOptions(ctx, tl.Config, &ctlog.LoadLogOptions{FullAudit: true,
+			AuditProgress: func(next, size int64) {
+				calls++
+				cancel()
+			}})
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("got %v, expected context.Canceled", err)
+		}
+		if calls != 1 {
+			t.Errorf("got %d progress calls after cancellation", calls)
+		}
+		if mb.Fetches("tile/data/039") != before {
+			t.Error("audit continued after cancellation")
+		}
+	})
+}
+
+func TestReloadRepairEdgeTile(t *testing.T) {
+	repaired := func(t *testing.T, tl *TestLog) float64 {
+		reg := prometheus.NewRegistry()
+		reg.MustRegister(tl.Log.Metrics()...)
+		families, err := reg.Gather()
+		fatalIfErr(t, err)
+		for _, mf := range families {
+			if mf.GetName() == "tree_repaired_edge_tiles_total" {
+				return mf.GetMetric()[0].GetCounter().GetValue()
+			}
+		}
+		return 0
+	}
+	for _, tc := range []struct {
+		name   string
+		size   int64
+		tile   string
+		damage func(t *testing.T, mb *MemoryBackend, key string)
+	}{
+		{"Missing", tileWidth + 5, "tile/0/001.p/5", func(t *testing.T, mb *MemoryBackend, key string) {
+			fatalIfErr(t, mb.Delete(context.Background(), key))
+		}},
+		{"Corrupted", tileWidth + 5, "tile/0/001.p/5", func(t *testing.T, mb *MemoryBackend, key string) {
+			mb.Corrupt(key)
+		}},
+		{"Full", tileWidth, "tile/0/000", func(t *testing.T, mb *MemoryBackend, key string) {
+			fatalIfErr(t, mb.Delete(context.Background(), key))
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tl := NewEmptyTestLog(t)
+			for range tc.size {
+				addCertificate(t, tl)
+			}
+			fatalIfErr(t, tl.Log.Sequence())
+			mb := tl.Config.Backend.(*MemoryBackend)
+			expected, err := mb.Fetch(context.Background(), tc.tile)
+			fatalIfErr(t, err)
+			tc.damage(t, mb, tc.tile)
+
+			tl = ReloadLog(t, tl)
+			if n := repaired(t, tl); n != 1 {
+				t.Errorf("got %v repaired tiles, expected 1", n)
+			}
+			got, err := mb.Fetch(context.Background(), tc.tile)
+			fatalIfErr(t, err)
+			if !bytes.Equal(got, expected) {
+				t.Errorf("repaired tile %q was not uploaded", tc.tile)
+			}
+			tl.CheckLog(tc.size)
+
+			addCertificate(t, tl)
+			fatalIfErr(t, tl.Log.Sequence())
+			tl.CheckLog(tc.size + 1)
+		})
+	}
+
+	t.Run("NoDataTile", func(t *testing.T) {
+		tl := NewEmptyTestLog(t)
+		for range tileWidth + 5 {
+			addCertificate(t, tl)
+		}
+		fatalIfErr(t, tl.Log.Sequence())
+		mb := tl.Config.Backend.(*MemoryBackend)
+		fatalIfErr(t, mb.Delete(context.Background(), "tile/0/001.p/5"))
+		fatalIfErr(t, mb.Delete(context.Background(), "tile/data/001.p/5"))
+		if _, err := ctlog.LoadLog(context.Background(), tl.Config); err == nil {
+			t.Fatal("expected loading to fail")
+		}
+	})
+
+	t.Run("CorruptedDataTile", func(t *testing.T) {
+		// The recomputed tile must still match the checkpoint.
+		tl := NewEmptyTestLog(t)
+		for range tileWidth + 5 {
+			addCertificate(t, tl)
+		}
+		fatalIfErr(t, tl.Log.Sequence())
+		mb := tl.Config.Backend.(*MemoryBackend)
+		fatalIfErr(t, mb.Delete(context.Background(), "tile/0/001.p/5"))
+		mb.Corrupt("tile/data/001.p/5")
+		if _, err := ctlog.LoadLog(context.Background(), tl.Config); err == nil {
+			t.Fatal("expected loading to fail")
+		}
+		if _, err := mb.Fetch(context.Background(), "tile/0/001.p/5"); err == nil {
+			t.Error("an unverified tile was uploaded")
+		}
+	})
+}
+
 func TestSubmit(t *testing.T) {
 	t.Run("Certificates", func(t *testing.T) {
 		testSubmit(t, false)
@@ -352,12 +744,14 @@ func testSubmit(t *testing.T, precert bool) {
 
 	var err error
 	var sct1, sct2 *ct.SignedCertificateTimestamp
+	chain := []ct.ASN1Cert{{Data: testLeaf}, {Data: testIntermediate}, {Data: testRoot}}
+	entryType := ct.X509LogEntryType
 	if precert {
-		sct1, err = logClient.AddPreChain(context.Background(), []ct.ASN1Cert{
-			{Data: testPrecert}, {Data: testIntermediate}, {Data: testRoot}})
+		chain[0].Data = testPrecert
+		entryType = ct.PrecertLogEntryType
+		sct1, err = logClient.AddPreChain(context.Background(), chain)
 	} else {
-		sct1, err = logClient.AddChain(context.Background(), []ct.ASN1Cert{
-			{Data: testLeaf}, {Data: testIntermediate}, {Data: testRoot}})
+		sct1, err = logClient.AddChain(context.Background(), chain)
 	}
 	fatalIfErr(t, err)
 
@@ -381,12 +775,20 @@ func testSubmit(t *testing.T, precert bool) {
 		t.Errorf("got extensions index %d, expected 1", idx)
 	}
 
+	// The LogClient already verifies the SCT, but check it independently of
+	// the submission path, against the RFC 6962 signature input.
+	verifier, err := ct.NewSignatureVerifier(tl.Config.Key.Public())
+	fatalIfErr(t, err)
+	leaf, err := ct.MerkleTreeLeafFromRawChain(chain, entryType, sct1.Timestamp)
+	fatalIfErr(t, err)
+	if err := verifier.VerifySCTSignature(*sct1, ct.LogEntry{Leaf: *leaf}); err != nil {
+		t.Errorf("SCT signature verification failed: %v", err)
+	}
+
 	if precert {
-		sct2, err = logClient.AddPreChain(context.Background(), []ct.ASN1Cert{
-			{Data: testPrecert}, {Data: testIntermediate}, {Data: testRoot}})
+		sct2, err = logClient.AddPreChain(context.Background(), chain)
 	} else {
-		sct2, err = logClient.AddChain(context.Background(), []ct.ASN1Cert{
-			{Data: testLeaf}, {Data: testIntermediate}, {Data: testRoot}})
+		sct2, err = logClient.AddChain(context.Background(), chain)
 	}
 	fatalIfErr(t, err)
 
@@ -399,351 +801,6474 @@ func testSubmit(t *testing.T, precert bool) {
 	}
 }
 
-func TestReloadWrongName(t *testing.T) {
+func TestPrecertTBS(t *testing.T) {
 	tl := NewEmptyTestLog(t)
-	log, err := ctlog.LoadLog(context.Background(), tl.Config)
+	logClient := tl.LogClient()
+
+	// testPrecert and testLeaf are the precertificate and final certificate
+	// issued by Let's Encrypt for the same TBSCertificate.
+	_, err := logClient.AddPreChain(context.Background(), []ct.ASN1Cert{
+		{Data: testPrecert}, {Data: testIntermediate}, {Data: testRoot}})
 	fatalIfErr(t, err)
-	t.Cleanup(func() { fatalIfErr(t, log.CloseCache()) })
+	tl.CheckLog(1)
 
-	c := tl.Config
-	c.Name = "wrong"
-	if _, err := ctlog.LoadLog(context.Background(), c); err == nil {
-		t.Error("expected loading to fail")
+	tile, err := tl.Config.Backend.Fetch(context.Background(), "tile/data/000.p/1")
+	fatalIfErr(t, err)
+	e, _, err := sunlight.ReadTileLeaf(tile)
+	fatalIfErr(t, err)
+	if !e.IsPrecert {
+		t.Fatal("entry is not a precertificate")
 	}
-}
 
-func TestReloadWrongKey(t *testing.T) {
-	tl := NewEmptyTestLog(t)
-	log, err := ctlog.LoadLog(context.Background(), tl.Config)
+	// RFC 6962, Section 3.2: the logged TBSCertificate is the precertificate's
+	// without the poison extension, which must match the final certificate's
+	// without the SCT list extension.
+	leaf, err := ctx509.ParseCertificate(testLeaf)
 	fatalIfErr(t, err)
-	t.Cleanup(func() { fatalIfErr(t, log.CloseCache()) })
-
-	c := tl.Config
-	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	exp, err := ctx509.RemoveSCTList(leaf.RawTBSCertificate)
 	fatalIfErr(t, err)
-	c.Key = key
-	if _, err := ctlog.LoadLog(context.Background(), c); err == nil {
-		t.Error("expected loading to fail")
+	if !bytes.Equal(e.Certificate, exp) {
+		t.Errorf("logged TBSCertificate doesn't match the final certificate")
 	}
-
-	c = tl.Config
-	_, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	precert, err := ctx509.ParseCertificate(testPrecert)
 	fatalIfErr(t, err)
-	c.WitnessKey = ed25519Key
-	if _, err := ctlog.LoadLog(context.Background(), c); err == nil {
-		t.Error("expected loading to fail")
+	if bytes.Equal(e.Certificate, precert.RawTBSCertificate) {
+		t.Errorf("logged TBSCertificate still contains the poison extension")
+	}
+
+	// The poison extension must be present on add-pre-chain, and absent on
+	// add-chain.
+	for _, tc := range []struct {
+		endpoint string
+		leaf     []byte
+	}{
+		{"add-chain", testPrecert},
+		{"add-pre-chain", testLeaf},
+	} {
+		body := fmt.Sprintf(`{"chain": [%q, %q, %q]}`,
+			base64.StdEncoding.EncodeToString(tc.leaf),
+			base64.StdEncoding.EncodeToString(testIntermediate),
+			base64.StdEncoding.EncodeToString(testRoot))
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/ct/v1/"+tc.endpoint, strings.NewReader(body))
+		tl.Log.Handler().ServeHTTP(rr, req)
+		if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), `"cert.wrong_endpoint"`) {
+			t.Errorf("%s: got status %d, expected 400 cert.wrong_endpoint: %s", tc.endpoint, rr.Code, rr.Body)
+		}
 	}
 }
 
-func TestStagingCollision(t *testing.T) {
+func TestMetadata(t *testing.T) {
 	tl := NewEmptyTestLog(t)
-	addCertificate(t, tl)
-	fatalIfErr(t, tl.Log.Sequence())
+	logClient := tl.LogClient()
 
-	time := monotonicTime()
-	ctlog.SetTimeNowUnixMilli(func() int64 { return time })
-	t.Cleanup(func() { ctlog.SetTimeNowUnixMilli(monotonicTime) })
+	chain := []ct.ASN1Cert{{Data: testLeaf}, {Data: testIntermediate}, {Data: testRoot}}
+	sct, err := logClient.AddChain(context.Background(), chain)
+	fatalIfErr(t, err)
 
-	addCertificateExpectFailureWithSeed(t, tl, 'A')
-	addCertificateExpectFailureWithSeed(t, tl, 'B')
+	rr := httptest.NewRecorder()
+	tl.Log.Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/metadata", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", rr.Code, rr.Body)
+	}
+	var fields map[string]any
+	fatalIfErr(t, json.Unmarshal(rr.Body.Bytes(), &fields))
+	for _, f := range []string{"description", "log_id", "key", "submission_url", "monitoring_url",
+		"mmd", "temporal_interval"} {
+		if _, ok := fields[f]; !ok {
+			t.Errorf("missing field %q in %s", f, rr.Body)
+		}
+	}
+	var md ctlog.Metadata
+	fatalIfErr(t, json.Unmarshal(rr.Body.Bytes(), &md))
 
-	tl.Config.Lock.(*MemoryLockBackend).ReplaceCallback = failLockAndNotPersist
-	sequenceExpectFailure(t, tl)
-	tl.CheckLog(1)
+	// The log ID and key in the metadata are the ones that verify the SCT.
+	if !bytes.Equal(md.LogID, sct.LogID.KeyID[:]) {
+		t.Errorf("got log ID %x, SCT has %x", md.LogID, sct.LogID.KeyID)
+	}
+	if h := sha256.Sum256(md.Key); !bytes.Equal(md.LogID, h[:]) {
+		t.Errorf("log ID %x is not the hash of the key", md.LogID)
+	}
+	key, err := x509.ParsePKIXPublicKey(md.Key)
+	fatalIfErr(t, err)
+	verifier, err := ct.NewSignatureVerifier(key)
+	fatalIfErr(t, err)
+	leaf, err := ct.MerkleTreeLeafFromRawChain(chain, ct.X509LogEntryType, sct.Timestamp)
+	fatalIfErr(t, err)
+	if err := verifier.VerifySCTSignature(*sct, ct.LogEntry{Leaf: *leaf}); err != nil {
+		t.Errorf("SCT doesn't verify with the metadata key: %v", err)
+	}
 
-	tl.Config.Lock.(*MemoryLockBackend).ReplaceCallback = nil
+	if md.SubmissionURL != "https://example.com/TestLog/" || md.MonitoringURL != md.SubmissionURL ||
+		md.MMD != 86400 || md.Description != "example.com/TestLog" {
+		t.Errorf("got URLs %q and %q, MMD %d, description %q",
+			md.SubmissionURL, md.MonitoringURL, md.MMD, md.Description)
+	}
+	if !md.TemporalInterval.StartInclusive.Equal(tl.Config.NotAfterStart) ||
+		!md.TemporalInterval.EndExclusive.Equal(tl.Config.NotAfterLimit) {
+		t.Errorf("got temporal interval %v", md.TemporalInterval)
+	}
+
+	tl.Config.SubmissionURL = "https://ct.example/2024h1/"
+	tl.Config.MMD = time.Minute
 	tl = ReloadLog(t, tl)
-	tl.CheckLog(1)
+	md2, err := tl.Log.Metadata()
+	fatalIfErr(t, err)
+	if md2.SubmissionURL != tl.Config.SubmissionURL || md2.MonitoringURL != tl.Config.SubmissionURL || md2.MMD != 60 {
+		t.Errorf("got URLs %q and %q, MMD %d", md2.SubmissionURL, md2.MonitoringURL, md2.MMD)
+	}
 
-	// First, cause the exact same staging bundle to be uploaded.
+	tl.Config.MonitoringURL = "https://bucket.example/2024h1/"
+	tl = ReloadLog(t, tl)
+	md3, err := tl.Log.Metadata()
+	fatalIfErr(t, err)
+	if md3.SubmissionURL != tl.Config.SubmissionURL || md3.MonitoringURL != tl.Config.MonitoringURL {
+		t.Errorf("got URLs %q and %q", md3.SubmissionURL, md3.MonitoringURL)
+	}
+}
 
-	addCertificateWithSeed(t, tl, 'A')
-	addCertificateWithSeed(t, tl, 'B')
-	fatalIfErr(t, tl.Log.Sequence())
-	tl.CheckLog(3)
+func TestFinalForPrecert(t *testing.T) {
+	tl := NewEmptyTestLog(t)
+	logClient := tl.LogClient()
 
-	// Again, but now due to a staging bundle upload error.
+	addCertificate(t, tl)
+	_, err := logClient.AddPreChain(context.Background(), []ct.ASN1Cert{
+		{Data: testPrecert}, {Data: testIntermediate}, {Data: testRoot}})
+	fatalIfErr(t, err)
 
-	time++
+	leaf, err := ctx509.ParseCertificate(testLeaf)
+	fatalIfErr(t, err)
+	tbs, err := ctx509.RemoveSCTList(leaf.RawTBSCertificate)
+	fatalIfErr(t, err)
+	tbsHash := sha256.Sum256(tbs)
 
-	addCertificateExpectFailureWithSeed(t, tl, 'C')
-	addCertificateExpectFailureWithSeed(t, tl, 'D')
+	precertIdx, finalIdx, err := tl.Log.FinalForPrecert(context.Background(), tbsHash)
+	fatalIfErr(t, err)
+	if precertIdx != 1 || finalIdx != -1 {
+		t.Errorf("got indexes (%d, %d), expected (1, -1)", precertIdx, finalIdx)
+	}
 
-	tl.Config.Backend.(*MemoryBackend).UploadCallback = failStagingButPersist
-	fatalIfErr(t, tl.Log.Sequence())
+	_, err = logClient.AddChain(context.Background(), []ct.ASN1Cert{
+		{Data: testLeaf}, {Data: testIntermediate}, {Data: testRoot}})
+	fatalIfErr(t, err)
 	tl.CheckLog(3)
 
-	tl.Config.Backend.(*MemoryBackend).UploadCallback = nil
+	precertIdx, finalIdx, err = tl.Log.FinalForPrecert(context.Background(), tbsHash)
+	fatalIfErr(t, err)
+	if precertIdx != 1 || finalIdx != 2 {
+		t.Errorf("got indexes (%d, %d), expected (1, 2)", precertIdx, finalIdx)
+	}
 
-	addCertificateWithSeed(t, tl, 'C')
-	addCertificateWithSeed(t, tl, 'D')
-	fatalIfErr(t, tl.Log.Sequence())
-	tl.CheckLog(5)
+	get := func(param string) *httptest.ResponseRecorder {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		tl.Log.Handler().ServeHTTP(rr, httptest.NewRequest("GET",
+			"/sunlight/v1/final-for-precert?tbs_hash="+url.QueryEscape(param), nil))
+		return rr
+	}
+	rr := get(base64.StdEncoding.EncodeToString(tbsHash[:]))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", rr.Code, rr.Body)
+	}
+	var res struct {
+		PrecertIndex *int64 `json:"precert_index"`
+		FinalIndex   *int64 `json:"final_index"`
+	}
+	fatalIfErr(t, json.Unmarshal(rr.Body.Bytes(), &res))
+	if res.PrecertIndex == nil || *res.PrecertIndex != 1 || res.FinalIndex == nil || *res.FinalIndex != 2 {
+		t.Errorf("got response %s, expected indexes 1 and 2", rr.Body)
+	}
 
-	// We wanted to also test reaching the same point through two different
-	// sequencing paths, as suggested in
-	// https://github.com/FiloSottile/sunlight/pull/18#discussion_r1704174301
-	// but that doesn't seem possible since time is required to move forward at
-	// each sequencing.
+	missing := sha256.Sum256([]byte("missing"))
+	if rr := get(base64.StdEncoding.EncodeToString(missing[:])); rr.Code != http.StatusNotFound {
+		t.Errorf("missing hash: got status %d, expected 404", rr.Code)
+	}
+	if rr := get(base64.StdEncoding.EncodeToString(tbsHash[:10])); rr.Code != http.StatusBadRequest {
+		t.Errorf("short hash: got status %d, expected 400", rr.Code)
+	}
 }
 
-func sequenceExpectFailure(t *testing.T, tl *TestLog) {
-	t.Helper()
-	if err := tl.Log.Sequence(); err == nil {
-		t.Error("expected error, got nil")
+func TestTBSFromFinalCertificate(t *testing.T) {
+	test := func(t *testing.T, tl *TestLog, chain [][]byte, final, issuer []byte) {
+		var rawChain []ct.ASN1Cert
+		for _, c := range chain {
+			rawChain = append(rawChain, ct.ASN1Cert{Data: c})
+		}
+		_, err := tl.LogClient().AddPreChain(context.Background(), rawChain)
+		fatalIfErr(t, err)
+		tl.CheckLog(1)
+
+		tile, err := tl.Config.Backend.Fetch(context.Background(), "tile/data/000.p/1")
+		fatalIfErr(t, err)
+		e, _, err := sunlight.ReadTileLeaf(tile)
+		fatalIfErr(t, err)
+
+		finalCert, err := x509.ParseCertificate(final)
+		fatalIfErr(t, err)
+		issuerCert, err := x509.ParseCertificate(issuer)
+		fatalIfErr(t, err)
+		for _, issuer := range []*x509.Certificate{nil, issuerCert} {
+			tbs, err := sunlight.TBSFromFinalCertificate(finalCert, issuer)
+			fatalIfErr(t, err)
+			if !bytes.Equal(tbs, e.Certificate) {
+				t.Errorf("issuer %v: TBSCertificate doesn't match the logged one", issuer != nil)
+			}
+		}
 	}
+	t.Run("LetsEncrypt", func(t *testing.T) {
+		tl := NewEmptyTestLog(t)
+		test(t, tl, [][]byte{testPrecert, testIntermediate, testRoot}, testLeaf, testIntermediate)
+	})
+	t.Run("Issuer", func(t *testing.T) {
+		tl := NewEmptyTestLog(t)
+		chain := tl.NewTestChain(true, false)
+		test(t, tl, chain.Chain(), chain.Final, chain.Intermediate)
+	})
+	t.Run("PreIssuer", func(t *testing.T) {
+		tl := NewEmptyTestLog(t)
+		chain := tl.NewTestChain(true, true)
+		test(t, tl, chain.Chain(), chain.Final, chain.Intermediate)
+	})
 }
 
-func TestFatalError(t *testing.T) {
+func TestSubmitPreIssuer(t *testing.T) {
 	tl := NewEmptyTestLog(t)
-	addCertificate(t, tl)
-	addCertificate(t, tl)
-	fatalIfErr(t, tl.Log.Sequence())
+	logClient := tl.LogClient()
+	chain := tl.NewTestChain(true, true)
 
-	tl.Config.Lock.(*MemoryLockBackend).ReplaceCallback = failLockAndNotPersist
-	addCertificateExpectFailure(t, tl)
+	var rawChain []ct.ASN1Cert
+	for _, c := range chain.Chain() {
+		rawChain = append(rawChain, ct.ASN1Cert{Data: c})
+	}
+	// The LogClient verifies the SCT against the chain, which requires the
+	// issuer_key_hash to be that of the Precertificate Signing Certificate
+	// issuer, not of the Precertificate Signing Certificate itself.
+	if _, err := logClient.AddPreChain(context.Background(), rawChain); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := logClient.AddChain(context.Background(), rawChain); err == nil {
+		t.Error("add-chain accepted a precertificate")
+	}
+	tl.CheckLog(1)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
-	defer cancel()
-	if err := tl.Log.RunSequencer(ctx, 1*time.Millisecond); err == nil {
-		t.Errorf("expected fatal error, got nil")
+	tile, err := tl.Config.Backend.Fetch(context.Background(), "tile/data/000.p/1")
+	fatalIfErr(t, err)
+	e, _, err := sunlight.ReadTileLeaf(tile)
+	fatalIfErr(t, err)
+	if !e.IsPrecert {
+		t.Fatal("entry is not a precertificate")
+	}
+	intermediate, err := x509.ParseCertificate(chain.Intermediate)
+	fatalIfErr(t, err)
+	if exp := sha256.Sum256(intermediate.RawSubjectPublicKeyInfo); e.IssuerKeyHash != exp {
+		t.Errorf("issuer key hash is %x, expected %x", e.IssuerKeyHash, exp)
+	}
+	if !bytes.Equal(e.PreCertificate, chain.Leaf) {
+		t.Error("pre_certificate is not the submitted precertificate")
+	}
+	expFingerprints := [][32]byte{sha256.Sum256(chain.PreIssuer),
+		sha256.Sum256(chain.Intermediate), sha256.Sum256(chain.Root)}
+	if !reflect.DeepEqual(e.ChainFingerprints, expFingerprints) {
+		t.Errorf("chain fingerprints are %x, expected %x", e.ChainFingerprints, expFingerprints)
 	}
-	tl.CheckLog(2)
 
-	tl.Config.Lock.(*MemoryLockBackend).ReplaceCallback = nil
+	// RFC 6962, Section 3.2: the issuer and authority key identifier of the
+	// logged TBSCertificate are those of the Precertificate Signing Certificate.
+	tbs, err := ctx509.ParseTBSCertificate(e.Certificate)
+	fatalIfErr(t, err)
+	preIssuer, err := ctx509.ParseCertificate(chain.PreIssuer)
+	fatalIfErr(t, err)
+	if !bytes.Equal(tbs.RawIssuer, intermediate.RawSubject) {
+		t.Errorf("TBSCertificate issuer is %q, expected %q", tbs.Issuer, intermediate.Subject)
+	}
+	if !bytes.Equal(tbs.AuthorityKeyId, preIssuer.AuthorityKeyId) {
+		t.Errorf("TBSCertificate authority key ID is %x, expected %x", tbs.AuthorityKeyId, preIssuer.AuthorityKeyId)
+	}
+
+	// A Precertificate Signing Certificate without its issuer is rejected.
+	if _, err := logClient.AddPreChain(context.Background(), []ct.ASN1Cert{
+		{Data: chain.Leaf}, {Data: chain.PreIssuer}}); err == nil {
+		t.Error("add-pre-chain accepted a chain without the signing certificate issuer")
+	}
+}
+
+func TestCheckIssuerSignatures(t *testing.T) {
+	tl := NewEmptyTestLog(t)
+	parse := func(raw ...[]byte) []*ctx509.Certificate {
+		t.Helper()
+		var chain []*ctx509.Certificate
+		for _, r := range raw {
+			c, err := ctx509.ParseCertificate(r)
+			fatalIfErr(t, err)
+			chain = append(chain, c)
+		}
+		return chain
+	}
+	a := tl.NewTestChain(true, true)
+	b := tl.NewTestChain(true, true)
+
+	if err := ctlog.CheckIssuerSignatures(parse(a.Chain()...)); err != nil {
+		t.Errorf("valid chain: %v", err)
+	}
+	if err := ctlog.CheckIssuerSignatures(parse(testLeaf, testIntermediate, testRoot)); err != nil {
+		t.Errorf("valid chain: %v", err)
+	}
+	if err := ctlog.CheckIssuerSignatures(parse(a.Leaf, b.PreIssuer, b.Intermediate, b.Root)); err == nil {
+		t.Error("accepted a leaf not signed by its issuer")
+	}
+	// The signing certificate signed the leaf, but the logged issuer key would
+	// be that of an unrelated CA.
+	if err := ctlog.CheckIssuerSignatures(parse(a.Leaf, a.PreIssuer, b.Intermediate, b.Root)); err == nil {
+		t.Error("accepted a signing certificate not signed by its issuer")
+	}
+	if err := ctlog.CheckIssuerSignatures(parse(a.Leaf, a.PreIssuer)); err == nil {
+		t.Error("accepted a signing certificate without its issuer")
+	}
+}
+
+func TestGetRoots(t *testing.T) {
+	tl := NewEmptyTestLog(t)
+	tl.NewTestChain(false, false)
+	c := tl.NewTestChain(false, false)
+	root, err := ctx509.ParseCertificate(c.Root)
+	fatalIfErr(t, err)
+	tl.Config.Roots.AddCert(root)
+
+	roots, err := tl.LogClient().GetAcceptedRoots(context.Background())
+	fatalIfErr(t, err)
+	if len(roots) != 3 {
+		t.Errorf("got %d roots, expected 3", len(roots))
+	}
+	if !slices.IsSortedFunc(roots, func(a, b ct.ASN1Cert) int { return bytes.Compare(a.Data, b.Data) }) {
+		t.Error("roots are not sorted")
+	}
+	if !slices.ContainsFunc(roots, func(r ct.ASN1Cert) bool { return bytes.Equal(r.Data, c.Root) }) {
+		t.Error("missing root")
+	}
+}
+
+func TestSetRoots(t *testing.T) {
+	tl := NewEmptyTestLog(t)
+	tl.StartSequencer()
+	c := tl.NewTestChain(false, false)
+	newRoot, err := ctx509.ParseCertificate(c.Root)
+	fatalIfErr(t, err)
+
+	post := func(chain ...[]byte) *httptest.ResponseRecorder {
+		var req struct {
+			Chain [][]byte `json:"chain"`
+		}
+		req.Chain = chain
+		body, err := json.Marshal(req)
+		fatalIfErr(t, err)
+		rr := httptest.NewRecorder()
+		tl.Log.Handler().ServeHTTP(rr, httptest.NewRequest("POST", "/ct/v1/add-chain", bytes.NewReader(body)))
+		return rr
+	}
+	if rr := post(testLeaf, testIntermediate, testRoot); rr.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", rr.Code, rr.Body)
+	}
+
+	if err := tl.Log.SetRoots(nil); err == nil {
+		t.Error("SetRoots accepted an empty pool")
+	}
+	fatalIfErr(t, tl.Log.SetRoots([]*ctx509.Certificate{newRoot}))
+
+	// The previously verified chain is cached, but its root was removed.
+	if rr := post(testLeaf, testIntermediate, testRoot); rr.Code != http.StatusBadRequest ||
+		!strings.Contains(rr.Body.String(), `"root.unknown"`) {
+		t.Errorf("got status %d, expected 400 root.unknown: %s", rr.Code, rr.Body)
+	}
+	if rr := post(c.Chain()...); rr.Code != http.StatusOK {
+		t.Errorf("got status %d: %s", rr.Code, rr.Body)
+	}
+
+	rr := httptest.NewRecorder()
+	tl.Log.Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/ct/v1/get-roots", nil))
+	var res struct {
+		Certificates [][]byte `json:"certificates"`
+	}
+	fatalIfErr(t, json.Unmarshal(rr.Body.Bytes(), &res))
+	if len(res.Certificates) != 1 || !bytes.Equal(res.Certificates[0], c.Root) {
+		t.Errorf("get-roots returned %d roots, expected only the new one", len(res.Certificates))
+	}
+}
+
+func TestGetSTH(t *testing.T) {
+	tl := NewEmptyTestLog(t)
+	logClient := tl.LogClient()
+
+	// Fetch the STH concurrently with sequencing, for the race detector.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var lastSize uint64
+		for ctx.Err() == nil {
+			sth, err := logClient.GetSTH(ctx)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				t.Errorf("GetSTH: %v", err)
+				return
+			}
+			if sth.TreeSize < lastSize {
+				t.Errorf("tree size went backwards: %d < %d", sth.TreeSize, lastSize)
+			}
+			lastSize = sth.TreeSize
+		}
+	}()
+
+	for range 5 {
+		wait := addCertificate(t, tl)
+		if _, err := wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cancel()
+	<-done
+
+	sth, err := logClient.GetSTH(context.Background())
+	fatalIfErr(t, err)
+	timestamp := tl.CheckLog(5)
+	if sth.TreeSize != 5 {
+		t.Errorf("got tree size %d, expected 5", sth.TreeSize)
+	}
+	if int64(sth.Timestamp) != timestamp {
+		t.Errorf("got timestamp %d, expected %d", sth.Timestamp, timestamp)
+	}
+	c := tl.Checkpoint()
+	if sth.SHA256RootHash != ct.SHA256Hash(c.Hash) {
+		t.Errorf("got root hash %x, expected %x", sth.SHA256RootHash, c.Hash)
+	}
+}
+
+func TestGetSTHConsistency(t *testing.T) {
+	tl := NewEmptyTestLog(t)
+	tl.Quiet()
+
+	// Record a checkpoint at a few sizes, including some with partial tiles
+	// that are later overwritten by full tiles.
+	checkpoints := make(map[int64]sunlight.Checkpoint)
+	var sizes []int64
+	var n int64
+	for _, batch := range []int64{1, 3, tileWidth - 5, 5, tileWidth + 44} {
+		for range batch {
+			addCertificate(t, tl)
+		}
+		fatalIfErr(t, tl.Log.Sequence())
+		n += batch
+		tl.CheckLog(n)
+		checkpoints[n] = tl.Checkpoint()
+		sizes = append(sizes, n)
+	}
+
+	logClient := tl.LogClient()
+	for _, first := range sizes {
+		for _, second := range sizes {
+			if first > second {
+				continue
+			}
+			proof, err := logClient.GetSTHConsistency(context.Background(), uint64(first), uint64(second))
+			if err != nil {
+				t.Errorf("GetSTHConsistency(%d, %d): %v", first, second, err)
+				continue
+			}
+			old, cur := checkpoints[first], checkpoints[second]
+			var tlogProof tlog.TreeProof
+			for _, h := range proof {
+				tlogProof = append(tlogProof, tlog.Hash(h))
+			}
+			if err := tlog.CheckTree(tlogProof, second, cur.Hash, first, old.Hash); err != nil {
+				t.Errorf("tlog.CheckTree(%d, %d): %v", first, second, err)
+			}
+			if err := merkleproof.VerifyConsistency(rfc6962.DefaultHasher,
+				uint64(first), uint64(second), proof, old.Hash[:], cur.Hash[:]); err != nil {
+				t.Errorf("proof.VerifyConsistency(%d, %d): %v", first, second, err)
+			}
+		}
+	}
+
+	if proof, err := logClient.GetSTHConsistency(context.Background(), 0, uint64(n)); err != nil {
+		t.Errorf("GetSTHConsistency(0, %d): %v", n, err)
+	} else if len(proof) != 0 {
+		t.Errorf("GetSTHConsistency(0, %d): got %d hashes, expected none", n, len(proof))
+	}
+	if _, err := logClient.GetSTHConsistency(context.Background(), 1, uint64(n+1)); err == nil {
+		t.Errorf("GetSTHConsistency(1, %d) succeeded beyond the tree size", n+1)
+	}
+	if _, err := logClient.GetSTHConsistency(context.Background(), 4, 1); err == nil {
+		t.Error("GetSTHConsistency(4, 1) succeeded")
+	}
+}
+
+func TestGetProofByHash(t *testing.T) {
+	tl := NewEmptyTestLog(t)
+	tl.Quiet()
+
+	var entries []*sunlight.LogEntry
+	sequence := func(tl *TestLog, n int) {
+		t.Helper()
+		var waits []func(context.Context) (*sunlight.LogEntry, error)
+		for range n {
+			waits = append(waits, addCertificate(t, tl))
+		}
+		fatalIfErr(t, tl.Log.Sequence())
+		for _, wait := range waits {
+			e, err := wait(context.Background())
+			fatalIfErr(t, err)
+			entries = append(entries, e)
+		}
+	}
+
+	sequence(tl, tileWidth+10)
+	old := tl.Checkpoint()
+
+	// Reload with an empty cache, to check that the leaf hashes are recovered
+	// from the backend, and then sequence more entries on top.
+	tl.Config.Cache = filepath.Join(t.TempDir(), "cache.db")
+	tl = ReloadLog(t, tl)
+	sequence(tl, 20)
+	cur := tl.Checkpoint()
+
+	logClient := tl.LogClient()
+	for _, c := range []sunlight.Checkpoint{old, cur} {
+		for _, idx := range []int64{0, 1, tileWidth - 1, tileWidth, c.N - 1} {
+			leafHash := tlog.RecordHash(entries[idx].MerkleTreeLeaf())
+			res, err := logClient.GetProofByHash(context.Background(), leafHash[:], uint64(c.N))
+			if err != nil {
+				t.Errorf("GetProofByHash(%d, %d): %v", idx, c.N, err)
+				continue
+			}
+			if res.LeafIndex != idx {
+				t.Errorf("GetProofByHash(%d, %d): got index %d", idx, c.N, res.LeafIndex)
+			}
+			var tlogProof tlog.RecordProof
+			for _, h := range res.AuditPath {
+				tlogProof = append(tlogProof, tlog.Hash(h))
+			}
+			if err := tlog.CheckRecord(tlogProof, c.N, c.Hash, idx, leafHash); err != nil {
+				t.Errorf("tlog.CheckRecord(%d, %d): %v", idx, c.N, err)
+			}
+			if err := merkleproof.VerifyInclusion(rfc6962.DefaultHasher,
+				uint64(idx), uint64(c.N), leafHash[:], res.AuditPath, c.Hash[:]); err != nil {
+				t.Errorf("proof.VerifyInclusion(%d, %d): %v", idx, c.N, err)
+			}
+		}
+	}
+
+	expectCode := func(hash []byte, treeSize int64, code int) {
+		t.Helper()
+		_, err := logClient.GetProofByHash(context.Background(), hash, uint64(treeSize))
+		var rspErr jsonclient.RspError
+		if !errors.As(err, &rspErr) {
+			t.Errorf("GetProofByHash(%x, %d): expected status %d, got %v", hash, treeSize, code, err)
+		} else if rspErr.StatusCode != code {
+			t.Errorf("GetProofByHash(%x, %d): got status %d, expected %d", hash, treeSize, rspErr.StatusCode, code)
+		}
+	}
+	missing := sha256.Sum256([]byte("missing"))
+	expectCode(missing[:], cur.N, http.StatusNotFound)
+	last := tlog.RecordHash(entries[cur.N-1].MerkleTreeLeaf())
+	expectCode(last[:], old.N, http.StatusNotFound)
+	expectCode(last[:], cur.N+1, http.StatusBadRequest)
+	expectCode(last[:10], cur.N, http.StatusBadRequest)
+}
+
+func TestCachePutFailure(t *testing.T) {
+	tl := NewEmptyTestLog(t)
+	tl.Quiet()
+
+	addCertificateWithSeed(t, tl, 1)
+	fatalIfErr(t, tl.Log.Sequence())
+
+	// Lose the cache writes of one round, but not of the next one.
+	ctlog.FailCachePut(t, func() error { return errors.New("cache put failed") })
+	wait := addCertificateWithSeed(t, tl, 2)
+	fatalIfErr(t, tl.Log.Sequence())
+	lost, err := wait(context.Background())
+	fatalIfErr(t, err)
+	ctlog.FailCachePut(t, nil)
+	addCertificateWithSeed(t, tl, 3)
+	fatalIfErr(t, tl.Log.Sequence())
+	tl.CheckLog(3)
+
+	// The lost entry is backfilled on load, even if later ones are cached.
+	tl = ReloadLog(t, tl)
+	wait = addCertificateWithSeed(t, tl, 2)
+	fatalIfErr(t, tl.Log.Sequence())
+	e, err := wait(context.Background())
+	fatalIfErr(t, err)
+	if e.LeafIndex != lost.LeafIndex || e.Timestamp != lost.Timestamp {
+		t.Errorf("got entry %d at %d, expected the lost entry %d at %d",
+			e.LeafIndex, e.Timestamp, lost.LeafIndex, lost.Timestamp)
+	}
+	tl.CheckLog(3)
+
+	leafHash := tlog.RecordHash(lost.MerkleTreeLeaf())
+	res, err := tl.LogClient().GetProofByHash(context.Background(), leafHash[:], 3)
+	fatalIfErr(t, err)
+	if res.LeafIndex != lost.LeafIndex {
+		t.Errorf("got index %d, expected %d", res.LeafIndex, lost.LeafIndex)
+	}
+}
+
+func TestGetEntries(t *testing.T) {
+	tl := NewEmptyTestLog(t)
+	tl.Quiet()
+
+	issuers := make(map[[32]byte][]byte)
+	for _, chain := range chains {
+		for _, issuer := range chain {
+			issuers[sha256.Sum256(issuer)] = issuer
+		}
+	}
+
+	var entries []*sunlight.LogEntry
+	for _, batch := range []int{tileWidth + 5, 3} {
+		var waits []func(context.Context) (*sunlight.LogEntry, error)
+		for i := range batch {
+			if i%3 == 0 {
+				waits = append(waits, addPreCertificate(t, tl))
+			} else {
+				waits = append(waits, addCertificate(t, tl))
+			}
+		}
+		fatalIfErr(t, tl.Log.Sequence())
+		for _, wait := range waits {
+			e, err := wait(context.Background())
+			fatalIfErr(t, err)
+			entries = append(entries, e)
+		}
+	}
+	n := int64(len(entries))
+
+	// Reload the log to check that issuers are fetched from the backend.
+	tl = ReloadLog(t, tl)
+	tl.Config.MaxGetEntries = 100
+	logClient := tl.LogClient()
+
+	check := func(start, end, expEnd int64) {
+		t.Helper()
+		res, err := logClient.GetRawEntries(context.Background(), start, end)
+		if err != nil {
+			t.Errorf("GetRawEntries(%d, %d): %v", start, end, err)
+			return
+		}
+		if int64(len(res.Entries)) != expEnd-start+1 {
+			t.Errorf("GetRawEntries(%d, %d): got %d entries, expected %d", start, end, len(res.Entries), expEnd-start+1)
+			return
+		}
+		for i, le := range res.Entries {
+			e := entries[start+int64(i)]
+			if !bytes.Equal(le.LeafInput, e.MerkleTreeLeaf()) {
+				t.Errorf("entry %d: leaf_input mismatch", e.LeafIndex)
+			}
+			var chain []ct.ASN1Cert
+			if e.IsPrecert {
+				var pce ct.PrecertChainEntry
+				if rest, err := tls.Unmarshal(le.ExtraData, &pce); err != nil || len(rest) != 0 {
+					t.Errorf("entry %d: invalid PrecertChainEntry: %v", e.LeafIndex, err)
+					continue
+				}
+				if !bytes.Equal(pce.PreCertificate.Data, e.PreCertificate) {
+					t.Errorf("entry %d: pre_certificate mismatch", e.LeafIndex)
+				}
+				chain = pce.CertificateChain
+			} else {
+				var cc ct.CertificateChain
+				if rest, err := tls.Unmarshal(le.ExtraData, &cc); err != nil || len(rest) != 0 {
+					t.Errorf("entry %d: invalid certificate_chain: %v", e.LeafIndex, err)
+					continue
+				}
+				chain = cc.Entries
+			}
+			if len(chain) != len(e.ChainFingerprints) {
+				t.Errorf("entry %d: got %d chain certificates, expected %d", e.LeafIndex, len(chain), len(e.ChainFingerprints))
+				continue
+			}
+			for j, c := range chain {
+				if !bytes.Equal(c.Data, issuers[e.ChainFingerprints[j]]) {
+					t.Errorf("entry %d: chain certificate %d mismatch", e.LeafIndex, j)
+				}
+			}
+		}
+	}
+	check(0, 0, 0)
+	check(0, n-1, 99)
+	check(tileWidth-10, tileWidth+4, tileWidth+4)
+	check(tileWidth+2, n-1, n-1)
+	check(n-1, n-1, n-1)
+
+	get := func(start, end int64) *httptest.ResponseRecorder {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", fmt.Sprintf("/ct/v1/get-entries?start=%d&end=%d", start, end), nil)
+		tl.Log.Handler().ServeHTTP(rr, req)
+		return rr
+	}
+	if rr := get(5, 4); rr.Code != http.StatusBadRequest {
+		t.Errorf("start > end: got %d, expected 400", rr.Code)
+	}
+	if rr := get(0, n); rr.Code != http.StatusBadRequest {
+		t.Errorf("end = tree size: got %d, expected 400", rr.Code)
+	}
+	if rr := get(0, 10); rr.Header().Get("Cache-Control") == "" {
+		t.Error("full tile response is not cacheable")
+	}
+	if rr := get(tileWidth, tileWidth+1); rr.Header().Get("Cache-Control") != "" {
+		t.Error("partial tile response is cacheable")
+	}
+
+	// Check that real entries are parsed correctly by the client.
+	for _, precert := range []bool{false, true} {
+		c := tl.NewTestChain(precert, false)
+		var rawChain []ct.ASN1Cert
+		for _, cert := range c.Chain() {
+			rawChain = append(rawChain, ct.ASN1Cert{Data: cert})
+		}
+		var sct *ct.SignedCertificateTimestamp
+		var err error
+		if precert {
+			sct, err = logClient.AddPreChain(context.Background(), rawChain)
+		} else {
+			sct, err = logClient.AddChain(context.Background(), rawChain)
+		}
+		fatalIfErr(t, err)
+		ext, err := sunlight.ParseExtensions(sct.Extensions)
+		fatalIfErr(t, err)
+		les, err := logClient.GetEntries(context.Background(), ext.LeafIndex, ext.LeafIndex)
+		fatalIfErr(t, err)
+		le := les[0]
+		if precert {
+			if le.Precert == nil || !bytes.Equal(le.Precert.Submitted.Data, c.Leaf) {
+				t.Errorf("precert entry %d not parsed correctly", ext.LeafIndex)
+			}
+		} else if le.X509Cert == nil || !bytes.Equal(le.X509Cert.Raw, c.Leaf) {
+			t.Errorf("certificate entry %d not parsed correctly", ext.LeafIndex)
+		}
+		if len(le.Chain) != 2 || !bytes.Equal(le.Chain[0].Data, c.Intermediate) ||
+			!bytes.Equal(le.Chain[1].Data, c.Root) {
+			t.Errorf("entry %d: unexpected chain", ext.LeafIndex)
+		}
+	}
+}
+
+func TestGetEntryAndProof(t *testing.T) {
+	t.Run("Fetch", func(t *testing.T) { testGetEntryAndProof(t, false) })
+	t.Run("FetchRange", func(t *testing.T) { testGetEntryAndProof(t, true) })
+}
+
+func testGetEntryAndProof(t *testing.T, ranged bool) {
+	tl := NewEmptyTestLog(t)
+	tl.Quiet()
+	if ranged {
+		tl.Config.Backend = &rangeBackend{MemoryBackend: tl.Config.Backend.(*MemoryBackend)}
+		tl.Config.UnverifiedRangeProofs = true
+	}
+
+	var entries []*sunlight.LogEntry
+	var checkpoints []sunlight.Checkpoint
+	for _, batch := range []int{5, tileWidth, 7} {
+		var waits []func(context.Context) (*sunlight.LogEntry, error)
+		for range batch {
+			waits = append(waits, addCertificate(t, tl))
+		}
+		fatalIfErr(t, tl.Log.Sequence())
+		for _, wait := range waits {
+			e, err := wait(context.Background())
+			fatalIfErr(t, err)
+			entries = append(entries, e)
+		}
+		checkpoints = append(checkpoints, tl.Checkpoint())
+	}
+
+	logClient := tl.LogClient()
+	for _, c := range checkpoints {
+		for _, idx := range []int64{0, 4, tileWidth - 1, tileWidth, c.N - 1} {
+			if idx >= c.N {
+				continue
+			}
+			res, err := logClient.GetEntryAndProof(context.Background(), uint64(idx), uint64(c.N))
+			if err != nil {
+				t.Errorf("GetEntryAndProof(%d, %d): %v", idx, c.N, err)
+				continue
+			}
+			if !bytes.Equal(res.LeafInput, entries[idx].MerkleTreeLeaf()) {
+				t.Errorf("GetEntryAndProof(%d, %d): leaf_input mismatch", idx, c.N)
+			}
+			if len(res.ExtraData) == 0 {
+				t.Errorf("GetEntryAndProof(%d, %d): empty extra_data", idx, c.N)
+			}
+			var proof tlog.RecordProof
+			for _, h := range res.AuditPath {
+				proof = append(proof, tlog.Hash(h))
+			}
+			leafHash := tlog.RecordHash(res.LeafInput)
+			if err := tlog.CheckRecord(proof, c.N, c.Hash, idx, leafHash); err != nil {
+				t.Errorf("tlog.CheckRecord(%d, %d): %v", idx, c.N, err)
+			}
+		}
+	}
+
+	if rb, ok := tl.Config.Backend.(*rangeBackend); ok && (rb.ranges == 0 || rb.hashRanges == 0) {
+		t.Errorf("FetchRange was never called for data and hash tiles")
+	}
+
+	n := int64(len(entries))
+	for _, tc := range [][2]int64{{0, n + 1}, {n, n}, {3, 3}, {0, 0}} {
+		_, err := logClient.GetEntryAndProof(context.Background(), uint64(tc[0]), uint64(tc[1]))
+		var rspErr jsonclient.RspError
+		if !errors.As(err, &rspErr) || rspErr.StatusCode != http.StatusBadRequest {
+			t.Errorf("GetEntryAndProof(%d, %d): expected 400, got %v", tc[0], tc[1], err)
+		}
+	}
+}
+
+func TestServeTiles(t *testing.T) {
+	tl := NewEmptyTestLog(t)
+	tl.Quiet()
+	for range tileWidth + 5 {
+		addCertificate(t, tl)
+	}
+	fatalIfErr(t, tl.Log.Sequence())
+	addCertificate(t, tl)
+	fatalIfErr(t, tl.Log.Sequence())
+	tl.CheckLog(tileWidth + 6)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		tl.Log.Handler().ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		return rr
+	}
+
+	mb := tl.Config.Backend.(*MemoryBackend)
+	mb.mu.Lock()
+	objects := maps.Clone(mb.m)
+	mb.mu.Unlock()
+	var tiles int
+	for key, data := range objects {
+		if !strings.HasPrefix(key, "tile/") && key != "checkpoint" {
+			continue
+		}
+		tiles++
+		rr := get("/" + key)
+		if rr.Code != http.StatusOK {
+			t.Errorf("%s: got status %d", key, rr.Code)
+			continue
+		}
+		if !bytes.Equal(rr.Body.Bytes(), data) {
+			t.Errorf("%s: content mismatch", key)
+		}
+		cc := rr.Header().Get("Cache-Control")
+		full := strings.HasPrefix(key, "tile/") && !strings.Contains(key, ".p/")
+		if full != strings.Contains(cc, "immutable") {
+			t.Errorf("%s: unexpected Cache-Control %q", key, cc)
+		}
+	}
+	if tiles < 5 {
+		t.Errorf("only %d tiles checked", tiles)
+	}
+
+	for _, path := range []string{"/tile/0/001", "/tile/data/000.p/3", "/tile/1/000.p/200"} {
+		if rr := get(path); rr.Code != http.StatusNotFound {
+			t.Errorf("%s: got status %d, expected 404", path, rr.Code)
+		}
+	}
+	for _, path := range []string{"/tile/8/0/000", "/tile/0/0", "/tile/0/000.p/256",
+		"/tile/../checkpoint", "/tile/0/..%2fcheckpoint", "/tile/0/000/../../checkpoint",
+		"/tile/0/x000/000", "/tile/0/000.p/0"} {
+		if rr := get(path); rr.Code == http.StatusOK {
+			t.Errorf("%s: got status %d, expected an error", path, rr.Code)
+		}
+	}
+
+	tl.Config.Backend = failingFetchBackend{tl.Config.Backend}
+	if rr := get("/tile/0/000"); rr.Code != http.StatusBadGateway {
+		t.Errorf("backend error: got status %d, expected 502", rr.Code)
+	}
+}
+
+func TestCompression(t *testing.T) {
+	tl := NewEmptyTestLog(t)
+	tl.Quiet()
+	for range tileWidth + 5 {
+		addCertificate(t, tl)
+	}
+	fatalIfErr(t, tl.Log.Sequence())
+
+	get := func(path, acceptEncoding string) (body []byte, encoding string) {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", path, nil)
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		tl.Log.Handler().ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s: got status %d", path, rr.Code)
+		}
+		encoding = rr.Header().Get("Content-Encoding")
+		if encoding != "gzip" {
+			return rr.Body.Bytes(), encoding
+		}
+		if cl := rr.Header().Get("Content-Length"); cl != strconv.Itoa(rr.Body.Len()) {
+			t.Errorf("%s: Content-Length %q, body is %d bytes", path, cl, rr.Body.Len())
+		}
+		r, err := gzip.NewReader(rr.Body)
+		fatalIfErr(t, err)
+		body, err = io.ReadAll(r)
+		fatalIfErr(t, err)
+		return body, encoding
+	}
+
+	for _, path := range []string{"/tile/data/000", "/ct/v1/get-entries?start=0&end=10"} {
+		plain, enc := get(path, "")
+		if enc != "" {
+			t.Errorf("%s: compressed without Accept-Encoding", path)
+		}
+		for range 2 {
+			compressed, enc := get(path, "br, gzip;q=0.8")
+			if enc != "gzip" {
+				t.Errorf("%s: not compressed", path)
+			}
+			if !bytes.Equal(plain, compressed) {
+				t.Errorf("%s: compressed content mismatch", path)
+			}
+		}
+		for _, ae := range []string{"gzip;q=0, identity", "*, gzip;q=0", "gzip; Q = 0 , *", "*;q=0", "br"} {
+			if _, enc := get(path, ae); enc != "" {
+				t.Errorf("%s: compressed despite Accept-Encoding %q", path, ae)
+			}
+		}
+		for _, ae := range []string{"*", "gzip;q=0.5, *;q=0", "GZIP ; q=1"} {
+			if _, enc := get(path, ae); enc != "gzip" {
+				t.Errorf("%s: not compressed with Accept-Encoding %q", path, ae)
+			}
+		}
+	}
+
+	// The checkpoint signature covers its exact bytes, hash tiles are not
+	// compressible, and the small partial data tile is not worth it.
+	for _, path := range []string{"/checkpoint", "/tile/0/000", "/tile/data/001.p/5", "/ct/v1/get-sth"} {
+		if _, enc := get(path, "gzip"); enc != "" {
+			t.Errorf("%s: unexpectedly compressed", path)
+		}
+	}
+}
+
+type failingFetchBackend struct {
+	ctlog.Backend
+}
+
+func (b failingFetchBackend) Fetch(ctx context.Context, key string) ([]byte, error) {
+	return nil, errors.New("fetch error")
+}
+
+func TestIssuers(t *testing.T) {
+	tl := NewEmptyTestLog(t)
+
+	// Check that every issuer is uploaded before a data tile referencing it.
+	var mu sync.Mutex
+	uploaded := make(map[string]int)
+	tl.Config.Backend.(*MemoryBackend).UploadCallback = func(key string, data []byte) (bool, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if strings.HasPrefix(key, "issuer/") {
+			uploaded[key]++
+		}
+		if !strings.HasPrefix(key, "tile/data/") {
+			return true, nil
+		}
+		for len(data) > 0 {
+			e, rest, err := sunlight.ReadTileLeaf(data)
+			if err != nil {
+				t.Errorf("%s: %v", key, err)
+				break
+			}
+			data = rest
+			for _, fp := range e.ChainFingerprints {
+				if uploaded[fmt.Sprintf("issuer/%x", fp)] == 0 {
+					t.Errorf("%s references issuer %x before it was uploaded", key, fp)
+				}
+			}
+		}
+		return true, nil
+	}
+
+	logClient := tl.LogClient()
+	var chains []*testChain
+	for _, precert := range []bool{false, true, false} {
+		c := tl.NewTestChain(precert, false)
+		chains = append(chains, c)
+		for range 2 {
+			var rawChain []ct.ASN1Cert
+			for _, cert := range c.Chain() {
+				rawChain = append(rawChain, ct.ASN1Cert{Data: cert})
+			}
+			var err error
+			if precert {
+				_, err = logClient.AddPreChain(context.Background(), rawChain)
+			} else {
+				_, err = logClient.AddChain(context.Background(), rawChain)
+			}
+			fatalIfErr(t, err)
+		}
+	}
+	mu.Lock()
+	for key, n := range uploaded {
+		if n != 1 {
+			t.Errorf("%s uploaded %d times", key, n)
+		}
+	}
+	mu.Unlock()
+
+	check := func(tl *TestLog) {
+		t.Helper()
+		for _, c := range chains {
+			for _, issuer := range [][]byte{c.Intermediate, c.Root} {
+				rr := httptest.NewRecorder()
+				path := fmt.Sprintf("/issuer/%x", sha256.Sum256(issuer))
+				tl.Log.Handler().ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+				if rr.Code != http.StatusOK {
+					t.Errorf("%s: got status %d", path, rr.Code)
+				} else if !bytes.Equal(rr.Body.Bytes(), issuer) {
+					t.Errorf("%s: content mismatch", path)
+				} else if contentType := rr.Header().Get("Content-Type"); contentType != "application/pkix-cert" {
+					t.Errorf("%s: got Content-Type %q", path, contentType)
+				}
+			}
+		}
+		missing := sha256.Sum256([]byte("missing"))
+		for _, path := range []string{
+			fmt.Sprintf("/issuer/%x", missing),
+			strings.ToUpper(fmt.Sprintf("/issuer/%x", sha256.Sum256(chains[0].Root))),
+			fmt.Sprintf("/issuer/%x", sha256.Sum256(chains[0].Root))[:20],
+			"/issuer/../checkpoint",
+		} {
+			rr := httptest.NewRecorder()
+			tl.Log.Handler().ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+			if rr.Code == http.StatusOK {
+				t.Errorf("%s: got status %d, expected an error", path, rr.Code)
+			}
+		}
+	}
+	check(tl)
+	// A reloaded log fetches the issuers from the backend.
+	check(ReloadLog(t, tl))
+}
+
+func TestSubmitErrors(t *testing.T) {
+	tl := NewEmptyTestLog(t)
+	tl.StartSequencer()
+
+	post := func(path, body string) (int, string) {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", path, strings.NewReader(body))
+		tl.Log.Handler().ServeHTTP(rr, req)
+		return rr.Code, rr.Body.String()
+	}
+
+	for _, path := range []string{"/ct/v1/add-chain", "/ct/v1/add-pre-chain"} {
+		if code, body := post(path, `{"chain": ["not base64!"]}`); code != http.StatusBadRequest {
+			t.Errorf("%s: malformed base64: got %d, expected 400", path, code)
+		} else if !strings.Contains(body, "failed to parse request") {
+			t.Errorf("%s: malformed base64: unexpected message %q", path, body)
+		}
+		if code, body := post(path, `{"chain": []}`); code != http.StatusBadRequest {
+			t.Errorf("%s: empty chain: got %d, expected 400", path, code)
+		} else if !strings.Contains(body, "empty chain") {
+			t.Errorf("%s: empty chain: unexpected message %q", path, body)
+		}
+		if code, _ := post(path, `{"chain": [`); code != http.StatusBadRequest {
+			t.Errorf("%s: truncated JSON: got %d, expected 400", path, code)
+		}
+	}
+
+	// A sequencing failure must produce a 500 instead of hanging.
+	tl.Config.Backend.(*MemoryBackend).UploadCallback = failStagingAndNotPersist
+	chain := fmt.Sprintf(`{"chain": [%q, %q, %q]}`,
+		base64.StdEncoding.EncodeToString(testLeaf),
+		base64.StdEncoding.EncodeToString(testIntermediate),
+		base64.StdEncoding.EncodeToString(testRoot))
+	if code, _ := post("/ct/v1/add-chain", chain); code != http.StatusInternalServerError {
+		t.Errorf("sequencing failure: got %d, expected 500", code)
+	}
+
+	tl.Config.Backend.(*MemoryBackend).UploadCallback = nil
+	if code, body := post("/ct/v1/add-chain", chain); code != http.StatusOK {
+		t.Errorf("resubmission after failure: got %d, expected 200: %s", code, body)
+	}
+}
+
+func TestRejectExpiredIntermediates(t *testing.T) {
+	tl := NewEmptyTestLog(t)
+	tl.StartSequencer()
+	chain := fmt.Sprintf(`{"chain": [%q, %q, %q]}`,
+		base64.StdEncoding.EncodeToString(testLeaf),
+		base64.StdEncoding.EncodeToString(testIntermediate),
+		base64.StdEncoding.EncodeToString(testRoot))
+	post := func() *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/ct/v1/add-chain", strings.NewReader(chain))
+		tl.Log.Handler().ServeHTTP(rr, req)
+		return rr
+	}
+
+	// The test intermediate expired in September 2025.
+	if rr := post(); rr.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", rr.Code, rr.Body)
+	}
+	// The verified chain is now cached, but the check must still apply.
+	tl.Config.RejectExpiredIntermediates = true
+	if rr := post(); rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), `"chain.expired"`) {
+		t.Errorf("got status %d, expected 400 chain.expired: %s", rr.Code, rr.Body)
+	}
+}
+
+func TestRejectInvalidAtSubmission(t *testing.T) {
+	tl := NewEmptyTestLog(t)
+	tl.Config.RejectExpired = true
+	tl.Config.RejectNotYetValid = true
+	tl.Config.NotBeforeSkew = time.Hour
+	leaf, err := ctx509.ParseCertificate(testLeaf)
+	fatalIfErr(t, err)
+
+	// submit returns the status of an add-chain request at the given time. The
+	// request context is already canceled, so accepted submissions fail with
+	// 500 while waiting to be sequenced, instead of blocking.
+	submit := func(now time.Time) *httptest.ResponseRecorder {
+		tl.Config.Clock = ctlogtest.NewManualClock(now).Now
+		body := fmt.Sprintf(`{"chain": [%q, %q, %q]}`,
+			base64.StdEncoding.EncodeToString(testLeaf),
+			base64.StdEncoding.EncodeToString(testIntermediate),
+			base64.StdEncoding.EncodeToString(testRoot))
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequestWithContext(ctx, "POST", "/ct/v1/add-chain", strings.NewReader(body))
+		tl.Log.Handler().ServeHTTP(rr, req)
+		return rr
+	}
+
+	for _, tc := range []struct {
+		name   string
+		now    time.Time
+		reason string
+	}{
+		{"AtNotAfter", leaf.NotAfter, ""},
+		{"AfterNotAfter", leaf.NotAfter.Add(time.Millisecond), "cert.expired"},
+		{"WithinSkew", leaf.NotBefore.Add(-time.Hour), ""},
+		{"BeyondSkew", leaf.NotBefore.Add(-time.Hour - time.Millisecond), "cert.not_yet_valid"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := submit(tc.now)
+			if tc.reason == "" {
+				if rr.Code == http.StatusBadRequest {
+					t.Errorf("got status 400, expected the submission to be accepted: %s", rr.Body)
+				}
+			} else if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), `"`+tc.reason+`"`) {
+				t.Errorf("got status %d, expected 400 %s: %s", rr.Code, tc.reason, rr.Body)
+			}
+		})
+	}
+}
+
+func TestChainCompletion(t *testing.T) {
+	tl := NewEmptyTestLog(t)
+	logClient := tl.LogClient()
+	post := func(chain ...[]byte) *httptest.ResponseRecorder {
+		t.Helper()
+		var req struct {
+			Chain [][]byte `json:"chain"`
+		}
+		req.Chain = chain
+		body, err := json.Marshal(req)
+		fatalIfErr(t, err)
+		rr := httptest.NewRecorder()
+		tl.Log.Handler().ServeHTTP(rr, httptest.NewRequest("POST", "/ct/v1/add-pre-chain", bytes.NewReader(body)))
+		return rr
+	}
+
+	// Before the intermediate is known, the chain can't be completed.
+	if rr := post(testPrecert); rr.Code != http.StatusBadRequest {
+		t.Fatalf("got status %d, expected 400: %s", rr.Code, rr.Body)
+	}
+	if _, err := logClient.AddChain(context.Background(), []ct.ASN1Cert{
+		{Data: testLeaf}, {Data: testIntermediate}, {Data: testRoot}}); err != nil {
+		t.Fatal(err)
+	}
+
+	tl.Config.StrictChains = true
+	if rr := post(testPrecert, testRoot); rr.Code != http.StatusBadRequest ||
+		!strings.Contains(rr.Body.String(), `"root.unknown"`) {
+		t.Errorf("strict: got status %d, expected 400 root.unknown: %s", rr.Code, rr.Body)
+	}
+	tl.Config.StrictChains = false
+	if rr := post(testPrecert, testRoot); rr.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", rr.Code, rr.Body)
+	}
+
+	// The completed chain is the one stored.
+	tile, err := tl.Config.Backend.Fetch(context.Background(), "tile/data/000.p/2")
+	fatalIfErr(t, err)
+	_, tile, err = sunlight.ReadTileLeaf(tile)
+	fatalIfErr(t, err)
+	e, _, err := sunlight.ReadTileLeaf(tile)
+	fatalIfErr(t, err)
+	if !e.IsPrecert {
+		t.Fatal("entry is not a precertificate")
+	}
+	exp := [][32]byte{sha256.Sum256(testIntermediate), sha256.Sum256(testRoot)}
+	if !reflect.DeepEqual(e.ChainFingerprints, exp) {
+		t.Errorf("chain fingerprints are %x, expected %x", e.ChainFingerprints, exp)
+	}
+}
+
+func TestRejectionDetails(t *testing.T) {
+	tl := NewEmptyTestLog(t)
+	intermediate, err := ctx509.ParseCertificate(testIntermediate)
+	fatalIfErr(t, err)
+	leaf, err := ctx509.ParseCertificate(testLeaf)
+	fatalIfErr(t, err)
+	badCert := []byte{0x30, 0x03, 0x02, 0x01, 0x00} // a SEQUENCE with an INTEGER
+
+	tests := []struct {
+		name     string
+		endpoint string
+		chain    [][]byte
+		setup    func(*ctlog.Config)
+		reason   string
+		index    int // -1 for no details
+		subject  string
+	}{
+		{"Parse", "add-chain", [][]byte{testLeaf, badCert, testRoot}, nil,
+			"cert.parse_error", 1, ""},
+		{"TooLarge", "add-chain", [][]byte{testLeaf, make([]byte, 70000)}, func(c *ctlog.Config) {
+			c.MaxBodySize = 1 << 20
+		}, "cert.too_large", 1, ""},
+		{"UnknownRoot", "add-chain", [][]byte{testLeaf}, nil,
+			"root.unknown", 0, leaf.Subject.String()},
+		{"NotAfter", "add-chain", [][]byte{testLeaf, testIntermediate, testRoot}, func(c *ctlog.Config) {
+			c.NotAfterLimit = c.NotAfterStart.Add(time.Hour)
+		}, "cert.not_after_out_of_range", 0, leaf.Subject.String()},
+		{"WrongEndpoint", "add-pre-chain", [][]byte{testLeaf, testIntermediate, testRoot}, nil,
+			"cert.wrong_endpoint", 0, leaf.Subject.String()},
+		{"Expired", "add-chain", [][]byte{testLeaf, testIntermediate, testRoot}, func(c *ctlog.Config) {
+			c.RejectExpired = true
+		}, "cert.expired", 0, leaf.Subject.String()},
+		{"ExpiredIntermediate", "add-chain", [][]byte{testLeaf, testIntermediate, testRoot}, func(c *ctlog.Config) {
+			c.RejectExpiredIntermediates = true
+		}, "chain.expired", 1, intermediate.Subject.String()},
+		{"Policy", "add-chain", [][]byte{testLeaf, testIntermediate, testRoot}, func(c *ctlog.Config) {
+			c.Policies = []ctlog.SubmissionPolicy{ctlog.IssuerDenylist{
+				sha256.Sum256(intermediate.RawSubjectPublicKeyInfo): true}}
+		}, "policy.rejected", -1, ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			config := *tl.Config
+			if tc.setup != nil {
+				tc.setup(&config)
+			}
+			// Recreate the log config, since some cases change NotAfterLimit.
+			fatalIfErr(t, config.Backend.(*MemoryBackend).Delete(context.Background(), "log-config"))
+			config.CreateMissingLogConfig = true
+			l, err := ctlog.LoadLog(context.Background(), &config)
+			fatalIfErr(t, err)
+			t.Cleanup(func() { fatalIfErr(t, l.CloseCache()) })
+
+			req, err := json.Marshal(map[string][][]byte{"chain": tc.chain})
+			fatalIfErr(t, err)
+			rr := httptest.NewRecorder()
+			l.Handler().ServeHTTP(rr, httptest.NewRequest("POST", "/ct/v1/"+tc.endpoint, bytes.NewReader(req)))
+			var rsp struct {
+				Code    string `json:"error_code"`
+				Details *struct {
+					Index   int    `json:"certificate_index"`
+					Subject string `json:"subject"`
+					Serial  string `json:"serial"`
+				} `json:"details"`
+			}
+			fatalIfErr(t, json.Unmarshal(rr.Body.Bytes(), &rsp))
+			if rr.Code != http.StatusBadRequest || rsp.Code != tc.reason {
+				t.Fatalf("got %d %q, expected 400 %q", rr.Code, rsp.Code, tc.reason)
+			}
+			if tc.index == -1 {
+				if rsp.Details != nil {
+					t.Errorf("unexpected details: %+v", rsp.Details)
+				}
+				return
+			}
+			if rsp.Details == nil {
+				t.Fatal("missing details")
+			}
+			if rsp.Details.Index != tc.index || rsp.Details.Subject != tc.subject {
+				t.Errorf("got details %+v, expected index %d and subject %q", rsp.Details, tc.index, tc.subject)
+			}
+			if tc.subject != "" && rsp.Details.Serial == "" {
+				t.Error("missing serial")
+			}
+			for _, c := range tc.chain {
+				if len(c) > 0 && strings.Contains(rr.Body.String(), base64.StdEncoding.EncodeToString(c)) {
+					t.Error("response echoes a submitted certificate")
+				}
+			}
+		})
+	}
+}
+
+func TestSubmitPEMAndDER(t *testing.T) {
+	tl := NewEmptyTestLog(t)
+	tl.StartSequencer()
+
+	post := func(contentType string, body []byte) *httptest.ResponseRecorder {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/ct/v1/add-chain", bytes.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		tl.Log.Handler().ServeHTTP(rr, req)
+		return rr
+	}
+	checkSCT := func(name string, rr *httptest.ResponseRecorder) {
+		t.Helper()
+		if rr.Code != http.StatusOK {
+			t.Errorf("%s: got status %d: %s", name, rr.Code, rr.Body)
+			return
+		}
+		var sct ct.AddChainResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &sct); err != nil || sct.SCTVersion != ct.V1 {
+			t.Errorf("%s: invalid SCT response %q", name, rr.Body)
+		}
+	}
+	pemCert := func(der []byte) []byte {
+		return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	}
+
+	bundle := slices.Concat([]byte("root\n"), pemCert(testRoot), pemCert(testLeaf), pemCert(testIntermediate))
+	checkSCT("PEM", post("application/pem-certificate-chain", bundle))
+	checkSCT("DER", post("application/pkix-cert", slices.Concat(testIntermediate, testLeaf)))
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for _, c := range [][]byte{testIntermediate, testLeaf} {
+		w, err := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {"application/pkix-cert"}})
+		fatalIfErr(t, err)
+		w.Write(c)
+	}
+	fatalIfErr(t, mw.Close())
+	checkSCT("multipart", post("multipart/mixed; boundary="+mw.Boundary(), buf.Bytes()))
+
+	for name, tc := range map[string]struct {
+		contentType string
+		body        []byte
+		reason      string
+	}{
+		"truncated DER":  {"application/pkix-cert", testLeaf[:len(testLeaf)-1], "request.malformed"},
+		"PEM key":        {"application/pem-certificate-chain", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY"}), "request.malformed"},
+		"empty PEM":      {"application/pem-certificate-chain", []byte("nothing here"), "request.malformed"},
+		"two leaves":     {"application/pkix-cert", slices.Concat(testLeaf, testLeaf), "chain.invalid"},
+		"unrelated cert": {"application/pkix-cert", slices.Concat(testLeaf, testRoot), "chain.invalid"},
+	} {
+		rr := post(tc.contentType, tc.body)
+		if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), `"`+tc.reason+`"`) {
+			t.Errorf("%s: got status %d, expected 400 %s: %s", name, rr.Code, tc.reason, rr.Body)
+		}
+	}
+}
+
+func TestSubmitLimits(t *testing.T) {
+	tl := NewEmptyTestLog(t)
+	tl.StartSequencer()
+
+	post := func(body io.Reader, contentLength int64) (int, string) {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/ct/v1/add-chain", body)
+		req.ContentLength = contentLength
+		tl.Log.Handler().ServeHTTP(rr, req)
+		if ct := rr.Header().Get("Content-Type"); rr.Code != http.StatusOK && ct != "application/json" {
+			t.Errorf("error response has Content-Type %q", ct)
+		}
+		var rsp struct {
+			Code    string `json:"error_code"`
+			Message string `json:"error_message"`
+		}
+		if rr.Code != http.StatusOK {
+			if err := json.Unmarshal(rr.Body.Bytes(), &rsp); err != nil {
+				t.Errorf("error response is not JSON: %q", rr.Body)
+			}
+		}
+		return rr.Code, rsp.Code
+	}
+	chainBody := func(certs ...[]byte) string {
+		var b64 []string
+		for _, c := range certs {
+			b64 = append(b64, fmt.Sprintf("%q", base64.StdEncoding.EncodeToString(c)))
+		}
+		return `{"chain": [` + strings.Join(b64, ", ") + `]}`
+	}
+	bigBody := func() io.Reader {
+		return io.MultiReader(strings.NewReader(`{"chain": ["`),
+			io.LimitReader(rand.Reader, 100<<20), strings.NewReader(`"]}`))
+	}
+
+	if code, reason := post(bigBody(), 100<<20); code != http.StatusRequestEntityTooLarge || reason != "body.too_large" {
+		t.Errorf("100MB declared body: got %d %q", code, reason)
+	}
+	if code, reason := post(bigBody(), -1); code != http.StatusRequestEntityTooLarge || reason != "body.too_large" {
+		t.Errorf("100MB streamed body: got %d %q", code, reason)
+	}
+
+	longChain := make([][]byte, 1000)
+	for i := range longChain {
+		longChain[i] = []byte{0}
+	}
+	body := chainBody(longChain...)
+	if code, reason := post(strings.NewReader(body), int64(len(body))); code != http.StatusBadRequest || reason != "chain.too_long" {
+		t.Errorf("1000 certificates: got %d %q", code, reason)
+	}
+
+	body = chainBody([]byte("not a certificate"))
+	if code, reason := post(strings.NewReader(body), int64(len(body))); code != http.StatusBadRequest || reason != "cert.parse_error" {
+		t.Errorf("invalid certificate: got %d %q", code, reason)
+	}
+	body = chainBody(testLeaf)
+	if code, reason := post(strings.NewReader(body), int64(len(body))); code != http.StatusBadRequest || reason != "root.unknown" {
+		t.Errorf("missing issuer: got %d %q", code, reason)
+	}
+	body = `{"chain": []}`
+	if code, reason := post(strings.NewReader(body), int64(len(body))); code != http.StatusBadRequest || reason != "chain.empty" {
+		t.Errorf("empty chain: got %d %q", code, reason)
+	}
+	body = chainBody(testLeaf, testIntermediate, testRoot)
+	if code, _ := post(strings.NewReader(body), int64(len(body))); code != http.StatusOK {
+		t.Errorf("valid chain: got %d", code)
+	}
+
+	// The reloaded logs don't run a sequencer, since their submissions are all
+	// rejected before reaching the pool.
+	tl.Config.MaxBodySize = 64 << 20
+	tl = ReloadLog(t, tl)
+	body = chainBody(make([]byte, 20<<20))
+	if code, reason := post(strings.NewReader(body), int64(len(body))); code != http.StatusBadRequest || reason != "cert.too_large" {
+		t.Errorf("20MB certificate: got %d %q", code, reason)
+	}
+
+	tl.Config.NotAfterLimit = tl.Config.NotAfterStart.Add(time.Hour)
+	fatalIfErr(t, tl.Config.Backend.(*MemoryBackend).Delete(context.Background(), "log-config"))
+	tl.Config.CreateMissingLogConfig = true
+	tl = ReloadLog(t, tl)
+	body = chainBody(testLeaf, testIntermediate, testRoot)
+	if code, reason := post(strings.NewReader(body), int64(len(body))); code != http.StatusBadRequest || reason != "cert.not_after_out_of_range" {
+		t.Errorf("NotAfter out of range: got %d %q", code, reason)
+	}
+
+	// The total size limit applies to the decoded certificates, regardless of
+	// the submission format.
+	tl.Config.MaxChainSize = len(testLeaf) + len(testIntermediate) + len(testRoot) - 1
+	body = chainBody(testLeaf, testIntermediate, testRoot)
+	if code, reason := post(strings.NewReader(body), int64(len(body))); code != http.StatusBadRequest || reason != "chain.too_large" {
+		t.Errorf("JSON chain over size limit: got %d %q", code, reason)
+	}
+	var pemChain []byte
+	for _, c := range [][]byte{testLeaf, testIntermediate, testRoot} {
+		pemChain = append(pemChain, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c})...)
+	}
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/ct/v1/add-chain", bytes.NewReader(pemChain))
+	req.Header.Set("Content-Type", "application/pem-certificate-chain")
+	tl.Log.Handler().ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), `"chain.too_large"`) {
+		t.Errorf("PEM chain over size limit: got %d %s", rr.Code, rr.Body)
+	}
+}
+
+// FuzzParseSubmission checks that no request body can panic the parsing of
+// submissions, in any of the supported formats.
+func FuzzParseSubmission(f *testing.F) {
+	chain := [][]byte{testLeaf, testIntermediate, testRoot}
+	var pemChain, derChain []byte
+	for _, c := range chain {
+		pemChain = append(pemChain, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c})...)
+		derChain = append(derChain, c...)
+	}
+	jsonChain, err := json.Marshal(map[string][][]byte{"chain": chain})
+	fatalIfErr(f, err)
+	f.Add("application/json", jsonChain)
+	f.Add("application/pem-certificate-chain", pemChain)
+	f.Add("application/pkix-cert", derChain)
+	f.Add("application/pkix-cert", testLeaf[:len(testLeaf)/2])
+	f.Add("multipart/mixed; boundary=x", []byte("--x\r\nContent-Type: application/pkix-cert\r\n\r\n"+string(testRoot)+"\r\n--x--\r\n"))
+	f.Fuzz(func(t *testing.T, contentType string, body []byte) {
+		rawChain, needsOrder, err := ctlog.ParseSubmission(contentType, body)
+		if err != nil || !needsOrder {
+			return
+		}
+		ctlog.OrderChain(rawChain)
+	})
+}
+
+func TestIssuerQuota(t *testing.T) {
+	intermediate, err := x509.ParseCertificate(testIntermediate)
+	fatalIfErr(t, err)
+	keyHash := sha256.Sum256(intermediate.RawSubjectPublicKeyInfo)
+
+	chain := fmt.Sprintf(`{"chain": [%q, %q, %q]}`,
+		base64.StdEncoding.EncodeToString(testLeaf),
+		base64.StdEncoding.EncodeToString(testIntermediate),
+		base64.StdEncoding.EncodeToString(testRoot))
+	post := func(tl *TestLog) *httptest.ResponseRecorder {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/ct/v1/add-chain", strings.NewReader(chain))
+		tl.Log.Handler().ServeHTTP(rr, req)
+		return rr
+	}
+
+	tl := NewEmptyTestLog(t)
+	tl.Config.IssuerQuotas = map[[32]byte]ctlog.Quota{keyHash: {Rate: 1e-6, Burst: 2}}
+	tl.Config.DefaultIssuerQuota = &ctlog.Quota{Rate: 1e-6, Burst: 1}
+	tl = ReloadLog(t, tl)
+	tl.StartSequencer()
+	for i := range 2 {
+		if rr := post(tl); rr.Code != http.StatusOK {
+			t.Fatalf("submission %d: got status %d: %s", i, rr.Code, rr.Body)
+		}
+	}
+	rr := post(tl)
+	if rr.Code != http.StatusTooManyRequests || !strings.Contains(rr.Body.String(), `"issuer.quota_exceeded"`) {
+		t.Errorf("over quota: got status %d: %s", rr.Code, rr.Body)
+	}
+	if ra, err := strconv.Atoi(rr.Header().Get("Retry-After")); err != nil || ra < 1 {
+		t.Errorf("unexpected Retry-After %q", rr.Header().Get("Retry-After"))
+	}
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(tl.Log.Metrics()...)
+	families, err := reg.Gather()
+	fatalIfErr(t, err)
+	var exceeded float64
+	for _, mf := range families {
+		if mf.GetName() == "addchain_issuer_quota_exceeded_total" {
+			for _, m := range mf.GetMetric() {
+				exceeded += m.GetCounter().GetValue()
+			}
+		}
+	}
+	if exceeded != 1 {
+		t.Errorf("got %v quota exceeded events, expected 1", exceeded)
+	}
+
+	// A non-positive rate would make Retry-After infinite or negative.
+	for _, rate := range []float64{0, -1, math.NaN()} {
+		c := *tl.Config
+		c.IssuerQuotas = map[[32]byte]ctlog.Quota{keyHash: {Rate: rate, Burst: 1}}
+		if _, err := ctlog.LoadLog(context.Background(), &c); err == nil {
+			t.Errorf("IssuerQuotas rate %v: LoadLog succeeded", rate)
+		}
+		c = *tl.Config
+		c.DefaultIssuerQuota = &ctlog.Quota{Rate: rate, Burst: 1}
+		if _, err := ctlog.LoadLog(context.Background(), &c); err == nil {
+			t.Errorf("DefaultIssuerQuota rate %v: LoadLog succeeded", rate)
+		}
+	}
+
+	// Issuers without a specific quota share the default one.
+	tl = NewEmptyTestLog(t)
+	tl.Config.DefaultIssuerQuota = &ctlog.Quota{Rate: 1e-6, Burst: 1}
+	tl = ReloadLog(t, tl)
+	tl.StartSequencer()
+	if rr := post(tl); rr.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", rr.Code, rr.Body)
+	}
+	if rr := post(tl); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("over default quota: got status %d", rr.Code)
+	}
+}
+
+func TestSubmissionPolicies(t *testing.T) {
+	tl := NewEmptyTestLog(t)
+	tl.StartSequencer()
+	intermediate, err := x509.ParseCertificate(testIntermediate)
+	fatalIfErr(t, err)
+
+	post := func(chain ...[]byte) *httptest.ResponseRecorder {
+		t.Helper()
+		var req struct {
+			Chain [][]byte `json:"chain"`
+		}
+		req.Chain = chain
+		body, err := json.Marshal(req)
+		fatalIfErr(t, err)
+		rr := httptest.NewRecorder()
+		tl.Log.Handler().ServeHTTP(rr, httptest.NewRequest("POST", "/ct/v1/add-chain", bytes.NewReader(body)))
+		return rr
+	}
+
+	tl.Config.Policies = []ctlog.SubmissionPolicy{
+		ctlog.MinRSAKeySize(2048),
+		ctlog.IssuerDenylist{sha256.Sum256(intermediate.RawSubjectPublicKeyInfo): true},
+	}
+	rr := post(testLeaf, testIntermediate, testRoot)
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), `"policy.rejected"`) {
+		t.Errorf("got status %d, expected 400 policy.rejected: %s", rr.Code, rr.Body)
+	}
+	if rr := post(tl.NewTestChain(false, false).Chain()...); rr.Code != http.StatusOK {
+		t.Errorf("got status %d: %s", rr.Code, rr.Body)
+	}
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(tl.Log.Metrics()...)
+	families, err := reg.Gather()
+	fatalIfErr(t, err)
+	rejections := make(map[string]float64)
+	for _, mf := range families {
+		if mf.GetName() == "addchain_policy_rejections_total" {
+			for _, m := range mf.GetMetric() {
+				rejections[m.GetLabel()[0].GetValue()] += m.GetCounter().GetValue()
+			}
+		}
+	}
+	if exp := map[string]float64{"issuer_denylist": 1}; !reflect.DeepEqual(rejections, exp) {
+		t.Errorf("got policy rejections %v, expected %v", rejections, exp)
+	}
+}
+
+func TestPoolFull(t *testing.T) {
+	tl := NewEmptyTestLog(t)
+	body := fmt.Sprintf(`{"chain": [%q, %q, %q]}`,
+		base64.StdEncoding.EncodeToString(testLeaf),
+		base64.StdEncoding.EncodeToString(testIntermediate),
+		base64.StdEncoding.EncodeToString(testRoot))
+	post := func() *httptest.ResponseRecorder {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		tl.Log.Handler().ServeHTTP(rr, httptest.NewRequest("POST", "/ct/v1/add-chain", strings.NewReader(body)))
+		return rr
+	}
+	checkFull := func(rr *httptest.ResponseRecorder) {
+		t.Helper()
+		if rr.Code != http.StatusServiceUnavailable || !strings.Contains(rr.Body.String(), `"pool.full"`) {
+			t.Errorf("got status %d, expected 503 pool.full: %s", rr.Code, rr.Body)
+		}
+		// The default sequencing period is one second.
+		if ra := rr.Header().Get("Retry-After"); ra != "1" && ra != "2" {
+			t.Errorf("unexpected Retry-After %q", ra)
+		}
+	}
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(tl.Log.Metrics()...)
+	gather := func(name string) float64 {
+		t.Helper()
+		families, err := reg.Gather()
+		fatalIfErr(t, err)
+		for _, mf := range families {
+			if mf.GetName() != name {
+				continue
+			}
+			m := mf.GetMetric()[0]
+			if c := m.GetCounter(); c != nil {
+				return c.GetValue()
+			}
+			return m.GetGauge().GetValue()
+		}
+		return 0
+	}
+
+	tl.Config.PoolSize = 1
+	addCertificate(t, tl)
+	checkFull(post())
+	if n := gather("sequencing_current_pool_entries"); n != 1 {
+		t.Errorf("got %v pool entries, expected 1", n)
+	}
+	fatalIfErr(t, tl.Log.Sequence())
+	if n := gather("sequencing_current_pool_entries"); n != 0 {
+		t.Errorf("got %v pool entries after sequencing, expected 0", n)
+	}
+
+	// The byte limit counts the encoded leaves, not just the certificate, so
+	// a pool limited to the size of testLeaf can't fit it.
+	tl.Config.PoolSize = 0
+	tl.Config.PoolMaxBytes = len(testLeaf)
+	checkFull(post())
+	addCertificate(t, tl)
+	if n := gather("sequencing_current_pool_bytes"); n == 0 || n > float64(len(testLeaf)) {
+		t.Errorf("got %v pool bytes, expected a small certificate", n)
+	}
+	checkFull(post())
+	fatalIfErr(t, tl.Log.Sequence())
+	if n := gather("sequencing_current_pool_bytes"); n != 0 {
+		t.Errorf("got %v pool bytes after sequencing, expected 0", n)
+	}
+
+	if full := gather("addchain_pool_full_total"); full != 3 {
+		t.Errorf("got %v pool full rejections, expected 3", full)
+	}
+}
+
+func TestPoolFlushBytes(t *testing.T) {
+	tl := NewEmptyTestLog(t)
+	entry := func(i int) *ctlog.PendingLogEntry {
+		cert := bytes.Repeat([]byte{byte(i)}, 64*1024)
+		return &ctlog.PendingLogEntry{Certificate: cert}
+	}
+	size := entry(0).EncodedSize()
+	tl.Config.PoolFlushBytes = 3 * size
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- tl.Log.RunSequencer(ctx, time.Hour) }()
+	t.Cleanup(func() { cancel(); <-done })
+
+	// Two entries are below the threshold, so nothing is sequenced until the
+	// next period.
+	var waits []func(context.Context) (*sunlight.LogEntry, error)
+	for i := range 2 {
+		f, _ := tl.Log.AddLeafToPool(entry(i))
+		waits = append(waits, f)
+	}
+	short, stop := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer stop()
+	if _, err := waits[0](short); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v below the threshold, expected the pool to wait", err)
+	}
+
+	// The third entry reaches the threshold, and triggers a round.
+	f, _ := tl.Log.AddLeafToPool(entry(2))
+	waits = append(waits, f)
+	long, stop := context.WithTimeout(context.Background(), 5*time.Second)
+	defer stop()
+	for i, wait := range waits {
+		e, err := wait(long)
+		fatalIfErr(t, err)
+		if e.LeafIndex != int64(i) {
+			t.Errorf("got leaf index %d, expected %d", e.LeafIndex, i)
+		}
+	}
+	tl.CheckLog(3)
+}
+
+func TestPoolFlushEntries(t *testing.T) {
+	tl := NewEmptyTestLog(t)
+	tl.Quiet()
+	tl.Config.PoolSize = 10
+	tl.Config.PoolFlushEntries = 10
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- tl.Log.RunSequencer(ctx, time.Hour) }()
+	t.Cleanup(func() { cancel(); <-done })
+
+	// A burst larger than the pool is accepted as rounds make room, without
+	// waiting for the period.
+	const burst = 100
+	var waits []func(context.Context) (*sunlight.LogEntry, error)
+	deadline := time.Now().Add(5 * time.Second)
+	for i := 0; len(waits) < burst; {
+		if time.Now().After(deadline) {
+			t.Fatalf("only %d entries accepted before the deadline", len(waits))
+		}
+		f, source := tl.Log.AddLeafToPool(&ctlog.PendingLogEntry{Certificate: fmt.Appendf(nil, "burst %d", i)})
+		if source == "ratelimit" {
+			time.Sleep(time.Millisecond)
+			continue
+		}
+		waits = append(waits, f)
+		i++
+	}
+
+	ctx, stop := context.WithTimeout(context.Background(), 5*time.Second)
+	defer stop()
+	rounds := make(map[int64]int)
+	var last int64
+	for i, wait := range waits {
+		e, err := wait(ctx)
+		fatalIfErr(t, err)
+		if e.LeafIndex != int64(i) {
+			t.Errorf("got leaf index %d, expected %d", e.LeafIndex, i)
+		}
+		if e.Timestamp < last {
+			t.Errorf("entry %d: timestamp %d is before the previous one, %d", i, e.Timestamp, last)
+		}
+		last = e.Timestamp
+		rounds[e.Timestamp]++
+	}
+	if len(rounds) < burst/10 {
+		t.Errorf("burst was sequenced in %d rounds, expected at least %d", len(rounds), burst/10)
+	}
+	for ts, n := range rounds {
+		if n > 10 {
+			t.Errorf("round %d sequenced %d entries, more than the pool size", ts, n)
+		}
+	}
+	if sth := tl.CheckLog(burst); sth < last {
+		t.Errorf("tree head timestamp %d is before the last SCT, %d", sth, last)
+	}
+}
+
+func TestEncodedSize(t *testing.T) {
+	for _, e := range []*ctlog.PendingLogEntry{
+		{Certificate: testLeaf, Issuers: [][]byte{testIntermediate, testRoot}},
+		{Certificate: []byte("tbs"), IsPrecert: true, PreCertificate: testLeaf,
+			Issuers: [][]byte{testIntermediate}},
+		{Certificate: []byte("A")},
+	} {
+		le := e.AsLogEntry(1<<39, time.Now().UnixMilli())
+		want := len(le.MerkleTreeLeaf()) + len(sunlight.AppendTileLeaf(nil, le))
+		if got := e.EncodedSize(); got != want {
+			t.Errorf("EncodedSize() = %d, want %d (precert: %v)", got, want, e.IsPrecert)
+		}
+	}
+}
+
+func TestHTTPMetrics(t *testing.T) {
+	tl := NewEmptyTestLog(t)
+	tl.StartSequencer()
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(tl.Log.Metrics()...)
+
+	chain := fmt.Sprintf(`{"chain": [%q, %q, %q]}`,
+		base64.StdEncoding.EncodeToString(testLeaf),
+		base64.StdEncoding.EncodeToString(testIntermediate),
+		base64.StdEncoding.EncodeToString(testRoot))
+	for _, body := range []string{chain, `{"chain": []}`} {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/ct/v1/add-chain", strings.NewReader(body))
+		tl.Log.Handler().ServeHTTP(rr, req)
+	}
+
+	families, err := reg.Gather()
+	fatalIfErr(t, err)
+	found := make(map[string]bool)
+	for _, mf := range families {
+		switch mf.GetName() {
+		case "http_request_duration_seconds":
+			for _, m := range mf.GetMetric() {
+				labels := make(map[string]string)
+				for _, lp := range m.GetLabel() {
+					labels[lp.GetName()] = lp.GetValue()
+				}
+				if labels["endpoint"] != "add-chain" {
+					continue
+				}
+				found["duration "+labels["code"]] = true
+				if c := m.GetHistogram().GetSampleCount(); c != 1 {
+					t.Errorf("add-chain %s: got %d samples, expected 1", labels["code"], c)
+				}
+			}
+		case "addchain_validation_seconds":
+			found["validation"] = true
+			if c := mf.GetMetric()[0].GetSummary().GetSampleCount(); c != 1 {
+				t.Errorf("validation: got %d samples, expected 1", c)
+			}
+		case "addchain_wait_seconds":
+			found["wait"] = true
+			if c := mf.GetMetric()[0].GetSummary().GetSampleCount(); c != 1 {
+				t.Errorf("wait: got %d samples, expected 1", c)
+			}
+		}
+	}
+	for _, k := range []string{"duration 200", "duration 400", "validation", "wait"} {
+		if !found[k] {
+			t.Errorf("missing metric %q", k)
+		}
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	tl := NewEmptyTestLog(t)
+	tl.Config.RateLimit = 0.001
+	tl.Config.RateLimitBurst = 2
+	tl.Config.TrustedProxies = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	tl = ReloadLog(t, tl)
+
+	do := func(method, path, remote, xff string) *httptest.ResponseRecorder {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader("{}"))
+		req.RemoteAddr = remote
+		if xff != "" {
+			req.Header.Set("X-Forwarded-For", xff)
+		}
+		tl.Log.Handler().ServeHTTP(rr, req)
+		return rr
+	}
+
+	for i := range 2 {
+		if rr := do("POST", "/ct/v1/add-chain", "192.0.2.1:1234", ""); rr.Code == http.StatusTooManyRequests {
+			t.Fatalf("request %d was rate limited", i)
+		}
+	}
+	rr := do("POST", "/ct/v1/add-pre-chain", "192.0.2.1:4321", "")
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("got status %d, expected 429", rr.Code)
+	}
+	if ra, err := strconv.Atoi(rr.Header().Get("Retry-After")); err != nil || ra < 1 {
+		t.Errorf("unexpected Retry-After %q", rr.Header().Get("Retry-After"))
+	}
+	if rr := do("GET", "/ct/v1/get-sth", "192.0.2.1:1234", ""); rr.Code != http.StatusOK {
+		t.Errorf("get-sth: got status %d, expected 200", rr.Code)
+	}
+
+	// X-Forwarded-For is ignored from untrusted peers.
+	if rr := do("POST", "/ct/v1/add-chain", "192.0.2.1:1234", "198.51.100.1"); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("spoofed X-Forwarded-For: got status %d, expected 429", rr.Code)
+	}
+	for i := range 2 {
+		if rr := do("POST", "/ct/v1/add-chain", "10.1.2.3:1234", "192.0.2.1, 198.51.100.1, 10.0.0.2"); rr.Code == http.StatusTooManyRequests {
+			t.Errorf("proxied request %d was rate limited", i)
+		}
+	}
+	if rr := do("POST", "/ct/v1/add-chain", "10.4.5.6:1234", "198.51.100.1"); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("proxied request: got status %d, expected 429", rr.Code)
+	}
+
+	// IPv6 clients are limited by /64.
+	for i := range 2 {
+		do("POST", "/ct/v1/add-chain", fmt.Sprintf("[2001:db8::%d]:1234", i), "")
+	}
+	if rr := do("POST", "/ct/v1/add-chain", "[2001:db8::ffff]:1234", ""); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("IPv6 /64: got status %d, expected 429", rr.Code)
+	}
+	if rr := do("POST", "/ct/v1/add-chain", "[2001:db8:0:1::1]:1234", ""); rr.Code == http.StatusTooManyRequests {
+		t.Errorf("different IPv6 /64 was rate limited")
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	tl := NewEmptyTestLog(t)
+	var mu sync.Mutex
+	var buf bytes.Buffer
+	tl.Config.AccessLog = slog.New(slog.NewJSONHandler(writerFunc(func(p []byte) (int, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return buf.Write(p)
+	}), nil))
+	tl.Config.AccessLogSampleRate = 1e-9
+	tl.StartSequencer()
+	records := func() []map[string]any {
+		mu.Lock()
+		defer mu.Unlock()
+		var recs []map[string]any
+		dec := json.NewDecoder(bytes.NewReader(buf.Bytes()))
+		for dec.More() {
+			var rec map[string]any
+			fatalIfErr(t, dec.Decode(&rec))
+			recs = append(recs, rec)
+		}
+		buf.Reset()
+		return recs
+	}
+
+	for range 10 {
+		rr := httptest.NewRecorder()
+		tl.Log.Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/ct/v1/get-sth", nil))
+	}
+	if recs := records(); len(recs) != 0 {
+		t.Errorf("sampled requests were logged: %v", recs)
+	}
+
+	chain := fmt.Sprintf(`{"chain": [%q, %q, %q]}`,
+		base64.StdEncoding.EncodeToString(testLeaf),
+		base64.StdEncoding.EncodeToString(testIntermediate),
+		base64.StdEncoding.EncodeToString(testRoot))
+	post := func() {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/ct/v1/add-chain", strings.NewReader(chain))
+		req.RemoteAddr = "192.0.2.1:1234"
+		tl.Log.Handler().ServeHTTP(rr, req)
+	}
+
+	tl.Config.Backend.(*MemoryBackend).UploadCallback = failStagingAndNotPersist
+	post()
+	recs := records()
+	if len(recs) != 2 {
+		t.Fatalf("got %d records, expected 2: %v", len(recs), recs)
+	}
+	seqErr, req := recs[0], recs[1]
+	if seqErr["msg"] != "pool sequencing failed" || req["msg"] != "http request" {
+		t.Fatalf("unexpected records: %v", recs)
+	}
+	if req["status"] != float64(http.StatusInternalServerError) || req["pool"] == nil || req["pool"] != seqErr["pool"] {
+		t.Errorf("failed request record doesn't match sequencer error: %v", recs)
+	}
+
+	tl.Config.Backend.(*MemoryBackend).UploadCallback = nil
+	post()
+	recs = records()
+	if len(recs) != 1 {
+		t.Fatalf("got %d records, expected 1: %v", len(recs), recs)
+	}
+	for k, v := range map[string]any{"endpoint": "add-chain", "method": "POST", "path": "/ct/v1/add-chain",
+		"status": float64(http.StatusOK), "client_ip": "192.0.2.1", "leaf_index": float64(0), "source": "sequencer"} {
+		if recs[0][k] != v {
+			t.Errorf("%s: got %v, expected %v", k, recs[0][k], v)
+		}
+	}
+	for _, k := range []string{"request_id", "duration", "leaf_hash", "pool"} {
+		if _, ok := recs[0][k]; !ok {
+			t.Errorf("missing %s attribute", k)
+		}
+	}
+}
+
+func TestDebugState(t *testing.T) {
+	tl := NewEmptyTestLog(t)
+	for range tileWidth + 2 {
+		addCertificate(t, tl)
+	}
+	if ds := tl.Log.DebugState(); ds.TreeSize != 0 || ds.PoolSize != tileWidth+2 {
+		t.Errorf("before sequencing: got %+v", ds)
+	}
+	fatalIfErr(t, tl.Log.Sequence())
+	ds := tl.Log.DebugState()
+	if ds.TreeSize != tileWidth+2 || ds.PoolSize != 0 || ds.InSequencing != 0 {
+		t.Errorf("after sequencing: got %+v", ds)
+	}
+	if c := tl.Checkpoint(); ds.RootHash != c.Hash.String() || ds.TreeSize != c.N {
+		t.Errorf("tree head mismatch: got %+v", ds)
+	}
+	expected := []string{"tile/0/001.p/2", "tile/1/000.p/1", "tile/data/001.p/2"}
+	if !slices.Equal(ds.EdgeTiles, expected) {
+		t.Errorf("got edge tiles %v, expected %v", ds.EdgeTiles, expected)
+	}
+}
+
+func TestServerShutdown(t *testing.T) {
+	tl := NewEmptyTestLog(t)
+	chain := tl.NewTestChain(false, false)
+	var rawChain []ct.ASN1Cert
+	for _, c := range chain.Chain() {
+		rawChain = append(rawChain, ct.ASN1Cert{Data: c})
+	}
+
+	// Block the first round that sequences an entry, after the checkpoint is
+	// committed but before the data tile is uploaded.
+	sequencing := make(chan struct{})
+	release := make(chan struct{})
+	var once sync.Once
+	tl.Config.Backend.(*MemoryBackend).UploadCallback = func(key string, data []byte) (bool, error) {
+		if strings.HasPrefix(key, "tile/data/") {
+			once.Do(func() {
+				close(sequencing)
+				<-release
+			})
+		}
+		return true, nil
+	}
+
+	s := &ctlog.Server{SequencePeriod: 50 * time.Millisecond, Log: tl.Config.Log}
+	s.AddLog("/logs/test", tl.Log)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	fatalIfErr(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- s.Serve(ctx, &http.Server{Handler: s.Handler()}, ln)
+	}()
+
+	url := "http://" + ln.Addr().String() + "/logs/test"
+	pubKey, err := x509.MarshalPKIXPublicKey(tl.Config.Key.Public())
+	fatalIfErr(t, err)
+	logClient, err := client.New(url, http.DefaultClient, jsonclient.Options{PublicKeyDER: pubKey})
+	fatalIfErr(t, err)
+
+	type result struct {
+		sct *ct.SignedCertificateTimestamp
+		err error
+	}
+	submitted := make(chan result, 1)
+	go func() {
+		sct, err := logClient.AddChain(context.Background(), rawChain)
+		submitted <- result{sct, err}
+	}()
+	<-sequencing
+
+	// Shut down while the submission is in flight, and wait for the server to
+	// start rejecting new submissions.
+	cancel()
+	for {
+		res, err := http.Post(url+"/ct/v1/add-chain", "application/json", strings.NewReader("{}"))
+		fatalIfErr(t, err)
+		res.Body.Close()
+		if res.StatusCode == http.StatusServiceUnavailable {
+			break
+		}
+		if res.StatusCode != http.StatusBadRequest {
+			t.Fatalf("unexpected status %d", res.StatusCode)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if res, err := http.Get(url + "/ct/v1/get-sth"); err != nil {
+		t.Errorf("get-sth during shutdown: %v", err)
+	} else if res.Body.Close(); res.StatusCode != http.StatusOK {
+		t.Errorf("get-sth during shutdown: got status %d", res.StatusCode)
+	}
+
+	close(release)
+	r := <-submitted
+	if r.err != nil {
+		t.Fatalf("submission accepted before shutdown failed: %v", r.err)
+	}
+	if err := <-serveErr; err != nil {
+		t.Errorf("Serve returned an error: %v", err)
+	}
+	tl.CheckLog(1)
+	if ext, err := sunlight.ParseExtensions(r.sct.Extensions); err != nil {
+		t.Error(err)
+	} else if ext.LeafIndex != 0 {
+		t.Errorf("got leaf index %d, expected 0", ext.LeafIndex)
+	}
+}
+
+func TestHealthChecks(t *testing.T) {
+	tl := NewEmptyTestLog(t)
+	tl.Quiet()
+	s := &ctlog.Server{HealthMaxFailures: 3}
+	s.AddLog("/log", tl.Log)
+	check := func(path string, expected int) {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		if rr.Code != expected {
+			t.Errorf("%s: got status %d, expected %d: %s", path, rr.Code, expected, rr.Body)
+		}
+	}
+
+	check("/readyz", http.StatusServiceUnavailable)
+	fatalIfErr(t, tl.Log.Sequence())
+	check("/readyz", http.StatusOK)
+	check("/healthz", http.StatusOK)
+
+	tl.Config.Backend.(*MemoryBackend).UploadCallback = failStagingAndNotPersist
+	for range 2 {
+		tl.Log.Sequence()
+	}
+	check("/healthz", http.StatusOK)
+	tl.Log.Sequence()
+	check("/healthz", http.StatusServiceUnavailable)
+	check("/readyz", http.StatusOK)
+
+	tl.Config.Backend.(*MemoryBackend).UploadCallback = nil
+	fatalIfErr(t, tl.Log.Sequence())
+	check("/healthz", http.StatusOK)
+
+	tl = NewEmptyTestLog(t)
+	tl.Config.Backend = failingFetchBackend{tl.Config.Backend}
+	s = &ctlog.Server{}
+	s.AddLog("/log", tl.Log)
+	fatalIfErr(t, tl.Log.Sequence())
+	check("/healthz", http.StatusServiceUnavailable)
+
+	// A failing Ping flips readiness, but doesn't stop the sequencer.
+	tl = NewEmptyTestLog(t)
+	pb := &pingingBackend{MemoryBackend: tl.Config.Backend.(*MemoryBackend)}
+	tl.Config.Backend = ctlog.NewMetricsBackend(pb)
+	tl = ReloadLog(t, tl)
+	s = &ctlog.Server{}
+	s.AddLog("/log", tl.Log)
+	pb.err = errors.New("backend unreachable")
+	fatalIfErr(t, tl.Log.Sequence())
+	check("/readyz", http.StatusServiceUnavailable)
+	check("/healthz", http.StatusServiceUnavailable)
+	if pb.pings != 1 {
+		t.Errorf("got %d pings, expected 1 cached probe", pb.pings)
+	}
+	addCertificate(t, tl)
+	fatalIfErr(t, tl.Log.Sequence())
+	tl.CheckLog(1)
+
+	// A probe cut short by the client's request context is not cached.
+	tl = NewEmptyTestLog(t)
+	pb = &pingingBackend{MemoryBackend: tl.Config.Backend.(*MemoryBackend)}
+	tl.Config.Backend = ctlog.NewMetricsBackend(pb)
+	tl = ReloadLog(t, tl)
+	s = &ctlog.Server{}
+	s.AddLog("/log", tl.Log)
+	fatalIfErr(t, tl.Log.Sequence())
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	rr := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/readyz", nil).WithContext(ctx))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("/readyz with canceled context: got status %d, expected %d", rr.Code, http.StatusServiceUnavailable)
+	}
+	check("/readyz", http.StatusOK)
+	check("/healthz", http.StatusOK)
+	if pb.pings != 2 {
+		t.Errorf("got %d pings, expected 2", pb.pings)
+	}
+
+	// Negative values disable the checks.
+	tl = NewEmptyTestLog(t)
+	tl.Quiet()
+	s = &ctlog.Server{HealthMaxFailures: -1, HealthMaxStaleness: -1}
+	s.AddLog("/log", tl.Log)
+	tl.Config.Backend.(*MemoryBackend).UploadCallback = failStagingAndNotPersist
+	for range 10 {
+		tl.Log.Sequence()
+	}
+	check("/healthz", http.StatusOK)
+}
+
+// pingingBackend is a MemoryBackend that implements PingBackend.
+type pingingBackend struct {
+	*MemoryBackend
+	mu    sync.Mutex
+	pings int
+	err   error
+}
+
+func (b *pingingBackend) Ping(ctx context.Context) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.pings++
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return b.err
+}
+
+func TestReloadWrongName(t *testing.T) {
+	tl := NewEmptyTestLog(t)
+	log, err := ctlog.LoadLog(context.Background(), tl.Config)
+	fatalIfErr(t, err)
+	t.Cleanup(func() { fatalIfErr(t, log.CloseCache()) })
+
+	c := tl.Config
+	c.Name = "wrong"
+	if _, err := ctlog.LoadLog(context.Background(), c); err == nil {
+		t.Error("expected loading to fail")
+	}
+}
+
+func TestReloadCrossedBackend(t *testing.T) {
+	// A log pointed at the object storage of another log, which has a
+	// different name or key, reports the specific mismatch.
+	for _, tc := range []struct {
+		name   string
+		modify func(c *ctlog.Config)
+		err    string
+	}{
+		{"Name", func(c *ctlog.Config) {
+			c.Name = "example.com/OtherLog"
+		}, `checkpoint is for log "example.com/OtherLog", not "example.com/TestLog"`},
+		{"Key", func(c *ctlog.Config) {
+			key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			fatalIfErr(t, err)
+			c.Key = key
+		}, "checkpoint was signed by key ID"},
+		{"WitnessKey", func(c *ctlog.Config) {
+			_, key, err := ed25519.GenerateKey(rand.Reader)
+			fatalIfErr(t, err)
+			c.WitnessKey = key
+		}, "checkpoint was signed by witness key ID"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tl := NewEmptyTestLog(t)
+			other := *tl.Config
+			tc.modify(&other)
+			other.Backend = NewMemoryBackend(t)
+			other.Lock = NewMemoryLockBackend(t)
+			other.Cache = filepath.Join(t.TempDir(), "cache.db")
+			fatalIfErr(t, ctlog.CreateLog(context.Background(), &other))
+
+			c := *tl.Config
+			c.Backend = other.Backend
+			_, err := ctlog.LoadLog(context.Background(), &c)
+			if err == nil {
+				t.Fatal("expected loading to fail")
+			}
+			if !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("got error %q, expected it to contain %q", err, tc.err)
+			}
+		})
+	}
+}
+
+func TestLogConfig(t *testing.T) {
+	t.Run("Stored", func(t *testing.T) {
+		tl := NewEmptyTestLog(t)
+		mb := tl.Config.Backend.(*MemoryBackend)
+		b, err := mb.Fetch(context.Background(), "log-config")
+		fatalIfErr(t, err)
+		if !bytes.HasPrefix(b, []byte("sunlight log config v1\n")) {
+			t.Errorf("log config doesn't start with header:\n%s", b)
+		}
+		if !bytes.Contains(b, []byte(`"origin": "example.com/TestLog"`)) {
+			t.Errorf("log config doesn't contain origin:\n%s", b)
+		}
+	})
+	for _, tc := range []struct {
+		name   string
+		modify func(c *ctlog.Config)
+		err    string
+	}{
+		{"NotAfterStart", func(c *ctlog.Config) {
+			c.NotAfterStart = c.NotAfterStart.Add(time.Hour)
+		}, "log config has NotAfterStart"},
+		{"NotAfterLimit", func(c *ctlog.Config) {
+			c.NotAfterLimit = c.NotAfterLimit.Add(-time.Hour)
+		}, "log config has NotAfterLimit"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tl := NewEmptyTestLog(t)
+			c := *tl.Config
+			tc.modify(&c)
+			_, err := ctlog.LoadLog(context.Background(), &c)
+			if err == nil {
+				t.Fatal("expected loading to fail")
+			}
+			if !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("got error %q, expected it to contain %q", err, tc.err)
+			}
+
+			// A different time zone for the same instant is fine.
+			c = *tl.Config
+			c.NotAfterStart = c.NotAfterStart.In(time.FixedZone("X", 3600))
+			ReloadLog(t, &TestLog{Config: &c})
+		})
+	}
+	t.Run("Tampered", func(t *testing.T) {
+		tl := NewEmptyTestLog(t)
+		mb := tl.Config.Backend.(*MemoryBackend)
+		b, err := mb.Fetch(context.Background(), "log-config")
+		fatalIfErr(t, err)
+		b = bytes.Replace(b, []byte("2024-07-01"), []byte("2025-07-01"), 1)
+		fatalIfErr(t, mb.Upload(context.Background(), "log-config", b, nil))
+
+		c := *tl.Config
+		c.NotAfterLimit = time.Date(2025, time.July, 1, 0, 0, 0, 0, time.UTC)
+		if _, err := ctlog.LoadLog(context.Background(), &c); err == nil {
+			t.Fatal("expected loading to fail")
+		} else if !strings.Contains(err.Error(), "signature") {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+	// Signed configurations that CreateLog wouldn't produce.
+	for _, tc := range []struct {
+		name   string
+		modify func(text string) string
+		err    string
+	}{
+		{"TileHeight", func(text string) string {
+			return strings.Replace(text, `"tile_height": 8`, `"tile_height": 7`, 1)
+		}, "log config has tile height 7"},
+		{"NoHeader", func(text string) string {
+			return strings.TrimPrefix(text, "sunlight log config v1\n")
+		}, "unknown format"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tl := NewEmptyTestLog(t)
+			mb := tl.Config.Backend.(*MemoryBackend)
+			b, err := mb.Fetch(context.Background(), "log-config")
+			fatalIfErr(t, err)
+			text, _, _ := strings.Cut(string(b), "\n\n")
+			modified := tc.modify(text + "\n")
+			if modified == text+"\n" {
+				t.Fatalf("log config not modified:\n%s", b)
+			}
+			signed, err := ctlog.SignLogConfig(tl.Config, modified)
+			fatalIfErr(t, err)
+			fatalIfErr(t, mb.Upload(context.Background(), "log-config", signed, nil))
+
+			if _, err := ctlog.LoadLog(context.Background(), tl.Config); err == nil {
+				t.Fatal("expected loading to fail")
+			} else if !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("got error %q, expected it to contain %q", err, tc.err)
+			}
+		})
+	}
+	t.Run("Missing", func(t *testing.T) {
+		tl := NewEmptyTestLog(t)
+		addCertificate(t, tl)
+		fatalIfErr(t, tl.Log.Sequence())
+		mb := tl.Config.Backend.(*MemoryBackend)
+		fatalIfErr(t, mb.Delete(context.Background(), "log-config"))
+
+		if _, err := ctlog.LoadLog(context.Background(), tl.Config); err == nil {
+			t.Fatal("expected loading to fail")
+		} else if !strings.Contains(err.Error(), "CreateMissingLogConfig") {
+			t.Errorf("unexpected error: %v", err)
+		}
+
+		tl.Config.CreateMissingLogConfig = true
+		tl = ReloadLog(t, tl)
+		tl.CheckLog(1)
+		if mb.Uploads("log-config") != 2 {
+			t.Errorf("log config uploaded %d times, expected 2", mb.Uploads("log-config"))
+		}
+
+		tl.Config.CreateMissingLogConfig = false
+		ReloadLog(t, tl)
+	})
+}
+
+func TestReloadWrongKey(t *testing.T) {
+	tl := NewEmptyTestLog(t)
+	log, err := ctlog.LoadLog(context.Background(), tl.Config)
+	fatalIfErr(t, err)
+	t.Cleanup(func() { fatalIfErr(t, log.CloseCache()) })
+
+	c := tl.Config
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	fatalIfErr(t, err)
+	c.Key = key
+	if _, err := ctlog.LoadLog(context.Background(), c); err == nil {
+		t.Error("expected loading to fail")
+	}
+
+	c = tl.Config
+	_, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	fatalIfErr(t, err)
+	c.WitnessKey = ed25519Key
+	if _, err := ctlog.LoadLog(context.Background(), c); err == nil {
+		t.Error("expected loading to fail")
+	}
+}
+
+func TestCrashAfterStaging(t *testing.T) {
+	tl := NewEmptyTestLog(t)
+	for range 10 {
+		addCertificate(t, tl)
+	}
+	fatalIfErr(t, tl.Log.Sequence())
+	tl.CheckLog(10)
+
+	// Simulate a crash right after the lock was updated, before any tile or
+	// the checkpoint made it to object storage.
+	mb := tl.Config.Backend.(*MemoryBackend)
+	var snapshot *MemoryBackendSnapshot
+	mb.UploadCallback = func(key string, data []byte) (bool, error) {
+		if snapshot == nil && strings.HasPrefix(key, "tile/") {
+			snapshot = mb.Snapshot()
+		}
+		return true, nil
+	}
+	for range 5 {
+		addCertificate(t, tl)
+	}
+	fatalIfErr(t, tl.Log.Sequence())
+	mb.UploadCallback = nil
+	if snapshot == nil {
+		t.Fatal("no tiles were uploaded")
+	}
+	keys := mb.Keys()
+	mb.Restore(snapshot)
+	if !slices.ContainsFunc(mb.Keys(), func(k string) bool { return strings.HasPrefix(k, "staging/") }) {
+		t.Fatal("staging bundle missing from snapshot")
+	}
+
+	// Reloading applies the staged tiles, and the next round publishes the
+	// checkpoint.
+	tl = ReloadLog(t, tl)
+	for _, key := range keys {
+		if !slices.Contains(mb.Keys(), key) && key != "checkpoint" {
+			t.Errorf("key %q was not restored from the staging bundle", key)
+		}
+	}
+	checkpoints := mb.Uploads("checkpoint")
+	fatalIfErr(t, tl.Log.Sequence())
+	if n := mb.Uploads("checkpoint"); n != checkpoints+1 {
+		t.Errorf("got %d checkpoint uploads, expected 1", n-checkpoints)
+	}
+	tl.CheckLog(15)
+}
+
+func TestStagingCollision(t *testing.T) {
+	tl := NewEmptyTestLog(t)
+	addCertificate(t, tl)
+	fatalIfErr(t, tl.Log.Sequence())
+
+	clock := ctlogtest.NewManualClock(time.UnixMilli(monotonicTime()))
+	tl.Config.Clock = clock.Now
+
+	addCertificateExpectFailureWithSeed(t, tl, 'A')
+	addCertificateExpectFailureWithSeed(t, tl, 'B')
+
+	tl.Config.Lock.(*MemoryLockBackend).ReplaceCallback = failLockAndNotPersist
+	sequenceExpectFailure(t, tl)
+	tl.CheckLog(1)
+
+	tl.Config.Lock.(*MemoryLockBackend).ReplaceCallback = nil
+	tl = ReloadLog(t, tl)
+	tl.CheckLog(1)
+
+	// First, cause the exact same staging bundle to be uploaded.
+
+	addCertificateWithSeed(t, tl, 'A')
+	addCertificateWithSeed(t, tl, 'B')
+	fatalIfErr(t, tl.Log.Sequence())
+	tl.CheckLog(3)
+
+	// Again, but now due to a staging bundle upload error.
+
+	clock.Advance(time.Millisecond)
+
+	addCertificateExpectFailureWithSeed(t, tl, 'C')
+	addCertificateExpectFailureWithSeed(t, tl, 'D')
+
+	tl.Config.Backend.(*MemoryBackend).UploadCallback = failStagingButPersist
+	fatalIfErr(t, tl.Log.Sequence())
+	tl.CheckLog(3)
+
+	tl.Config.Backend.(*MemoryBackend).UploadCallback = nil
+
+	addCertificateWithSeed(t, tl, 'C')
+	addCertificateWithSeed(t, tl, 'D')
+	fatalIfErr(t, tl.Log.Sequence())
+	tl.CheckLog(5)
+
+	// We wanted to also test reaching the same point through two different
+	// sequencing paths, as suggested in
+	// https://github.com/FiloSottile/sunlight/pull/18#discussion_r1704174301
+	// but that doesn't seem possible since time is required to move forward at
+	// each sequencing.
+}
+
+func sequenceExpectFailure(t *testing.T, tl *TestLog) {
+	t.Helper()
+	if err := tl.Log.Sequence(); err == nil {
+		t.Error("expected error, got nil")
+	}
+}
+
+func TestLocalBackend(t *testing.T) {
+	dir := t.TempDir()
+	logHandler, _ := testLogHandler(t)
+	b, err := ctlog.NewLocalBackend(context.Background(), dir, slog.New(logHandler))
+	fatalIfErr(t, err)
+	ctx := context.Background()
+
+	fatalIfErr(t, b.Upload(ctx, "tile/0/x001", []byte("hello"), &ctlog.UploadOptions{Immutable: true}))
+	fatalIfErr(t, b.Upload(ctx, "checkpoint", []byte("one"), nil))
+	fatalIfErr(t, b.Upload(ctx, "checkpoint", []byte("two"), nil))
+	if data, err := b.Fetch(ctx, "tile/0/x001"); err != nil || string(data) != "hello" {
+		t.Errorf("Fetch(tile/0/x001) = %q, %v", data, err)
+	}
+	if data, err := b.Fetch(ctx, "checkpoint"); err != nil || string(data) != "two" {
+		t.Errorf("Fetch(checkpoint) = %q, %v", data, err)
+	}
+	if _, err := b.Fetch(ctx, "tile/0/x002"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Fetch of a missing key returned %v, expected fs.ErrNotExist", err)
+	}
+	for _, key := range []string{"", "../escape", "/absolute", "tile/./x", "tile//x"} {
+		if err := b.Upload(ctx, key, []byte("x"), nil); err == nil {
+			t.Errorf("Upload(%q) succeeded", key)
+		}
+		if _, err := b.Fetch(ctx, key); err == nil || errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("Fetch(%q) returned %v, expected an invalid key error", key, err)
+		}
+	}
+
+	// No temporary files are left behind.
+	var files []string
+	fatalIfErr(t, filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			rel, _ := filepath.Rel(dir, path)
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return err
+	}))
+	if !slices.Equal(files, []string{"checkpoint", "tile/0/x001"}) {
+		t.Errorf("found files %q", files)
+	}
+
+	for _, key := range []string{"tile/0/x002.p/1", "tile/0/x002.p/2", "tile/1/000.p/1"} {
+		fatalIfErr(t, b.Upload(ctx, key, []byte("x"), nil))
+	}
+	for prefix, exp := range map[string][]string{
+		"tile/0/x002.p/": {"tile/0/x002.p/1", "tile/0/x002.p/2"},
+		"tile/0/":        {"tile/0/x001", "tile/0/x002.p/1", "tile/0/x002.p/2"},
+		"tile/0/x00":     {"tile/0/x001", "tile/0/x002.p/1", "tile/0/x002.p/2"},
+		"tile/2/":        nil,
+		"":               {"checkpoint", "tile/0/x001", "tile/0/x002.p/1", "tile/0/x002.p/2", "tile/1/000.p/1"},
+	} {
+		if keys, err := b.List(ctx, prefix); err != nil || !slices.Equal(keys, exp) {
+			t.Errorf("List(%q) = %q, %v, expected %q", prefix, keys, err, exp)
+		}
+	}
+	fatalIfErr(t, b.Delete(ctx, "tile/0/x002.p/1"))
+	fatalIfErr(t, b.Delete(ctx, "tile/0/x002.p/1"))
+	if _, err := b.Fetch(ctx, "tile/0/x002.p/1"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Fetch of a deleted key returned %v, expected fs.ErrNotExist", err)
+	}
+
+	fatalIfErr(t, b.Ping(ctx))
+	if keys, err := b.List(ctx, "health/"); err != nil || len(keys) != 0 {
+		t.Errorf("Ping left objects behind: %q, %v", keys, err)
+	}
+
+	fatalIfErr(t, b.Upload(ctx, "tile/0/x003", []byte("0123456789"), nil))
+	for _, tc := range []struct {
+		offset, length int64
+		exp            string
+	}{{0, 4, "0123"}, {6, 4, "6789"}, {8, 4, "89"}, {10, 4, ""}, {20, 4, ""}} {
+		if data, err := b.FetchRange(ctx, "tile/0/x003", tc.offset, tc.length); err != nil || string(data) != tc.exp {
+			t.Errorf("FetchRange(%d, %d) = %q, %v, expected %q", tc.offset, tc.length, data, err, tc.exp)
+		}
+	}
+	if _, err := b.FetchRange(ctx, "tile/0/x004", 0, 4); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("FetchRange of a missing key returned %v, expected fs.ErrNotExist", err)
+	}
+	createOnly := &ctlog.UploadOptions{CreateOnly: true}
+	if err := b.Upload(ctx, "tile/0/x003", []byte("other"), createOnly); !errors.Is(err, ctlog.ErrObjectExists) {
+		t.Errorf("CreateOnly Upload of an existing key returned %v, expected ErrObjectExists", err)
+	}
+	if data, err := b.Fetch(ctx, "tile/0/x003"); err != nil || string(data) != "0123456789" {
+		t.Errorf("CreateOnly Upload replaced the object: %q, %v", data, err)
+	}
+	fatalIfErr(t, b.Upload(ctx, "tile/0/x004", []byte("new"), createOnly))
+}
+
+func TestSQLiteObjectBackend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log.db")
+	logHandler, _ := testLogHandler(t)
+	b, err := ctlog.NewSQLiteObjectBackend(context.Background(), path, slog.New(logHandler))
+	fatalIfErr(t, err)
+	defer b.Close()
+	ctx := context.Background()
+
+	fatalIfErr(t, b.Upload(ctx, "checkpoint", []byte("one"), nil))
+	fatalIfErr(t, b.Upload(ctx, "checkpoint", []byte("two"), nil))
+	if data, err := b.Fetch(ctx, "checkpoint"); err != nil || string(data) != "two" {
+		t.Errorf("Fetch(checkpoint) = %q, %v", data, err)
+	}
+	if _, err := b.Fetch(ctx, "tile/0/x002"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Fetch of a missing key returned %v, expected fs.ErrNotExist", err)
+	}
+
+	// Concurrent uploads wait for each other.
+	g, gctx := errgroup.WithContext(ctx)
+	for i := range 50 {
+		g.Go(func() error {
+			return b.Upload(gctx, fmt.Sprintf("tile/0/x%03d", i), []byte{byte(i)}, nil)
+		})
+	}
+	fatalIfErr(t, g.Wait())
+	for i := range 50 {
+		if data, err := b.Fetch(ctx, fmt.Sprintf("tile/0/x%03d", i)); err != nil || !bytes.Equal(data, []byte{byte(i)}) {
+			t.Errorf("Fetch(tile/0/x%03d) = %x, %v", i, data, err)
+		}
+	}
+
+	fatalIfErr(t, b.BatchUpload(ctx, []ctlog.Object{
+		{Key: "tile/0/x100.p/1", Data: []byte("a")},
+		{Key: "tile/0/x100.p/2", Data: []byte("b")},
+		{Key: "tile/1/000.p/1", Data: []byte("c")},
+	}))
+	for prefix, exp := range map[string][]string{
+		"tile/0/x100.p/": {"tile/0/x100.p/1", "tile/0/x100.p/2"},
+		"tile/1/":        {"tile/1/000.p/1"},
+		"tile/2/":        nil,
+	} {
+		if keys, err := b.List(ctx, prefix); err != nil || !slices.Equal(keys, exp) {
+			t.Errorf("List(%q) = %q, %v, expected %q", prefix, keys, err, exp)
+		}
+	}
+	fatalIfErr(t, b.Delete(ctx, "tile/0/x100.p/1"))
+	fatalIfErr(t, b.Delete(ctx, "tile/0/x100.p/1"))
+	if _, err := b.Fetch(ctx, "tile/0/x100.p/1"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Fetch of a deleted key returned %v, expected fs.ErrNotExist", err)
+	}
+	fatalIfErr(t, b.Ping(ctx))
+
+	fatalIfErr(t, b.Upload(ctx, "tile/0/x200", []byte("0123456789"), nil))
+	for _, tc := range []struct {
+		offset, length int64
+		exp            string
+	}{{0, 4, "0123"}, {6, 4, "6789"}, {8, 4, "89"}, {10, 4, ""}, {20, 4, ""}} {
+		if data, err := b.FetchRange(ctx, "tile/0/x200", tc.offset, tc.length); err != nil || string(data) != tc.exp {
+			t.Errorf("FetchRange(%d, %d) = %q, %v, expected %q", tc.offset, tc.length, data, err, tc.exp)
+		}
+	}
+	if _, err := b.FetchRange(ctx, "tile/0/x201", 0, 4); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("FetchRange of a missing key returned %v, expected fs.ErrNotExist", err)
+	}
+	createOnly := &ctlog.UploadOptions{CreateOnly: true}
+	if err := b.Upload(ctx, "tile/0/x200", []byte("other"), createOnly); !errors.Is(err, ctlog.ErrObjectExists) {
+		t.Errorf("CreateOnly Upload of an existing key returned %v, expected ErrObjectExists", err)
+	}
+	if data, err := b.Fetch(ctx, "tile/0/x200"); err != nil || string(data) != "0123456789" {
+		t.Errorf("CreateOnly Upload replaced the object: %q, %v", data, err)
+	}
+	fatalIfErr(t, b.Upload(ctx, "tile/0/x201", []byte("new"), createOnly))
+
+	// The lock view only replaces the checkpoint that was fetched.
+	lb := b.LockBackend()
+	logID := sha256.Sum256([]byte("log"))
+	fatalIfErr(t, lb.Create(ctx, logID, []byte("one")))
+	if err := lb.Create(ctx, logID, []byte("one")); err == nil {
+		t.Errorf("Create of an existing checkpoint succeeded")
+	}
+	old, err := lb.Fetch(ctx, logID)
+	fatalIfErr(t, err)
+	if _, err := lb.Replace(ctx, old, []byte("two")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lb.Replace(ctx, old, []byte("three")); err == nil {
+		t.Errorf("Replace of a stale checkpoint succeeded")
+	}
+	if c, err := lb.Fetch(ctx, logID); err != nil || string(c.Bytes()) != "two" {
+		t.Errorf("Fetch = %v, %v, expected two", c, err)
+	}
+
+	// A whole log can live in a database, and survive reopening it.
+	path = filepath.Join(t.TempDir(), "log.db")
+	b, err = ctlog.NewSQLiteObjectBackend(ctx, path, slog.New(logHandler))
+	fatalIfErr(t, err)
+	tl := NewEmptyTestLog(t)
+	tl.Config.Backend = b
+	tl.Config.Lock = b.LockBackend()
+	tl.Config.Cache = filepath.Join(t.TempDir(), "cache.db")
+	fatalIfErr(t, ctlog.CreateLog(ctx, tl.Config))
+	tl = ReloadLog(t, tl)
+	for range tileWidth + 5 {
+		addCertificate(t, tl)
+	}
+	fatalIfErr(t, tl.Log.Sequence())
+	tl.CheckLog(tileWidth + 5)
+
+	fatalIfErr(t, b.Close())
+	b, err = ctlog.NewSQLiteObjectBackend(ctx, path, slog.New(logHandler))
+	fatalIfErr(t, err)
+	defer b.Close()
+	tl.Config.Backend = b
+	tl.Config.Lock = b.LockBackend()
+	tl = ReloadLog(t, tl)
+	addCertificate(t, tl)
+	fatalIfErr(t, tl.Log.Sequence())
+	tl.CheckLog(tileWidth + 6)
+}
+
+func TestPartialTileGC(t *testing.T) {
+	tl := NewEmptyTestLog(t)
+	tl.Config.PartialTileGC = true
+	tl.Config.PartialTileGCDryRun = true
+	tl = ReloadLog(t, tl)
+	mb := tl.Config.Backend.(*MemoryBackend)
+	partialTiles := func() (keys []string) {
+		for _, key := range mb.Keys() {
+			if strings.Contains(key, ".p/") {
+				keys = append(keys, key)
+			}
+		}
+		return keys
+	}
+	expectPartialTiles := func(exp ...string) {
+		t.Helper()
+		if keys := partialTiles(); !slices.Equal(keys, exp) {
+			t.Errorf("got partial tiles %q, expected %q", keys, exp)
+		}
+	}
+
+	// In dry-run mode nothing is deleted.
+	for range 3 {
+		addCertificate(t, tl)
+		fatalIfErr(t, tl.Log.Sequence())
+	}
+	expectPartialTiles("tile/0/000.p/1", "tile/0/000.p/2", "tile/0/000.p/3",
+		"tile/data/000.p/1", "tile/data/000.p/2", "tile/data/000.p/3")
+
+	// Otherwise, only the edge tiles are kept.
+	tl.Config.PartialTileGCDryRun = false
+	addCertificate(t, tl)
+	fatalIfErr(t, tl.Log.Sequence())
+	expectPartialTiles("tile/0/000.p/4", "tile/data/000.p/4")
+
+	// Partial tiles superseded by full ones are deleted too.
+	for range tileWidth - 4 {
+		addCertificate(t, tl)
+	}
+	fatalIfErr(t, tl.Log.Sequence())
+	expectPartialTiles("tile/1/000.p/1")
+	addCertificate(t, tl)
+	fatalIfErr(t, tl.Log.Sequence())
+	expectPartialTiles("tile/0/001.p/1", "tile/1/000.p/1", "tile/data/001.p/1")
+	tl.CheckLog(tileWidth + 1)
+
+	// The Backend must support List and Delete.
+	tl.Config.Backend = struct{ ctlog.Backend }{mb}
+	if _, err := ctlog.LoadLog(context.Background(), tl.Config); err == nil {
+		t.Errorf("LoadLog succeeded with a Backend that can't delete")
+	}
+}
+
+func TestStagingGC(t *testing.T) {
+	tl := NewEmptyTestLog(t)
+	tl.Quiet()
+	tl.Config.StagingGC = true
+	mb := tl.Config.Backend.(*MemoryBackend)
+	staging := func() []string {
+		var keys []string
+		for _, k := range mb.Keys() {
+			if strings.HasPrefix(k, "staging/") {
+				keys = append(keys, k)
+			}
+		}
+		return keys
+	}
+
+	// A published round doesn't need its bundle anymore.
+	addCertificate(t, tl)
+	fatalIfErr(t, tl.Log.Sequence())
+	if keys := staging(); len(keys) != 0 {
+		t.Errorf("got staging bundles %v after a published round", keys)
+	}
+
+	// A round that failed after the lock commit needs its bundle for LoadLog,
+	// which keeps it until a round is published past it.
+	addCertificateExpectFailure(t, tl)
+	mb.UploadCallback = failDataTileAndNotPersist
+	if err := tl.Log.Sequence(); err == nil {
+		t.Fatal("expected a fatal error")
+	}
+	mb.UploadCallback = nil
+	leftover := staging()
+	if len(leftover) != 1 {
+		t.Fatalf("got staging bundles %v after a failed upload, expected one", leftover)
+	}
+	tl = ReloadLog(t, tl)
+	if keys := staging(); !slices.Equal(keys, leftover) {
+		t.Errorf("got staging bundles %v after reload, expected %v", keys, leftover)
+	}
+	addCertificate(t, tl)
+	fatalIfErr(t, tl.Log.Sequence())
+	if keys := staging(); !slices.Equal(keys, leftover) {
+		t.Errorf("got staging bundles %v, expected only the leftover %v", keys, leftover)
+	}
+	tl = ReloadLog(t, tl)
+	if keys := staging(); len(keys) != 0 {
+		t.Errorf("got staging bundles %v after reload, expected none", keys)
+	}
+	tl.CheckLog(3)
+}
+
+// flakyBackend fails the first failures calls to Fetch with err.
+type flakyBackend struct {
+	*MemoryBackend
+	failures atomic.Int64
+	err      error
+}
+
+func (b *flakyBackend) Fetch(ctx context.Context, key string) ([]byte, error) {
+	if b.failures.Add(-1) >= 0 {
+		return nil, b.err
+	}
+	return b.MemoryBackend.Fetch(ctx, key)
+}
+
+func TestRetryBackend(t *testing.T) {
+	logHandler, _ := testLogHandler(t)
+	mb := NewMemoryBackend(t)
+	fb := &flakyBackend{MemoryBackend: mb, err: errors.New("flaky")}
+	rb := ctlog.NewRetryBackend(fb, slog.New(logHandler))
+	rb.BaseDelay = time.Millisecond
+	rb.MaxAttempts = 3
+	ctx := context.Background()
+
+	var uploadFailures atomic.Int64
+	errUpload := errors.New("upload failed")
+	mb.UploadCallback = func(key string, data []byte) (bool, error) {
+		if uploadFailures.Add(-1) >= 0 {
+			return false, errUpload
+		}
+		return true, nil
+	}
+
+	// Transient errors are retried until success.
+	uploadFailures.Store(2)
+	fatalIfErr(t, rb.Upload(ctx, "checkpoint", []byte("hello"), nil))
+	if n := mb.Uploads("checkpoint"); n != 3 {
+		t.Errorf("got %d uploads, expected 3", n)
+	}
+	fb.failures.Store(2)
+	if data, err := rb.Fetch(ctx, "checkpoint"); err != nil || string(data) != "hello" {
+		t.Errorf("Fetch = %q, %v", data, err)
+	}
+
+	// After MaxAttempts, the last error is returned.
+	uploadFailures.Store(3)
+	if err := rb.Upload(ctx, "key", []byte("x"), nil); !errors.Is(err, errUpload) ||
+		!strings.Contains(err.Error(), "after 3 attempts") {
+		t.Errorf("Upload returned %v, expected to give up after 3 attempts", err)
+	}
+	if n := mb.Uploads("key"); n != 3 {
+		t.Errorf("got %d uploads, expected 3", n)
+	}
+	uploadFailures.Store(0)
+
+	// Permanent errors are not retried.
+	before := mb.Fetches("missing")
+	if _, err := rb.Fetch(ctx, "missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Fetch of a missing key returned %v", err)
+	}
+	if n := mb.Fetches("missing") - before; n != 1 {
+		t.Errorf("got %d fetches of a missing key, expected 1", n)
+	}
+	rb.Retryable = func(err error) bool { return !errors.Is(err, errUpload) }
+	uploadFailures.Store(1)
+	if err := rb.Upload(ctx, "permanent", []byte("x"), nil); !errors.Is(err, errUpload) {
+		t.Errorf("Upload returned %v, expected the permanent error", err)
+	}
+	if n := mb.Uploads("permanent"); n != 1 {
+		t.Errorf("got %d uploads, expected 1", n)
+	}
+	rb.Retryable = nil
+
+	// Retries don't outlive the context deadline.
+	rb.BaseDelay = time.Second
+	rb.MaxAttempts = 10
+	fb.failures.Store(10)
+	ctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := rb.Fetch(ctx, "checkpoint"); err == nil {
+		t.Error("Fetch succeeded, expected an error")
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Fetch took %v, longer than the context deadline", elapsed)
+	}
+}
+
+func TestMetricsBackend(t *testing.T) {
+	mb := NewMemoryBackend(t)
+	b := ctlog.NewMetricsBackend(mb)
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(b.Metrics()...)
+	ctx := context.Background()
+
+	fatalIfErr(t, b.Upload(ctx, "checkpoint", []byte("hello"), nil))
+	fatalIfErr(t, b.Upload(ctx, "tile/data/000", []byte("data"), nil))
+	fatalIfErr(t, b.Upload(ctx, "tile/0/000.p/5", []byte("hash"), nil))
+	if _, err := b.Fetch(ctx, "checkpoint"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Fetch(ctx, "issuer/missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Fetch of a missing key returned %v", err)
+	}
+	mb.UploadCallback = failCheckpointAndNotPersist
+	if err := b.Upload(ctx, "checkpoint", []byte("fail"), nil); err == nil {
+		t.Error("Upload succeeded, expected an error")
+	}
+
+	families, err := reg.Gather()
+	fatalIfErr(t, err)
+	requests := make(map[string]float64)
+	sizes := make(map[string]float64)
+	for _, mf := range families {
+		for _, m := range mf.GetMetric() {
+			labels := make(map[string]string)
+			for _, lp := range m.GetLabel() {
+				labels[lp.GetName()] = lp.GetValue()
+			}
+			switch mf.GetName() {
+			case "backend_requests_total":
+				requests[labels["method"]+" "+labels["class"]+" "+labels["result"]] = m.GetCounter().GetValue()
+			case "backend_bytes_total":
+				sizes[labels["method"]+" "+labels["class"]] = m.GetCounter().GetValue()
+			}
+		}
+	}
+	expRequests := map[string]float64{
+		"upload checkpoint ok":    1,
+		"upload checkpoint error": 1,
+		"upload data_tile ok":     1,
+		"upload hash_tile ok":     1,
+		"fetch checkpoint ok":     1,
+		"fetch issuer not_found":  1,
+	}
+	if !reflect.DeepEqual(requests, expRequests) {
+		t.Errorf("got requests %v, expected %v", requests, expRequests)
+	}
+	expBytes := map[string]float64{
+		"upload checkpoint": 5,
+		"upload data_tile":  4,
+		"upload hash_tile":  4,
+		"fetch checkpoint":  5,
+	}
+	if !reflect.DeepEqual(sizes, expBytes) {
+		t.Errorf("got bytes %v, expected %v", sizes, expBytes)
+	}
+}
+
+// gatedBackend blocks Fetch calls until gate is closed.
+type gatedBackend struct {
+	*MemoryBackend
+	gate chan struct{}
+}
+
+func (b *gatedBackend) Fetch(ctx context.Context, key string) ([]byte, error) {
+	<-b.gate
+	return b.MemoryBackend.Fetch(ctx, key)
+}
+
+func TestTileCacheBackend(t *testing.T) {
+	mb := NewMemoryBackend(t)
+	gb := &gatedBackend{MemoryBackend: mb, gate: make(chan struct{})}
+	close(gb.gate)
+	b := ctlog.NewTileCacheBackend(gb, 10)
+	ctx := context.Background()
+
+	for _, key := range []string{"tile/0/000", "tile/0/001", "tile/0/002.p/5", "checkpoint"} {
+		fatalIfErr(t, b.Upload(ctx, key, []byte("abcdef"), nil))
+	}
+	fetch := func(key string) {
+		t.Helper()
+		if data, err := b.Fetch(ctx, key); err != nil || string(data) != "abcdef" {
+			t.Errorf("Fetch(%q) = %q, %v", key, data, err)
+		}
+	}
+
+	// Full tiles are cached, partial tiles and checkpoints are not.
+	for range 3 {
+		fetch("tile/0/000")
+		fetch("tile/0/002.p/5")
+		fetch("checkpoint")
+	}
+	for key, exp := range map[string]int{"tile/0/000": 1, "tile/0/002.p/5": 3, "checkpoint": 3} {
+		if n := mb.Fetches(key); n != exp {
+			t.Errorf("%s: got %d backend fetches, expected %d", key, n, exp)
+		}
+	}
+
+	// Only one tile fits in the budget, so fetching another evicts the first.
+	fetch("tile/0/001")
+	fetch("tile/0/000")
+	if n := mb.Fetches("tile/0/000"); n != 2 {
+		t.Errorf("got %d backend fetches after eviction, expected 2", n)
+	}
+
+	// Missing tiles are not cached.
+	for range 2 {
+		if _, err := b.Fetch(ctx, "tile/0/003"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("Fetch of a missing tile returned %v", err)
+		}
+	}
+	if n := mb.Fetches("tile/0/003"); n != 2 {
+		t.Errorf("got %d backend fetches of a missing tile, expected 2", n)
+	}
+
+	// Concurrent fetches of the same tile share a single backend fetch.
+	b = ctlog.NewTileCacheBackend(gb, 10)
+	gb.gate = make(chan struct{})
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b.Fetch(ctx, "tile/0/001")
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(gb.gate)
+	wg.Wait()
+	if n := mb.Fetches("tile/0/001"); n != 2 {
+		t.Errorf("got %d backend fetches for concurrent requests, expected 1", n-1)
+	}
+}
+
+func TestDiskCacheBackend(t *testing.T) {
+	logHandler, _ := testLogHandler(t)
+	ctx := context.Background()
+	mb := NewMemoryBackend(t)
+	dir := t.TempDir()
+	b, err := ctlog.NewDiskCacheBackend(mb, dir, 2*(32+6), slog.New(logHandler))
+	fatalIfErr(t, err)
+
+	for _, key := range []string{"tile/0/000", "tile/0/001", "tile/0/002", "tile/0/003.p/5", "checkpoint"} {
+		fatalIfErr(t, b.Upload(ctx, key, []byte("abcdef"), nil))
+	}
+	fetch := func(key string) {
+		t.Helper()
+		if data, err := b.Fetch(ctx, key); err != nil || string(data) != "abcdef" {
+			t.Errorf("Fetch(%q) = %q, %v", key, data, err)
+		}
+	}
+
+	// Full tiles are cached, partial tiles and checkpoints are not.
+	for range 3 {
+		fetch("tile/0/000")
+		fetch("tile/0/003.p/5")
+		fetch("checkpoint")
+	}
+	for key, exp := range map[string]int{"tile/0/000": 1, "tile/0/003.p/5": 3, "checkpoint": 3} {
+		if n := mb.Fetches(key); n != exp {
+			t.Errorf("%s: got %d backend fetches, expected %d", key, n, exp)
+		}
+	}
+
+	// A truncated file is discarded and fetched again.
+	fatalIfErr(t, os.Truncate(filepath.Join(dir, "tile/0/000"), 20))
+	fetch("tile/0/000")
+	fetch("tile/0/000")
+	if n := mb.Fetches("tile/0/000"); n != 2 {
+		t.Errorf("got %d backend fetches after corruption, expected 2", n)
+	}
+
+	// Two tiles fit in the budget, so fetching two more evicts the first.
+	fetch("tile/0/001")
+	fetch("tile/0/002")
+	if _, err := os.Stat(filepath.Join(dir, "tile/0/000")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("evicted tile is still on disk: %v", err)
+	}
+
+	// The cache survives a restart.
+	b, err = ctlog.NewDiskCacheBackend(mb, dir, 2*(32+6), slog.New(logHandler))
+	fatalIfErr(t, err)
+	fetch("tile/0/001")
+	fetch("tile/0/002")
+	for _, key := range []string{"tile/0/001", "tile/0/002"} {
+		if n := mb.Fetches(key); n != 1 {
+			t.Errorf("%s: got %d backend fetches after restart, expected 1", key, n)
+		}
+	}
+}
+
+func TestDiskCacheLoadLog(t *testing.T) {
+	tl := NewEmptyTestLog(t)
+	for range 2 * tileWidth {
+		addCertificate(t, tl)
+	}
+	fatalIfErr(t, tl.Log.Sequence())
+	tl.CheckLog(2 * tileWidth)
+
+	logHandler, _ := testLogHandler(t)
+	mb := tl.Config.Backend.(*MemoryBackend)
+	dir := t.TempDir()
+	b, err := ctlog.NewDiskCacheBackend(mb, dir, 1<<20, slog.New(logHandler))
+	fatalIfErr(t, err)
+	tl.Config.Backend = b
+	tl = ReloadLog(t, tl)
+
+	// Replace a cached edge tile with a well-formed but wrong one. LoadLog
+	// must notice it doesn't match the checkpoint, and refetch it.
+	path := filepath.Join(dir, "tile/0/001")
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("edge tile was not cached: %v", err)
+	}
+	wrong := make([]byte, tileWidth*32)
+	sum := sha256.Sum256(wrong)
+	fatalIfErr(t, os.WriteFile(path, append(sum[:], wrong...), 0o644))
+	tl = ReloadLog(t, tl)
+
+	cached, err := os.ReadFile(path)
+	fatalIfErr(t, err)
+	tile, err := mb.Fetch(context.Background(), "tile/0/001")
+	fatalIfErr(t, err)
+	if !bytes.Equal(cached[32:], tile) {
+		t.Errorf("corrupted edge tile was not replaced in the disk cache")
+	}
+
+	addCertificate(t, tl)
+	fatalIfErr(t, tl.Log.Sequence())
+	tl.CheckLog(2*tileWidth + 1)
+}
+
+func TestCompressBackend(t *testing.T) {
+	tl := NewEmptyTestLog(t)
+	mb := tl.Config.Backend.(*MemoryBackend)
+	addCertificate(t, tl)
+	fatalIfErr(t, tl.Log.Sequence())
+
+	if _, err := ctlog.NewCompressBackend(mb, []string{"hash_tile"}); err == nil {
+		t.Errorf("NewCompressBackend accepted hash tiles")
+	}
+	isCompressed := func(key string) bool {
+		t.Helper()
+		data, err := mb.Fetch(context.Background(), key)
+		fatalIfErr(t, err)
+		return bytes.HasPrefix(data, []byte("\xffZS1"))
+	}
+
+	// With compression enabled, new data tiles are stored compressed, while
+	// hash tiles and checkpoints are not. Uncompressed tiles are still read.
+	b, err := ctlog.NewCompressBackend(mb, []string{"data_tile", "staging"})
+	fatalIfErr(t, err)
+	tl.Config.Backend = b
+	tl = ReloadLog(t, tl)
+	for range tileWidth {
+		addCertificate(t, tl)
+	}
+	fatalIfErr(t, tl.Log.Sequence())
+	tl.CheckLog(tileWidth + 1)
+	if !isCompressed("tile/data/000") || !isCompressed("tile/data/001.p/1") {
+		t.Errorf("data tiles are not compressed")
+	}
+	if isCompressed("tile/0/000") || isCompressed("checkpoint") {
+		t.Errorf("hash tile or checkpoint are compressed")
+	}
+
+	// With compression disabled, for example because tiles are served directly
+	// from the bucket, new data tiles are stored uncompressed, and compressed
+	// ones are still read.
+	b, err = ctlog.NewCompressBackend(mb, nil)
+	fatalIfErr(t, err)
+	tl.Config.Backend = b
+	tl = ReloadLog(t, tl)
+	addCertificate(t, tl)
+	fatalIfErr(t, tl.Log.Sequence())
+	tl.CheckLog(tileWidth + 2)
+	if isCompressed("tile/data/001.p/2") {
+		t.Errorf("data tile is compressed with compression disabled")
+	}
+	if !isCompressed("tile/data/000") {
+		t.Errorf("full data tile was rewritten")
+	}
+}
+
+func TestVerifyingBackend(t *testing.T) {
+	ctx := context.Background()
+	tl := NewEmptyTestLog(t)
+	for range tileWidth + 3 {
+		addCertificate(t, tl)
+	}
+	fatalIfErr(t, tl.Log.Sequence())
+	mb := tl.Config.Backend.(*MemoryBackend)
+	b := ctlog.NewVerifyingBackend(mb)
+
+	for _, key := range []string{"tile/data/000", "tile/data/001.p/3", "tile/0/000", "tile/0/001.p/3", "checkpoint"} {
+		if _, err := b.Fetch(ctx, key); err != nil {
+			t.Errorf("Fetch(%q) = %v", key, err)
+		}
+	}
+	tl.Config.Backend = b
+	ReloadLog(t, tl)
+
+	mb.Corrupt("tile/data/001.p/3")
+	if _, err := b.Fetch(ctx, "tile/data/001.p/3"); !errors.Is(err, ctlog.ErrCorruptTile) {
+		t.Errorf("Fetch of a corrupt data tile returned %v", err)
+	}
+	mb.Corrupt("tile/0/000")
+	if _, err := b.Fetch(ctx, "tile/data/000"); !errors.Is(err, ctlog.ErrCorruptTile) {
+		t.Errorf("Fetch of a data tile with a corrupt level 0 tile returned %v", err)
+	}
+
+	// Full hash tiles are checked against the tile above them. Make up a level
+	// 1 tile that matches tile/0/005 but not tile/0/006.
+	randomTile := func() []byte {
+		b := make([]byte, tileWidth*32)
+		rand.Read(b)
+		return b
+	}
+	hb := ctlog.NewVerifyingBackend(NewMemoryBackend(t))
+	tile5, tile6, parent := randomTile(), randomTile(), randomTile()
+	root := func(data []byte) []byte {
+		var hashes []tlog.Hash
+		for i := 0; i < len(data); i += 32 {
+			hashes = append(hashes, tlog.Hash(data[i:i+32]))
+		}
+		for len(hashes) > 1 {
+			for i := range len(hashes) / 2 {
+				hashes[i] = tlog.NodeHash(hashes[2*i], hashes[2*i+1])
+			}
+			hashes = hashes[:len(hashes)/2]
+		}
+		return hashes[0][:]
+	}
+	copy(parent[5*32:], root(tile5))
+	fatalIfErr(t, hb.Upload(ctx, "tile/0/005", tile5, nil))
+	fatalIfErr(t, hb.Upload(ctx, "tile/0/006", tile6, nil))
+	fatalIfErr(t, hb.Upload(ctx, "tile/0/x256/000", randomTile(), nil))
+	if _, err := hb.Fetch(ctx, "tile/0/x256/000"); err != nil {
+		t.Errorf("Fetch of a tile without a full parent returned %v", err)
+	}
+	fatalIfErr(t, hb.Upload(ctx, "tile/1/000", parent, nil))
+	if _, err := hb.Fetch(ctx, "tile/0/005"); err != nil {
+		t.Errorf("Fetch of a valid tile returned %v", err)
+	}
+	if _, err := hb.Fetch(ctx, "tile/0/006"); !errors.Is(err, ctlog.ErrCorruptTile) {
+		t.Errorf("Fetch of an inconsistent tile returned %v", err)
+	}
+	fatalIfErr(t, hb.Upload(ctx, "tile/0/007", tile5[:100], nil))
+	if _, err := hb.Fetch(ctx, "tile/0/007"); !errors.Is(err, ctlog.ErrCorruptTile) {
+		t.Errorf("Fetch of a truncated tile returned %v", err)
+	}
+}
+
+func TestS3BackendUploadOptions(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_CONFIG_FILE", os.DevNull)
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", os.DevNull)
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+
+	var mu sync.Mutex
+	headers := make(map[string]http.Header)
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		mu.Lock()
+		headers[strings.TrimPrefix(r.URL.Path, "/bucket/prefix/")] = r.Header
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	logHandler, _ := testLogHandler(t)
+	ctx := context.Background()
+	b, err := ctlog.NewS3Backend(ctx, "us-east-1", "bucket", srv.URL, "prefix/", slog.New(logHandler))
+	fatalIfErr(t, err)
+	b.UsePathStyle()
+	b.ObjectLockRetention = 24 * time.Hour
+
+	for key, opts := range map[string]*ctlog.UploadOptions{
+		"tile/0/000":     {Immutable: true},
+		"tile/0/001.p/5": {CacheControl: "public, max-age=5"},
+		"issuer/abcd":    {ContentType: "application/pkix-cert", Immutable: true},
+		"checkpoint":     {ContentType: "text/plain; charset=utf-8", CacheControl: "public, max-age=5"},
+	} {
+		fatalIfErr(t, b.Upload(ctx, key, []byte("hello"), opts))
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for key, exp := range map[string]map[string]string{
+		"tile/0/000": {
+			"Content-Type":           "application/octet-stream",
+			"Cache-Control":          "public, max-age=604800, immutable",
+			"X-Amz-Object-Lock-Mode": "GOVERNANCE",
+		},
+		"tile/0/001.p/5": {
+			"Cache-Control":          "public, max-age=5",
+			"X-Amz-Object-Lock-Mode": "",
+		},
+		"issuer/abcd": {
+			"Content-Type":           "application/pkix-cert",
+			"X-Amz-Object-Lock-Mode": "GOVERNANCE",
+		},
+		"checkpoint": {
+			"Content-Type":  "text/plain; charset=utf-8",
+			"Cache-Control": "public, max-age=5",
+		},
+	} {
+		h, ok := headers[key]
+		if !ok {
+			t.Errorf("no request for %q", key)
+			continue
+		}
+		for name, value := range exp {
+			if got := h.Get(name); got != value {
+				t.Errorf("%s: %s is %q, expected %q", key, name, got, value)
+			}
+		}
+	}
+	if h := headers["tile/0/000"]; h.Get("X-Amz-Object-Lock-Retain-Until-Date") == "" {
+		t.Errorf("missing Object Lock retention date")
+	}
+}
+
+func TestS3BackendErrors(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_CONFIG_FILE", os.DevNull)
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", os.DevNull)
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+
+	s3Error := func(rw http.ResponseWriter, status int, code string) {
+		rw.Header().Set("Content-Type", "application/xml")
+		rw.WriteHeader(status)
+		fmt.Fprintf(rw, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+	}
+	var mu sync.Mutex
+	requests := make(map[string]int)
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		key := strings.TrimPrefix(r.URL.Path, "/bucket/prefix/")
+		mu.Lock()
+		requests[r.Method+" "+key]++
+		n := requests[r.Method+" "+key]
+		mu.Unlock()
+		switch {
+		case key == "missing":
+			s3Error(rw, http.StatusNotFound, "NoSuchKey")
+		case key == "denied":
+			s3Error(rw, http.StatusForbidden, "AccessDenied")
+		case key == "exists" && r.Header.Get("If-None-Match") == "*":
+			s3Error(rw, http.StatusPreconditionFailed, "PreconditionFailed")
+		case key == "throttled" && n <= 2:
+			s3Error(rw, http.StatusServiceUnavailable, "SlowDown")
+		case key == "flaky" && n <= 2:
+			s3Error(rw, http.StatusInternalServerError, "InternalError")
+		case r.Method == "GET":
+			rw.Write([]byte("hello"))
+		}
+	}))
+	defer srv.Close()
+
+	logHandler, _ := testLogHandler(t)
+	ctx := context.Background()
+	b, err := ctlog.NewS3Backend(ctx, "us-east-1", "bucket", srv.URL, "prefix/", slog.New(logHandler))
+	fatalIfErr(t, err)
+	b.UsePathStyle()
+	b.ConditionalWrites = true
+
+	if _, err := b.Fetch(ctx, "missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Fetch of missing object: got %v, expected fs.ErrNotExist", err)
+	}
+	if _, err := b.FetchRange(ctx, "missing", 0, 5); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("FetchRange of missing object: got %v, expected fs.ErrNotExist", err)
+	}
+	if _, err := b.Fetch(ctx, "denied"); err == nil || errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Fetch of denied object: got %v, expected a non-ErrNotExist error", err)
+	}
+
+	err = b.Upload(ctx, "exists", []byte("hello"), &ctlog.UploadOptions{CreateOnly: true})
+	if !errors.Is(err, ctlog.ErrObjectExists) {
+		t.Errorf("CreateOnly Upload of existing object: got %v, expected ErrObjectExists", err)
+	}
+	fatalIfErr(t, b.Upload(ctx, "exists", []byte("hello"), nil))
+
+	data, err := b.Fetch(ctx, "throttled")
+	fatalIfErr(t, err)
+	if string(data) != "hello" {
+		t.Errorf("Fetch of throttled object: got %q, expected %q", data, "hello")
+	}
+	fatalIfErr(t, b.Upload(ctx, "flaky", []byte("hello"), nil))
+
+	mu.Lock()
+	defer mu.Unlock()
+	for req, exp := range map[string]int{
+		"GET throttled": 3,
+		"PUT flaky":     3,
+		"GET denied":    1,
+		"PUT exists":    2,
+	} {
+		if requests[req] != exp {
+			t.Errorf("%s: got %d requests, expected %d", req, requests[req], exp)
+		}
+	}
+}
+
+func TestGCSBackend(t *testing.T) {
+	srv, err := fakestorage.NewServerWithOptions(fakestorage.Options{
+		Scheme: "http", Host: "127.0.0.1", Writer: io.Discard})
+	fatalIfErr(t, err)
+	defer srv.Stop()
+	srv.CreateBucketWithOpts(fakestorage.CreateBucketOpts{Name: "bucket"})
+	t.Setenv("STORAGE_EMULATOR_HOST", srv.URL())
+
+	logHandler, _ := testLogHandler(t)
+	ctx := context.Background()
+	b, err := ctlog.NewGCSBackend(ctx, "bucket", "prefix/", slog.New(logHandler))
+	fatalIfErr(t, err)
+	fatalIfErr(t, b.Ping(ctx))
+
+	data := bytes.Repeat([]byte("hello"), 100)
+	fatalIfErr(t, b.Upload(ctx, "tile/data/000", data, &ctlog.UploadOptions{Compress: true, Immutable: true}))
+	fatalIfErr(t, b.Upload(ctx, "checkpoint", []byte("one"),
+		&ctlog.UploadOptions{ContentType: "text/plain; charset=utf-8", CacheControl: "public, max-age=5"}))
+	for key, exp := range map[string]struct{ contentType, encoding string }{
+		"tile/data/000": {"application/octet-stream", "gzip"},
+		"checkpoint":    {"text/plain; charset=utf-8", ""},
+	} {
+		obj, err := srv.GetObject("bucket", "prefix/"+key)
+		fatalIfErr(t, err)
+		if obj.ContentType != exp.contentType || obj.ContentEncoding != exp.encoding {
+			t.Errorf("%s: got type %q and encoding %q, expected %q and %q",
+				key, obj.ContentType, obj.ContentEncoding, exp.contentType, exp.encoding)
+		}
+	}
+	if got, err := b.Fetch(ctx, "tile/data/000"); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("Fetch of compressed object: got %q, %v", got, err)
+	}
+	if _, err := b.Fetch(ctx, "missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Fetch of missing object returned %v", err)
+	}
+
+	// CreateOnly uploads use a DoesNotExist precondition.
+	createOnly := &ctlog.UploadOptions{CreateOnly: true}
+	fatalIfErr(t, b.Upload(ctx, "tile/0/000", []byte("first"), createOnly))
+	if err := b.Upload(ctx, "tile/0/000", []byte("second"), createOnly); !errors.Is(err, ctlog.ErrObjectExists) {
+		t.Errorf("CreateOnly upload over an existing object returned %v", err)
+	}
+	if got, err := b.Fetch(ctx, "tile/0/000"); err != nil || string(got) != "first" {
+		t.Errorf("got %q, %v after a failed CreateOnly upload", got, err)
+	}
+	fatalIfErr(t, b.Upload(ctx, "checkpoint", []byte("two"), nil))
+	if got, err := b.Fetch(ctx, "checkpoint"); err != nil || string(got) != "two" {
+		t.Errorf("got %q, %v after an overwrite", got, err)
+	}
+
+	keys, err := b.List(ctx, "tile/")
+	fatalIfErr(t, err)
+	slices.Sort(keys)
+	if !slices.Equal(keys, []string{"tile/0/000", "tile/data/000"}) {
+		t.Errorf("List returned %q", keys)
+	}
+	fatalIfErr(t, b.Delete(ctx, "tile/0/000"))
+	if _, err := b.Fetch(ctx, "tile/0/000"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Fetch after Delete returned %v", err)
+	}
+
+	// Run a log on top of it.
+	tl := NewEmptyTestLog(t)
+	tl.Quiet()
+	c := *tl.Config
+	c.Backend, err = ctlog.NewGCSBackend(ctx, "bucket", "log/", c.Log)
+	fatalIfErr(t, err)
+	c.Lock = NewMemoryLockBackend(t)
+	c.Cache = filepath.Join(t.TempDir(), "cache.db")
+	fatalIfErr(t, ctlog.CreateLog(ctx, &c))
+	tl.Config = &c
+	tl = ReloadLog(t, tl)
+	for range tileWidth + 5 {
+		addCertificate(t, tl)
+	}
+	fatalIfErr(t, tl.Log.Sequence())
+	tl.CheckLog(tileWidth + 5)
+}
+
+// batchBackend records the keys of each BatchUpload call, and fails them with
+// err if it's not nil.
+type batchBackend struct {
+	*MemoryBackend
+	mu      sync.Mutex
+	batches [][]string
+	err     error
+}
+
+func (b *batchBackend) BatchUpload(ctx context.Context, objects []ctlog.Object) error {
+	var keys []string
+	for _, o := range objects {
+		keys = append(keys, o.Key)
+	}
+	b.mu.Lock()
+	b.batches = append(b.batches, keys)
+	err := b.err
+	b.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	for _, o := range objects {
+		if err := b.MemoryBackend.Upload(ctx, o.Key, o.Data, o.Opts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestBatchUpload(t *testing.T) {
+	tl := NewEmptyTestLog(t)
+	bb := &batchBackend{MemoryBackend: tl.Config.Backend.(*MemoryBackend)}
+	tl.Config.Backend = ctlog.NewMetricsBackend(bb)
+	tl = ReloadLog(t, tl)
+
+	// All the tiles of a round are uploaded in a single batch, even through
+	// other Backend wrappers.
+	for range tileWidth + 1 {
+		addCertificate(t, tl)
+	}
+	fatalIfErr(t, tl.Log.Sequence())
+	tl.CheckLog(tileWidth + 1)
+	if len(bb.batches) != 1 {
+		t.Fatalf("got %d batches, expected 1", len(bb.batches))
+	}
+	slices.Sort(bb.batches[0])
+	exp := []string{"tile/0/000", "tile/0/001.p/1", "tile/1/000.p/1", "tile/data/000", "tile/data/001.p/1"}
+	if !slices.Equal(bb.batches[0], exp) {
+		t.Errorf("got batch %q, expected %q", bb.batches[0], exp)
+	}
+
+	// If the batch fails, the checkpoint is not published, and the round is
+	// recovered by LoadLog.
+	checkpoint, err := bb.Fetch(context.Background(), "checkpoint")
+	fatalIfErr(t, err)
+	bb.err = errors.New("batch failed")
+	addCertificateExpectFailure(t, tl)
+	if err := tl.Log.Sequence(); err == nil {
+		t.Errorf("Sequence succeeded with a failing batch")
+	}
+	if c, err := bb.Fetch(context.Background(), "checkpoint"); err != nil || !bytes.Equal(c, checkpoint) {
+		t.Errorf("checkpoint was updated after a failed batch")
+	}
+	bb.err = nil
+	tl = ReloadLog(t, tl)
+	tl.CheckLog(tileWidth + 2)
+
+	// LocalBackend implements BatchUpload.
+	logHandler, _ := testLogHandler(t)
+	dir := t.TempDir()
+	lb, err := ctlog.NewLocalBackend(context.Background(), dir, slog.New(logHandler))
+	fatalIfErr(t, err)
+	fatalIfErr(t, lb.BatchUpload(context.Background(), []ctlog.Object{
+		{Key: "tile/0/000", Data: []byte("a")},
+		{Key: "tile/0/001", Data: []byte("b")},
+		{Key: "tile/data/000", Data: []byte("c")},
+	}))
+	for key, exp := range map[string]string{"tile/0/000": "a", "tile/0/001": "b", "tile/data/000": "c"} {
+		if data, err := lb.Fetch(context.Background(), key); err != nil || string(data) != exp {
+			t.Errorf("Fetch(%q) = %q, %v", key, data, err)
+		}
+	}
+	if err := lb.BatchUpload(context.Background(), []ctlog.Object{
+		{Key: "tile/0/002", Data: []byte("a")}, {Key: "../x", Data: []byte("b")},
+	}); err == nil {
+		t.Errorf("BatchUpload with an invalid key succeeded")
+	}
+	// Nothing of a batch with an invalid key is written, not even to a
+	// temporary file.
+	temps, err := filepath.Glob(filepath.Join(dir, "tile", "0", ".upload-*"))
+	fatalIfErr(t, err)
+	if len(temps) != 0 {
+		t.Errorf("left temporary files %v", temps)
+	}
+	if _, err := lb.Fetch(context.Background(), "tile/0/002"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Fetch of an object of a failed batch: %v", err)
+	}
+}
+
+// blockingBackend is a Backend whose tile fetches block until their context
+// is done, after signaling the first one on blocked.
+type blockingBackend struct {
+	ctlog.Backend
+	blocked chan struct{}
+	once    sync.Once
+}
+
+func (b *blockingBackend) Fetch(ctx context.Context, key string) ([]byte, error) {
+	if !strings.HasPrefix(key, "tile/") {
+		return b.Backend.Fetch(ctx, key)
+	}
+	b.once.Do(func() { close(b.blocked) })
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestLoadLogCancel(t *testing.T) {
+	tl := NewEmptyTestLog(t)
+	for range tileWidth + 5 {
+		addCertificate(t, tl)
+	}
+	fatalIfErr(t, tl.Log.Sequence())
+
+	bb := &blockingBackend{Backend: tl.Config.Backend, blocked: make(chan struct{})}
+	c := *tl.Config
+	c.Backend = bb
+	c.Cache = filepath.Join(t.TempDir(), "cache.db")
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-bb.blocked
+		cancel()
+	}()
+	errc := make(chan error, 1)
+	go func() {
+		_, err := ctlog.LoadLog(ctx, &c)
+		errc <- err
+	}()
+	select {
+	case err := <-errc:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("LoadLog returned %v, expected context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("LoadLog didn't return after the context was canceled")
+	}
+
+	// A deadline on the context applies to the fetches, too.
+	bb = &blockingBackend{Backend: tl.Config.Backend, blocked: make(chan struct{})}
+	c.Backend = bb
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := ctlog.LoadLog(ctx, &c); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("LoadLog returned %v, expected context.DeadlineExceeded", err)
+	}
+
+	tl = ReloadLog(t, tl)
+	tl.CheckLog(tileWidth + 5)
+}
+
+func TestLease(t *testing.T) {
+	tl := NewEmptyTestLog(t)
+	addCertificate(t, tl)
+	fatalIfErr(t, tl.Log.Sequence())
+
+	// Two instances race to load the log, and only one gets the lease.
+	dir := t.TempDir()
+	instance := func(holder string) *ctlog.Config {
+		lb, err := ctlog.NewFileLeaseBackend(dir)
+		fatalIfErr(t, err)
+		c := *tl.Config
+		c.Cache = filepath.Join(t.TempDir(), "cache.db")
+		c.Lease = lb
+		c.LeaseHolder = holder
+		return &c
+	}
+	configs := []*ctlog.Config{instance("a"), instance("b")}
+	logs := make([]*ctlog.Log, len(configs))
+	errs := make([]error, len(configs))
+	var wg sync.WaitGroup
+	for i, c := range configs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			logs[i], errs[i] = ctlog.LoadLog(context.Background(), c)
+		}()
+	}
+	wg.Wait()
+	winner, loser := 0, 1
+	if errs[0] != nil {
+		winner, loser = 1, 0
+	}
+	if errs[winner] != nil {
+		t.Fatalf("both instances failed to load the log: %v, %v", errs[0], errs[1])
+	}
+	if !errors.Is(errs[loser], ctlog.ErrLeaseHeld) {
+		t.Fatalf("got error %v for the second instance, expected ErrLeaseHeld", errs[loser])
+	}
+	t.Cleanup(func() { fatalIfErr(t, logs[winner].CloseCache()) })
+	tl = &TestLog{t: t, Log: logs[winner], Config: configs[winner]}
+	addCertificate(t, tl)
+	fatalIfErr(t, tl.Log.Sequence())
+	tl.CheckLog(2)
+
+	// Stopping the sequencer releases the lease.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	tl.Log.RunSequencer(ctx, time.Millisecond)
+	tl = ReloadLog(t, &TestLog{Config: configs[loser]})
+	addCertificate(t, tl)
+	fatalIfErr(t, tl.Log.Sequence())
+	tl.CheckLog(3)
+
+	// If the lease expires and is acquired by another instance, the first
+	// one fails to renew it, and doesn't publish a checkpoint.
+	mlb := NewMemoryLeaseBackend()
+	ca, cb := *tl.Config, *tl.Config
+	ca.Lease, ca.LeaseHolder, ca.Cache = mlb, "a", filepath.Join(t.TempDir(), "cache.db")
+	cb.Lease, cb.LeaseHolder, cb.Cache = mlb, "b", filepath.Join(t.TempDir(), "cache.db")
+	a := ReloadLog(t, &TestLog{Config: &ca})
+	if _, err := ctlog.LoadLog(context.Background(), &cb); !errors.Is(err, ctlog.ErrLeaseHeld) {
+		t.Fatalf("got error %v for the second instance, expected ErrLeaseHeld", err)
+	}
+	logID, err := logIDFromKey(tl.Config.Key)
+	fatalIfErr(t, err)
+	mlb.Expire(logID)
+	b := ReloadLog(t, &TestLog{Config: &cb})
+	checkpoint, err := tl.Config.Lock.Fetch(context.Background(), logID)
+	fatalIfErr(t, err)
+	addCertificateExpectFailure(t, a)
+	if err := a.Log.Sequence(); !errors.Is(err, ctlog.ErrLeaseLost) {
+		t.Errorf("got error %v sequencing without the lease, expected ErrLeaseLost", err)
+	}
+	if c, err := tl.Config.Lock.Fetch(context.Background(), logID); err != nil ||
+		!bytes.Equal(c.Bytes(), checkpoint.Bytes()) {
+		t.Errorf("checkpoint was replaced without the lease")
+	}
+	addCertificate(t, b)
+	fatalIfErr(t, b.Log.Sequence())
+	b.CheckLog(4)
+}
+
+func TestCrashConsistency(t *testing.T) {
+	// newLog returns a log with a few entries, sequenced so that the next
+	// round fills a data tile and uploads partial and full tiles at multiple
+	// levels.
+	newLog := func(t *testing.T) (*TestLog, *FaultBackend) {
+		tl := NewEmptyTestLog(t)
+		tl.Quiet()
+		fb := NewFaultBackend(tl.Config.Backend)
+		tl.Config.Backend = fb
+		tl = ReloadLog(t, tl)
+		for range tileWidth - 2 {
+			addCertificate(t, tl)
+		}
+		fatalIfErr(t, tl.Log.Sequence())
+		tl.CheckLog(tileWidth - 2)
+		fb.Heal()
+		return tl, fb
+	}
+
+	// Count the uploads of the round under test.
+	tl, fb := newLog(t)
+	for range 5 {
+		addCertificate(t, tl)
+	}
+	fatalIfErr(t, tl.Log.Sequence())
+	tl.CheckLog(tileWidth + 3)
+	round := fb.Uploads()
+	staging := slices.IndexFunc(round, func(key string) bool { return strings.HasPrefix(key, "staging/") })
+	if staging < 0 || round[len(round)-1] != "checkpoint" {
+		t.Fatalf("unexpected uploads %q", round)
+	}
+
+	for i, key := range round {
+		if i == staging {
+			key = "staging"
+		}
+		t.Run(fmt.Sprintf("%d-%s", i+1, key), func(t *testing.T) {
+			tl, fb := newLog(t)
+			fb.CrashAt = i + 1
+			for range 5 {
+				addCertificateExpectFailure(t, tl)
+			}
+			// Only failures after the lock is updated are fatal, but all
+			// crashes fail the round, as checked by the entries.
+			if err := tl.Log.Sequence(); err != nil && !errors.Is(err, errInjected) {
+				t.Fatalf("got error %v, expected an injected fault", err)
+			}
+
+			// The round is committed once the checkpoint is replaced in the
+			// lock backend, which happens after the staging upload.
+			size := int64(tileWidth - 2)
+			if i > staging {
+				size += 5
+			}
+			tl = ReloadAfterFault(t, tl, fb)
+			tl.CheckLog(size)
+			addCertificate(t, tl)
+			fatalIfErr(t, tl.Log.Sequence())
+			tl.CheckLog(size + 1)
+		})
+	}
+
+	// Uploads failing without crashing are recovered by the next round, or
+	// by LoadLog.
+	t.Run("FailKeys", func(t *testing.T) {
+		tl, fb := newLog(t)
+		fb.FailKeys = regexp.MustCompile(`^tile/data/`)
+		for range 5 {
+			addCertificateExpectFailure(t, tl)
+		}
+		if err := tl.Log.Sequence(); !errors.Is(err, errInjected) {
+			t.Fatalf("got error %v, expected an injected fault", err)
+		}
+		tl = ReloadAfterFault(t, tl, fb)
+		tl.CheckLog(tileWidth + 3)
+	})
+
+	// A panic while uploading the checkpoint leaves the log as if the
+	// process had crashed.
+	t.Run("Panic", func(t *testing.T) {
+		tl, fb := newLog(t)
+		fb.PanicAt = len(round)
+		fb.Delay = time.Millisecond
+		for i := range 5 {
+			// The entries of the panicking round never resolve, so don't
+			// wait for them.
+			tl.Log.AddLeafToPool(&ctlog.PendingLogEntry{Certificate: fmt.Appendf(nil, "panic %d", i)})
+		}
+		if panicked, err := sequenceRecoveringPanic(tl); !panicked {
+			t.Fatalf("Sequence didn't panic, returned %v", err)
+		}
+		tl = ReloadAfterFault(t, tl, fb)
+		tl.CheckLog(tileWidth + 3)
+		addCertificate(t, tl)
+		fatalIfErr(t, tl.Log.Sequence())
+		tl.CheckLog(tileWidth + 4)
+	})
+}
+
+func TestSpool(t *testing.T) {
+	newLog := func(t *testing.T) (*TestLog, *FaultBackend) {
+		tl := NewEmptyTestLog(t)
+		fb := NewFaultBackend(tl.Config.Backend)
+		tl.Config.Backend = fb
+		tl.Config.Spool = filepath.Join(t.TempDir(), "spool")
+		tl = ReloadLog(t, tl)
+		for range 3 {
+			addCertificate(t, tl)
+		}
+		fatalIfErr(t, tl.Log.Sequence())
+		tl.CheckLog(3)
+		fb.Heal()
+		return tl, fb
+	}
+	add := func(tl *TestLog, name string, n int) {
+		for i := range n {
+			// The entries of a crashed log never resolve, so don't wait for
+			// them.
+			tl.Log.AddLeafToPool(&ctlog.PendingLogEntry{Certificate: fmt.Appendf(nil, "%s %d", name, i)})
+		}
+	}
+
+	// Entries in the pool when the process crashes are sequenced by the next
+	// one, and a torn record at the end of the spool is ignored.
+	t.Run("Pool", func(t *testing.T) {
+		tl, _ := newLog(t)
+		add(tl, "pool", 5)
+		f, err := os.OpenFile(tl.Config.Spool, os.O_WRONLY|os.O_APPEND, 0)
+		fatalIfErr(t, err)
+		_, err = f.Write([]byte{0, 0, 1, 0, 1, 2, 3})
+		fatalIfErr(t, err)
+		fatalIfErr(t, f.Close())
+
+		tl = ReloadLog(t, tl)
+		fatalIfErr(t, tl.Log.Sequence())
+		tl.CheckLog(8)
+
+		// Once sequenced, the entries are removed from the spool.
+		tl = ReloadLog(t, tl)
+		fatalIfErr(t, tl.Log.Sequence())
+		tl.CheckLog(8)
+	})
+
+	// Entries of a round that crashed before committing are replayed, along
+	// with the ones added to the following pool.
+	t.Run("Uncommitted", func(t *testing.T) {
+		tl, fb := newLog(t)
+		fb.PanicAt = 1 // staging
+		add(tl, "uncommitted", 5)
+		if panicked, err := sequenceRecoveringPanic(tl); !panicked {
+			t.Fatalf("Sequence didn't panic, returned %v", err)
+		}
+		add(tl, "next", 2)
+		tl = ReloadAfterFault(t, tl, fb)
+		tl.CheckLog(3)
+		fatalIfErr(t, tl.Log.Sequence())
+		tl.CheckLog(10)
+	})
+
+	// Entries of a round that committed, but crashed before removing them from
+	// the spool and adding them to the deduplication cache, are not logged
+	// twice.
+	t.Run("Committed", func(t *testing.T) {
+		tl, fb := newLog(t)
+		fb.PanicAt = 2 // the first tile after staging
+		add(tl, "committed", 5)
+		if panicked, err := sequenceRecoveringPanic(tl); !panicked {
+			t.Fatalf("Sequence didn't panic, returned %v", err)
+		}
+		tl = ReloadAfterFault(t, tl, fb)
+		tl.CheckLog(8)
+		fatalIfErr(t, tl.Log.Sequence())
+		tl.CheckLog(8)
+	})
+}
+
+func TestCreateOnlyTiles(t *testing.T) {
+	newLog := func(t *testing.T) (*TestLog, *MemoryBackend) {
+		tl := NewEmptyTestLog(t)
+		mb := tl.Config.Backend.(*MemoryBackend)
+		mb.CreateOnly = true
+		for range tileWidth - 2 {
+			addCertificate(t, tl)
+		}
+		fatalIfErr(t, tl.Log.Sequence())
+		tl.CheckLog(tileWidth - 2)
+		return tl, mb
+	}
+
+	// Tiles uploaded again with the same contents, like by LoadLog after a
+	// crash, are fine.
+	t.Run("Benign", func(t *testing.T) {
+		tl, mb := newLog(t)
+		mb.UploadCallback = failCheckpointAndNotPersist
+		for range 5 {
+			addCertificateExpectFailure(t, tl)
+		}
+		fatalIfErr(t, tl.Log.Sequence())
+		mb.UploadCallback = nil
+		tl = ReloadLog(t, tl)
+		tl.CheckLog(tileWidth + 3)
+		if n := mb.Uploads("tile/data/000"); n < 2 {
+			t.Errorf("full data tile uploaded %d times, expected it to be uploaded again", n)
+		}
+		addCertificate(t, tl)
+		fatalIfErr(t, tl.Log.Sequence())
+		tl.CheckLog(tileWidth + 4)
+	})
+
+	// A full tile uploaded by someone else with different contents stops the
+	// sequencer and prevents the log from loading.
+	t.Run("Conflict", func(t *testing.T) {
+		tl, mb := newLog(t)
+		fatalIfErr(t, mb.Upload(context.Background(), "tile/data/000", []byte("other"), nil))
+		for range 5 {
+			addCertificateExpectFailure(t, tl)
+		}
+		if err := tl.Log.Sequence(); err == nil || !strings.Contains(err.Error(), "another instance") {
+			t.Fatalf("Sequence returned %v, expected a tile conflict", err)
+		}
+		if data, err := mb.Fetch(context.Background(), "tile/data/000"); err != nil || string(data) != "other" {
+			t.Errorf("conflicting tile was overwritten: %q, %v", data, err)
+		}
+		if _, err := ctlog.LoadLog(context.Background(), tl.Config); err == nil ||
+			!strings.Contains(err.Error(), "another instance") {
+			t.Errorf("LoadLog returned %v, expected a tile conflict", err)
+		}
+	})
+}
+
+func TestRunSequencer(t *testing.T) {
+	run := func(tl *TestLog, period time.Duration) (cancel func(), done <-chan error) {
+		ctx, cancel := context.WithCancel(context.Background())
+		ch := make(chan error, 1)
+		go func() { ch <- tl.Log.RunSequencer(ctx, period) }()
+		t.Cleanup(cancel)
+		return cancel, ch
+	}
+
+	t.Run("FlushOnCancel", func(t *testing.T) {
+		tl := NewEmptyTestLog(t)
+		cancel, done := run(tl, time.Hour)
+		wait := addCertificate(t, tl)
+		cancel()
+		if err := <-done; err != context.Canceled {
+			t.Errorf("got %v, expected context.Canceled", err)
+		}
+		wait(context.Background())
+		tl.CheckLog(1)
+	})
+
+	t.Run("DefaultPeriod", func(t *testing.T) {
+		tl := NewEmptyTestLog(t)
+		cancel, done := run(tl, 0)
+		ctx, stop := context.WithTimeout(context.Background(), 5*time.Second)
+		defer stop()
+		addCertificate(t, tl)(ctx)
+		cancel()
+		if err := <-done; err != context.Canceled {
+			t.Errorf("got %v, expected context.Canceled", err)
+		}
+		tl.CheckLog(1)
+	})
+
+	t.Run("Transient", func(t *testing.T) {
+		tl := NewEmptyTestLog(t)
+		var broken atomic.Bool
+		var failures atomic.Int64
+		broken.Store(true)
+		tl.Config.Backend.(*MemoryBackend).UploadCallback = func(key string, data []byte) (bool, error) {
+			if !broken.Load() {
+				return true, nil
+			}
+			if strings.HasPrefix(key, "staging/") {
+				failures.Add(1)
+			}
+			return failStagingAndNotPersist(key, data)
+		}
+		cancel, done := run(tl, time.Millisecond)
+
+		// Every failed round gets a new submission, so that it's not empty.
+		for failures.Load() < 3 {
+			addCertificateExpectFailure(t, tl)
+			select {
+			case err := <-done:
+				t.Fatalf("sequencer stopped on a non-fatal error: %v", err)
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+		broken.Store(false)
+
+		ctx, stop := context.WithTimeout(context.Background(), 5*time.Second)
+		defer stop()
+		addCertificate(t, tl)(ctx)
+		cancel()
+		if err := <-done; err != context.Canceled {
+			t.Errorf("got %v, expected context.Canceled", err)
+		}
+		tl.CheckLog(1)
+	})
+
+	t.Run("Fatal", func(t *testing.T) {
+		tl := NewEmptyTestLog(t)
+		tl.Config.Lock.(*MemoryLockBackend).ReplaceCallback = failLockAndNotPersist
+		addCertificateExpectFailure(t, tl)
+		_, done := run(tl, time.Millisecond)
+		select {
+		case err := <-done:
+			if !errors.Is(err, ctlog.ErrFatal) {
+				t.Errorf("got %v, expected a fatal error", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("sequencer didn't stop on a fatal error")
+		}
+		// The sequencer is stopped, so further submissions fail immediately.
+		addCertificateExpectFailure(t, tl)
+	})
+}
+
+func TestPause(t *testing.T) {
+	t.Run("Sequence", func(t *testing.T) {
+		tl := NewEmptyTestLog(t)
+		wait := addCertificate(t, tl)
+		tl.Log.Pause()
+		checkpoints := tl.Config.Backend.(*MemoryBackend).Uploads("checkpoint")
+		fatalIfErr(t, tl.Log.Sequence())
+		if n := tl.Config.Backend.(*MemoryBackend).Uploads("checkpoint"); n != checkpoints {
+			t.Errorf("checkpoint uploaded %d times while paused", n-checkpoints)
+		}
+		if ds := tl.Log.DebugState(); !ds.Paused || ds.PoolSize != 1 || ds.TreeSize != 0 {
+			t.Errorf("got %+v while paused, expected a paused log with a pending entry", ds)
+		}
+
+		tl.Log.Resume()
+		fatalIfErr(t, tl.Log.Sequence())
+		if _, err := wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if tl.Log.Paused() {
+			t.Error("log is still paused after Resume")
+		}
+		tl.CheckLog(1)
+	})
+
+	t.Run("RejectWhilePaused", func(t *testing.T) {
+		tl := NewEmptyTestLog(t)
+		tl.Config.RejectWhilePaused = true
+		tl.Log.Pause()
+		f, source := tl.Log.AddLeafToPool(&ctlog.PendingLogEntry{Certificate: []byte("paused")})
+		if _, err := f(context.Background()); err != ctlog.ErrPaused || source != "paused" {
+			t.Errorf("got %v from %q while paused, expected ErrPaused", err, source)
+		}
+		tl.Log.Resume()
+		addCertificate(t, tl)
+		fatalIfErr(t, tl.Log.Sequence())
+		tl.CheckLog(1)
+	})
+
+	t.Run("RunSequencer", func(t *testing.T) {
+		tl := NewEmptyTestLog(t)
+		tl.Log.Pause()
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() { done <- tl.Log.RunSequencer(ctx, time.Millisecond) }()
+		t.Cleanup(func() { cancel(); <-done })
+
+		wait, _ := tl.Log.AddLeafToPool(&ctlog.PendingLogEntry{Certificate: []byte("paused")})
+		short, stop := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer stop()
+		if _, err := wait(short); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("got %v while paused, expected the pool to wait", err)
+		}
+
+		tl.Log.Resume()
+		long, stop := context.WithTimeout(context.Background(), 5*time.Second)
+		defer stop()
+		if _, err := wait(long); err != nil {
+			t.Fatal(err)
+		}
+		tl.CheckLog(1)
+	})
+
+	t.Run("Shutdown", func(t *testing.T) {
+		tl := NewEmptyTestLog(t)
+		wait, _ := tl.Log.AddLeafToPool(&ctlog.PendingLogEntry{Certificate: []byte("paused")})
+		tl.Log.Pause()
+		fatalIfErr(t, tl.Log.Shutdown(context.Background()))
+		if _, err := wait(context.Background()); err != ctlog.ErrShuttingDown {
+			t.Errorf("got %v after Shutdown while paused, expected ErrShuttingDown", err)
+		}
+		tl.CheckLog(0)
+	})
+}
+
+func TestWitnesses(t *testing.T) {
+	cosigners := func(t *testing.T, checkpoint []byte) []string {
+		t.Helper()
+		var names []string
+		for _, line := range strings.Split(string(checkpoint), "\n") {
+			if name, ok := strings.CutPrefix(line, "— witness"); ok {
+				names = append(names, "witness"+strings.Fields(name)[0])
+			}
+		}
+		slices.Sort(names)
+		return names
+	}
+	fetchCheckpoint := func(t *testing.T, tl *TestLog) []byte {
+		t.Helper()
+		b, err := tl.Config.Backend.Fetch(context.Background(), "checkpoint")
+		fatalIfErr(t, err)
+		return b
+	}
+
+	t.Run("Quorum", func(t *testing.T) {
+		tl := NewEmptyTestLog(t)
+		w1, w2, w3 := NewFakeWitness(t, tl, "witness1"), NewFakeWitness(t, tl, "witness2"), NewFakeWitness(t, tl, "witness3")
+		w3.Hang.Store(true)
+		tl.Config.Witnesses = []ctlog.Witness{w1.Witness, w2.Witness, w3.Witness}
+		tl.Config.WitnessQuorum = 2
+		tl.Config.WitnessTimeout = 100 * time.Millisecond
+		tl.Quiet()
+		tl = ReloadLog(t, tl)
+
+		for i := range 3 {
+			for range 5 {
+				addCertificate(t, tl)
+			}
+			start := time.Now()
+			fatalIfErr(t, tl.Log.Sequence())
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("round took %v with a hanging witness", elapsed)
+			}
+			if got := cosigners(t, fetchCheckpoint(t, tl)); !slices.Equal(got, []string{"witness1", "witness2"}) {
+				t.Errorf("round %d: got cosignatures from %v, expected witness1 and witness2", i, got)
+			}
+			// The witnesses verified a consistency proof from the previous size.
+			if w1.Size() != int64(5*(i+1)) || w2.Size() != int64(5*(i+1)) {
+				t.Errorf("round %d: witnesses are at sizes %d and %d", i, w1.Size(), w2.Size())
+			}
+		}
+
+		// The lock checkpoint is not cosigned, and the cosigned checkpoint in
+		// object storage is loaded fine. The reloaded log doesn't know the
+		// witness sizes, and learns them from the 409 responses.
+		logID, err := logIDFromKey(tl.Config.Key)
+		fatalIfErr(t, err)
+		lock, err := tl.Config.Lock.Fetch(context.Background(), logID)
+		fatalIfErr(t, err)
+		if got := cosigners(t, lock.Bytes()); len(got) != 0 {
+			t.Errorf("lock checkpoint is cosigned by %v", got)
+		}
+		tl = ReloadLog(t, tl)
+		addCertificate(t, tl)
+		fatalIfErr(t, tl.Log.Sequence())
+		if got := cosigners(t, fetchCheckpoint(t, tl)); len(got) != 2 || w1.Size() != 16 {
+			t.Errorf("after reload, got cosignatures from %v, witness at size %d", got, w1.Size())
+		}
+		tl.CheckLog(16)
+	})
+
+	t.Run("NoQuorum", func(t *testing.T) {
+		tl := NewEmptyTestLog(t)
+		w := NewFakeWitness(t, tl, "witness1")
+		w.Fail.Store(true)
+		tl.Config.Witnesses = []ctlog.Witness{w.Witness}
+		tl.Quiet()
+		tl = ReloadLog(t, tl)
+
+		addCertificate(t, tl)
+		fatalIfErr(t, tl.Log.Sequence())
+		if got := cosigners(t, fetchCheckpoint(t, tl)); len(got) != 0 {
+			t.Errorf("got cosignatures from %v from a failing witness", got)
+		}
+		tl.CheckLog(1)
+	})
+
+	t.Run("RequireQuorum", func(t *testing.T) {
+		tl := NewEmptyTestLog(t)
+		w := NewFakeWitness(t, tl, "witness1")
+		w.Fail.Store(true)
+		tl.Config.Witnesses = []ctlog.Witness{w.Witness}
+		tl.Config.RequireWitnessQuorum = true
+		tl.Quiet()
+		tl = ReloadLog(t, tl)
+
+		addCertificateExpectFailure(t, tl)
+		fatalIfErr(t, tl.Log.Sequence())
+		if ds := tl.Log.DebugState(); ds.TreeSize != 0 {
+			t.Errorf("published tree size %d without a witness quorum", ds.TreeSize)
+		}
+
+		// The next round publishes a checkpoint that includes the entries of
+		// the failed round, which were committed.
+		w.Fail.Store(false)
+		addCertificate(t, tl)
+		fatalIfErr(t, tl.Log.Sequence())
+		if got := cosigners(t, fetchCheckpoint(t, tl)); len(got) != 1 {
+			t.Errorf("got cosignatures from %v, expected witness1", got)
+		}
+		tl.CheckLog(2)
+	})
+
+	t.Run("Served", func(t *testing.T) {
+		// The checkpoint served by the log is the cosigned one in object
+		// storage, after a round and after a reload.
+		tl := NewEmptyTestLog(t)
+		w := NewFakeWitness(t, tl, "witness1")
+		tl.Config.Witnesses = []ctlog.Witness{w.Witness}
+		tl.Quiet()
+		tl = ReloadLog(t, tl)
+		served := func(t *testing.T) []byte {
+			t.Helper()
+			rr := httptest.NewRecorder()
+			tl.Log.Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/checkpoint", nil))
+			if rr.Code != http.StatusOK {
+				t.Fatalf("got status %d: %s", rr.Code, rr.Body)
+			}
+			return rr.Body.Bytes()
+		}
+
+		addCertificate(t, tl)
+		fatalIfErr(t, tl.Log.Sequence())
+		stored := fetchCheckpoint(t, tl)
+		if got := cosigners(t, stored); len(got) != 1 {
+			t.Fatalf("got cosignatures from %v, expected witness1", got)
+		}
+		if b := served(t); !bytes.Equal(b, stored) {
+			t.Errorf("served checkpoint differs from object storage:\n%s\n%s", b, stored)
+		}
+		if b := tl.Log.Checkpoint(); !bytes.Equal(b, stored) {
+			t.Errorf("Log.Checkpoint differs from object storage:\n%s\n%s", b, stored)
+		}
+		m, err := ctlog.LoadMirror(context.Background(), tl.Config.Name, tl.Config.Key.Public(), tl.Config.Backend, nil)
+		fatalIfErr(t, err)
+		if b := m.Checkpoint(); !bytes.Equal(b, stored) {
+			t.Errorf("Mirror.Checkpoint differs from object storage:\n%s\n%s", b, stored)
+		}
+
+		tl = ReloadLog(t, tl)
+		if b := served(t); !bytes.Equal(b, stored) {
+			t.Errorf("after reload, served checkpoint differs from object storage:\n%s\n%s", b, stored)
+		}
+	})
+}
+
+func TestCheckpointEd25519Signature(t *testing.T) {
+	tl := NewEmptyTestLog(t)
+	addCertificate(t, tl)
+	fatalIfErr(t, tl.Log.Sequence())
+
+	// The checkpoint can be verified by ordinary note tooling, with just the
+	// Ed25519 key, and without knowing about RFC 6962 note signatures.
+	vk, err := note.NewEd25519VerifierKey(tl.Config.Name, tl.Config.WitnessKey.Public().(ed25519.PublicKey))
+	fatalIfErr(t, err)
+	v, err := note.NewVerifier(vk)
+	fatalIfErr(t, err)
+	checkpoint, err := tl.Config.Backend.Fetch(context.Background(), "checkpoint")
+	fatalIfErr(t, err)
+	n, err := note.Open(checkpoint, note.VerifierList(v))
+	fatalIfErr(t, err)
+	if len(n.Sigs) != 1 || n.Sigs[0].Name != tl.Config.Name {
+		t.Errorf("got verified signatures %v, expected one from %q", n.Sigs, tl.Config.Name)
+	}
+	c, err := sunlight.ParseCheckpoint(n.Text)
+	fatalIfErr(t, err)
+	if c.N != 1 {
+		t.Errorf("got tree size %d, expected 1", c.N)
+	}
+	tl.CheckLog(1)
+}
+
+func TestShutdown(t *testing.T) {
+	checkRejected := func(t *testing.T, tl *TestLog) {
+		t.Helper()
+		f, source := tl.Log.AddLeafToPool(&ctlog.PendingLogEntry{Certificate: []byte("late")})
+		if _, err := f(context.Background()); err != ctlog.ErrShuttingDown || source != "shutdown" {
+			t.Errorf("got %v from %q after Shutdown, expected ErrShuttingDown", err, source)
+		}
+	}
+	checkEntry := func(t *testing.T, wait func(context.Context) (*sunlight.LogEntry, error)) {
+		t.Helper()
+		// The submission must already be sequenced when Shutdown returns.
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		if e, err := wait(ctx); err != nil {
+			t.Errorf("submission was not sequenced by Shutdown: %v", err)
+		} else if e.LeafIndex != 0 {
+			t.Errorf("got leaf index %d, expected 0", e.LeafIndex)
+		}
+	}
+
+	t.Run("Sequencer", func(t *testing.T) {
+		tl := NewEmptyTestLog(t)
+		done := make(chan error, 1)
+		go func() { done <- tl.Log.RunSequencer(context.Background(), time.Hour) }()
+		wait := addCertificate(t, tl)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		fatalIfErr(t, tl.Log.Shutdown(ctx))
+		// If Shutdown won the race with RunSequencer, it sequenced the pool
+		// itself, and RunSequencer refused to start.
+		if err := <-done; err != nil && err != ctlog.ErrShuttingDown {
+			t.Errorf("RunSequencer returned %v", err)
+		}
+		checkEntry(t, wait)
+		tl.CheckLog(1)
+		checkRejected(t, tl)
+		fatalIfErr(t, tl.Log.Shutdown(ctx))
+	})
+
+	t.Run("NoSequencer", func(t *testing.T) {
+		tl := NewEmptyTestLog(t)
+		wait := addCertificate(t, tl)
+		fatalIfErr(t, tl.Log.Shutdown(context.Background()))
+		checkEntry(t, wait)
+		tl.CheckLog(1)
+		checkRejected(t, tl)
+		fatalIfErr(t, tl.Log.Shutdown(context.Background()))
+		tl.CheckLog(1)
+	})
+
+	t.Run("HTTP", func(t *testing.T) {
+		tl := NewEmptyTestLog(t)
+		fatalIfErr(t, tl.Log.Shutdown(context.Background()))
+		body := fmt.Sprintf(`{"chain": [%q, %q, %q]}`,
+			base64.StdEncoding.EncodeToString(testLeaf),
+			base64.StdEncoding.EncodeToString(testIntermediate),
+			base64.StdEncoding.EncodeToString(testRoot))
+		rr := httptest.NewRecorder()
+		tl.Log.Handler().ServeHTTP(rr, httptest.NewRequest("POST", "/ct/v1/add-chain", strings.NewReader(body)))
+		if rr.Code != http.StatusServiceUnavailable || !strings.Contains(rr.Body.String(), `"server.shutting_down"`) {
+			t.Errorf("got status %d, expected 503 server.shutting_down: %s", rr.Code, rr.Body)
+		}
+		if rr.Header().Get("Retry-After") == "" {
+			t.Error("missing Retry-After")
+		}
+	})
+}
+
+func TestClose(t *testing.T) {
+	checkClosed := func(t *testing.T, tl *TestLog) {
+		t.Helper()
+		f, source := tl.Log.AddLeafToPool(&ctlog.PendingLogEntry{Certificate: []byte("late")})
+		if _, err := f(context.Background()); err != ctlog.ErrLogClosed || source != "closed" {
+			t.Errorf("got %v from %q after Close, expected ErrLogClosed", err, source)
+		}
+		if err := tl.Log.Sequence(); err != ctlog.ErrLogClosed {
+			t.Errorf("Sequence: got %v after Close, expected ErrLogClosed", err)
+		}
+		if _, _, err := tl.Log.FinalForPrecert(context.Background(), [32]byte{}); err != ctlog.ErrLogClosed {
+			t.Errorf("FinalForPrecert: got %v after Close, expected ErrLogClosed", err)
+		}
+		// Double Close, and a CloseCache cleanup, are safe.
+		fatalIfErr(t, tl.Log.Close(context.Background()))
+		fatalIfErr(t, tl.Log.CloseCache())
+	}
+
+	t.Run("Sequencer", func(t *testing.T) {
+		tl := NewEmptyTestLog(t)
+		done := make(chan error, 1)
+		go func() { done <- tl.Log.RunSequencer(context.Background(), time.Hour) }()
+		wait := addCertificate(t, tl)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		fatalIfErr(t, tl.Log.Close(ctx))
+		if err := <-done; err != nil && err != ctlog.ErrShuttingDown {
+			t.Errorf("RunSequencer returned %v", err)
+		}
+		if _, err := wait(context.Background()); err != nil {
+			t.Errorf("submission was not sequenced by Close: %v", err)
+		}
+		checkClosed(t, tl)
+		if n := tl.Log.TreeSize(); n != 1 {
+			t.Errorf("TreeSize: got %d after Close, expected 1", n)
+		}
+		tl.CheckLog(1)
+
+		// The cache databases can be opened again.
+		tl = ReloadLog(t, tl)
+		addCertificate(t, tl)
+		fatalIfErr(t, tl.Log.Sequence())
+		tl.CheckLog(2)
+	})
+
+	t.Run("NoSequencer", func(t *testing.T) {
+		tl := NewEmptyTestLog(t)
+		wait := addCertificate(t, tl)
+		fatalIfErr(t, tl.Log.Close(context.Background()))
+		if _, err := wait(context.Background()); err != nil {
+			t.Errorf("submission was not sequenced by Close: %v", err)
+		}
+		checkClosed(t, tl)
+		tl.CheckLog(1)
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		// If the final round doesn't complete in time, the Log is left open.
+		tl := NewEmptyTestLog(t)
+		fb := NewFaultBackend(tl.Config.Backend)
+		tl.Config.Backend = fb
+		tl = ReloadLog(t, tl)
+		done := make(chan error, 1)
+		go func() { done <- tl.Log.RunSequencer(context.Background(), 10*time.Millisecond) }()
+		// Wait for a round, to be sure that Close has to wait for RunSequencer.
+		if _, err := addCertificate(t, tl)(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		addCertificate(t, tl)
+		fb.Delay = 100 * time.Millisecond
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		if err := tl.Log.Close(ctx); err == nil {
+			t.Fatal("Close succeeded before the final round completed")
+		}
+		fatalIfErr(t, tl.Log.Close(context.Background()))
+		if err := <-done; err != nil && err != ctlog.ErrShuttingDown {
+			t.Errorf("RunSequencer returned %v", err)
+		}
+		fb.Heal()
+		checkClosed(t, tl)
+		tl.CheckLog(2)
+	})
+}
+
+func TestRegistry(t *testing.T) {
+	// Three logs run their sequencers concurrently, and a backend outage of
+	// one of them doesn't affect the others.
+	tl := NewEmptyTestLog(t)
+	tl.Quiet()
+	var configs []*ctlog.Config
+	var backends []*FaultBackend
+	for _, name := range []string{"example.com/TestLog1", "example.com/TestLog2", "example.com/TestLog3"} {
+		c := *tl.Config
+		c.Name = name
+		c.Cache = filepath.Join(t.TempDir(), "cache.db")
+		c.Lock = NewMemoryLockBackend(t)
+		fb := NewFaultBackend(NewMemoryBackend(t))
+		c.Backend = fb
+		fatalIfErr(t, ctlog.CreateLog(context.Background(), &c))
+		configs = append(configs, &c)
+		backends = append(backends, fb)
+	}
+
+	if _, err := ctlog.LoadRegistry(context.Background(), append(configs, configs[1])); err == nil {
+		t.Fatal("expected duplicate names to be rejected")
+	}
+
+	r, err := ctlog.LoadRegistry(context.Background(), configs)
+	fatalIfErr(t, err)
+	t.Cleanup(func() { fatalIfErr(t, r.Close(context.Background())) })
+	if names := r.Names(); !reflect.DeepEqual(names, []string{configs[0].Name, configs[1].Name, configs[2].Name}) {
+		t.Errorf("got names %q", names)
+	}
+	if r.Log("example.com/Missing") != nil {
+		t.Error("got a log for an unknown name")
+	}
+	fatalIfErr(t, r.Metrics(prometheus.NewRegistry()))
+
+	backends[1].FailKeys = regexp.MustCompile(".")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	runErr := make(chan error, 1)
+	go func() { runErr <- r.Run(ctx, 10*time.Millisecond) }()
+
+	logs := make([]*TestLog, len(configs))
+	for i, c := range configs {
+		logs[i] = &TestLog{t: t, Log: r.Log(c.Name), Config: c}
+	}
+	for range 3 {
+		var waits []func(context.Context) (*sunlight.LogEntry, error)
+		for i, tl := range logs {
+			if i == 1 {
+				e := &ctlog.PendingLogEntry{Certificate: []byte("outage")}
+				f, _ := tl.Log.AddLeafToPool(e)
+				waits = append(waits, waitFuncWrapper(t, e, false, f))
+				continue
+			}
+			waits = append(waits, addCertificate(t, tl))
+		}
+		for _, wait := range waits {
+			wait(context.Background())
+		}
+	}
+	if size := logs[1].Log.TreeSize(); size != 0 {
+		t.Errorf("failing log has tree size %d", size)
+	}
+
+	backends[1].Heal()
+	if _, err := addCertificate(t, logs[1])(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	cancel()
+	fatalIfErr(t, <-runErr)
+	logs[0].CheckLog(3)
+	logs[1].CheckLog(1)
+	logs[2].CheckLog(3)
+
+	// The aggregate Handler serves each log at its name.
+	h := r.Handler()
+	for i, c := range configs {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest("GET", "https://"+c.Name+"/ct/v1/get-sth", nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s: got status %d: %s", c.Name, rr.Code, rr.Body)
+		}
+		var sth struct {
+			TreeSize int64 `json:"tree_size"`
+		}
+		fatalIfErr(t, json.Unmarshal(rr.Body.Bytes(), &sth))
+		if sth.TreeSize != logs[i].Log.TreeSize() {
+			t.Errorf("%s: got tree size %d, expected %d", c.Name, sth.TreeSize, logs[i].Log.TreeSize())
+		}
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "https://example.com/TestLog4/ct/v1/get-sth", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("unknown log: got status %d", rr.Code)
+	}
+}
+
+func TestSequencerDelay(t *testing.T) {
+	const p = 100 * time.Millisecond
+	tests := []struct {
+		failures int64
+		elapsed  time.Duration
+		want     time.Duration
+	}{
+		{0, 10 * time.Millisecond, 90 * time.Millisecond},
+		// An overrunning round skips the missed ticks.
+		{0, 250 * time.Millisecond, 50 * time.Millisecond},
+		{1, 10 * time.Millisecond, 190 * time.Millisecond},
+		{3, 10 * time.Millisecond, 790 * time.Millisecond},
+		{1000, 0, time.Minute},
+	}
+	for _, tt := range tests {
+		if got := ctlog.SequencerDelay(p, tt.failures, tt.elapsed); got != tt.want {
+			t.Errorf("SequencerDelay(%v, %d, %v) = %v, want %v", p, tt.failures, tt.elapsed, got, tt.want)
+		}
+	}
+}
+
+func TestThrottleBackend(t *testing.T) {
+	ctx := context.Background()
+	mb := NewMemoryBackend(t)
+	fatalIfErr(t, mb.Upload(ctx, "a", []byte("a"), nil))
+	fb := NewFaultBackend(mb)
+	b := ctlog.NewThrottleBackend(fb,
+		ctlog.ThrottleBudget{Concurrency: 2},
+		ctlog.ThrottleBudget{Rate: 100, Burst: 1, Concurrency: 1})
+
+	// Reads are limited to Rate per second.
+	start := time.Now()
+	for range 10 {
+		if _, err := b.Fetch(ctx, "a"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 80*time.Millisecond {
+		t.Errorf("10 fetches at 100/s took %v", elapsed)
+	}
+
+	// Calls over budget wait until their context is done.
+	fb.Delay = 100 * time.Millisecond
+	go b.Fetch(ctx, "a")
+	time.Sleep(20 * time.Millisecond)
+	shortCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err := b.Fetch(shortCtx, "a"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v for a fetch over budget, expected DeadlineExceeded", err)
+	}
+
+	// Writes have their own budget, so they proceed while reads are queued.
+	for range 3 {
+		go b.Fetch(ctx, "a")
+	}
+	start = time.Now()
+	var wg sync.WaitGroup
+	for i := range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fatalIfErr(t, b.Upload(ctx, fmt.Sprintf("b%d", i), []byte("b"), nil))
+		}()
+	}
+	wg.Wait()
+	if elapsed := time.Since(start); elapsed > 190*time.Millisecond {
+		t.Errorf("concurrent uploads took %v, expected about 100ms", elapsed)
+	}
+
+	// A third concurrent upload waits for one of the first two.
+	start = time.Now()
+	for i := range 3 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fatalIfErr(t, b.Upload(ctx, fmt.Sprintf("c%d", i), []byte("c"), nil))
+		}()
+	}
+	wg.Wait()
+	if elapsed := time.Since(start); elapsed < 190*time.Millisecond {
+		t.Errorf("three uploads with concurrency 2 took %v, expected about 200ms", elapsed)
+	}
+}
+
+// rangeBackend is a MemoryBackend that implements RangeBackend, and counts the
+// ranges of all tiles and of hash tiles, and the fetched bytes.
+type rangeBackend struct {
+	*MemoryBackend
+	mu         sync.Mutex
+	ranges     int
+	hashRanges int
+	fetched    int64
+}
+
+func (b *rangeBackend) SupportsRanges(key string) bool { return true }
+
+func (b *rangeBackend) Fetch(ctx context.Context, key string) ([]byte, error) {
+	data, err := b.MemoryBackend.Fetch(ctx, key)
+	b.mu.Lock()
+	b.fetched += int64(len(data))
+	b.mu.Unlock()
+	return data, err
+}
+
+func (b *rangeBackend) FetchRange(ctx context.Context, key string, offset, length int64) ([]byte, error) {
+	data, err := b.MemoryBackend.Fetch(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	data = data[min(offset, int64(len(data))):]
+	data = data[:min(length, int64(len(data)))]
+	b.mu.Lock()
+	b.ranges++
+	if !strings.HasPrefix(key, "tile/data/") {
+		b.hashRanges++
+	}
+	b.fetched += int64(len(data))
+	b.mu.Unlock()
+	return data, nil
+}
+
+func TestRangeHashReader(t *testing.T) {
+	tl := NewEmptyTestLog(t)
+	tl.Quiet()
+	for _, batch := range []int{tileWidth*3 + 5, 10, tileWidth} {
+		for range batch {
+			addCertificate(t, tl)
+		}
+		fatalIfErr(t, tl.Log.Sequence())
+	}
+	tl.Config.Backend = &rangeBackend{MemoryBackend: tl.Config.Backend.(*MemoryBackend)}
+
+	n := int64(tileWidth*4 + 15)
+	var indexes []int64
+	for i := range tlog.StoredHashCount(n) {
+		indexes = append(indexes, i)
+	}
+	full, err := tl.Log.ReadHashes(indexes, false)
+	fatalIfErr(t, err)
+	ranged, err := tl.Log.ReadHashes(indexes, true)
+	fatalIfErr(t, err)
+	if !slices.Equal(full, ranged) {
+		t.Errorf("ranged hashes differ from full tile hashes")
+	}
+
+	// A single hash is read with a single small range.
+	rb := tl.Config.Backend.(*rangeBackend)
+	rb.fetched = 0
+	if _, err := tl.Log.ReadHashes([]int64{tlog.StoredHashIndex(0, 17)}, true); err != nil {
+		t.Fatal(err)
+	}
+	if rb.fetched != tlog.HashSize {
+		t.Errorf("fetched %d bytes for a single hash", rb.fetched)
+	}
+
+	if _, err := tl.Log.ReadHashes([]int64{tlog.StoredHashCount(n)}, true); err == nil {
+		t.Errorf("reading a hash outside the tree succeeded")
+	}
+}
+
+func TestRangeProofsOptIn(t *testing.T) {
+	tl := NewEmptyTestLog(t)
+	tl.Quiet()
+	for range tileWidth*2 + 5 {
+		addCertificate(t, tl)
+	}
+	fatalIfErr(t, tl.Log.Sequence())
+	rb := &rangeBackend{MemoryBackend: tl.Config.Backend.(*MemoryBackend)}
+	tl.Config.Backend = rb
+	n := uint64(tileWidth*2 + 5)
+
+	// Without UnverifiedRangeProofs, proofs are computed from whole tiles.
+	logClient := tl.LogClient()
+	if _, err := logClient.GetEntryAndProof(context.Background(), 3, n); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := logClient.GetSTHConsistency(context.Background(), 10, n); err != nil {
+		t.Fatal(err)
+	}
+	if rb.hashRanges != 0 {
+		t.Errorf("hash tiles were read with FetchRange without UnverifiedRangeProofs")
+	}
+	if rb.ranges == 0 {
+		t.Errorf("data tiles were not read with FetchRange")
+	}
+
+	// Data tiles are compressed by CompressBackend, so they are read whole.
+	cb, err := ctlog.NewCompressBackend(rb, nil)
+	fatalIfErr(t, err)
+	tl.Config.Backend = cb
+	tl.Config.UnverifiedRangeProofs = true
+	rb.ranges, rb.hashRanges = 0, 0
+	if _, err := logClient.GetEntryAndProof(context.Background(), 3, n); err != nil {
+		t.Fatal(err)
+	}
+	if rb.ranges != rb.hashRanges || rb.hashRanges == 0 {
+		t.Errorf("got %d ranges and %d hash tile ranges through CompressBackend",
+			rb.ranges, rb.hashRanges)
+	}
+
+	// VerifyingBackend can't verify ranges, so it reads everything whole.
+	tl.Config.Backend = ctlog.NewVerifyingBackend(rb)
+	rb.ranges, rb.hashRanges = 0, 0
+	if _, err := logClient.GetEntryAndProof(context.Background(), 3, n); err != nil {
+		t.Fatal(err)
+	}
+	if rb.ranges != 0 {
+		t.Errorf("got %d ranges through VerifyingBackend", rb.ranges)
+	}
+}
+
+func BenchmarkProofServing(b *testing.B) {
+	for _, ranged := range []bool{false, true} {
+		b.Run(fmt.Sprintf("range=%v", ranged), func(b *testing.B) {
+			tl := NewEmptyTestLog(b)
+			tl.Quiet()
+			for i := range 16 {
+				for j := range tileWidth {
+					tl.Log.AddLeafToPool(&ctlog.PendingLogEntry{
+						Certificate: fmt.Appendf(bytes.Repeat([]byte("A"), 1500), "%d-%d", i, j)})
+				}
+				fatalIfErr(b, tl.Log.Sequence())
+			}
+			rb := &rangeBackend{MemoryBackend: tl.Config.Backend.(*MemoryBackend)}
+			tl.Config.Backend = rb
+			tl.Config.UnverifiedRangeProofs = true
+			if !ranged {
+				// Hide the FetchRange method.
+				tl.Config.Backend = struct{ ctlog.Backend }{rb}
+			}
+			h := tl.Log.Handler()
+			size := 16 * tileWidth
+			b.ResetTimer()
+			for range b.N {
+				url := fmt.Sprintf("/ct/v1/get-entry-and-proof?leaf_index=%d&tree_size=%d",
+					mathrand.Intn(size), size)
+				rr := httptest.NewRecorder()
+				h.ServeHTTP(rr, httptest.NewRequest("GET", url, nil))
+				if rr.Code != http.StatusOK {
+					b.Fatalf("got status %d: %s", rr.Code, rr.Body)
+				}
+			}
+			b.ReportMetric(float64(rb.fetched)/float64(b.N), "fetched-bytes/op")
+		})
+	}
+}
+
+func TestMirrorBackend(t *testing.T) {
+	logHandler, _ := testLogHandler(t)
+	ctx := context.Background()
+	primary, mirror := NewMemoryBackend(t), NewMemoryBackend(t)
+	flakyPrimary := &flakyBackend{MemoryBackend: primary, err: errors.New("primary down")}
+	b, err := ctlog.NewMirrorBackend(flakyPrimary, []ctlog.Backend{mirror}, 0, slog.New(logHandler))
+	fatalIfErr(t, err)
+
+	fatalIfErr(t, b.Upload(ctx, "checkpoint", []byte("hello"), nil))
+	for _, m := range []*MemoryBackend{primary, mirror} {
+		if data, err := m.Fetch(ctx, "checkpoint"); err != nil || string(data) != "hello" {
+			t.Errorf("replica Fetch = %q, %v", data, err)
+		}
+	}
+
+	// Reads fall back to the mirror if the primary is down or missing the key.
+	flakyPrimary.failures.Store(1)
+	if data, err := b.Fetch(ctx, "checkpoint"); err != nil || string(data) != "hello" {
+		t.Errorf("Fetch with the primary down = %q, %v", data, err)
+	}
+	fatalIfErr(t, mirror.Upload(ctx, "mirror-only", []byte("x"), nil))
+	if data, err := b.Fetch(ctx, "mirror-only"); err != nil || string(data) != "x" {
+		t.Errorf("Fetch of a key missing from the primary = %q, %v", data, err)
+	}
+	if _, err := b.Fetch(ctx, "missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Fetch of a missing key returned %v", err)
+	}
+	flakyPrimary.failures.Store(1)
+	if _, err := b.Fetch(ctx, "missing"); err == nil || errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Fetch of a missing key with the primary down returned %v, expected the primary error", err)
+	}
+
+	// Writes fail if a mirror is down, and report which replica is behind.
+	mirror.UploadCallback = failCheckpointAndNotPersist
+	err = b.Upload(ctx, "checkpoint", []byte("two"), nil)
+	var merr *ctlog.MirrorError
+	if !errors.As(err, &merr) {
+		t.Fatalf("Upload with the mirror down returned %v, expected a MirrorError", err)
+	}
+	if !slices.Equal(merr.Behind, []int{1}) || merr.Key != "checkpoint" {
+		t.Errorf("got MirrorError %v, expected replica 1 behind", merr)
+	}
+	if data, _ := primary.Fetch(ctx, "checkpoint"); string(data) != "two" {
+		t.Errorf("primary has %q, expected the new upload", data)
+	}
+
+	// With a quorum of one, the same failure is tolerated.
+	b, err = ctlog.NewMirrorBackend(primary, []ctlog.Backend{mirror}, 1, slog.New(logHandler))
+	fatalIfErr(t, err)
+	fatalIfErr(t, b.Upload(ctx, "checkpoint", []byte("three"), nil))
+	if _, err := ctlog.NewMirrorBackend(primary, []ctlog.Backend{mirror}, 3, slog.New(logHandler)); err == nil {
+		t.Error("accepted a quorum larger than the number of replicas")
+	}
+}
+
+func TestFatalError(t *testing.T) {
+	tl := NewEmptyTestLog(t)
+	addCertificate(t, tl)
+	addCertificate(t, tl)
+	fatalIfErr(t, tl.Log.Sequence())
+
+	tl.Config.Lock.(*MemoryLockBackend).ReplaceCallback = failLockAndNotPersist
+	addCertificateExpectFailure(t, tl)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+	if err := tl.Log.RunSequencer(ctx, 1*time.Millisecond); err == nil {
+		t.Errorf("expected fatal error, got nil")
+	}
+	tl.CheckLog(2)
+
+	tl.Config.Lock.(*MemoryLockBackend).ReplaceCallback = nil
+
+	tl = ReloadLog(t, tl)
+	addCertificate(t, tl)
+	fatalIfErr(t, tl.Log.Sequence())
+	tl.CheckLog(3)
+}
+
+func TestConcurrentSequencers(t *testing.T) {
+	tl := NewEmptyTestLog(t)
+	other := ReloadLog(t, tl)
+
+	addCertificate(t, tl)
+	fatalIfErr(t, tl.Log.Sequence())
+	tl.CheckLog(1)
+
+	// The other instance holds a stale lock checkpoint, so its compare-and-swap
+	// must fail and halt it instead of forking the log.
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+	if err := other.Log.RunSequencer(ctx, 1*time.Millisecond); err == nil || ctx.Err() != nil {
+		t.Errorf("expected fatal error from the stale sequencer, got %v", err)
+	}
+	tl.CheckLog(1)
+
+	addCertificate(t, tl)
+	fatalIfErr(t, tl.Log.Sequence())
+	tl.CheckLog(2)
+}
+
+func TestNonFatalError(t *testing.T) {
+	// TODO
+}
+
+func TestSequenceErrors(t *testing.T) {
+	tests := []struct {
+		name           string
+		breakSeq       func(*TestLog)
+		unbreakSeq     func(*TestLog)
+		expectProgress bool
+		expectFatal    bool
+		// expectSuccess is set if the submissions succeed anyway.
+		expectSuccess bool
+	}{
+		{
+			// A fatal error while uploading to the lock backend. The upload is
+			// retried, and the same tiles are generated and uploaded again.
+			name: "LockUpload",
+			breakSeq: func(tl *TestLog) {
+				tl.Config.Lock.(*MemoryLockBackend).ReplaceCallback = failLockAndNotPersist
+			},
+			unbreakSeq: func(tl *TestLog) {
+				tl.Config.Lock.(*MemoryLockBackend).ReplaceCallback = nil
+			},
+			expectProgress: false,
+			expectFatal:    true,
+		},
+		{
+			// An error while uploading to the lock backend, where the lock is
+			// persisted anyway, such as a response timeout.
+			name: "LockUploadPersisted",
+			breakSeq: func(tl *TestLog) {
+				tl.Config.Lock.(*MemoryLockBackend).ReplaceCallback = failLockButPersist
+			},
+			unbreakSeq: func(tl *TestLog) {
+				tl.Config.Lock.(*MemoryLockBackend).ReplaceCallback = nil
+			},
+			expectProgress: true,
+			expectFatal:    true,
+		},
+		{
+			name: "CheckpointUpload",
+			breakSeq: func(tl *TestLog) {
+				tl.Config.Backend.(*MemoryBackend).UploadCallback = failCheckpointAndNotPersist
+			},
+			unbreakSeq: func(tl *TestLog) {
+				tl.Config.Backend.(*MemoryBackend).UploadCallback = nil
+			},
+			expectProgress: true,
+			expectFatal:    false,
+		},
+		{
+			// The checkpoint is fetched back after the error, and found to be
+			// the new one.
+			name: "CheckpointUploadPersisted",
+			breakSeq: func(tl *TestLog) {
+				tl.Config.Backend.(*MemoryBackend).UploadCallback = failCheckpointButPersist
+			},
+			unbreakSeq: func(tl *TestLog) {
+				tl.Config.Backend.(*MemoryBackend).UploadCallback = nil
+			},
+			expectProgress: true,
+			expectFatal:    false,
+			expectSuccess:  true,
+		},
+		{
+			name: "StagingUpload",
+			breakSeq: func(tl *TestLog) {
+				tl.Config.Backend.(*MemoryBackend).UploadCallback = failStagingAndNotPersist
+			},
+			unbreakSeq: func(tl *TestLog) {
+				tl.Config.Backend.(*MemoryBackend).UploadCallback = nil
+			},
+			expectProgress: false,
+			expectFatal:    false,
+		},
+		{
+			name: "StagingUploadPersisted",
+			breakSeq: func(tl *TestLog) {
+				tl.Config.Backend.(*MemoryBackend).UploadCallback = failStagingButPersist
+			},
+			unbreakSeq: func(tl *TestLog) {
+				tl.Config.Backend.(*MemoryBackend).UploadCallback = nil
+			},
+			expectProgress: false,
+			expectFatal:    false,
+		},
+		{
+			name: "DataTileUpload",
+			breakSeq: func(tl *TestLog) {
+				tl.Config.Backend.(*MemoryBackend).UploadCallback = failDataTileAndNotPersist
+			},
+			unbreakSeq: func(tl *TestLog) {
+				tl.Config.Backend.(*MemoryBackend).UploadCallback = nil
+			},
+			expectProgress: true,
+			expectFatal:    true,
+		},
+		{
+			name: "DataTileUploadPersisted",
+			breakSeq: func(tl *TestLog) {
+				tl.Config.Backend.(*MemoryBackend).UploadCallback = failDataTileButPersist
+			},
+			unbreakSeq: func(tl *TestLog) {
+				tl.Config.Backend.(*MemoryBackend).UploadCallback = nil
+			},
+			expectProgress: true,
+			expectFatal:    true,
+		},
+		{
+			name: "Level0TileUpload",
+			breakSeq: func(tl *TestLog) {
+				tl.Config.Backend.(*MemoryBackend).UploadCallback = failTile0AndNotPersist
+			},
+			unbreakSeq: func(tl *TestLog) {
+				tl.Config.Backend.(*MemoryBackend).UploadCallback = nil
+			},
+			expectProgress: true,
+			expectFatal:    true,
+		},
+		{
+			name: "Level0TileUploadPersisted",
+			breakSeq: func(tl *TestLog) {
+				tl.Config.Backend.(*MemoryBackend).UploadCallback = failTile0ButPersist
+			},
+			unbreakSeq: func(tl *TestLog) {
+				tl.Config.Backend.(*MemoryBackend).UploadCallback = nil
+			},
+			expectProgress: true,
+			expectFatal:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var tl *TestLog
+			var expectedSize int64
+			var broken bool
+			breakSeq := func() {
+				tt.breakSeq(tl)
+				broken = true
+			}
+			unbreakSeq := func() {
+				tt.unbreakSeq(tl)
+				broken = false
+			}
+			addBroken := func() {
+				t.Helper()
+				if tt.expectSuccess {
+					addCertificate(t, tl)
+				} else {
+					addCertificateExpectFailure(t, tl)
+				}
+			}
+			sequence := func(added int64) {
+				err := tl.Log.Sequence()
+				if broken && tt.expectFatal {
+					if err == nil {
+						t.Error("expected error, got nil")
+					}
+				} else {
+					fatalIfErr(t, err)
+				}
+				if !broken || tt.expectProgress {
+					expectedSize += added
+				}
+				tl.CheckLog(expectedSize)
+				if err != nil {
+					if broken {
+						tt.unbreakSeq(tl)
+					}
+					tl = ReloadLog(t, tl)
+					if broken {
+						tt.breakSeq(tl)
+					}
+				}
+			}
+
+			tl = NewEmptyTestLog(t)
+			for range tileWidth - 2 {
+				addCertificate(t, tl)
+			}
+			sequence(tileWidth - 2)
+
+			breakSeq()
+			addBroken()
+			addBroken()
+			addBroken()
+			sequence(3)
+
+			// Re-failing the same tile sizes.
+			addBroken()
+			addBroken()
+			addBroken()
+			sequence(3)
+
+			unbreakSeq()
+
+			// Succeeding with the same size that failed.
+			addCertificate(t, tl)
+			addCertificate(t, tl)
+			addCertificate(t, tl)
+			sequence(3)
+
+			tl = NewEmptyTestLog(t)
+			expectedSize = 0
+			for range tileWidth - 2 {
+				addCertificate(t, tl)
+			}
+			sequence(tileWidth - 2)
+
+			breakSeq()
+			addBroken()
+			addBroken()
+			addBroken()
+			sequence(3)
+
+			unbreakSeq()
+
+			// Succeeding with a different set of tiles.
+			addCertificate(t, tl)
+			sequence(1)
+		})
+	}
+}
+
+func TestCheckpointUploadFailure(t *testing.T) {
+	newLog := func(t *testing.T) (*TestLog, *FaultBackend) {
+		tl := NewEmptyTestLog(t)
+		tl.Quiet()
+		fb := NewFaultBackend(tl.Config.Backend)
+		tl.Config.Backend = fb
+		tl = ReloadLog(t, tl)
+		addCertificate(t, tl)
+		fatalIfErr(t, tl.Log.Sequence())
+		tl.CheckLog(1)
+		fb.Heal()
+		return tl, fb
+	}
+	checkpointUploads := func(fb *FaultBackend) (n int) {
+		for _, key := range fb.Uploads() {
+			if key == "checkpoint" {
+				n++
+			}
+		}
+		return n
+	}
+
+	t.Run("Persisted", func(t *testing.T) {
+		tl, fb := newLog(t)
+		fb.PersistKeys = regexp.MustCompile("^checkpoint$")
+		addCertificate(t, tl)
+		fatalIfErr(t, tl.Log.Sequence())
+		if n := checkpointUploads(fb); n != 1 {
+			t.Errorf("got %d checkpoint uploads, expected 1", n)
+		}
+		fb.Heal()
+		tl.CheckLog(2)
+	})
+
+	t.Run("Retried", func(t *testing.T) {
+		tl, fb := newLog(t)
+		fb.FailKeys = regexp.MustCompile("^checkpoint$")
+		fb.FailKeysTimes = 2
+		addCertificate(t, tl)
+		fatalIfErr(t, tl.Log.Sequence())
+		if n := checkpointUploads(fb); n != 3 {
+			t.Errorf("got %d checkpoint uploads, expected 3", n)
+		}
+		tl.CheckLog(2)
+	})
+
+	t.Run("GaveUp", func(t *testing.T) {
+		tl, fb := newLog(t)
+		fb.FailKeys = regexp.MustCompile("^checkpoint$")
+		addCertificateExpectFailure(t, tl)
+		fatalIfErr(t, tl.Log.Sequence())
+		if n := checkpointUploads(fb); n != 3 {
+			t.Errorf("got %d checkpoint uploads, expected 3", n)
+		}
+
+		// The next round publishes a checkpoint that includes the entry.
+		fb.Heal()
+		addCertificate(t, tl)
+		fatalIfErr(t, tl.Log.Sequence())
+		tl.CheckLog(3)
+	})
+
+	t.Run("Conflict", func(t *testing.T) {
+		tl, fb := newLog(t)
+		// Another process overwrote the checkpoint in object storage.
+		other := ReloadLog(t, tl)
+		addCertificate(t, other)
+		fatalIfErr(t, other.Log.Sequence())
+
+		fb.FailKeys = regexp.MustCompile("^checkpoint$")
+		addCertificateExpectFailure(t, tl)
+		if err := tl.Log.Sequence(); !errors.Is(err, ctlog.ErrFatal) {
+			t.Errorf("got %v, expected a fatal error", err)
+		}
+		if n := checkpointUploads(fb); n != 1 {
+			t.Errorf("got %d checkpoint uploads, expected 1", n)
+		}
+	})
+}
+
+func BenchmarkSequencerLocal(b *testing.B) {
+	for _, batch := range []bool{true, false} {
+		b.Run(fmt.Sprintf("batch=%v", batch), func(b *testing.B) {
+			tl := NewEmptyTestLog(b)
+			logHandler, _ := testLogHandler(b)
+			lb, err := ctlog.NewLocalBackend(context.Background(), b.TempDir(), slog.New(logHandler))
+			fatalIfErr(b, err)
+			// Copy the checkpoint created by NewEmptyTestLog.
+			checkpoint, err := tl.Config.Backend.Fetch(context.Background(), "checkpoint")
+			fatalIfErr(b, err)
+			fatalIfErr(b, lb.Upload(context.Background(), "checkpoint", checkpoint, nil))
+			tl.Config.Backend = lb
+			if !batch {
+				// Hide the BatchUpload method.
+				tl.Config.Backend = struct{ ctlog.Backend }{lb}
+			}
+			tl = ReloadLog(b, tl)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				// Each round uploads two full tiles and three partial ones.
+				for range tileWidth {
+					tl.Log.AddLeafToPool(&ctlog.PendingLogEntry{Certificate: bytes.Repeat([]byte("A"), 2350)})
+				}
+				fatalIfErr(b, tl.Log.Sequence())
+			}
+		})
+	}
+}
+
+func BenchmarkSequencer(b *testing.B) {
+	tl := NewEmptyTestLog(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		const poolSize = 3000
+		if i%poolSize == 0 && i != 0 {
+			fatalIfErr(b, tl.Log.Sequence())
+		}
+		tl.Log.AddLeafToPool(&ctlog.PendingLogEntry{Certificate: bytes.Repeat([]byte("A"), 2350)})
+	}
+}
+
+var testLeaf, _ = base64.StdEncoding.DecodeString("MIIEJjCCAw6gAwIBAgISA9YVxv2Lcc/y6IhrW5svQmHPMA0GCSqGSIb3DQEBCwUAMDIxCzAJBgNVBAYTAlVTMRYwFAYDVQQKEw1MZXQncyBFbmNyeXB0MQswCQYDVQQDEwJSMzAeFw0yMzExMTUxMDE5MTFaFw0yNDAyMTMxMDE5MTBaMB0xGzAZBgNVBAMTEnJvbWUuY3QuZmlsaXBwby5pbzBZMBMGByqGSM49AgEGCCqGSM49AwEHA0IABMufQMpi+5cCSw8a6D2se6bjTR6Vpcm5kr5b1UHaJZVdM4tOCy66d3iO9LcKYwIdXJJD1TbtzAuLlRCWa1HNlGSjggIUMIICEDAOBgNVHQ8BAf8EBAMCB4AwHQYDVR0lBBYwFAYIKwYBBQUHAwEGCCsGAQUFBwMCMAwGA1UdEwEB/wQCMAAwHQYDVR0OBBYEFIiqDtb1Rz6Y9iVID4JBRl36tE47MB8GA1UdIwQYMBaAFBQusxe3WFbLrlAJQOYfr52LFMLGMFUGCCsGAQUFBwEBBEkwRzAhBggrBgEFBQcwAYYVaHR0cDovL3IzLm8ubGVuY3Iub3JnMCIGCCsGAQUFBzAChhZodHRwOi8vcjMuaS5sZW5jci5vcmcvMB0GA1UdEQQWMBSCEnJvbWUuY3QuZmlsaXBwby5pbzATBgNVHSAEDDAKMAgGBmeBDAECATCCAQQGCisGAQQB1nkCBAIEgfUEgfIA8AB2AEiw42vapkc0D+VqAvqdMOscUgHLVt0sgdm7v6s52IRzAAABi9K04WIAAAQDAEcwRQIhAIjFeq4LZpEUNCTtVu1s3yURyaX18TRp4qjt02A2FYHEAiBWQxxfEsyYUFuDOFIYSh6q6MA9m2YenRmL7FqzgpMvpAB2ADtTd3U+LbmAToswWwb+QDtn2E/D9Me9AA0tcm/h+tQXAAABi9K0418AAAQDAEcwRQIhAJfS1HrW24DPJJCzwZ+Xgo4jX/o6nsXNVRuOrrqoFjBmAiAi53R5tlmS94uXLnUyX6+ULDxwCuSRSb23iEidzugiVDANBgkqhkiG9w0BAQsFAAOCAQEAc0EXBRfCal3xyXZ60DJspRf66ulLpVii1BPvcf0PWWGC/MCjbY2xwz+1p6fePMSMrUJpOTtP5L52bZNQBptq6oKSOKGpVn8eIaVqNPeJsYCuzL5tKnzfhBoyIs9tqc8U7JwZuIyCIFsxd5eDNLSNyphX9+jxATorpFJ8RYibzjmBkDjRSl6T2f32Qy4AKy2FJe2yryJjdiDHqzT3SoTYcJp/2wWklYFMtBV/j4qTGyFiVdVZ1GQUhHvlw1iVqXLHe8cVQoSc+iStlDxeFWEuKnHRTtpfNz+KzP15R13C6CBswODDjqH2HCS2OKhyENB6SF7KhhD5/hMVyj6UWq9pDw==")
+var testPrecert, _ = base64.StdEncoding.DecodeString("MIIDMzCCAhugAwIBAgISA9YVxv2Lcc/y6IhrW5svQmHPMA0GCSqGSIb3DQEBCwUAMDIxCzAJBgNVBAYTAlVTMRYwFAYDVQQKEw1MZXQncyBFbmNyeXB0MQswCQYDVQQDEwJSMzAeFw0yMzExMTUxMDE5MTFaFw0yNDAyMTMxMDE5MTBaMB0xGzAZBgNVBAMTEnJvbWUuY3QuZmlsaXBwby5pbzBZMBMGByqGSM49AgEGCCqGSM49AwEHA0IABMufQMpi+5cCSw8a6D2se6bjTR6Vpcm5kr5b1UHaJZVdM4tOCy66d3iO9LcKYwIdXJJD1TbtzAuLlRCWa1HNlGSjggEhMIIBHTAOBgNVHQ8BAf8EBAMCB4AwHQYDVR0lBBYwFAYIKwYBBQUHAwEGCCsGAQUFBwMCMAwGA1UdEwEB/wQCMAAwHQYDVR0OBBYEFIiqDtb1Rz6Y9iVID4JBRl36tE47MB8GA1UdIwQYMBaAFBQusxe3WFbLrlAJQOYfr52LFMLGMFUGCCsGAQUFBwEBBEkwRzAhBggrBgEFBQcwAYYVaHR0cDovL3IzLm8ubGVuY3Iub3JnMCIGCCsGAQUFBzAChhZodHRwOi8vcjMuaS5sZW5jci5vcmcvMB0GA1UdEQQWMBSCEnJvbWUuY3QuZmlsaXBwby5pbzATBgNVHSAEDDAKMAgGBmeBDAECATATBgorBgEEAdZ5AgQDAQH/BAIFADANBgkqhkiG9w0BAQsFAAOCAQEAk4K63mYRtOqH2LprGfBDIXnOXGt7wicdyBD2Zh5tkqMBB0XulcAi94IUfEOBSfIIzZ5lTh8WvAB6RxMGXYf8Qx4dHCP1McpMvkOJNEz9cHVjoBxx8asdAsV6d+av3MsK83n/fnN6looyUoDz09AZNvmlR74HCmpgLydMMv8ugdiPjRlYLaKy8wiA+HpX2rb4oWJ9kSD7dxuu6+NqPi4qWVsopQKBMcYEhCfQN26tcm2X3jebcwE3TFNxhK5RcRTWMO3i5AtaUZDT4bWUTFTHP8668wvCpI8MyfIlVdlUv3BOnyjvr/zpSBb/SfbyE0yiUBKhxl5z3+LImTNwxbc5sg==")
+var testIntermediate, _ = base64.StdEncoding.DecodeString("MIIFFjCCAv6gAwIBAgIRAJErCErPDBinU/bWLiWnX1owDQYJKoZIhvcNAQELBQAwTzELMAkGA1UEBhMCVVMxKTAnBgNVBAoTIEludGVybmV0IFNlY3VyaXR5IFJlc2VhcmNoIEdyb3VwMRUwEwYDVQQDEwxJU1JHIFJvb3QgWDEwHhcNMjAwOTA0MDAwMDAwWhcNMjUwOTE1MTYwMDAwWjAyMQswCQYDVQQGEwJVUzEWMBQGA1UEChMNTGV0J3MgRW5jcnlwdDELMAkGA1UEAxMCUjMwggEiMA0GCSqGSIb3DQEBAQUAA4IBDwAwggEKAoIBAQC7AhUozPaglNMPEuyNVZLD+ILxmaZ6QoinXSaqtSu5xUyxr45r+XXIo9cPR5QUVTVXjJ6oojkZ9YI8QqlObvU7wy7bjcCwXPNZOOftz2nwWgsbvsCUJCWH+jdxsxPnHKzhm+/b5DtFUkWWqcFTzjTIUu61ru2P3mBw4qVUq7ZtDpelQDRrK9O8ZutmNHz6a4uPVymZ+DAXXbpyb/uBxa3Shlg9F8fnCbvxK/eG3MHacV3URuPMrSXBiLxgZ3Vms/EY96Jc5lP/Ooi2R6X/ExjqmAl3P51T+c8B5fWmcBcUr2Ok/5mzk53cU6cG/kiFHaFpriV1uxPMUgP17VGhi9sVAgMBAAGjggEIMIIBBDAOBgNVHQ8BAf8EBAMCAYYwHQYDVR0lBBYwFAYIKwYBBQUHAwIGCCsGAQUFBwMBMBIGA1UdEwEB/wQIMAYBAf8CAQAwHQYDVR0OBBYEFBQusxe3WFbLrlAJQOYfr52LFMLGMB8GA1UdIwQYMBaAFHm0WeZ7tuXkAXOACIjIGlj26ZtuMDIGCCsGAQUFBwEBBCYwJDAiBggrBgEFBQcwAoYWaHR0cDovL3gxLmkubGVuY3Iub3JnLzAnBgNVHR8EIDAeMBygGqAYhhZodHRwOi8veDEuYy5sZW5jci5vcmcvMCIGA1UdIAQbMBkwCAYGZ4EMAQIBMA0GCysGAQQBgt8TAQEBMA0GCSqGSIb3DQEBCwUAA4ICAQCFyk5HPqP3hUSFvNVneLKYY611TR6WPTNlclQtgaDqw+34IL9fzLdwALduO/ZelN7kIJ+m74uyA+eitRY8kc607TkC53wlikfmZW4/RvTZ8M6UK+5UzhK8jCdLuMGYL6KvzXGRSgi3yLgjewQtCPkIVz6D2QQzCkcheAmCJ8MqyJu5zlzyZMjAvnnAT45tRAxekrsu94sQ4egdRCnbWSDtY7kh+BImlJNXoB1lBMEKIq4QDUOXoRgffuDghje1WrG9ML+Hbisq/yFOGwXD9RiX8F6sw6W4avAuvDszue5L3sz85K+EC4Y/wFVDNvZo4TYXao6Z0f+lQKc0t8DQYzk1OXVu8rp2yJMC6alLbBfODALZvYH7n7do1AZls4I9d1P4jnkDrQoxB3UqQ9hVl3LEKQ73xF1OyK5GhDDX8oVfGKF5u+decIsH4YaTw7mP3GFxJSqv3+0lUFJoi5Lc5da149p90IdshCExroL1+7mryIkXPeFM5TgO9r0rvZaBFOvV2z0gp35Z0+L4WPlbuEjN/lxPFin+HlUjr8gRsI3qfJOQFy/9rKIJR0Y/8Omwt/8oTWgy1mdeHmmjk7j1nYsvC9JSQ6ZvMldlTTKB3zhThV1+XWYp6rjd5JW1zbVWEkLNxE7GJThEUG3szgBVGP7pSWTUTsqXnLRbwHOoq7hHwg==")
+var testRoot, _ = base64.StdEncoding.DecodeString("MIIFazCCA1OgAwIBAgIRAIIQz7DSQONZRGPgu2OCiwAwDQYJKoZIhvcNAQELBQAwTzELMAkGA1UEBhMCVVMxKTAnBgNVBAoTIEludGVybmV0IFNlY3VyaXR5IFJlc2VhcmNoIEdyb3VwMRUwEwYDVQQDEwxJU1JHIFJvb3QgWDEwHhcNMTUwNjA0MTEwNDM4WhcNMzUwNjA0MTEwNDM4WjBPMQswCQYDVQQGEwJVUzEpMCcGA1UEChMgSW50ZXJuZXQgU2VjdXJpdHkgUmVzZWFyY2ggR3JvdXAxFTATBgNVBAMTDElTUkcgUm9vdCBYMTCCAiIwDQYJKoZIhvcNAQEBBQADggIPADCCAgoCggIBAK3oJHP0FDfzm54rVygch77ct984kIxuPOZXoHj3dcKi/vVqbvYATyjb3miGbESTtrFj/RQSa78f0uoxmyF+0TM8ukj13Xnfs7j/EvEhmkvBioZxaUpmZmyPfjxwv60pIgbz5MDmgK7iS4+3mX6UA5/TR5d8mUgjU+g4rk8Kb4Mu0UlXjIB0ttov0DiNewNwIRt18jA8+o+u3dpjq+sWT8KOEUt+zwvo/7V3LvSye0rgTBIlDHCNAymg4VMk7BPZ7hm/ELNKjD+Jo2FR3qyHB5T0Y3HsLuJvW5iB4YlcNHlsdu87kGJ55tukmi8mxdAQ4Q7e2RCOFvu396j3x+UCB5iPNgiV5+I3lg02dZ77DnKxHZu8A/lJBdiB3QW0KtZB6awBdpUKD9jf1b0SHzUvKBds0pjBqAlkd25HN7rOrFleaJ1/ctaJxQZBKT5ZPt0m9STJEadao0xAH0ahmbWnOlFuhjuefXKnEgV4We0+UXgVCwOPjdAvBbI+e0ocS3MFEvzG6uBQE3xDk3SzynTnjh8BCNAw1FtxNrQHusEwMFxIt4I7mKZ9YIqioymCzLq9gwQbooMDQaHWBfEbwrbwqHyGO0aoSCqI3Haadr8faqU9GY/rOPNk3sgrDQoo//fb4hVC1CLQJ13hef4Y53CIrU7m2Ys6xt0nUW7/vGT1M0NPAgMBAAGjQjBAMA4GA1UdDwEB/wQEAwIBBjAPBgNVHRMBAf8EBTADAQH/MB0GA1UdDgQWBBR5tFnme7bl5AFzgAiIyBpY9umbbjANBgkqhkiG9w0BAQsFAAOCAgEAVR9YqbyyqFDQDLHYGmkgJykIrGF1XIpu+ILlaS/V9lZLubhzEFnTIZd+50xx+7LSYK05qAvqFyFWhfFQDlnrzuBZ6brJFe+GnY+EgPbk6ZGQ3BebYhtF8GaV0nxvwuo77x/Py9auJ/GpsMiu/X1+mvoiBOv/2X/qkSsisRcOj/KKNFtY2PwByVS5uCbMiogziUwthDyC3+6WVwW6LLv3xLfHTjuCvjHIInNzktHCgKQ5ORAzI4JMPJ+GslWYHb4phowim57iaztXOoJwTdwJx4nLCgdNbOhdjsnvzqvHu7UrTkXWStAmzOVyyghqpZXjFaH3pO3JLF+l+/+sKAIuvtd7u+Nxe5AW0wdeRlN8NwdCjNPElpzVmbUq4JUagEiuTDkHzsxHpFKVK7q4+63SM1N95R1NbdWhscdCb+ZAJzVcoyi3B43njTOQ5yOf+1CceWxG1bQVs5ZufpsMljq4Ui0/1lvh+wjChP4kqKOJ2qxq4RgqsahDYVvTH9w7jXbyLeiNdd8XM2w9U/t7y0Ff/9yi0GE44Za4rF2LN9d11TPAmRGunUHBcnWEvgJBQl9nJEiU0Zsnvgc/ubhPgXRR4Xq37Z0j4r7g1SgEEzwxA57demyPxgcYxn/eR44/KJ4EBs+lVDR3veyJm+kXQ99b21/+jh5Xos1AnX5iItreGCc=")
+
+func TestNewerTiles(t *testing.T) {
+	// newLog returns a log with two entries short of a full tile, and a
+	// function that loses the lock checkpoints committed after it.
+	newLog := func(t *testing.T, createOnly bool) (*TestLog, func()) {
+		tl := NewEmptyTestLog(t)
+		tl.Config.Backend.(*MemoryBackend).CreateOnly = createOnly
+		tl.Quiet()
+		for range sunlight.TileWidth - 2 {
+			addCertificate(t, tl)
+		}
+		fatalIfErr(t, tl.Log.Sequence())
+		tl.CheckLog(sunlight.TileWidth - 2)
+
+		logID, err := logIDFromKey(tl.Config.Key)
+		fatalIfErr(t, err)
+		lock, err := tl.Config.Lock.Fetch(context.Background(), logID)
+		fatalIfErr(t, err)
+		return tl, func() { tl.Config.Lock.(*MemoryLockBackend).Rollback(logID, lock) }
+	}
+	// sequenceNewer sequences five entries, which fill the data tile and
+	// start a new one.
+	sequenceNewer := func(t *testing.T, tl *TestLog) {
+		for range 5 {
+			addCertificate(t, tl)
+		}
+		fatalIfErr(t, tl.Log.Sequence())
+		tl.CheckLog(sunlight.TileWidth + 3)
+	}
+	reload := func(t *testing.T, tl *TestLog, policy ctlog.NewerTilesPolicy) (*TestLog, error) {
+		tl.Config.NewerTiles = policy
+		log, err := ctlog.LoadLog(context.Background(), tl.Config)
+		if err != nil {
+			return nil, err
+		}
+		t.Cleanup(func() { fatalIfErr(t, log.CloseCache()) })
+		return &TestLog{t: t, Log: log, Config: tl.Config}, nil
+	}
+
+	t.Run("Fail", func(t *testing.T) {
+		tl, rollback := newLog(t, false)
+		sequenceNewer(t, tl)
+		rollback()
+		if _, err := reload(t, tl, ctlog.NewerTilesFail); err == nil {
+			t.Fatal("expected loading to fail")
+		}
+	})
+
+	t.Run("FailUnpublished", func(t *testing.T) {
+		tl, rollback := newLog(t, false)
+		// Crash after uploading the data tiles, before the checkpoint.
+		mb := tl.Config.Backend.(*MemoryBackend)
+		mb.UploadCallback = failCheckpointAndNotPersist
+		for range 5 {
+			addCertificateExpectFailure(t, tl)
+		}
+		fatalIfErr(t, tl.Log.Sequence())
+		mb.UploadCallback = nil
+		rollback()
+		if _, err := reload(t, tl, ctlog.NewerTilesFail); err == nil {
+			t.Fatal("expected loading to fail")
+		}
+	})
+
+	for _, createOnly := range []bool{false, true} {
+		t.Run(fmt.Sprintf("RollForward/CreateOnly=%v", createOnly), func(t *testing.T) {
+			tl, rollback := newLog(t, createOnly)
+			sequenceNewer(t, tl)
+			rollback()
+			tl, err := reload(t, tl, ctlog.NewerTilesRollForward)
+			fatalIfErr(t, err)
+			tl.CheckLog(sunlight.TileWidth + 3)
+
+			addCertificate(t, tl)
+			fatalIfErr(t, tl.Log.Sequence())
+			tl.CheckLog(sunlight.TileWidth + 4)
+		})
+	}
+
+	t.Run("RollForward/MissingHashTiles", func(t *testing.T) {
+		tl, rollback := newLog(t, true)
+		// Crash after uploading the data tiles, before the level 0 hash tiles.
+		// Uploads are staged data tiles first, so with a concurrency of one
+		// the data tiles are persisted before the hash tile upload fails and
+		// cancels the rest.
+		tl.Config.UploadConcurrency = 1
+		tl = ReloadLog(t, tl)
+		mb := tl.Config.Backend.(*MemoryBackend)
+		mb.UploadCallback = failTile0AndNotPersist
+		for range 5 {
+			addCertificateExpectFailure(t, tl)
+		}
+		if err := tl.Log.Sequence(); err == nil {
+			t.Fatal("expected sequencing to fail")
+		}
+		mb.UploadCallback = nil
+		for _, key := range []string{"tile/data/000", "tile/data/001.p/3"} {
+			if _, err := mb.Fetch(context.Background(), key); err != nil {
+				t.Fatalf("data tile %s was not persisted: %v", key, err)
+			}
+		}
+		rollback()
+		tl, err := reload(t, tl, ctlog.NewerTilesRollForward)
+		fatalIfErr(t, err)
+		tl.CheckLog(sunlight.TileWidth + 3)
+	})
+
+	t.Run("RollForward/Corrupted", func(t *testing.T) {
+		tl, rollback := newLog(t, false)
+		sequenceNewer(t, tl)
+		rollback()
+		tl.Config.Backend.(*MemoryBackend).Corrupt("tile/data/001.p/3")
+		if _, err := reload(t, tl, ctlog.NewerTilesRollForward); err == nil {
+			t.Fatal("expected loading to fail")
+		}
+	})
+
+	for _, createOnly := range []bool{false, true} {
+		t.Run(fmt.Sprintf("Ignore/CreateOnly=%v", createOnly), func(t *testing.T) {
+			tl, rollback := newLog(t, createOnly)
+			sequenceNewer(t, tl)
+			rollback()
+			tl, err := reload(t, tl, ctlog.NewerTilesIgnore)
+			fatalIfErr(t, err)
+			for _, key := range tl.Config.Backend.(*MemoryBackend).Keys() {
+				if key == "tile/data/000" || key == "tile/data/001.p/3" {
+					t.Errorf("newer data tile %q was not deleted", key)
+				}
+			}
+
+			// The next rounds overwrite the newer entries.
+			for range 3 {
+				addCertificate(t, tl)
+			}
+			fatalIfErr(t, tl.Log.Sequence())
+			tl.CheckLog(sunlight.TileWidth + 1)
+		})
+	}
+}
+
+func TestClockSkew(t *testing.T) {
+	tl := NewEmptyTestLog(t)
+	clock := ctlogtest.NewManualClock(time.UnixMilli(monotonicTime()))
+	tl.Config.Clock = clock.Now
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(tl.Log.Metrics()...)
+	skewed := func() float64 {
+		t.Helper()
+		families, err := reg.Gather()
+		fatalIfErr(t, err)
+		for _, mf := range families {
+			if mf.GetName() == "sequencing_clock_skew_total" {
+				return mf.GetMetric()[0].GetCounter().GetValue()
+			}
+		}
+		return 0
+	}
+
+	clock.Advance(time.Millisecond)
+	addCertificate(t, tl)
+	fatalIfErr(t, tl.Log.Sequence())
+	ts := tl.CheckLog(1)
+
+	// A clock stepped back within the skew doesn't stop the rounds, which are
+	// timestamped right after the previous tree head, like their SCTs.
+	clock.Advance(-time.Second)
+	for i := range 3 {
+		wait := addCertificate(t, tl)
+		fatalIfErr(t, tl.Log.Sequence())
+		if got := tl.CheckLog(int64(2 + i)); got != ts+1 {
+			t.Errorf("round %d: got timestamp %d, expected %d", i, got, ts+1)
+		}
+		e, err := wait(context.Background())
+		fatalIfErr(t, err)
+		if e.Timestamp != ts+1 {
+			t.Errorf("round %d: got SCT timestamp %d, expected %d", i, e.Timestamp, ts+1)
+		}
+		ts++
+	}
+	if n := skewed(); n != 3 {
+		t.Errorf("got %v skewed rounds, expected 3", n)
+	}
 
+	// The log can be reloaded with the clock still behind, and the first round
+	// is still timestamped after the loaded tree head.
 	tl = ReloadLog(t, tl)
 	addCertificate(t, tl)
 	fatalIfErr(t, tl.Log.Sequence())
-	tl.CheckLog(3)
+	if got := tl.CheckLog(5); got != ts+1 {
+		t.Errorf("after reload: got timestamp %d, expected %d", got, ts+1)
+	}
+
+	// Past the skew, sequencing fails.
+	clock.Advance(-5 * time.Second)
+	addCertificateExpectFailure(t, tl)
+	if err := tl.Log.Sequence(); !errors.Is(err, ctlog.ErrFatal) {
+		t.Errorf("got %v, expected a fatal error", err)
+	}
+	tl.CheckLog(5)
+
+	clock.Advance(10 * time.Second)
+	tl = ReloadLog(t, tl)
+	addCertificate(t, tl)
+	fatalIfErr(t, tl.Log.Sequence())
+	tl.CheckLog(6)
 }
 
-func TestNonFatalError(t *testing.T) {
-	// TODO
+func TestRoundTimestamp(t *testing.T) {
+	const skew = 2 * time.Second
+	steps := map[string]func(r *mathrand.Rand) int64{
+		"Jitter": func(r *mathrand.Rand) int64 { return r.Int63n(2*skew.Milliseconds()) - skew.Milliseconds() },
+		"Stall":  func(r *mathrand.Rand) int64 { return r.Int63n(2) },
+		"Backwards": func(r *mathrand.Rand) int64 {
+			if r.Intn(10) == 0 {
+				return -r.Int63n(skew.Milliseconds())
+			}
+			return r.Int63n(1000)
+		},
+		"Broken": func(r *mathrand.Rand) int64 { return r.Int63n(10*skew.Milliseconds()) - 5*skew.Milliseconds() },
+	}
+	for name, step := range steps {
+		t.Run(name, func(t *testing.T) {
+			r := mathrand.New(mathrand.NewSource(1))
+			now := time.Now().UnixMilli()
+			treeTime := now
+			for i := range 10000 {
+				now += step(r)
+				ts, stepped, err := ctlog.RoundTimestamp(now, treeTime, skew)
+				if err != nil {
+					// The only error is a clock too far behind, which leaves
+					// the tree head as it was.
+					if treeTime-now <= skew.Milliseconds() {
+						t.Fatalf("step %d: unexpected error at %d after %d: %v", i, now, treeTime, err)
+					}
+					continue
+				}
+				if ts <= treeTime {
+					t.Fatalf("step %d: timestamp %d is not after tree head %d", i, ts, treeTime)
+				}
+				if ts != max(treeTime+1, now) {
+					t.Fatalf("step %d: timestamp %d, expected max(%d+1, %d)", i, ts, treeTime, now)
+				}
+				if stepped != (now <= treeTime) {
+					t.Fatalf("step %d: stepped is %v at %d after %d", i, stepped, now, treeTime)
+				}
+				treeTime = ts
+			}
+		})
+	}
 }
 
-func TestSequenceErrors(t *testing.T) {
-	tests := []struct {
-		name           string
-		breakSeq       func(*TestLog)
-		unbreakSeq     func(*TestLog)
-		expectProgress bool
-		expectFatal    bool
+func TestUploadConcurrency(t *testing.T) {
+	for _, limit := range []int{1, 2} {
+		t.Run(fmt.Sprintf("Limit=%d", limit), func(t *testing.T) {
+			tl := NewEmptyTestLog(t)
+			tl.Quiet()
+			tl.Config.UploadConcurrency = limit
+			tl = ReloadLog(t, tl)
+
+			var inFlight, peak atomic.Int64
+			tl.Config.Backend.(*MemoryBackend).UploadCallback = func(key string, data []byte) (bool, error) {
+				if strings.HasPrefix(key, "tile/") {
+					n := inFlight.Add(1)
+					defer inFlight.Add(-1)
+					for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+					}
+					time.Sleep(5 * time.Millisecond)
+				}
+				return true, nil
+			}
+
+			// Two full tiles at level 0, plus partials at both levels.
+			for i := range 2*tileWidth + 5 {
+				addCertificateWithSeed(t, tl, int64(i))
+			}
+			fatalIfErr(t, tl.Log.Sequence())
+			tl.CheckLog(2*tileWidth + 5)
+			if p := peak.Load(); p != int64(limit) {
+				t.Errorf("got %d concurrent tile uploads, expected %d", p, limit)
+			}
+
+			reg := prometheus.NewRegistry()
+			reg.MustRegister(tl.Log.Metrics()...)
+			families, err := reg.Gather()
+			fatalIfErr(t, err)
+			for _, mf := range families {
+				if mf.GetName() == "sequencing_uploads_in_flight" {
+					if v := mf.GetMetric()[0].GetGauge().GetValue(); v != 0 {
+						t.Errorf("got %v uploads in flight after the round", v)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestSequencerMetrics(t *testing.T) {
+	tl := NewEmptyTestLog(t)
+	reg := prometheus.NewRegistry()
+	tl.Config.Registerer = prometheus.WrapRegistererWith(prometheus.Labels{"log": "test"}, reg)
+	tl = ReloadLog(t, tl)
+
+	// Samples are keyed by name and phase, if any.
+	gather := func() map[string]float64 {
+		t.Helper()
+		families, err := reg.Gather()
+		fatalIfErr(t, err)
+		samples := make(map[string]float64)
+		for _, mf := range families {
+			for _, m := range mf.GetMetric() {
+				name := mf.GetName()
+				for _, lp := range m.GetLabel() {
+					switch {
+					case lp.GetName() == "log" && lp.GetValue() != "test":
+						t.Errorf("%s: got log label %q", name, lp.GetValue())
+					case lp.GetName() == "phase":
+						name += "/" + lp.GetValue()
+					case lp.GetName() == "error" && lp.GetValue() != "":
+						name += "/error"
+					}
+				}
+				switch {
+				case m.GetCounter() != nil:
+					samples[name] += m.GetCounter().GetValue()
+				case m.GetGauge() != nil:
+					samples[name] = m.GetGauge().GetValue()
+				case m.GetSummary() != nil:
+					samples[name] = float64(m.GetSummary().GetSampleCount())
+				}
+			}
+		}
+		return samples
+	}
+
+	addCertificate(t, tl)
+	fatalIfErr(t, tl.Log.Sequence())
+	tl.CheckLog(1)
+	s := gather()
+	for _, phase := range []string{"hash", "stage", "sign", "lock", "upload", "checkpoint"} {
+		if n := s["sequencing_phase_duration_seconds/"+phase]; n != 1 {
+			t.Errorf("got %v observations of phase %q, expected 1", n, phase)
+		}
+	}
+	if s["sequencing_uploaded_tiles_bytes_total"] == 0 {
+		t.Error("no uploaded tile bytes")
+	}
+	if s["tree_size_leaves_total"] != 1 {
+		t.Errorf("got tree size %v, expected 1", s["tree_size_leaves_total"])
+	}
+	if age := s["tree_age_seconds"]; age < 0 || age > 60 {
+		t.Errorf("got tree age %v", age)
+	}
+
+	// Failed rounds are counted, and only their completed phases observed.
+	// The tree age keeps growing, since it's computed at collection time.
+	tl.Config.Backend.(*MemoryBackend).UploadCallback = failStagingAndNotPersist
+	addCertificateExpectFailure(t, tl)
+	fatalIfErr(t, tl.Log.Sequence())
+	tl.Config.Backend.(*MemoryBackend).UploadCallback = nil
+	s1 := gather()
+	if n := s1["sequencing_rounds_total/error"]; n != 1 {
+		t.Errorf("got %v failed rounds, expected 1", n)
+	}
+	if n := s1["sequencing_phase_duration_seconds/hash"]; n != 2 {
+		t.Errorf("got %v observations of phase hash, expected 2", n)
+	}
+	if n := s1["sequencing_phase_duration_seconds/stage"]; n != 1 {
+		t.Errorf("got %v observations of phase stage, expected 1", n)
+	}
+	if s1["tree_age_seconds"] <= s["tree_age_seconds"] {
+		t.Errorf("tree age went from %v to %v", s["tree_age_seconds"], s1["tree_age_seconds"])
+	}
+
+	// The metrics of two Logs can't be registered together.
+	if _, err := ctlog.LoadLog(context.Background(), tl.Config); err == nil {
+		t.Error("expected loading with the same Registerer to fail")
+	}
+}
+
+func TestWaitCanceled(t *testing.T) {
+	tl := NewEmptyTestLog(t)
+	addCertificate(t, tl)
+
+	e := &ctlog.PendingLogEntry{Certificate: []byte("abandoned")}
+	wait, source := tl.Log.AddLeafToPool(e)
+	if source != "sequencer" {
+		t.Fatalf("got source %q", source)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := wait(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, expected context.Canceled", err)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, expected context.DeadlineExceeded", err)
+	}
+
+	// The abandoned entry is still sequenced normally.
+	fatalIfErr(t, tl.Log.Sequence())
+	tl.CheckLog(2)
+	le, err := wait(context.Background())
+	fatalIfErr(t, err)
+	if le.LeafIndex != 1 {
+		t.Errorf("got leaf index %d, expected 1", le.LeafIndex)
+	}
+	wait, source = tl.Log.AddLeafToPool(e)
+	if source != "cache" {
+		t.Errorf("got source %q for a resubmission, expected cache", source)
+	}
+	if le1, err := wait(context.Background()); err != nil || le1.LeafIndex != 1 {
+		t.Errorf("got %v, %v for a resubmission", le1, err)
+	}
+}
+
+func TestFailedRoundWaiters(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		fail   func(tl *TestLog)
+		reload bool
+		// size is the tree size after the next round: if the failure came
+		// after the lock commit, the failed round makes it anyway.
+		size int64
 	}{
-		{
-			// A fatal error while uploading to the lock backend. The upload is
-			// retried, and the same tiles are generated and uploaded again.
-			name: "LockUpload",
-			breakSeq: func(tl *TestLog) {
-				tl.Config.Lock.(*MemoryLockBackend).ReplaceCallback = failLockAndNotPersist
-			},
-			unbreakSeq: func(tl *TestLog) {
-				tl.Config.Lock.(*MemoryLockBackend).ReplaceCallback = nil
-			},
-			expectProgress: false,
-			expectFatal:    true,
-		},
-		{
-			// An error while uploading to the lock backend, where the lock is
-			// persisted anyway, such as a response timeout.
-			name: "LockUploadPersisted",
-			breakSeq: func(tl *TestLog) {
-				tl.Config.Lock.(*MemoryLockBackend).ReplaceCallback = failLockButPersist
-			},
-			unbreakSeq: func(tl *TestLog) {
-				tl.Config.Lock.(*MemoryLockBackend).ReplaceCallback = nil
-			},
-			expectProgress: true,
-			expectFatal:    true,
-		},
-		{
-			name: "CheckpointUpload",
-			breakSeq: func(tl *TestLog) {
-				tl.Config.Backend.(*MemoryBackend).UploadCallback = failCheckpointAndNotPersist
-			},
-			unbreakSeq: func(tl *TestLog) {
-				tl.Config.Backend.(*MemoryBackend).UploadCallback = nil
-			},
-			expectProgress: true,
-			expectFatal:    false,
-		},
-		{
-			name: "CheckpointUploadPersisted",
-			breakSeq: func(tl *TestLog) {
-				tl.Config.Backend.(*MemoryBackend).UploadCallback = failCheckpointButPersist
-			},
-			unbreakSeq: func(tl *TestLog) {
-				tl.Config.Backend.(*MemoryBackend).UploadCallback = nil
-			},
-			expectProgress: true,
-			expectFatal:    false,
-		},
-		{
-			name: "StagingUpload",
-			breakSeq: func(tl *TestLog) {
-				tl.Config.Backend.(*MemoryBackend).UploadCallback = failStagingAndNotPersist
-			},
-			unbreakSeq: func(tl *TestLog) {
-				tl.Config.Backend.(*MemoryBackend).UploadCallback = nil
-			},
-			expectProgress: false,
-			expectFatal:    false,
-		},
-		{
-			name: "StagingUploadPersisted",
-			breakSeq: func(tl *TestLog) {
-				tl.Config.Backend.(*MemoryBackend).UploadCallback = failStagingButPersist
-			},
-			unbreakSeq: func(tl *TestLog) {
-				tl.Config.Backend.(*MemoryBackend).UploadCallback = nil
-			},
-			expectProgress: false,
-			expectFatal:    false,
-		},
-		{
-			name: "DataTileUpload",
-			breakSeq: func(tl *TestLog) {
-				tl.Config.Backend.(*MemoryBackend).UploadCallback = failDataTileAndNotPersist
-			},
-			unbreakSeq: func(tl *TestLog) {
-				tl.Config.Backend.(*MemoryBackend).UploadCallback = nil
-			},
-			expectProgress: true,
-			expectFatal:    true,
-		},
-		{
-			name: "DataTileUploadPersisted",
-			breakSeq: func(tl *TestLog) {
-				tl.Config.Backend.(*MemoryBackend).UploadCallback = failDataTileButPersist
-			},
-			unbreakSeq: func(tl *TestLog) {
-				tl.Config.Backend.(*MemoryBackend).UploadCallback = nil
-			},
-			expectProgress: true,
-			expectFatal:    true,
-		},
-		{
-			name: "Level0TileUpload",
-			breakSeq: func(tl *TestLog) {
-				tl.Config.Backend.(*MemoryBackend).UploadCallback = failTile0AndNotPersist
-			},
-			unbreakSeq: func(tl *TestLog) {
-				tl.Config.Backend.(*MemoryBackend).UploadCallback = nil
-			},
-			expectProgress: true,
-			expectFatal:    true,
-		},
-		{
-			name: "Level0TileUploadPersisted",
-			breakSeq: func(tl *TestLog) {
-				tl.Config.Backend.(*MemoryBackend).UploadCallback = failTile0ButPersist
-			},
-			unbreakSeq: func(tl *TestLog) {
-				tl.Config.Backend.(*MemoryBackend).UploadCallback = nil
-			},
-			expectProgress: true,
-			expectFatal:    true,
-		},
+		{"Staging", func(tl *TestLog) {
+			tl.Config.Backend.(*MemoryBackend).UploadCallback = failStagingAndNotPersist
+		}, false, 2},
+		{"Lock", func(tl *TestLog) {
+			tl.Config.Lock.(*MemoryLockBackend).ReplaceCallback = failLockAndNotPersist
+		}, true, 2},
+		{"DataTile", func(tl *TestLog) {
+			tl.Config.Backend.(*MemoryBackend).UploadCallback = failDataTileAndNotPersist
+		}, true, 5},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tl := NewEmptyTestLog(t)
+			tl.Quiet()
+			addCertificate(t, tl)
+			fatalIfErr(t, tl.Log.Sequence())
+
+			var waiters []func(context.Context) (*sunlight.LogEntry, error)
+			for i := range 3 {
+				e := &ctlog.PendingLogEntry{Certificate: []byte{byte(i)}}
+				w, _ := tl.Log.AddLeafToPool(e)
+				waiters = append(waiters, w)
+				// A resubmission of a pending entry waits on the same pool.
+				w, _ = tl.Log.AddLeafToPool(e)
+				waiters = append(waiters, w)
+			}
+			tc.fail(tl)
+			tl.Log.Sequence()
+			tl.Config.Backend.(*MemoryBackend).UploadCallback = nil
+			tl.Config.Lock.(*MemoryLockBackend).ReplaceCallback = nil
+
+			errs := make(chan error, len(waiters))
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			for _, w := range waiters {
+				go func() {
+					_, err := w(ctx)
+					errs <- err
+				}()
+			}
+			for range waiters {
+				if err := <-errs; err == nil {
+					t.Error("expected an error")
+				} else if errors.Is(err, context.DeadlineExceeded) {
+					t.Fatal("waiter hung after a failed round")
+				}
+			}
+
+			// The entries are not carried over to the next round.
+			if tc.reload {
+				tl = ReloadLog(t, tl)
+			}
+			addCertificate(t, tl)
+			fatalIfErr(t, tl.Log.Sequence())
+			tl.CheckLog(tc.size)
+		})
+	}
+}
+
+func TestRoundRetries(t *testing.T) {
+	start := time.UnixMilli(monotonicTime())
+	// run sequences entries 1-3, and then 4-7 after the given staging upload
+	// failures, with a pinned clock so that the tiles only depend on the order
+	// in which the entries are sequenced.
+	run := func(t *testing.T, failures ...func(key string, data []byte) (bool, error)) *TestLog {
+		tl := NewEmptyTestLog(t)
+		tl.Quiet()
+		tl.Config.RoundRetries = 2
+		clock := ctlogtest.NewManualClock(start)
+		tl.Config.Clock = clock.Now
+		mb := tl.Config.Backend.(*MemoryBackend)
+
+		for i := range 3 {
+			addCertificateWithSeed(t, tl, int64(1+i))
+		}
+		clock.Advance(time.Second)
+		fatalIfErr(t, tl.Log.Sequence())
+
+		var waiters []func(context.Context) (*sunlight.LogEntry, error)
+		for i := range 3 {
+			waiters = append(waiters, addCertificateWithSeed(t, tl, int64(4+i)))
+		}
+		clock.Advance(time.Second)
+		for i, fail := range failures {
+			mb.UploadCallback = fail
+			fatalIfErr(t, tl.Log.Sequence())
+			if i == 0 {
+				// Entries submitted while the round is retried go after the
+				// requeued ones.
+				waiters = append(waiters, addCertificateWithSeed(t, tl, 7))
+			}
+		}
+		mb.UploadCallback = nil
+		if len(failures) == 0 {
+			waiters = append(waiters, addCertificateWithSeed(t, tl, 7))
+		}
+		fatalIfErr(t, tl.Log.Sequence())
+		tl.CheckLog(7)
+
+		for i, w := range waiters {
+			e, err := w(context.Background())
+			fatalIfErr(t, err)
+			if e.LeafIndex != int64(3+i) {
+				t.Errorf("entry %d: got index %d, expected %d", 4+i, e.LeafIndex, 3+i)
+			}
+		}
+		return tl
+	}
+
+	t.Run("Retried", func(t *testing.T) {
+		expected := run(t)
+		// The first failure persists the staging bundle, so the retry uploads
+		// it again, which MemoryBackend checks is byte-identical.
+		tl := run(t, failStagingButPersist, failStagingAndNotPersist)
+
+		if got, exp := tl.Checkpoint().Hash, expected.Checkpoint().Hash; got != exp {
+			t.Errorf("got tree hash %v, expected %v", got, exp)
+		}
+		mb, expMB := tl.Config.Backend.(*MemoryBackend), expected.Config.Backend.(*MemoryBackend)
+		var tiles []string
+		for _, key := range mb.Keys() {
+			if strings.HasPrefix(key, "tile/") {
+				tiles = append(tiles, key)
+			}
+		}
+		var expTiles []string
+		for _, key := range expMB.Keys() {
+			if strings.HasPrefix(key, "tile/") {
+				expTiles = append(expTiles, key)
+			}
+		}
+		if !slices.Equal(tiles, expTiles) {
+			t.Fatalf("got tiles %v, expected %v", tiles, expTiles)
+		}
+		for _, key := range tiles {
+			got, err := mb.Fetch(context.Background(), key)
+			fatalIfErr(t, err)
+			exp, err := expMB.Fetch(context.Background(), key)
+			fatalIfErr(t, err)
+			if !bytes.Equal(got, exp) {
+				t.Errorf("tile %q differs from the never-failed run", key)
+			}
+		}
+	})
+
+	t.Run("Exhausted", func(t *testing.T) {
+		tl := NewEmptyTestLog(t)
+		tl.Quiet()
+		tl.Config.RoundRetries = 2
+		addCertificate(t, tl)
+		fatalIfErr(t, tl.Log.Sequence())
+
+		addCertificateExpectFailure(t, tl)
+		tl.Config.Backend.(*MemoryBackend).UploadCallback = failStagingAndNotPersist
+		for range 3 {
+			fatalIfErr(t, tl.Log.Sequence())
+		}
+		tl.Config.Backend.(*MemoryBackend).UploadCallback = nil
+
+		// After the retries ran out, the entry is not carried over.
+		addCertificate(t, tl)
+		fatalIfErr(t, tl.Log.Sequence())
+		tl.CheckLog(2)
+	})
+
+	t.Run("Committed", func(t *testing.T) {
+		tl := NewEmptyTestLog(t)
+		tl.Quiet()
+		tl.Config.RoundRetries = 2
+		addCertificate(t, tl)
+		fatalIfErr(t, tl.Log.Sequence())
+
+		// A round that committed the tree is not retried, even if it failed
+		// to publish its checkpoint.
+		addCertificateExpectFailure(t, tl)
+		tl.Config.Backend.(*MemoryBackend).UploadCallback = failCheckpointAndNotPersist
+		fatalIfErr(t, tl.Log.Sequence())
+		tl.Config.Backend.(*MemoryBackend).UploadCallback = nil
+
+		addCertificate(t, tl)
+		fatalIfErr(t, tl.Log.Sequence())
+		tl.CheckLog(3)
+	})
+}
+
+// fetchOverrideBackend is a CreateOnlyBackend that replaces the Fetch method of
+// a MemoryBackend.
+type fetchOverrideBackend struct {
+	*MemoryBackend
+	fetch func(ctx context.Context, key string) ([]byte, error)
+}
+
+func (b *fetchOverrideBackend) Fetch(ctx context.Context, key string) ([]byte, error) {
+	return b.fetch(ctx, key)
+}
+
+func TestCreateLog(t *testing.T) {
+	ctx := context.Background()
+	// newConfig returns the Config of a log that was never created, with the
+	// same key and object storage as tl.
+	newConfig := func(tl *TestLog) *ctlog.Config {
+		c := *tl.Config
+		c.Lock = NewMemoryLockBackend(t)
+		c.Cache = filepath.Join(t.TempDir(), "cache.db")
+		return &c
 	}
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			var tl *TestLog
-			var expectedSize int64
-			var broken bool
-			breakSeq := func() {
-				tt.breakSeq(tl)
-				broken = true
-			}
-			unbreakSeq := func() {
-				tt.unbreakSeq(tl)
-				broken = false
-			}
-			sequence := func(added int64) {
-				err := tl.Log.Sequence()
-				if broken && tt.expectFatal {
-					if err == nil {
-						t.Error("expected error, got nil")
-					}
-				} else {
-					fatalIfErr(t, err)
-				}
-				if !broken || tt.expectProgress {
-					expectedSize += added
-				}
-				tl.CheckLog(expectedSize)
-				if err != nil {
-					if broken {
-						tt.unbreakSeq(tl)
-					}
-					tl = ReloadLog(t, tl)
-					if broken {
-						tt.breakSeq(tl)
-					}
+	t.Run("Empty", func(t *testing.T) {
+		tl := NewEmptyTestLog(t)
+		c := newConfig(tl)
+		c.Backend = NewMemoryBackend(t)
+		fatalIfErr(t, ctlog.CreateLog(ctx, c))
+		tl.Config = c
+		tl = ReloadLog(t, tl)
+		addCertificate(t, tl)
+		fatalIfErr(t, tl.Log.Sequence())
+		tl.CheckLog(1)
+	})
+
+	t.Run("Exists", func(t *testing.T) {
+		tl := NewEmptyTestLog(t)
+		addCertificate(t, tl)
+		fatalIfErr(t, tl.Log.Sequence())
+		if err := ctlog.CreateLog(ctx, tl.Config); err != ctlog.ErrLogExists {
+			t.Errorf("got %v, expected ErrLogExists", err)
+		}
+		if err := ctlog.ForceCreateLog(ctx, tl.Config); err != ctlog.ErrLogExists {
+			t.Errorf("ForceCreateLog: got %v, expected ErrLogExists", err)
+		}
+		tl.CheckLog(1)
+	})
+
+	t.Run("ObjectStorageOnly", func(t *testing.T) {
+		tl := NewEmptyTestLog(t)
+		addCertificate(t, tl)
+		fatalIfErr(t, tl.Log.Sequence())
+		c := newConfig(tl)
+		if err := ctlog.CreateLog(ctx, c); err == nil {
+			t.Fatal("CreateLog overwrote the checkpoint in object storage")
+		}
+		tl.CheckLog(1)
+
+		// ForceCreateLog resets the log.
+		fatalIfErr(t, ctlog.ForceCreateLog(ctx, c))
+		tl.Config = c
+		tl.CheckLog(0)
+	})
+
+	t.Run("FetchError", func(t *testing.T) {
+		tl := NewEmptyTestLog(t)
+		c := newConfig(tl)
+		c.Backend = &fetchOverrideBackend{tl.Config.Backend.(*MemoryBackend),
+			func(ctx context.Context, key string) ([]byte, error) {
+				return nil, errors.New("object storage unavailable")
+			}}
+		if err := ctlog.CreateLog(ctx, c); err == nil {
+			t.Fatal("CreateLog succeeded without checking object storage")
+		}
+		logID, err := logIDFromKey(c.Key)
+		fatalIfErr(t, err)
+		if _, err := c.Lock.Fetch(ctx, logID); err == nil {
+			t.Error("CreateLog committed a checkpoint to the lock backend")
+		}
+	})
+
+	t.Run("CreateOnly", func(t *testing.T) {
+		// A checkpoint that appears after the check is not overwritten either,
+		// if the Backend supports CreateOnly.
+		tl := NewEmptyTestLog(t)
+		mb := tl.Config.Backend.(*MemoryBackend)
+		mb.CreateOnly = true
+		c := newConfig(tl)
+		c.Backend = &fetchOverrideBackend{mb,
+			func(ctx context.Context, key string) ([]byte, error) {
+				if key == "checkpoint" {
+					return nil, fmt.Errorf("hidden: %w", fs.ErrNotExist)
 				}
-			}
+				return mb.Fetch(ctx, key)
+			}}
+		if err := ctlog.CreateLog(ctx, c); !errors.Is(err, ctlog.ErrObjectExists) {
+			t.Fatalf("got %v, expected ErrObjectExists", err)
+		}
+		tl.CheckLog(0)
+	})
 
-			tl = NewEmptyTestLog(t)
-			for range tileWidth - 2 {
-				addCertificate(t, tl)
+	t.Run("LogConfigExists", func(t *testing.T) {
+		// An existing log config is kept if it matches, and otherwise
+		// CreateLog fails instead of overwriting it.
+		tl := NewEmptyTestLog(t)
+		mb := tl.Config.Backend.(*MemoryBackend)
+		mb.CreateOnly = true
+		stored, err := mb.Fetch(ctx, "log-config")
+		fatalIfErr(t, err)
+
+		c := newConfig(tl)
+		c.NotAfterLimit = c.NotAfterLimit.Add(-time.Hour)
+		fatalIfErr(t, mb.Delete(ctx, "checkpoint"))
+		if err := ctlog.CreateLog(ctx, c); err == nil {
+			t.Fatal("CreateLog succeeded with a different log config in object storage")
+		} else if !strings.Contains(err.Error(), "log config has NotAfterLimit") {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if b, err := mb.Fetch(ctx, "log-config"); err != nil || !bytes.Equal(b, stored) {
+			t.Errorf("log config was overwritten")
+		}
+
+		c = newConfig(tl)
+		fatalIfErr(t, ctlog.CreateLog(ctx, c))
+		if mb.Uploads("log-config") != 3 {
+			t.Errorf("log config uploaded %d times, expected 3", mb.Uploads("log-config"))
+		}
+		tl.Config = c
+		tl = ReloadLog(t, tl)
+		tl.CheckLog(0)
+	})
+}
+
+func TestSequenceTimeout(t *testing.T) {
+	newLog := func(t *testing.T) (*TestLog, *FaultBackend) {
+		tl := NewEmptyTestLog(t)
+		tl.Quiet()
+		fb := NewFaultBackend(tl.Config.Backend)
+		tl.Config.Backend = fb
+		tl = ReloadLog(t, tl)
+		addCertificate(t, tl)
+		fatalIfErr(t, tl.Log.Sequence())
+		tl.CheckLog(1)
+		// Every upload of a round takes longer than the base timeout.
+		tl.Config.SequenceTimeout = 10 * time.Millisecond
+		fb.Delay = 50 * time.Millisecond
+		return tl, fb
+	}
+
+	t.Run("TooSmall", func(t *testing.T) {
+		tl, fb := newLog(t)
+		wait, _ := tl.Log.AddLeafToPool(&ctlog.PendingLogEntry{Certificate: []byte("slow")})
+		fatalIfErr(t, tl.Log.Sequence())
+		_, err := wait(context.Background())
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("got %v, expected a timeout", err)
+		}
+		if !strings.Contains(err.Error(), "in the stage phase") {
+			t.Errorf("timeout error %q doesn't name the stage phase", err)
+		}
+
+		fb.Heal()
+		addCertificate(t, tl)
+		fatalIfErr(t, tl.Log.Sequence())
+		tl.CheckLog(2)
+	})
+
+	t.Run("Scaled", func(t *testing.T) {
+		tl, _ := newLog(t)
+		// The round uploads a partial data tile and a partial level 0 tile, so
+		// the timeout is extended by two seconds, which covers all uploads.
+		tl.Config.SequenceTimeoutPerTile = time.Second
+		addCertificate(t, tl)
+		fatalIfErr(t, tl.Log.Sequence())
+		tl.CheckLog(2)
+	})
+}
+
+func TestEvictAfterFailures(t *testing.T) {
+	tl := NewEmptyTestLog(t)
+	tl.Quiet()
+	tl.Config.EvictAfterFailures = 2
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(tl.Log.Metrics()...)
+	evictions := func() float64 {
+		t.Helper()
+		families, err := reg.Gather()
+		fatalIfErr(t, err)
+		for _, mf := range families {
+			if mf.GetName() == "sequencing_evicted_entries_total" {
+				return mf.GetMetric()[0].GetCounter().GetValue()
 			}
-			sequence(tileWidth - 2)
+		}
+		return 0
+	}
+	var attempts int
+	ctlog.FailLeaf(t, func(e *ctlog.PendingLogEntry) error {
+		if string(e.Certificate) == "poison" {
+			attempts++
+			return errors.New("poisoned leaf")
+		}
+		return nil
+	})
 
-			breakSeq()
-			addCertificateExpectFailure(t, tl)
-			addCertificateExpectFailure(t, tl)
-			addCertificateExpectFailure(t, tl)
-			sequence(3)
+	addCertificate(t, tl)
+	poison, _ := tl.Log.AddLeafToPool(&ctlog.PendingLogEntry{Certificate: []byte("poison")})
+	addCertificate(t, tl)
 
-			// Re-failing the same tile sizes.
-			addCertificateExpectFailure(t, tl)
-			addCertificateExpectFailure(t, tl)
-			addCertificateExpectFailure(t, tl)
-			sequence(3)
+	// The first failure is retried even without RoundRetries, since it's
+	// attributed to the poisoned leaf, which the second one evicts.
+	fatalIfErr(t, tl.Log.Sequence())
+	if n := evictions(); n != 0 {
+		t.Errorf("got %v evictions after one failure, expected 0", n)
+	}
+	fatalIfErr(t, tl.Log.Sequence())
+	if n := evictions(); n != 1 {
+		t.Errorf("got %v evictions, expected 1", n)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := poison(ctx); err == nil || !strings.Contains(err.Error(), "evicted") {
+		t.Errorf("expected an eviction error, got %v", err)
+	}
 
-			unbreakSeq()
+	// The rest of the pool is sequenced by the next round.
+	addCertificate(t, tl)
+	fatalIfErr(t, tl.Log.Sequence())
+	tl.CheckLog(3)
+	if attempts != 2 {
+		t.Errorf("poisoned leaf was added %d times, expected 2", attempts)
+	}
+}
 
-			// Succeeding with the same size that failed.
-			addCertificate(t, tl)
-			addCertificate(t, tl)
+func TestSequenceUploadsOnlyNewTiles(t *testing.T) {
+	tl := NewEmptyTestLog(t)
+	tl.Quiet()
+	mb := tl.Config.Backend.(*MemoryBackend)
+
+	// Round sizes around the boundaries of level -1 and 0 tiles, and of level
+	// 1 tiles if not in short mode.
+	rounds := []int{0, 1, tileWidth - 2, 0, 1, 1, 0, tileWidth - 1, 1, tileWidth, 0}
+	if !testing.Short() {
+		rounds = append(rounds, tileWidth*(tileWidth-4)-3, 1, 0, 1, tileWidth, 0)
+	}
+	for _, n := range rounds {
+		before := make(map[string]int)
+		for _, key := range mb.Keys() {
+			before[key] = mb.Uploads(key)
+		}
+		for range n {
 			addCertificate(t, tl)
-			sequence(3)
+		}
+		fatalIfErr(t, tl.Log.Sequence())
 
-			tl = NewEmptyTestLog(t)
-			expectedSize = 0
-			for range tileWidth - 2 {
+		// Tiles are immutable at a given path, since partial tile paths
+		// include their width, so a tile must be uploaded if and only if it's
+		// new, and only once.
+		for _, key := range mb.Keys() {
+			if !strings.HasPrefix(key, "tile/") {
+				continue
+			}
+			old, existed := before[key]
+			uploads := mb.Uploads(key) - old
+			switch {
+			case existed && uploads != 0:
+				t.Errorf("round of %d: existing tile %q uploaded %d times", n, key, uploads)
+			case !existed && uploads != 1:
+				t.Errorf("round of %d: new tile %q uploaded %d times", n, key, uploads)
+			}
+		}
+	}
+	tl.CheckLog(-1)
+}
+
+func TestEdgeDataTileNotMutated(t *testing.T) {
+	tl := NewEmptyTestLog(t)
+	tl.Quiet()
+	mb := tl.Config.Backend.(*MemoryBackend)
+
+	type published struct{ b, copy []byte }
+	var tiles []published
+	round := func(n int, fail bool) {
+		t.Helper()
+		for range n {
+			if fail {
+				addCertificateExpectFailure(t, tl)
+			} else {
 				addCertificate(t, tl)
 			}
-			sequence(tileWidth - 2)
+		}
+		if fail {
+			mb.UploadCallback = failStagingAndNotPersist
+		}
+		fatalIfErr(t, tl.Log.Sequence())
+		mb.UploadCallback = nil
+		b := tl.Log.EdgeDataTile()
+		tiles = append(tiles, published{b, bytes.Clone(b)})
+	}
+	// Grow a partial data tile in place, through a failed round that
+	// appends past it, up to and across the tile boundary.
+	for _, r := range []struct {
+		n    int
+		fail bool
+	}{{3, false}, {1, false}, {0, false}, {5, true}, {2, false},
+		{tileWidth - 8, false}, {3, false}, {1, true}, {1, false}} {
+		round(r.n, r.fail)
+	}
+	tl.CheckLog(tileWidth + 2)
 
-			breakSeq()
-			addCertificateExpectFailure(t, tl)
-			addCertificateExpectFailure(t, tl)
-			addCertificateExpectFailure(t, tl)
-			sequence(3)
+	for i, p := range tiles {
+		if !bytes.Equal(p.b, p.copy) {
+			t.Errorf("edge data tile published by round %d was modified", i)
+		}
+	}
+}
 
-			unbreakSeq()
+func TestSequencePipelinedTiles(t *testing.T) {
+	rounds := []int{5, tileWidth*3 + 10, 1000}
+	if !testing.Short() {
+		// Fill a level 1 tile, in a round that started in a partial one.
+		rounds = append(rounds, tileWidth*tileWidth)
+	}
+	// Data tiles include the round timestamps, so make them match.
+	start := time.Now().Add(time.Hour).UnixMilli()
+	sequence := func(sequential bool) *MemoryBackend {
+		if sequential {
+			ctlog.SequentialTiles(t)
+		}
+		tl := NewEmptyTestLog(t)
+		tl.Quiet()
+		clock, size := start, int64(0)
+		tl.Config.Clock = func() int64 { clock++; return clock }
+		for i, round := range rounds {
+			for j := range round {
+				tl.Log.AddLeafToPool(&ctlog.PendingLogEntry{
+					Certificate: fmt.Appendf(nil, "%d-%d", i, j)})
+			}
+			fatalIfErr(t, tl.Log.Sequence())
+			size += int64(round)
+		}
+		tl.CheckLog(size)
+		return tl.Config.Backend.(*MemoryBackend)
+	}
+	pipelined, sequential := sequence(false), sequence(true)
 
-			// Succeeding with a different set of tiles.
-			addCertificate(t, tl)
-			sequence(1)
+	var tiles int
+	for _, key := range sequential.Keys() {
+		if !strings.HasPrefix(key, "tile/") {
+			continue
+		}
+		tiles++
+		want, err := sequential.Fetch(context.Background(), key)
+		fatalIfErr(t, err)
+		got, err := pipelined.Fetch(context.Background(), key)
+		if err != nil {
+			t.Errorf("%s: %v", key, err)
+		} else if !bytes.Equal(got, want) {
+			t.Errorf("%s differs from the sequential implementation", key)
+		}
+	}
+	if tiles == 0 {
+		t.Fatal("no tiles were uploaded")
+	}
+	for _, key := range pipelined.Keys() {
+		if _, err := sequential.Fetch(context.Background(), key); strings.HasPrefix(key, "tile/") && err != nil {
+			t.Errorf("%s was not uploaded by the sequential implementation", key)
+		}
+	}
+}
+
+func BenchmarkSequenceLargeRound(b *testing.B) {
+	for _, sequential := range []bool{false, true} {
+		b.Run(fmt.Sprintf("sequential=%v", sequential), func(b *testing.B) {
+			if sequential {
+				ctlog.SequentialTiles(b)
+			}
+			tl := NewEmptyTestLog(b)
+			tl.Quiet()
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				for j := range 10000 {
+					tl.Log.AddLeafToPool(&ctlog.PendingLogEntry{
+						Certificate: fmt.Appendf(bytes.Repeat([]byte("A"), 2350), "%d-%d", i, j)})
+				}
+				b.StartTimer()
+				fatalIfErr(b, tl.Log.Sequence())
+			}
 		})
 	}
 }
 
-func BenchmarkSequencer(b *testing.B) {
+func BenchmarkSequenceOneLeaf(b *testing.B) {
 	tl := NewEmptyTestLog(b)
+	tl.Quiet()
+	// Fill 90% of a data tile with large precertificate-like entries.
+	for i := range tileWidth * 9 / 10 {
+		tl.Log.AddLeafToPool(&ctlog.PendingLogEntry{
+			Certificate: fmt.Appendf(bytes.Repeat([]byte("A"), 3000), "%d", i)})
+	}
+	fatalIfErr(b, tl.Log.Sequence())
+	b.ReportAllocs()
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		const poolSize = 3000
-		if i%poolSize == 0 && i != 0 {
+		tl.Log.AddLeafToPool(&ctlog.PendingLogEntry{
+			Certificate: fmt.Appendf(bytes.Repeat([]byte("B"), 3000), "%d", i)})
+		fatalIfErr(b, tl.Log.Sequence())
+		if i%(tileWidth/10) == tileWidth/10-1 {
+			// Go back to a 90%-full tile.
+			b.StopTimer()
+			for j := range tileWidth - tileWidth/10 {
+				tl.Log.AddLeafToPool(&ctlog.PendingLogEntry{
+					Certificate: fmt.Appendf(bytes.Repeat([]byte("C"), 3000), "%d-%d", i, j)})
+			}
 			fatalIfErr(b, tl.Log.Sequence())
+			b.StartTimer()
 		}
-		tl.Log.AddLeafToPool(&ctlog.PendingLogEntry{Certificate: bytes.Repeat([]byte("A"), 2350)})
 	}
 }
 
-var testLeaf, _ = base64.StdEncoding.DecodeString("MIIEJjCCAw6gAwIBAgISA9YVxv2Lcc/y6IhrW5svQmHPMA0GCSqGSIb3DQEBCwUAMDIxCzAJBgNVBAYTAlVTMRYwFAYDVQQKEw1MZXQncyBFbmNyeXB0MQswCQYDVQQDEwJSMzAeFw0yMzExMTUxMDE5MTFaFw0yNDAyMTMxMDE5MTBaMB0xGzAZBgNVBAMTEnJvbWUuY3QuZmlsaXBwby5pbzBZMBMGByqGSM49AgEGCCqGSM49AwEHA0IABMufQMpi+5cCSw8a6D2se6bjTR6Vpcm5kr5b1UHaJZVdM4tOCy66d3iO9LcKYwIdXJJD1TbtzAuLlRCWa1HNlGSjggIUMIICEDAOBgNVHQ8BAf8EBAMCB4AwHQYDVR0lBBYwFAYIKwYBBQUHAwEGCCsGAQUFBwMCMAwGA1UdEwEB/wQCMAAwHQYDVR0OBBYEFIiqDtb1Rz6Y9iVID4JBRl36tE47MB8GA1UdIwQYMBaAFBQusxe3WFbLrlAJQOYfr52LFMLGMFUGCCsGAQUFBwEBBEkwRzAhBggrBgEFBQcwAYYVaHR0cDovL3IzLm8ubGVuY3Iub3JnMCIGCCsGAQUFBzAChhZodHRwOi8vcjMuaS5sZW5jci5vcmcvMB0GA1UdEQQWMBSCEnJvbWUuY3QuZmlsaXBwby5pbzATBgNVHSAEDDAKMAgGBmeBDAECATCCAQQGCisGAQQB1nkCBAIEgfUEgfIA8AB2AEiw42vapkc0D+VqAvqdMOscUgHLVt0sgdm7v6s52IRzAAABi9K04WIAAAQDAEcwRQIhAIjFeq4LZpEUNCTtVu1s3yURyaX18TRp4qjt02A2FYHEAiBWQxxfEsyYUFuDOFIYSh6q6MA9m2YenRmL7FqzgpMvpAB2ADtTd3U+LbmAToswWwb+QDtn2E/D9Me9AA0tcm/h+tQXAAABi9K0418AAAQDAEcwRQIhAJfS1HrW24DPJJCzwZ+Xgo4jX/o6nsXNVRuOrrqoFjBmAiAi53R5tlmS94uXLnUyX6+ULDxwCuSRSb23iEidzugiVDANBgkqhkiG9w0BAQsFAAOCAQEAc0EXBRfCal3xyXZ60DJspRf66ulLpVii1BPvcf0PWWGC/MCjbY2xwz+1p6fePMSMrUJpOTtP5L52bZNQBptq6oKSOKGpVn8eIaVqNPeJsYCuzL5tKnzfhBoyIs9tqc8U7JwZuIyCIFsxd5eDNLSNyphX9+jxATorpFJ8RYibzjmBkDjRSl6T2f32Qy4AKy2FJe2yryJjdiDHqzT3SoTYcJp/2wWklYFMtBV/j4qTGyFiVdVZ1GQUhHvlw1iVqXLHe8cVQoSc+iStlDxeFWEuKnHRTtpfNz+KzP15R13C6CBswODDjqH2HCS2OKhyENB6SF7KhhD5/hMVyj6UWq9pDw==")
-var testPrecert, _ = base64.StdEncoding.DecodeString("MIIDMzCCAhugAwIBAgISA9YVxv2Lcc/y6IhrW5svQmHPMA0GCSqGSIb3DQEBCwUAMDIxCzAJBgNVBAYTAlVTMRYwFAYDVQQKEw1MZXQncyBFbmNyeXB0MQswCQYDVQQDEwJSMzAeFw0yMzExMTUxMDE5MTFaFw0yNDAyMTMxMDE5MTBaMB0xGzAZBgNVBAMTEnJvbWUuY3QuZmlsaXBwby5pbzBZMBMGByqGSM49AgEGCCqGSM49AwEHA0IABMufQMpi+5cCSw8a6D2se6bjTR6Vpcm5kr5b1UHaJZVdM4tOCy66d3iO9LcKYwIdXJJD1TbtzAuLlRCWa1HNlGSjggEhMIIBHTAOBgNVHQ8BAf8EBAMCB4AwHQYDVR0lBBYwFAYIKwYBBQUHAwEGCCsGAQUFBwMCMAwGA1UdEwEB/wQCMAAwHQYDVR0OBBYEFIiqDtb1Rz6Y9iVID4JBRl36tE47MB8GA1UdIwQYMBaAFBQusxe3WFbLrlAJQOYfr52LFMLGMFUGCCsGAQUFBwEBBEkwRzAhBggrBgEFBQcwAYYVaHR0cDovL3IzLm8ubGVuY3Iub3JnMCIGCCsGAQUFBzAChhZodHRwOi8vcjMuaS5sZW5jci5vcmcvMB0GA1UdEQQWMBSCEnJvbWUuY3QuZmlsaXBwby5pbzATBgNVHSAEDDAKMAgGBmeBDAECATATBgorBgEEAdZ5AgQDAQH/BAIFADANBgkqhkiG9w0BAQsFAAOCAQEAk4K63mYRtOqH2LprGfBDIXnOXGt7wicdyBD2Zh5tkqMBB0XulcAi94IUfEOBSfIIzZ5lTh8WvAB6RxMGXYf8Qx4dHCP1McpMvkOJNEz9cHVjoBxx8asdAsV6d+av3MsK83n/fnN6looyUoDz09AZNvmlR74HCmpgLydMMv8ugdiPjRlYLaKy8wiA+HpX2rb4oWJ9kSD7dxuu6+NqPi4qWVsopQKBMcYEhCfQN26tcm2X3jebcwE3TFNxhK5RcRTWMO3i5AtaUZDT4bWUTFTHP8668wvCpI8MyfIlVdlUv3BOnyjvr/zpSBb/SfbyE0yiUBKhxl5z3+LImTNwxbc5sg==")
-var testIntermediate, _ = base64.StdEncoding.DecodeString("MIIFFjCCAv6gAwIBAgIRAJErCErPDBinU/bWLiWnX1owDQYJKoZIhvcNAQELBQAwTzELMAkGA1UEBhMCVVMxKTAnBgNVBAoTIEludGVybmV0IFNlY3VyaXR5IFJlc2VhcmNoIEdyb3VwMRUwEwYDVQQDEwxJU1JHIFJvb3QgWDEwHhcNMjAwOTA0MDAwMDAwWhcNMjUwOTE1MTYwMDAwWjAyMQswCQYDVQQGEwJVUzEWMBQGA1UEChMNTGV0J3MgRW5jcnlwdDELMAkGA1UEAxMCUjMwggEiMA0GCSqGSIb3DQEBAQUAA4IBDwAwggEKAoIBAQC7AhUozPaglNMPEuyNVZLD+ILxmaZ6QoinXSaqtSu5xUyxr45r+XXIo9cPR5QUVTVXjJ6oojkZ9YI8QqlObvU7wy7bjcCwXPNZOOftz2nwWgsbvsCUJCWH+jdxsxPnHKzhm+/b5DtFUkWWqcFTzjTIUu61ru2P3mBw4qVUq7ZtDpelQDRrK9O8ZutmNHz6a4uPVymZ+DAXXbpyb/uBxa3Shlg9F8fnCbvxK/eG3MHacV3URuPMrSXBiLxgZ3Vms/EY96Jc5lP/Ooi2R6X/ExjqmAl3P51T+c8B5fWmcBcUr2Ok/5mzk53cU6cG/kiFHaFpriV1uxPMUgP17VGhi9sVAgMBAAGjggEIMIIBBDAOBgNVHQ8BAf8EBAMCAYYwHQYDVR0lBBYwFAYIKwYBBQUHAwIGCCsGAQUFBwMBMBIGA1UdEwEB/wQIMAYBAf8CAQAwHQYDVR0OBBYEFBQusxe3WFbLrlAJQOYfr52LFMLGMB8GA1UdIwQYMBaAFHm0WeZ7tuXkAXOACIjIGlj26ZtuMDIGCCsGAQUFBwEBBCYwJDAiBggrBgEFBQcwAoYWaHR0cDovL3gxLmkubGVuY3Iub3JnLzAnBgNVHR8EIDAeMBygGqAYhhZodHRwOi8veDEuYy5sZW5jci5vcmcvMCIGA1UdIAQbMBkwCAYGZ4EMAQIBMA0GCysGAQQBgt8TAQEBMA0GCSqGSIb3DQEBCwUAA4ICAQCFyk5HPqP3hUSFvNVneLKYY611TR6WPTNlclQtgaDqw+34IL9fzLdwALduO/ZelN7kIJ+m74uyA+eitRY8kc607TkC53wlikfmZW4/RvTZ8M6UK+5UzhK8jCdLuMGYL6KvzXGRSgi3yLgjewQtCPkIVz6D2QQzCkcheAmCJ8MqyJu5zlzyZMjAvnnAT45tRAxekrsu94sQ4egdRCnbWSDtY7kh+BImlJNXoB1lBMEKIq4QDUOXoRgffuDghje1WrG9ML+Hbisq/yFOGwXD9RiX8F6sw6W4avAuvDszue5L3sz85K+EC4Y/wFVDNvZo4TYXao6Z0f+lQKc0t8DQYzk1OXVu8rp2yJMC6alLbBfODALZvYH7n7do1AZls4I9d1P4jnkDrQoxB3UqQ9hVl3LEKQ73xF1OyK5GhDDX8oVfGKF5u+decIsH4YaTw7mP3GFxJSqv3+0lUFJoi5Lc5da149p90IdshCExroL1+7mryIkXPeFM5TgO9r0rvZaBFOvV2z0gp35Z0+L4WPlbuEjN/lxPFin+HlUjr8gRsI3qfJOQFy/9rKIJR0Y/8Omwt/8oTWgy1mdeHmmjk7j1nYsvC9JSQ6ZvMldlTTKB3zhThV1+XWYp6rjd5JW1zbVWEkLNxE7GJThEUG3szgBVGP7pSWTUTsqXnLRbwHOoq7hHwg==")
-var testRoot, _ = base64.StdEncoding.DecodeString("MIIFazCCA1OgAwIBAgIRAIIQz7DSQONZRGPgu2OCiwAwDQYJKoZIhvcNAQELBQAwTzELMAkGA1UEBhMCVVMxKTAnBgNVBAoTIEludGVybmV0IFNlY3VyaXR5IFJlc2VhcmNoIEdyb3VwMRUwEwYDVQQDEwxJU1JHIFJvb3QgWDEwHhcNMTUwNjA0MTEwNDM4WhcNMzUwNjA0MTEwNDM4WjBPMQswCQYDVQQGEwJVUzEpMCcGA1UEChMgSW50ZXJuZXQgU2VjdXJpdHkgUmVzZWFyY2ggR3JvdXAxFTATBgNVBAMTDElTUkcgUm9vdCBYMTCCAiIwDQYJKoZIhvcNAQEBBQADggIPADCCAgoCggIBAK3oJHP0FDfzm54rVygch77ct984kIxuPOZXoHj3dcKi/vVqbvYATyjb3miGbESTtrFj/RQSa78f0uoxmyF+0TM8ukj13Xnfs7j/EvEhmkvBioZxaUpmZmyPfjxwv60pIgbz5MDmgK7iS4+3mX6UA5/TR5d8mUgjU+g4rk8Kb4Mu0UlXjIB0ttov0DiNewNwIRt18jA8+o+u3dpjq+sWT8KOEUt+zwvo/7V3LvSye0rgTBIlDHCNAymg4VMk7BPZ7hm/ELNKjD+Jo2FR3qyHB5T0Y3HsLuJvW5iB4YlcNHlsdu87kGJ55tukmi8mxdAQ4Q7e2RCOFvu396j3x+UCB5iPNgiV5+I3lg02dZ77DnKxHZu8A/lJBdiB3QW0KtZB6awBdpUKD9jf1b0SHzUvKBds0pjBqAlkd25HN7rOrFleaJ1/ctaJxQZBKT5ZPt0m9STJEadao0xAH0ahmbWnOlFuhjuefXKnEgV4We0+UXgVCwOPjdAvBbI+e0ocS3MFEvzG6uBQE3xDk3SzynTnjh8BCNAw1FtxNrQHusEwMFxIt4I7mKZ9YIqioymCzLq9gwQbooMDQaHWBfEbwrbwqHyGO0aoSCqI3Haadr8faqU9GY/rOPNk3sgrDQoo//fb4hVC1CLQJ13hef4Y53CIrU7m2Ys6xt0nUW7/vGT1M0NPAgMBAAGjQjBAMA4GA1UdDwEB/wQEAwIBBjAPBgNVHRMBAf8EBTADAQH/MB0GA1UdDgQWBBR5tFnme7bl5AFzgAiIyBpY9umbbjANBgkqhkiG9w0BAQsFAAOCAgEAVR9YqbyyqFDQDLHYGmkgJykIrGF1XIpu+ILlaS/V9lZLubhzEFnTIZd+50xx+7LSYK05qAvqFyFWhfFQDlnrzuBZ6brJFe+GnY+EgPbk6ZGQ3BebYhtF8GaV0nxvwuo77x/Py9auJ/GpsMiu/X1+mvoiBOv/2X/qkSsisRcOj/KKNFtY2PwByVS5uCbMiogziUwthDyC3+6WVwW6LLv3xLfHTjuCvjHIInNzktHCgKQ5ORAzI4JMPJ+GslWYHb4phowim57iaztXOoJwTdwJx4nLCgdNbOhdjsnvzqvHu7UrTkXWStAmzOVyyghqpZXjFaH3pO3JLF+l+/+sKAIuvtd7u+Nxe5AW0wdeRlN8NwdCjNPElpzVmbUq4JUagEiuTDkHzsxHpFKVK7q4+63SM1N95R1NbdWhscdCb+ZAJzVcoyi3B43njTOQ5yOf+1CceWxG1bQVs5ZufpsMljq4Ui0/1lvh+wjChP4kqKOJ2qxq4RgqsahDYVvTH9w7jXbyLeiNdd8XM2w9U/t7y0Ff/9yi0GE44Za4rF2LN9d11TPAmRGunUHBcnWEvgJBQl9nJEiU0Zsnvgc/ubhPgXRR4Xq37Z0j4r7g1SgEEzwxA57demyPxgcYxn/eR44/KJ4EBs+lVDR3veyJm+kXQ99b21/+jh5Xos1AnX5iItreGCc=")
+func TestMirror(t *testing.T) {
+	tl := NewEmptyTestLog(t)
+	tl.Quiet()
+	for range tileWidth + 5 {
+		addCertificate(t, tl)
+	}
+	fatalIfErr(t, tl.Log.Sequence())
+	mb := tl.Config.Backend.(*MemoryBackend)
+
+	m, err := ctlog.LoadMirror(context.Background(), tl.Config.Name, tl.Config.Key.Public(), mb,
+		&ctlog.MirrorOptions{Log: tl.Config.Log})
+	fatalIfErr(t, err)
+	check := func(t *testing.T) {
+		t.Helper()
+		if m.TreeSize() != tl.Log.TreeSize() || m.RootHash() != tl.Log.RootHash() {
+			t.Fatalf("mirror has size %d and root %v, log has %d and %v",
+				m.TreeSize(), m.RootHash(), tl.Log.TreeSize(), tl.Log.RootHash())
+		}
+		if !m.CheckpointTime().Equal(tl.Log.CheckpointTime()) {
+			t.Errorf("mirror checkpoint time %v, log has %v", m.CheckpointTime(), tl.Log.CheckpointTime())
+		}
+		n := m.TreeSize()
+		entries, err := m.Entries(context.Background(), 0, n)
+		fatalIfErr(t, err)
+		if int64(len(entries)) != n {
+			t.Fatalf("got %d entries, expected %d", len(entries), n)
+		}
+		for _, i := range []int64{0, tileWidth - 1, n - 1} {
+			proof, err := m.InclusionProof(context.Background(), i, n)
+			fatalIfErr(t, err)
+			leaf := tlog.RecordHash(entries[i].MerkleTreeLeaf())
+			if err := tlog.CheckRecord(proof, n, m.RootHash(), i, leaf); err != nil {
+				t.Errorf("inclusion proof for %d doesn't verify: %v", i, err)
+			}
+		}
+		edge, err := m.ReadTile(context.Background(), tlog.TileForIndex(sunlight.TileHeight, tlog.StoredHashIndex(0, n-1)))
+		fatalIfErr(t, err)
+		if len(edge) != int(n%tileWidth)*tlog.HashSize && len(edge) != tileWidth*tlog.HashSize {
+			t.Errorf("got %d bytes for the edge tile", len(edge))
+		}
+	}
+	check(t)
+
+	// Refresh follows the growth of the log.
+	oldSize, oldRoot := m.TreeSize(), m.RootHash()
+	for range 10 {
+		addCertificate(t, tl)
+	}
+	fatalIfErr(t, tl.Log.Sequence())
+	fatalIfErr(t, m.Refresh(context.Background()))
+	check(t)
+	proof, err := m.ConsistencyProof(context.Background(), oldSize, m.TreeSize())
+	fatalIfErr(t, err)
+	if err := tlog.CheckTree(proof, m.TreeSize(), m.RootHash(), oldSize, oldRoot); err != nil {
+		t.Errorf("consistency proof doesn't verify: %v", err)
+	}
+
+	// Run refreshes periodically.
+	ctx, cancel := context.WithCancel(context.Background())
+	runErr := make(chan error, 1)
+	go func() { runErr <- m.Run(ctx, 10*time.Millisecond) }()
+	addCertificate(t, tl)
+	fatalIfErr(t, tl.Log.Sequence())
+	for deadline := time.Now().Add(5 * time.Second); m.TreeSize() != tl.Log.TreeSize(); {
+		if time.Now().After(deadline) {
+			t.Fatal("mirror didn't follow the log")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	if err := <-runErr; err != context.Canceled {
+		t.Errorf("Run returned %v", err)
+	}
+	check(t)
+
+	// Concurrent refreshes don't go backwards while the log grows.
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var last int64
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if err := m.Refresh(context.Background()); err != nil {
+					t.Errorf("concurrent Refresh: %v", err)
+					return
+				}
+				if size := m.TreeSize(); size < last {
+					t.Errorf("mirror went from size %d to %d", last, size)
+				} else {
+					last = size
+				}
+			}
+		}()
+	}
+	for range 5 {
+		addCertificate(t, tl)
+		fatalIfErr(t, tl.Log.Sequence())
+	}
+	close(stop)
+	wg.Wait()
+	fatalIfErr(t, m.Refresh(context.Background()))
+	check(t)
+
+	t.Run("WrongKey", func(t *testing.T) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		fatalIfErr(t, err)
+		if _, err := ctlog.LoadMirror(context.Background(), tl.Config.Name, key.Public(), mb, nil); err == nil {
+			t.Error("expected loading with the wrong key to fail")
+		}
+	})
+	t.Run("Rollback", func(t *testing.T) {
+		snapshot := mb.Snapshot()
+		m, err := ctlog.LoadMirror(context.Background(), tl.Config.Name, tl.Config.Key.Public(), mb, nil)
+		fatalIfErr(t, err)
+		addCertificate(t, tl)
+		fatalIfErr(t, tl.Log.Sequence())
+		fatalIfErr(t, m.Refresh(context.Background()))
+
+		size := m.TreeSize()
+		mb.Restore(snapshot)
+		if err := m.Refresh(context.Background()); !errors.Is(err, ctlog.ErrInconsistentCheckpoint) {
+			t.Errorf("got %v, expected ErrInconsistentCheckpoint", err)
+		}
+		if m.TreeSize() != size {
+			t.Errorf("mirror rolled back to size %d", m.TreeSize())
+		}
+	})
+	t.Run("Fork", func(t *testing.T) {
+		// A different tree, signed with the same key, replaces the log.
+		other := *tl.Config
+		ob := NewMemoryBackend(t)
+		other.Backend = ob
+		other.Lock = NewMemoryLockBackend(t)
+		other.Cache = filepath.Join(t.TempDir(), "cache.db")
+		fatalIfErr(t, ctlog.CreateLog(context.Background(), &other))
+		fork := ReloadLog(t, &TestLog{Config: &other})
+		for range tl.Log.TreeSize() + 3 {
+			addCertificate(t, fork)
+		}
+		fatalIfErr(t, fork.Log.Sequence())
+
+		m, err := ctlog.LoadMirror(context.Background(), tl.Config.Name, tl.Config.Key.Public(), mb, nil)
+		fatalIfErr(t, err)
+		mb.Restore(ob.Snapshot())
+		if err := m.Refresh(context.Background()); !errors.Is(err, ctlog.ErrInconsistentCheckpoint) {
+			t.Errorf("got %v, expected ErrInconsistentCheckpoint", err)
+		}
+	})
+}
@@ -0,0 +1,36 @@
+// Package ctlogtest provides helpers for tests of code that uses ctlog.
+package ctlogtest
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// ManualClock is a clock that only moves when Set or Advance are called, for
+// use as ctlog.Config.Clock through its Now method. It's safe for concurrent
+// use.
+type ManualClock struct {
+	ms atomic.Int64
+}
+
+// NewManualClock returns a ManualClock set to t.
+func NewManualClock(t time.Time) *ManualClock {
+	c := &ManualClock{}
+	c.Set(t)
+	return c
+}
+
+// Now returns the current time of the clock, in milliseconds since the epoch.
+func (c *ManualClock) Now() int64 {
+	return c.ms.Load()
+}
+
+// Set sets the clock to t, which can be before the current time.
+func (c *ManualClock) Set(t time.Time) {
+	c.ms.Store(t.UnixMilli())
+}
+
+// Advance moves the clock forward by d, or backward if d is negative.
+func (c *ManualClock) Advance(d time.Duration) {
+	c.ms.Add(d.Milliseconds())
+}
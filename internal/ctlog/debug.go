@@ -0,0 +1,49 @@
+package ctlog
+
+import (
+	"encoding/base64"
+	"slices"
+)
+
+// DebugState is a summary of the in-memory state of a Log, for debugging.
+type DebugState struct {
+	TreeSize  int64
+	Timestamp int64 // milliseconds since the epoch
+	RootHash  string
+
+	// EdgeTiles are the paths of the right-most tile of each level.
+	EdgeTiles []string
+
+	// PoolSize is the number of entries waiting to be sequenced, and
+	// InSequencing is the number of entries in the pool being sequenced.
+	PoolSize     int
+	InSequencing int
+
+	// Paused is true if sequencing is paused by Log.Pause.
+	Paused bool
+}
+
+// DebugState returns a summary of the current state of the log.
+//
+// The tree is read from the last published snapshot rather than from the
+// fields owned by the sequencer, so this is safe to call concurrently with
+// RunSequencer.
+func (l *Log) DebugState() DebugState {
+	state := l.state.Load()
+	ds := DebugState{
+		TreeSize:  state.tree.N,
+		Timestamp: state.tree.Time,
+		RootHash:  base64.StdEncoding.EncodeToString(state.tree.Hash[:]),
+	}
+	for _, t := range state.edgeTiles {
+		ds.EdgeTiles = append(ds.EdgeTiles, t.Path())
+	}
+	slices.Sort(ds.EdgeTiles)
+
+	l.poolMu.Lock()
+	defer l.poolMu.Unlock()
+	ds.PoolSize = len(l.currentPool.pendingLeaves)
+	ds.InSequencing = len(l.inSequencing)
+	ds.Paused = l.paused.Load()
+	return ds
+}
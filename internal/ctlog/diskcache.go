@@ -0,0 +1,253 @@
+package ctlog
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DiskCacheBackend is a Backend that keeps full tiles fetched from another
+// Backend in a local directory, up to a total size in bytes, so that they
+// survive restarts.
+//
+// Each file starts with the SHA-256 hash of the tile, and files that don't
+// match it, for example because they were truncated, are discarded and
+// fetched again. Like TileCacheBackend, all other keys bypass the cache.
+type DiskCacheBackend struct {
+	b        Backend
+	dir      string
+	maxBytes int64
+	bytes    atomic.Int64
+	// index tracks the size of the cached files in LRU order. Evicting an
+	// entry removes the file.
+	index   *lru.Cache[string, int64]
+	indexMu sync.Mutex // serializes size accounting in write
+	fetches *prometheus.CounterVec
+	log     *slog.Logger
+}
+
+func NewDiskCacheBackend(b Backend, dir string, maxBytes int64, l *slog.Logger) (*DiskCacheBackend, error) {
+	d := &DiskCacheBackend{b: b, dir: dir, maxBytes: maxBytes, log: l}
+	index, err := lru.NewWithEvict(math.MaxInt, func(key string, size int64) {
+		d.bytes.Add(-size)
+		if err := os.Remove(d.path(key)); err != nil && !os.IsNotExist(err) {
+			d.log.Warn("failed to remove evicted tile from disk cache", "key", key, "err", err)
+		}
+	})
+	if err != nil {
+		panic(err) // only returned for a non-positive size
+	}
+	d.index = index
+	d.fetches = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "disk_cache_fetches_total",
+			Help: "Fetches of full tiles through the disk cache, by result (hit, miss, or corrupt).",
+		},
+		[]string{"result"},
+	)
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create disk cache directory: %w", err)
+	}
+
+	// Index the existing files, oldest first, so they are evicted first.
+	type file struct {
+		key     string
+		size    int64
+		modTime time.Time
+	}
+	var files []file
+	err = filepath.WalkDir(dir, func(path string, e fs.DirEntry, err error) error {
+		if err != nil || e.IsDir() {
+			return err
+		}
+		if filepath.Base(path)[0] == '.' {
+			// Leftover temporary file from an interrupted write.
+			return os.Remove(path)
+		}
+		info, err := e.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, file{filepath.ToSlash(rel), info.Size(), info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to index disk cache directory: %w", err)
+	}
+	slices.SortFunc(files, func(a, b file) int { return a.modTime.Compare(b.modTime) })
+	for _, f := range files {
+		d.bytes.Add(f.size)
+		d.index.Add(f.key, f.size)
+	}
+	d.evict()
+	l.Info("loaded disk cache", "dir", dir, "files", d.index.Len(), "bytes", d.bytes.Load())
+
+	return d, nil
+}
+
+var _ ListDeleteBackend = &DiskCacheBackend{}
+var _ PingBackend = &DiskCacheBackend{}
+var _ RangeBackend = &DiskCacheBackend{}
+var _ CreateOnlyBackend = &DiskCacheBackend{}
+var _ BatchBackend = &DiskCacheBackend{}
+
+func (d *DiskCacheBackend) path(key string) string {
+	return filepath.Join(d.dir, filepath.FromSlash(key))
+}
+
+func (d *DiskCacheBackend) Upload(ctx context.Context, key string, data []byte, opts *UploadOptions) error {
+	return d.b.Upload(ctx, key, data, opts)
+}
+
+func (d *DiskCacheBackend) BatchUpload(ctx context.Context, objects []Object) error {
+	return batchUpload(ctx, d.b, objects)
+}
+
+func (d *DiskCacheBackend) Fetch(ctx context.Context, key string) ([]byte, error) {
+	if !isImmutableTile(key) || !fs.ValidPath(key) {
+		return d.b.Fetch(ctx, key)
+	}
+	if _, ok := d.index.Get(key); ok {
+		if tile, ok := d.read(key); ok {
+			d.fetches.WithLabelValues("hit").Inc()
+			return tile, nil
+		}
+		d.log.WarnContext(ctx, "discarding corrupt tile from disk cache", "key", key)
+		d.fetches.WithLabelValues("corrupt").Inc()
+		d.index.Remove(key)
+	} else {
+		d.fetches.WithLabelValues("miss").Inc()
+	}
+	tile, err := d.b.Fetch(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.write(key, tile); err != nil {
+		d.log.WarnContext(ctx, "failed to write tile to disk cache", "key", key, "err", err)
+	}
+	return tile, nil
+}
+
+// FetchRange serves the rest of the tile from offset from the cache, if the
+// tile is cached, and otherwise fetches only the range, without caching it.
+func (d *DiskCacheBackend) FetchRange(ctx context.Context, key string, offset, length int64) ([]byte, error) {
+	if _, ok := d.index.Get(key); ok && isImmutableTile(key) && fs.ValidPath(key) {
+		if tile, ok := d.read(key); ok {
+			d.fetches.WithLabelValues("hit").Inc()
+			return sliceFrom(tile, offset), nil
+		}
+	}
+	return fetchRange(ctx, d.b, key, offset, length)
+}
+
+func (d *DiskCacheBackend) SupportsRanges(key string) bool {
+	return supportsRanges(d.b, key)
+}
+
+// read returns the contents of the cached file for key, and false if it's
+// missing or doesn't match its hash.
+func (d *DiskCacheBackend) read(key string) ([]byte, bool) {
+	data, err := os.ReadFile(d.path(key))
+	if err != nil || len(data) < sha256.Size {
+		return nil, false
+	}
+	h, tile := data[:sha256.Size], data[sha256.Size:]
+	if sum := sha256.Sum256(tile); !bytes.Equal(h, sum[:]) {
+		return nil, false
+	}
+	return tile, true
+}
+
+func (d *DiskCacheBackend) write(key string, tile []byte) error {
+	size := int64(sha256.Size + len(tile))
+	if size > d.maxBytes {
+		return nil
+	}
+	path := d.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), ".tile-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name()) // no-op after a successful rename
+	sum := sha256.Sum256(tile)
+	if _, err := f.Write(append(sum[:], tile...)); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return err
+	}
+	d.indexMu.Lock()
+	defer d.indexMu.Unlock()
+	if old, ok := d.index.Peek(key); ok {
+		d.bytes.Add(-old)
+	}
+	d.bytes.Add(size)
+	d.index.Add(key, size)
+	d.evict()
+	return nil
+}
+
+func (d *DiskCacheBackend) evict() {
+	for d.bytes.Load() > d.maxBytes {
+		if _, _, ok := d.index.RemoveOldest(); !ok {
+			break
+		}
+	}
+}
+
+func (d *DiskCacheBackend) List(ctx context.Context, prefix string) ([]string, error) {
+	return listObjects(ctx, d.b, prefix)
+}
+
+func (d *DiskCacheBackend) Delete(ctx context.Context, key string) error {
+	d.index.Remove(key)
+	return deleteObject(ctx, d.b, key)
+}
+
+func (d *DiskCacheBackend) Ping(ctx context.Context) error {
+	return pingBackend(ctx, d.b)
+}
+
+func (d *DiskCacheBackend) SupportsCreateOnly() bool {
+	return supportsCreateOnly(d.b)
+}
+
+// Invalidate removes keys from the cache, for example because they failed
+// verification, and from the underlying Backend's cache, if any.
+func (d *DiskCacheBackend) Invalidate(keys ...string) {
+	for _, key := range keys {
+		d.index.Remove(key)
+	}
+	if c, ok := d.b.(cacheInvalidator); ok {
+		c.Invalidate(keys...)
+	}
+}
+
+func (d *DiskCacheBackend) Metrics() []prometheus.Collector {
+	return append([]prometheus.Collector{d.fetches}, d.b.Metrics()...)
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"log/slog"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -128,6 +129,108 @@ func (b *DynamoDBBackend) Create(ctx context.Context, logID [sha256.Size]byte, n
 	return err
 }
 
+var _ LeaseBackend = &DynamoDBBackend{}
+
+// leaseItemKey returns the key of the lease item for logID, which is stored in
+// the same table as the checkpoints.
+func leaseItemKey(logID [sha256.Size]byte) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"logID": &types.AttributeValueMemberB{Value: append([]byte("lease:"), logID[:]...)},
+	}
+}
+
+func dynamoDBNumber(n int64) *types.AttributeValueMemberN {
+	return &types.AttributeValueMemberN{Value: strconv.FormatInt(n, 10)}
+}
+
+func (b *DynamoDBBackend) Acquire(ctx context.Context, logID [sha256.Size]byte, holder string, ttl time.Duration) (*Lease, error) {
+	now := time.Now()
+	expiry := now.Add(ttl)
+	resp, err := b.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(b.table),
+		Key:                 leaseItemKey(logID),
+		ConditionExpression: aws.String("attribute_not_exists(logID) OR expiry < :now OR holder = :holder"),
+		UpdateExpression:    aws.String("SET holder = :holder, expiry = :expiry ADD #token :one"),
+		ExpressionAttributeNames: map[string]string{
+			"#token": "token",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":now":    dynamoDBNumber(now.UnixMilli()),
+			":holder": &types.AttributeValueMemberS{Value: holder},
+			":expiry": dynamoDBNumber(expiry.UnixMilli()),
+			":one":    dynamoDBNumber(1),
+		},
+		ReturnValues: types.ReturnValueUpdatedNew,
+	})
+	if condErr := (*types.ConditionalCheckFailedException)(nil); errors.As(err, &condErr) {
+		return nil, fmt.Errorf("%w: %w", ErrLeaseHeld, err)
+	}
+	if err != nil {
+		return nil, fmtErrorf("failed to acquire DynamoDB lease: %w", err)
+	}
+	token, ok := resp.Attributes["token"].(*types.AttributeValueMemberN)
+	if !ok {
+		return nil, fmtErrorf("DynamoDB lease is missing the token")
+	}
+	t, err := strconv.ParseInt(token.Value, 10, 64)
+	if err != nil {
+		return nil, fmtErrorf("invalid DynamoDB lease token: %w", err)
+	}
+	return &Lease{LogID: logID, Holder: holder, Token: t, Expiry: expiry}, nil
+}
+
+func (b *DynamoDBBackend) Renew(ctx context.Context, lease *Lease, ttl time.Duration) (*Lease, error) {
+	expiry := time.Now().Add(ttl)
+	_, err := b.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(b.table),
+		Key:                 leaseItemKey(lease.LogID),
+		ConditionExpression: aws.String("#token = :token AND holder = :holder"),
+		UpdateExpression:    aws.String("SET expiry = :expiry"),
+		ExpressionAttributeNames: map[string]string{
+			"#token": "token",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":token":  dynamoDBNumber(lease.Token),
+			":holder": &types.AttributeValueMemberS{Value: lease.Holder},
+			":expiry": dynamoDBNumber(expiry.UnixMilli()),
+		},
+	})
+	if condErr := (*types.ConditionalCheckFailedException)(nil); errors.As(err, &condErr) {
+		return nil, fmt.Errorf("%w: %w", ErrLeaseLost, err)
+	}
+	if err != nil {
+		return nil, fmtErrorf("failed to renew DynamoDB lease: %w", err)
+	}
+	l := *lease
+	l.Expiry = expiry
+	return &l, nil
+}
+
+func (b *DynamoDBBackend) Release(ctx context.Context, lease *Lease) error {
+	_, err := b.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(b.table),
+		Key:                 leaseItemKey(lease.LogID),
+		ConditionExpression: aws.String("#token = :token AND holder = :holder"),
+		UpdateExpression:    aws.String("SET expiry = :zero"),
+		ExpressionAttributeNames: map[string]string{
+			"#token": "token",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":token":  dynamoDBNumber(lease.Token),
+			":holder": &types.AttributeValueMemberS{Value: lease.Holder},
+			":zero":   dynamoDBNumber(0),
+		},
+	})
+	if condErr := (*types.ConditionalCheckFailedException)(nil); errors.As(err, &condErr) {
+		// The lease was already acquired by another holder.
+		return nil
+	}
+	if err != nil {
+		return fmtErrorf("failed to release DynamoDB lease: %w", err)
+	}
+	return nil
+}
+
 func (b *DynamoDBBackend) Metrics() []prometheus.Collector {
 	return b.metrics
 }
@@ -0,0 +1,147 @@
+package ctlog
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/google/certificate-transparency-go/x509"
+)
+
+// Reasons are machine-readable error codes, served in the "error_code" field
+// of JSON error responses. They are stable, so that clients can alert on them.
+const (
+	reasonBodyTooLarge     = "body.too_large"
+	reasonMalformed        = "request.malformed"
+	reasonBadParameter     = "request.bad_parameter"
+	reasonChainEmpty       = "chain.empty"
+	reasonChainTooLong     = "chain.too_long"
+	reasonChainTooLarge    = "chain.too_large"
+	reasonChainInvalid     = "chain.invalid"
+	reasonChainExpired     = "chain.expired"
+	reasonCertTooLarge     = "cert.too_large"
+	reasonCertParse        = "cert.parse_error"
+	reasonCertNotAfter     = "cert.not_after_out_of_range"
+	reasonCertExpired      = "cert.expired"
+	reasonCertNotYetValid  = "cert.not_yet_valid"
+	reasonCertWrongType    = "cert.wrong_endpoint"
+	reasonPrecertInvalid   = "precert.invalid"
+	reasonPrecertIssuer    = "precert.missing_issuer"
+	reasonSignatureInvalid = "chain.bad_signature"
+	reasonRootUnknown      = "root.unknown"
+	reasonPolicy           = "policy.rejected"
+	reasonNotFound         = "entry.not_found"
+	reasonPoolFull         = "pool.full"
+	reasonRateLimited      = "rate.limited"
+	reasonIssuerQuota      = "issuer.quota_exceeded"
+	reasonShuttingDown     = "server.shutting_down"
+	reasonPaused           = "server.paused"
+	reasonInternal         = "internal"
+)
+
+type reasonError struct {
+	reason string
+	err    error
+}
+
+func (e reasonError) Error() string { return e.err.Error() }
+func (e reasonError) Unwrap() error { return e.err }
+
+// withReason annotates err with a reason to be served to the client.
+func withReason(reason string, err error) error {
+	return reasonError{reason: reason, err: err}
+}
+
+// errorReason returns the reason err was annotated with by withReason, or a
+// generic one based on the HTTP status code.
+func errorReason(err error, code int) string {
+	var reasonErr reasonError
+	if errors.As(err, &reasonErr) {
+		return reasonErr.reason
+	}
+	if code >= 500 {
+		return reasonInternal
+	}
+	return reasonMalformed
+}
+
+// maxDetailLength is the maximum length of each string field of certDetails.
+const maxDetailLength = 256
+
+// certDetails identifies the certificate of a chain that caused it to be
+// rejected. It never includes the raw certificate.
+type certDetails struct {
+	Index   int    `json:"certificate_index"`
+	Subject string `json:"subject,omitempty"`
+	Serial  string `json:"serial,omitempty"`
+}
+
+func (d *certDetails) LogValue() slog.Value {
+	return slog.GroupValue(slog.Int("certificate_index", d.Index),
+		slog.String("subject", d.Subject), slog.String("serial", d.Serial))
+}
+
+type detailsError struct {
+	details *certDetails
+	err     error
+}
+
+func (e detailsError) Error() string { return e.err.Error() }
+func (e detailsError) Unwrap() error { return e.err }
+
+// withCertDetails annotates err with the index of the certificate in the
+// chain that caused it, and if cert is not nil, its subject and serial.
+func withCertDetails(index int, cert *x509.Certificate, err error) error {
+	d := &certDetails{Index: index}
+	if cert != nil {
+		d.Subject = truncate(cert.Subject.String(), maxDetailLength)
+		if cert.SerialNumber != nil {
+			d.Serial = truncate(cert.SerialNumber.Text(16), maxDetailLength)
+		}
+	}
+	return detailsError{details: d, err: err}
+}
+
+// errorDetails returns the details err was annotated with by withCertDetails,
+// or nil.
+func errorDetails(err error) *certDetails {
+	var detailsErr detailsError
+	if errors.As(err, &detailsErr) {
+		return detailsErr.details
+	}
+	return nil
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return strings.ToValidUTF8(s[:n], "")
+}
+
+// writeError serves a JSON error response like
+//
+//	{"error_code": "chain.too_long", "error_message": "chain has 12 certificates, the limit is 10"}
+//
+// Like http.Error, it doesn't otherwise modify rw, and the caller should not
+// write to it further.
+func writeError(rw http.ResponseWriter, code int, reason, message string) {
+	writeErrorDetails(rw, code, reason, message, nil)
+}
+
+// writeErrorDetails is like writeError, but also serves details, if not nil,
+// in the "details" field.
+func writeErrorDetails(rw http.ResponseWriter, code int, reason, message string, details *certDetails) {
+	h := rw.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Type", "application/json")
+	h.Set("X-Content-Type-Options", "nosniff")
+	rw.WriteHeader(code)
+	json.NewEncoder(rw).Encode(struct {
+		Code    string       `json:"error_code"`
+		Message string       `json:"error_message"`
+		Details *certDetails `json:"details,omitempty"`
+	}{reason, message, details})
+}
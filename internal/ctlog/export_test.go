@@ -2,8 +2,11 @@ package ctlog
 
 import (
 	"context"
+	"testing"
 
 	"filippo.io/sunlight"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"golang.org/x/mod/sumdb/tlog"
 )
 
 func (l *Log) AddLeafToPool(e *PendingLogEntry) (waitEntryFunc, string) {
@@ -14,14 +17,38 @@ func (l *Log) Sequence() error {
 	return l.sequence(context.Background())
 }
 
+// ReadHashes reads hashes from the current tree, with rangeHashReader if
+// ranged is true, and otherwise with stateHashReader.
+func (l *Log) ReadHashes(indexes []int64, ranged bool) ([]tlog.Hash, error) {
+	state := l.state.Load()
+	if ranged {
+		return l.rangeHashReader(context.Background(), state)(indexes)
+	}
+	return l.stateHashReader(context.Background(), state).ReadHashes(indexes)
+}
+
+// EdgeDataTile returns the right-most data tile of the published state.
+func (l *Log) EdgeDataTile() []byte {
+	return l.state.Load().edgeTiles[-1].B
+}
+
 func (e *PendingLogEntry) AsLogEntry(idx, timestamp int64) *sunlight.LogEntry {
 	return e.asLogEntry(idx, timestamp)
 }
 
-func SetTimeNowUnixMilli(f func() int64) {
-	timeNowUnixMilli = f
+func (e *PendingLogEntry) EncodedSize() int {
+	return e.encodedSize()
 }
 
+var (
+	CheckIssuerSignatures = checkIssuerSignatures
+	ParseSubmission       = parseSubmission
+	OrderChain            = orderChain
+	SequencerDelay        = sequencerDelay
+	RoundTimestamp        = roundTimestamp
+	ErrFatal              = errFatal
+)
+
 var seqRunning chan struct{}
 
 func PauseSequencer() {
@@ -34,3 +61,38 @@ func PauseSequencer() {
 func ResumeSequencer() {
 	close(seqRunning)
 }
+
+// FailLeaf makes rounds fail when adding an entry for which fail returns an
+// error, until the test ends.
+func FailLeaf(t testing.TB, fail func(*PendingLogEntry) error) {
+	testingOnlyFailLeaf = fail
+	t.Cleanup(func() { testingOnlyFailLeaf = nil })
+}
+
+// FailCachePut makes cachePut fail if fail returns an error, until the test
+// ends.
+func FailCachePut(t testing.TB, fail func() error) {
+	testingOnlyFailCachePut = fail
+	t.Cleanup(func() { testingOnlyFailCachePut = nil })
+}
+
+// SequentialTiles makes sequencing read all hash tiles after hashing the whole
+// round, until the test ends.
+func SequentialTiles(t testing.TB) {
+	testingOnlySequentialTiles = true
+	t.Cleanup(func() { testingOnlySequentialTiles = false })
+}
+
+// SignLogConfig signs text as the log configuration of c, so that tests can
+// store configurations that CreateLog wouldn't produce.
+func SignLogConfig(c *Config, text string) ([]byte, error) {
+	return signLogConfig(c, text)
+}
+
+// UsePathStyle makes the S3 client address the bucket in the path rather than
+// in the hostname, so it can be pointed at a local test server.
+func (s *S3Backend) UsePathStyle() {
+	o := s.client.Options()
+	o.UsePathStyle = true
+	s.client = s3.New(o)
+}
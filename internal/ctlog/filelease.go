@@ -0,0 +1,144 @@
+//go:build unix
+
+package ctlog
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// FileLeaseBackend is a LeaseBackend based on flock(2) locks on files in a
+// local directory, for instances that share a host or a filesystem with
+// reliable flock support.
+//
+// The lock is held until it's released or the process exits, so the lease
+// never expires while the holder is alive, regardless of its Expiry.
+type FileLeaseBackend struct {
+	dir string
+
+	mu    sync.Mutex
+	files map[[sha256.Size]byte]*os.File
+}
+
+func NewFileLeaseBackend(dir string) (*FileLeaseBackend, error) {
+	if fi, err := os.Stat(dir); err != nil {
+		return nil, fmt.Errorf("failed to stat lease directory: %w", err)
+	} else if !fi.IsDir() {
+		return nil, fmt.Errorf("lease path %q is not a directory", dir)
+	}
+	return &FileLeaseBackend{dir: dir, files: make(map[[sha256.Size]byte]*os.File)}, nil
+}
+
+var _ LeaseBackend = &FileLeaseBackend{}
+
+func (b *FileLeaseBackend) path(logID [sha256.Size]byte) string {
+	return filepath.Join(b.dir, hex.EncodeToString(logID[:])+".lease")
+}
+
+func (b *FileLeaseBackend) Acquire(ctx context.Context, logID [sha256.Size]byte, holder string, ttl time.Duration) (*Lease, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	f, err := os.OpenFile(b.path(logID), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmtErrorf("failed to open lease file: %w", err)
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, fmt.Errorf("%w: %s is locked", ErrLeaseHeld, f.Name())
+		}
+		return nil, fmtErrorf("failed to lock lease file: %w", err)
+	}
+	token, err := readLeaseToken(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	token++
+	if err := writeLeaseToken(f, token, holder); err != nil {
+		f.Close()
+		return nil, err
+	}
+	b.files[logID] = f
+	return &Lease{LogID: logID, Holder: holder, Token: token, Expiry: time.Now().Add(ttl)}, nil
+}
+
+func (b *FileLeaseBackend) Renew(ctx context.Context, lease *Lease, ttl time.Duration) (*Lease, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	f, ok := b.files[lease.LogID]
+	if !ok {
+		return nil, fmt.Errorf("%w: lease file is not locked", ErrLeaseLost)
+	}
+	token, err := readLeaseToken(f)
+	if err != nil {
+		return nil, err
+	}
+	if token != lease.Token {
+		return nil, fmt.Errorf("%w: lease token is %d, expected %d", ErrLeaseLost, token, lease.Token)
+	}
+	l := *lease
+	l.Expiry = time.Now().Add(ttl)
+	return &l, nil
+}
+
+func (b *FileLeaseBackend) Release(ctx context.Context, lease *Lease) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	f, ok := b.files[lease.LogID]
+	if !ok {
+		return nil
+	}
+	if token, err := readLeaseToken(f); err != nil {
+		return err
+	} else if token != lease.Token {
+		return fmtErrorf("lease token is %d, expected %d", token, lease.Token)
+	}
+	delete(b.files, lease.LogID)
+	// Closing the file releases the lock.
+	return f.Close()
+}
+
+// The lease file contains the fencing token and the holder of the most
+// recently acquired lease, separated by a space.
+
+func readLeaseToken(f *os.File) (int64, error) {
+	buf := make([]byte, 512)
+	n, err := f.ReadAt(buf, 0)
+	if err != nil && err != io.EOF {
+		return 0, fmtErrorf("failed to read lease file: %w", err)
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	tokenString, _, _ := strings.Cut(string(buf[:n]), " ")
+	token, err := strconv.ParseInt(tokenString, 10, 64)
+	if err != nil {
+		return 0, fmtErrorf("invalid lease file: %w", err)
+	}
+	return token, nil
+}
+
+func writeLeaseToken(f *os.File, token int64, holder string) error {
+	if err := f.Truncate(0); err != nil {
+		return fmtErrorf("failed to truncate lease file: %w", err)
+	}
+	if _, err := f.WriteAt([]byte(strconv.FormatInt(token, 10)+" "+holder+"\n"), 0); err != nil {
+		return fmtErrorf("failed to write lease file: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		return fmtErrorf("failed to sync lease file: %w", err)
+	}
+	return nil
+}
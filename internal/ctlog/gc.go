@@ -0,0 +1,136 @@
+package ctlog
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+
+	"filippo.io/sunlight"
+	"golang.org/x/mod/sumdb/tlog"
+)
+
+// gcPartialTiles deletes the partial tiles that are superseded by edgeTiles,
+// the right-most tiles of each level of the published tree.
+//
+// A partial tile is superseded if it's for the same tile index as the edge
+// tile but narrower, or if it's for the tile right before it, which is full.
+// Partial tiles for earlier tile indexes are assumed to have been collected by
+// previous runs.
+func (l *Log) gcPartialTiles(ctx context.Context, edgeTiles map[int]tileWithBytes) error {
+	var errs []error
+	for _, edge := range edgeTiles {
+		for n := max(edge.N-1, 0); n <= edge.N; n++ {
+			full := tlog.Tile{H: edge.H, L: edge.L, N: n, W: sunlight.TileWidth}
+			prefix := sunlight.TilePath(full) + ".p/"
+			keys, err := listObjects(ctx, l.c.Backend, prefix)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			for _, key := range keys {
+				w, err := strconv.Atoi(strings.TrimPrefix(key, prefix))
+				if err != nil || w <= 0 || w >= sunlight.TileWidth {
+					l.c.Log.WarnContext(ctx, "unexpected key in partial tiles", "key", key)
+					continue
+				}
+				if n == edge.N && w >= edge.W {
+					// Still referenced by the checkpoint, or newer.
+					continue
+				}
+				if l.c.PartialTileGCDryRun {
+					l.c.Log.InfoContext(ctx, "would delete superseded partial tile",
+						"key", key, "edge", edge)
+					continue
+				}
+				if err := deleteObject(ctx, l.c.Backend, key); err != nil {
+					errs = append(errs, err)
+					continue
+				}
+				l.c.Log.DebugContext(ctx, "deleted superseded partial tile",
+					"key", key, "edge", edge)
+				l.m.GCDeletedTiles.Inc()
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// gcStagingBundles deletes the staging bundles left behind by rounds other
+// than the one of tree, the lock checkpoint, up to its size.
+//
+// Bundles of smaller trees were either applied, or never committed because
+// their round failed before updating the lock backend, and so were bundles of
+// trees of the same size with a different hash. The bundle of tree itself is
+// kept, since the checkpoint in object storage might still be older, and a
+// crash before the next round would need it again. Bundles of larger trees can
+// only be from failed rounds, too, but they are left for a later run.
+func gcStagingBundles(ctx context.Context, c *Config, tree tlog.Tree) error {
+	keys, err := listObjects(ctx, c.Backend, "staging/")
+	if err != nil {
+		return err
+	}
+	var errs []error
+	var deleted int
+	for _, key := range keys {
+		t, ok := parseStagingPath(key)
+		if !ok {
+			c.Log.WarnContext(ctx, "unexpected key in staging", "key", key)
+			continue
+		}
+		if t.N > tree.N || t == tree {
+			continue
+		}
+		if err := deleteObject(ctx, c.Backend, key); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		deleted++
+	}
+	if deleted > 0 {
+		c.Log.InfoContext(ctx, "deleted leftover staging bundles", "count", deleted, "tree_size", tree.N)
+	}
+	return errors.Join(errs...)
+}
+
+// parseStagingPath is the inverse of stagingPath.
+func parseStagingPath(key string) (tlog.Tree, bool) {
+	rest := strings.TrimPrefix(key, "staging/")
+	i := strings.LastIndex(rest, "/")
+	if i < 0 {
+		return tlog.Tree{}, false
+	}
+	dir, hash := rest[:i], rest[i+1:]
+	n, err := strconv.ParseInt(strings.NewReplacer("x", "", "/", "").Replace(dir), 10, 64)
+	if err != nil {
+		return tlog.Tree{}, false
+	}
+	h, err := hex.DecodeString(hash)
+	if err != nil || len(h) != tlog.HashSize {
+		return tlog.Tree{}, false
+	}
+	t := tlog.Tree{N: n, Hash: tlog.Hash(h)}
+	if stagingPath(t) != key {
+		return tlog.Tree{}, false
+	}
+	return t, true
+}
+
+// listObjects calls List on b, if it implements ListDeleteBackend.
+func listObjects(ctx context.Context, b Backend, prefix string) ([]string, error) {
+	lb, ok := b.(ListDeleteBackend)
+	if !ok {
+		return nil, fmtErrorf("backend doesn't support listing objects: %w", errors.ErrUnsupported)
+	}
+	return lb.List(ctx, prefix)
+}
+
+// deleteObject calls Delete on b, if it implements ListDeleteBackend.
+func deleteObject(ctx context.Context, b Backend, key string) error {
+	lb, ok := b.(ListDeleteBackend)
+	if !ok {
+		return fmtErrorf("backend doesn't support deleting objects: %w", errors.ErrUnsupported)
+	}
+	return lb.Delete(ctx, key)
+}
@@ -0,0 +1,257 @@
+package ctlog
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/googleapis/gax-go/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+	htransport "google.golang.org/api/transport/http"
+)
+
+// GCSBackend is a Backend that stores objects in a Google Cloud Storage
+// bucket, using the JSON API.
+//
+// Credentials are loaded with Application Default Credentials. If the
+// STORAGE_EMULATOR_HOST environment variable is set, requests are instead
+// unauthenticated, and sent to that emulator.
+type GCSBackend struct {
+	client        *storage.Client
+	bucket        *storage.BucketHandle
+	keyPrefix     string
+	metrics       []prometheus.Collector
+	uploadSize    prometheus.Summary
+	compressRatio prometheus.Summary
+	log           *slog.Logger
+}
+
+func NewGCSBackend(ctx context.Context, bucket, keyPrefix string, l *slog.Logger) (*GCSBackend, error) {
+	counter := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gcs_requests_total",
+			Help: "GCS HTTP requests performed, by method and response code.",
+		},
+		[]string{"method", "code"},
+	)
+	duration := prometheus.NewSummaryVec(
+		prometheus.SummaryOpts{
+			Name:       "gcs_request_duration_seconds",
+			Help:       "GCS HTTP request latencies, by method and response code.",
+			Objectives: map[float64]float64{0.5: 0.05, 0.75: 0.025, 0.9: 0.01, 0.99: 0.001},
+			MaxAge:     1 * time.Minute,
+			AgeBuckets: 6,
+		},
+		[]string{"method", "code"},
+	)
+	uploadSize := prometheus.NewSummary(
+		prometheus.SummaryOpts{
+			Name:       "gcs_upload_size_bytes",
+			Help:       "GCS (compressed) body size in bytes for object uploads.",
+			Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
+			MaxAge:     1 * time.Minute,
+			AgeBuckets: 6,
+		},
+	)
+	compressRatio := prometheus.NewSummary(
+		prometheus.SummaryOpts{
+			Name:       "gcs_compress_ratio",
+			Help:       "Ratio of compressed to uncompressed body size for compressible object uploads.",
+			MaxAge:     1 * time.Minute,
+			AgeBuckets: 6,
+		},
+	)
+
+	transport := http.RoundTripper(http.DefaultTransport.(*http.Transport).Clone())
+	transport = promhttp.InstrumentRoundTripperCounter(counter, transport)
+	transport = promhttp.InstrumentRoundTripperDuration(duration, transport)
+	// A custom HTTP client replaces the authenticating one, so wrap the
+	// instrumented transport with credentials, unless using an emulator.
+	if os.Getenv("STORAGE_EMULATOR_HOST") == "" {
+		var err error
+		transport, err = htransport.NewTransport(ctx, transport,
+			option.WithScopes(storage.ScopeReadWrite))
+		if err != nil {
+			return nil, fmt.Errorf("failed to load Google credentials for GCS backend: %w", err)
+		}
+	}
+	client, err := storage.NewClient(ctx, storage.WithJSONReads(),
+		option.WithHTTPClient(&http.Client{Transport: transport}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCS client: %w", err)
+	}
+
+	return &GCSBackend{
+		client: client,
+		// Every object is written with the same contents on every attempt,
+		// or with a DoesNotExist precondition, so all uploads are safe to
+		// retry, like in the S3 backend, briefly.
+		bucket: client.Bucket(bucket).Retryer(
+			storage.WithPolicy(storage.RetryAlways),
+			storage.WithMaxAttempts(4),
+			storage.WithBackoff(gax.Backoff{
+				Initial:    5 * time.Millisecond,
+				Max:        50 * time.Millisecond,
+				Multiplier: 2,
+			})),
+		keyPrefix:     keyPrefix,
+		metrics:       []prometheus.Collector{counter, duration, uploadSize, compressRatio},
+		uploadSize:    uploadSize,
+		compressRatio: compressRatio,
+		log:           l,
+	}, nil
+}
+
+var _ ListDeleteBackend = &GCSBackend{}
+var _ PingBackend = &GCSBackend{}
+var _ CreateOnlyBackend = &GCSBackend{}
+
+// SupportsCreateOnly returns true, since GCS always supports the
+// DoesNotExist precondition.
+func (s *GCSBackend) SupportsCreateOnly() bool {
+	return true
+}
+
+func (s *GCSBackend) Upload(ctx context.Context, key string, data []byte, opts *UploadOptions) error {
+	start := time.Now()
+	contentType := "application/octet-stream"
+	if opts != nil && opts.ContentType != "" {
+		contentType = opts.ContentType
+	}
+	var contentEncoding string
+	if opts != nil && opts.Compress {
+		b := &bytes.Buffer{}
+		w := gzip.NewWriter(b)
+		if _, err := w.Write(data); err != nil {
+			return fmtErrorf("failed to compress %q: %w", key, err)
+		}
+		if err := w.Close(); err != nil {
+			return fmtErrorf("failed to compress %q: %w", key, err)
+		}
+		s.compressRatio.Observe(float64(b.Len()) / float64(len(data)))
+		data = b.Bytes()
+		contentEncoding = "gzip"
+	}
+	var cacheControl string
+	if opts != nil && opts.CacheControl != "" {
+		cacheControl = opts.CacheControl
+	} else if opts != nil && opts.Immutable {
+		cacheControl = cacheControlImmutable
+	}
+	obj := s.bucket.Object(s.keyPrefix + key)
+	createOnly := opts != nil && opts.CreateOnly
+	if createOnly {
+		obj = obj.If(storage.Conditions{DoesNotExist: true})
+	}
+	w := obj.NewWriter(ctx)
+	w.ContentType = contentType
+	w.ContentEncoding = contentEncoding
+	w.CacheControl = cacheControl
+	// Send the object in a single request, buffered so it can be retried.
+	w.ChunkSize = len(data) + 1
+	_, err := w.Write(data)
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	s.log.DebugContext(ctx, "GCS upload", "key", key, "size", len(data),
+		"compress", contentEncoding != "", "type", contentType,
+		"create_only", createOnly, "cache_control", cacheControl,
+		"elapsed", time.Since(start), "err", err)
+	s.uploadSize.Observe(float64(len(data)))
+	// A retried request might fail the precondition because the first one
+	// succeeded. The caller can tell by fetching the object.
+	var apiErr *googleapi.Error
+	if createOnly && errors.As(err, &apiErr) && apiErr.Code == http.StatusPreconditionFailed {
+		return fmtErrorf("failed to upload %q to GCS: %w (%w)", key, ErrObjectExists, err)
+	}
+	if err != nil {
+		return fmtErrorf("failed to upload %q to GCS: %w", key, err)
+	}
+	return nil
+}
+
+// Fetch fetches an object. Objects uploaded with UploadOptions.Compress are
+// decompressed by GCS, with decompressive transcoding.
+func (s *GCSBackend) Fetch(ctx context.Context, key string) ([]byte, error) {
+	r, err := s.bucket.Object(s.keyPrefix + key).NewReader(ctx)
+	if err != nil {
+		s.log.DebugContext(ctx, "GCS GET", "key", key, "err", err)
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			return nil, fmtErrorf("failed to fetch %q from GCS: %w (%w)", key, fs.ErrNotExist, err)
+		}
+		return nil, fmtErrorf("failed to fetch %q from GCS: %w", key, err)
+	}
+	defer r.Close()
+	s.log.DebugContext(ctx, "GCS GET", "key", key,
+		"size", r.Attrs.Size, "encoding", r.Attrs.ContentEncoding)
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmtErrorf("failed to read %q from GCS: %w", key, err)
+	}
+	return data, nil
+}
+
+func (s *GCSBackend) List(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	it := s.bucket.Objects(ctx, &storage.Query{Prefix: s.keyPrefix + prefix})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmtErrorf("failed to list %q in GCS: %w", prefix, err)
+		}
+		keys = append(keys, strings.TrimPrefix(attrs.Name, s.keyPrefix))
+	}
+	s.log.DebugContext(ctx, "GCS LIST", "prefix", prefix, "keys", len(keys))
+	return keys, nil
+}
+
+func (s *GCSBackend) Delete(ctx context.Context, key string) error {
+	err := s.bucket.Object(s.keyPrefix + key).Delete(ctx)
+	s.log.DebugContext(ctx, "GCS DELETE", "key", key, "err", err)
+	if err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
+		return fmtErrorf("failed to delete %q from GCS: %w", key, err)
+	}
+	return nil
+}
+
+// Ping checks that the bucket exists and is accessible, and that an object
+// can be written and deleted under the health/ prefix.
+func (s *GCSBackend) Ping(ctx context.Context) error {
+	if _, err := s.bucket.Attrs(ctx); err != nil {
+		return fmtErrorf("failed to ping GCS bucket: %w", err)
+	}
+	obj := s.bucket.Object(s.keyPrefix + healthKey)
+	w := obj.NewWriter(ctx)
+	if _, err := io.WriteString(w, "ok"); err != nil {
+		w.Close()
+		return fmtErrorf("failed to ping GCS bucket: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmtErrorf("failed to ping GCS bucket: %w", err)
+	}
+	if err := obj.Delete(ctx); err != nil {
+		return fmtErrorf("failed to ping GCS bucket: %w", err)
+	}
+	return nil
+}
+
+func (s *GCSBackend) Metrics() []prometheus.Collector {
+	return s.metrics
+}
@@ -0,0 +1,138 @@
+package ctlog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// probeInterval is how long the result of a backend probe is reused, so that
+// health checks are cheap enough to run every few seconds.
+const probeInterval = 5 * time.Second
+
+// checkReady returns an error if the log didn't complete a sequencing round
+// since it was loaded, or if the backend probe fails.
+func (l *Log) checkReady(ctx context.Context) error {
+	if l.seqLastSuccess.Load() == 0 {
+		return errors.New("no successful sequencing round yet")
+	}
+	if err := l.probeBackend(ctx); err != nil {
+		return fmt.Errorf("backend probe failed: %w", err)
+	}
+	return nil
+}
+
+// checkHealth returns an error if the last maxFailures sequencing rounds
+// failed, if the last successful round is older than maxStaleness, or if the
+// backend can't serve the checkpoint. Non-positive values of maxFailures and
+// maxStaleness disable the respective check. The staleness check is skipped while sequencing is paused, so that the
+// process isn't restarted out from under the operator.
+func (l *Log) checkHealth(ctx context.Context, maxFailures int, maxStaleness time.Duration) error {
+	if n := l.seqFailures.Load(); maxFailures > 0 && n >= int64(maxFailures) {
+		return fmt.Errorf("last %d sequencing rounds failed", n)
+	}
+	if last := l.seqLastSuccess.Load(); maxStaleness > 0 && last != 0 && !l.paused.Load() {
+		if since := time.Since(time.Unix(0, last)); since > maxStaleness {
+			return fmt.Errorf("last successful sequencing round was %v ago", since.Round(time.Second))
+		}
+	}
+	if err := l.probeBackend(ctx); err != nil {
+		return fmt.Errorf("backend probe failed: %w", err)
+	}
+	return nil
+}
+
+// probeBackend pings the backend, reusing the result for probeInterval.
+//
+// If ctx is done before the ping completes, for example because the client of a
+// health check gave up, the error is returned but not reused, since it says
+// nothing about the backend.
+func (l *Log) probeBackend(ctx context.Context) error {
+	l.probeMu.Lock()
+	defer l.probeMu.Unlock()
+	if !l.probeTime.IsZero() && time.Since(l.probeTime) < probeInterval {
+		return l.probeErr
+	}
+	pingCtx, cancel := context.WithTimeout(ctx, probeInterval)
+	defer cancel()
+	err := pingBackend(pingCtx, l.c.Backend)
+	if err != nil && ctx.Err() != nil {
+		return err
+	}
+	l.probeErr = err
+	l.probeTime = time.Now()
+	if l.probeErr != nil {
+		l.m.BackendProbeSuccess.Set(0)
+	} else {
+		l.m.BackendProbeSuccess.Set(1)
+	}
+	return l.probeErr
+}
+
+// runBackendProbe probes the backend every probeInterval until ctx is done,
+// so that the readiness check and the metric reflect the backend state even
+// without incoming health checks. A failing probe doesn't stop the sequencer,
+// which might still succeed thanks to retries.
+func (l *Log) runBackendProbe(ctx context.Context) {
+	t := time.NewTicker(probeInterval)
+	defer t.Stop()
+	for {
+		if err := l.probeBackend(ctx); err != nil && ctx.Err() == nil {
+			l.c.Log.WarnContext(ctx, "backend probe failed", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// pingBackend calls b.Ping if b implements PingBackend, and otherwise fetches
+// the checkpoint.
+func pingBackend(ctx context.Context, b Backend) error {
+	if pb, ok := b.(PingBackend); ok {
+		return pb.Ping(ctx)
+	}
+	_, err := b.Fetch(ctx, "checkpoint")
+	return err
+}
+
+func (s *Server) readyz(rw http.ResponseWriter, r *http.Request) {
+	for _, sl := range s.logs {
+		if err := sl.log.checkReady(r.Context()); err != nil {
+			http.Error(rw, fmt.Sprintf("%s: %v", sl.prefix, err), http.StatusServiceUnavailable)
+			return
+		}
+	}
+	s.mu.Lock()
+	draining := s.draining
+	s.mu.Unlock()
+	if draining {
+		http.Error(rw, "server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(rw, "ready")
+}
+
+func (s *Server) healthz(rw http.ResponseWriter, r *http.Request) {
+	// Negative values are passed through to checkHealth, which disables the
+	// respective check.
+	maxFailures := s.HealthMaxFailures
+	if maxFailures == 0 {
+		maxFailures = 5
+	}
+	maxStaleness := s.HealthMaxStaleness
+	if maxStaleness == 0 {
+		maxStaleness = 30 * time.Second
+	}
+	for _, sl := range s.logs {
+		if err := sl.log.checkHealth(r.Context(), maxFailures, maxStaleness); err != nil {
+			http.Error(rw, fmt.Sprintf("%s: %v", sl.prefix, err), http.StatusServiceUnavailable)
+			return
+		}
+	}
+	fmt.Fprintln(rw, "ok")
+}
@@ -1,15 +1,24 @@
 package ctlog
 
 import (
+	"bytes"
 	"context"
 	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"log/slog"
 	"math/rand"
 	"net"
 	"net/http"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
 	"sync/atomic"
 	"time"
 
@@ -20,32 +29,57 @@ import (
 	"github.com/google/certificate-transparency-go/x509util"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"golang.org/x/crypto/cryptobyte"
+	"golang.org/x/mod/sumdb/tlog"
 )
 
 func (l *Log) Handler() http.Handler {
-	addChainLabels := prometheus.Labels{"endpoint": "add-chain"}
-	addChain := http.Handler(http.HandlerFunc(l.addChain))
-	addChain = promhttp.InstrumentHandlerCounter(l.m.ReqCount.MustCurryWith(addChainLabels), addChain)
-	addChain = promhttp.InstrumentHandlerDuration(l.m.ReqDuration.MustCurryWith(addChainLabels), addChain)
-	addChain = promhttp.InstrumentHandlerInFlight(l.m.ReqInFlight.With(addChainLabels), addChain)
-
-	addPreChainLabels := prometheus.Labels{"endpoint": "add-pre-chain"}
-	addPreChain := http.Handler(http.HandlerFunc(l.addPreChain))
-	addPreChain = promhttp.InstrumentHandlerCounter(l.m.ReqCount.MustCurryWith(addPreChainLabels), addPreChain)
-	addPreChain = promhttp.InstrumentHandlerDuration(l.m.ReqDuration.MustCurryWith(addPreChainLabels), addPreChain)
-	addPreChain = promhttp.InstrumentHandlerInFlight(l.m.ReqInFlight.With(addPreChainLabels), addPreChain)
-
-	getRootsLabels := prometheus.Labels{"endpoint": "get-roots"}
-	getRoots := http.Handler(http.HandlerFunc(l.getRoots))
-	getRoots = promhttp.InstrumentHandlerCounter(l.m.ReqCount.MustCurryWith(getRootsLabels), getRoots)
-	getRoots = promhttp.InstrumentHandlerDuration(l.m.ReqDuration.MustCurryWith(getRootsLabels), getRoots)
-	getRoots = promhttp.InstrumentHandlerInFlight(l.m.ReqInFlight.With(getRootsLabels), getRoots)
+	instrument := func(endpoint string, h http.HandlerFunc) http.Handler {
+		labels := prometheus.Labels{"endpoint": endpoint}
+		handler := l.recoverPanics(endpoint, h)
+		handler = promhttp.InstrumentHandlerCounter(l.m.ReqCount.MustCurryWith(labels), handler)
+		handler = promhttp.InstrumentHandlerDuration(l.m.ReqDuration.MustCurryWith(labels), handler)
+		handler = promhttp.InstrumentHandlerInFlight(l.m.ReqInFlight.With(labels), handler)
+		handler = l.accessLog(endpoint, handler)
+		return handler
+	}
 
 	mux := http.NewServeMux()
-	mux.Handle("POST /ct/v1/add-chain", addChain)
-	mux.Handle("POST /ct/v1/add-pre-chain", addPreChain)
-	mux.Handle("GET /ct/v1/get-roots", getRoots)
-	return http.MaxBytesHandler(mux, 128*1024)
+	mux.Handle("POST /ct/v1/add-chain", instrument("add-chain", l.rateLimit(l.addChain)))
+	mux.Handle("POST /ct/v1/add-pre-chain", instrument("add-pre-chain", l.rateLimit(l.addPreChain)))
+	mux.Handle("GET /ct/v1/get-roots", instrument("get-roots", l.getRoots))
+	mux.Handle("GET /ct/v1/get-sth", instrument("get-sth", l.getSTH))
+	mux.Handle("GET /ct/v1/get-sth-consistency", instrument("get-sth-consistency", l.getSTHConsistency))
+	mux.Handle("GET /ct/v1/get-proof-by-hash", instrument("get-proof-by-hash", l.getProofByHash))
+	mux.Handle("GET /ct/v1/get-entries", instrument("get-entries", l.getEntries))
+	mux.Handle("GET /ct/v1/get-entry-and-proof", instrument("get-entry-and-proof", l.getEntryAndProof))
+	mux.Handle("GET /sunlight/v1/final-for-precert", instrument("final-for-precert", l.getFinalForPrecert))
+	mux.Handle("GET /checkpoint", instrument("checkpoint", l.getCheckpoint))
+	mux.Handle("GET /tile/", instrument("tile", l.getTile))
+	mux.Handle("GET /issuer/{fingerprint}", instrument("issuer", l.getIssuer))
+	mux.Handle("GET /metadata", instrument("metadata", l.getMetadata))
+	return http.MaxBytesHandler(mux, l.maxBodySize())
+}
+
+// recoverPanics serves a 500 if h panics, instead of letting net/http abort the
+// connection, so that the panic is also counted by the metrics middleware.
+func (l *Log) recoverPanics(endpoint string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			if p == http.ErrAbortHandler {
+				panic(p)
+			}
+			l.m.ReqPanics.WithLabelValues(endpoint).Inc()
+			l.c.Log.ErrorContext(r.Context(), "panic serving request", "endpoint", endpoint,
+				"panic", p, "stack", string(debug.Stack()))
+			writeError(rw, http.StatusInternalServerError, reasonInternal, "internal server error")
+		}()
+		h.ServeHTTP(rw, r)
+	})
 }
 
 type reusedConnContextKey struct{}
@@ -57,20 +91,38 @@ func ReusedConnContext(ctx context.Context, c net.Conn) context.Context {
 }
 
 func (l *Log) addChain(rw http.ResponseWriter, r *http.Request) {
-	rsp, code, err := l.addChainOrPreChain(r.Context(), r.Body, func(le *PendingLogEntry) error {
+	rsp, code, err := l.addChainOrPreChain(r, func(le *PendingLogEntry) error {
 		if le.IsPrecert {
-			return fmtErrorf("pre-certificate submitted to add-chain")
+			return withReason(reasonCertWrongType, fmtErrorf("pre-certificate submitted to add-chain"))
 		}
 		return nil
 	})
 	if err != nil {
-		l.c.Log.DebugContext(r.Context(), "add-chain error", "code", code, "err", err)
+		details := errorDetails(err)
+		if details != nil {
+			l.c.Log.DebugContext(r.Context(), "add-chain error", "code", code, "err", err, "details", details)
+		} else {
+			l.c.Log.DebugContext(r.Context(), "add-chain error", "code", code, "err", err)
+		}
+		if retryErr := (retryAfterError{}); errors.As(err, &retryErr) {
+			rw.Header().Set("Retry-After", retryAfterSeconds(retryErr.after))
+		}
+		if err == ErrShuttingDown || err == ErrLogClosed {
+			rw.Header().Set("Retry-After", shutdownRetryAfter())
+			writeError(rw, code, reasonShuttingDown, "log is shutting down")
+			return
+		}
+		if err == ErrPaused {
+			rw.Header().Set("Retry-After", shutdownRetryAfter())
+			writeError(rw, code, reasonPaused, "log is paused")
+			return
+		}
 		if code == http.StatusServiceUnavailable {
-			rw.Header().Set("Retry-After", fmt.Sprintf("%d", 30+rand.Intn(60)))
-			http.Error(rw, "😮‍💨 this party is popular and the pool is full ✨ please retry later 🥺", code)
+			rw.Header().Set("Retry-After", retryAfterSeconds(l.poolRetryAfter()))
+			writeError(rw, code, reasonPoolFull, "😮‍💨 this party is popular and the pool is full ✨ please retry later 🥺")
 			return
 		}
-		http.Error(rw, err.Error(), code)
+		writeErrorDetails(rw, code, errorReason(err, code), err.Error(), details)
 		return
 	}
 
@@ -83,20 +135,38 @@ func (l *Log) addChain(rw http.ResponseWriter, r *http.Request) {
 }
 
 func (l *Log) addPreChain(rw http.ResponseWriter, r *http.Request) {
-	rsp, code, err := l.addChainOrPreChain(r.Context(), r.Body, func(le *PendingLogEntry) error {
+	rsp, code, err := l.addChainOrPreChain(r, func(le *PendingLogEntry) error {
 		if !le.IsPrecert {
-			return fmtErrorf("final certificate submitted to add-pre-chain")
+			return withReason(reasonCertWrongType, fmtErrorf("final certificate submitted to add-pre-chain"))
 		}
 		return nil
 	})
 	if err != nil {
-		l.c.Log.DebugContext(r.Context(), "add-pre-chain error", "code", code, "err", err)
+		details := errorDetails(err)
+		if details != nil {
+			l.c.Log.DebugContext(r.Context(), "add-pre-chain error", "code", code, "err", err, "details", details)
+		} else {
+			l.c.Log.DebugContext(r.Context(), "add-pre-chain error", "code", code, "err", err)
+		}
+		if retryErr := (retryAfterError{}); errors.As(err, &retryErr) {
+			rw.Header().Set("Retry-After", retryAfterSeconds(retryErr.after))
+		}
+		if err == ErrShuttingDown || err == ErrLogClosed {
+			rw.Header().Set("Retry-After", shutdownRetryAfter())
+			writeError(rw, code, reasonShuttingDown, "log is shutting down")
+			return
+		}
+		if err == ErrPaused {
+			rw.Header().Set("Retry-After", shutdownRetryAfter())
+			writeError(rw, code, reasonPaused, "log is paused")
+			return
+		}
 		if code == http.StatusServiceUnavailable {
-			rw.Header().Set("Retry-After", fmt.Sprintf("%d", 30+rand.Intn(60)))
-			http.Error(rw, "😮‍💨 this party is popular and the pool is full ✨ please retry later 🥺", code)
+			rw.Header().Set("Retry-After", retryAfterSeconds(l.poolRetryAfter()))
+			writeError(rw, code, reasonPoolFull, "😮‍💨 this party is popular and the pool is full ✨ please retry later 🥺")
 			return
 		}
-		http.Error(rw, err.Error(), code)
+		writeErrorDetails(rw, code, errorReason(err, code), err.Error(), details)
 		return
 	}
 
@@ -108,7 +178,8 @@ func (l *Log) addPreChain(rw http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (l *Log) addChainOrPreChain(ctx context.Context, reqBody io.ReadCloser, checkType func(*PendingLogEntry) error) (response []byte, code int, err error) {
+func (l *Log) addChainOrPreChain(r *http.Request, checkType func(*PendingLogEntry) error) (response []byte, code int, err error) {
+	ctx := r.Context()
 	labels := prometheus.Labels{"error": "", "issuer": "", "root": "", "reused": "",
 		"precert": "", "preissuer": "", "chain_len": "", "source": ""}
 	defer func() {
@@ -120,24 +191,48 @@ func (l *Log) addChainOrPreChain(ctx context.Context, reqBody io.ReadCloser, che
 	if b, ok := ctx.Value(reusedConnContextKey{}).(*atomic.Bool); ok && b.Swap(true) {
 		labels["reused"] = "true"
 	}
+	validateTimer := prometheus.NewTimer(l.m.AddChainValidate)
 
-	body, err := io.ReadAll(reqBody)
-	if err != nil {
+	// The body is already limited by the http.MaxBytesHandler in Handler, but
+	// reject requests that declare a large body before reading anything.
+	if r.ContentLength > l.maxBodySize() {
+		return nil, http.StatusRequestEntityTooLarge, withReason(reasonBodyTooLarge,
+			fmtErrorf("request body too large: %d bytes, the limit is %d", r.ContentLength, l.maxBodySize()))
+	}
+	body, err := io.ReadAll(r.Body)
+	if maxBytesErr := (*http.MaxBytesError)(nil); errors.As(err, &maxBytesErr) {
+		return nil, http.StatusRequestEntityTooLarge, withReason(reasonBodyTooLarge,
+			fmtErrorf("request body too large: the limit is %d", maxBytesErr.Limit))
+	} else if err != nil {
 		return nil, http.StatusInternalServerError, fmtErrorf("failed to read body: %w", err)
 	}
-	var req struct {
-		Chain [][]byte
+	rawChain, needsOrder, err := parseSubmission(r.Header.Get("Content-Type"), body)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
 	}
-	if err := json.Unmarshal(body, &req); err != nil {
-		return nil, http.StatusBadRequest, fmtErrorf("failed to parse request: %w", err)
+	if len(rawChain) == 0 {
+		return nil, http.StatusBadRequest, withReason(reasonChainEmpty, fmtErrorf("empty chain"))
 	}
-	if len(req.Chain) == 0 {
-		return nil, http.StatusBadRequest, fmtErrorf("empty chain")
+	if len(rawChain) > l.maxChainLength() {
+		return nil, http.StatusBadRequest, withReason(reasonChainTooLong,
+			fmtErrorf("chain too long: %d certificates, the limit is %d", len(rawChain), l.maxChainLength()))
+	}
+	var chainSize int
+	for i, c := range rawChain {
+		if len(c) > l.maxCertificateSize() {
+			return nil, http.StatusBadRequest, withCertDetails(i, nil, withReason(reasonCertTooLarge,
+				fmtErrorf("certificate too large: certificate %d is %d bytes, the limit is %d", i, len(c), l.maxCertificateSize())))
+		}
+		chainSize += len(c)
+	}
+	if chainSize > l.maxChainSize() {
+		return nil, http.StatusBadRequest, withReason(reasonChainTooLarge,
+			fmtErrorf("chain too large: %d bytes, the limit is %d", chainSize, l.maxChainSize()))
 	}
 
-	chain, err := ctfe.ValidateChain(req.Chain, ctfe.NewCertValidationOpts(l.c.Roots, time.Time{}, false, false, &l.c.NotAfterStart, &l.c.NotAfterLimit, false, []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}))
+	chain, err := l.verifyChain(rawChain, needsOrder)
 	if err != nil {
-		return nil, http.StatusBadRequest, fmtErrorf("invalid chain: %w", err)
+		return nil, http.StatusBadRequest, err
 	}
 	labels["chain_len"] = fmt.Sprintf("%d", len(chain))
 	labels["root"] = x509util.NameToString(chain[len(chain)-1].Subject)
@@ -149,12 +244,12 @@ func (l *Log) addChainOrPreChain(ctx context.Context, reqBody io.ReadCloser, che
 	}
 	if isPrecert, err := ctfe.IsPrecertificate(chain[0]); err != nil {
 		l.c.Log.WarnContext(ctx, "invalid precertificate", "err", err, "body", body)
-		return nil, http.StatusBadRequest, fmtErrorf("invalid precertificate: %w", err)
+		return nil, http.StatusBadRequest, withReason(reasonPrecertInvalid, fmtErrorf("invalid precertificate: %w", err))
 	} else if isPrecert {
 		labels["precert"] = "true"
 		if len(chain) < 2 {
 			l.c.Log.WarnContext(ctx, "missing precertificate issuer", "err", err, "body", body)
-			return nil, http.StatusBadRequest, fmtErrorf("missing precertificate issuer")
+			return nil, http.StatusBadRequest, withReason(reasonPrecertIssuer, fmtErrorf("missing precertificate issuer"))
 		}
 
 		var preIssuer *x509.Certificate
@@ -164,28 +259,35 @@ func (l *Log) addChainOrPreChain(ctx context.Context, reqBody io.ReadCloser, che
 			labels["issuer"] = x509util.NameToString(preIssuer.Issuer)
 			if len(chain) < 3 {
 				l.c.Log.WarnContext(ctx, "missing precertificate signing certificate issuer", "err", err, "body", body)
-				return nil, http.StatusBadRequest, fmtErrorf("missing precertificate signing certificate issuer")
+				return nil, http.StatusBadRequest, withReason(reasonPrecertIssuer, fmtErrorf("missing precertificate signing certificate issuer"))
 			}
 		}
 
 		defangedTBS, err := x509.BuildPrecertTBS(chain[0].RawTBSCertificate, preIssuer)
 		if err != nil {
-			l.c.Log.ErrorContext(ctx, "failed to build TBSCertificate", "err", err, "body", body)
-			return nil, http.StatusInternalServerError, fmtErrorf("failed to build TBSCertificate: %w", err)
+			// The TBSCertificate was already parsed successfully, so this
+			// is due to a malformed poison extension or signing certificate.
+			l.c.Log.WarnContext(ctx, "failed to build TBSCertificate", "err", err, "body", body)
+			return nil, http.StatusBadRequest, withReason(reasonPrecertInvalid, fmtErrorf("failed to build TBSCertificate: %w", err))
 		}
 
 		e.IsPrecert = true
 		e.Certificate = defangedTBS
 		e.PreCertificate = chain[0].Raw
-		if preIssuer != nil {
-			e.IssuerKeyHash = sha256.Sum256(chain[2].RawSubjectPublicKeyInfo)
-		} else {
-			e.IssuerKeyHash = sha256.Sum256(chain[1].RawSubjectPublicKeyInfo)
-		}
+		e.IssuerKeyHash = issuerKeyHash(chain)
 	}
 	if err := checkType(e); err != nil {
+		return nil, http.StatusBadRequest, withCertDetails(0, chain[0], err)
+	}
+	if err := l.checkPolicies(ctx, chain, e.IsPrecert); err != nil {
 		return nil, http.StatusBadRequest, err
 	}
+	if err := l.checkIssuerQuota(issuerKeyHash(chain)); err != nil {
+		l.m.IssuerQuotaExceeded.WithLabelValues(labels["issuer"]).Inc()
+		return nil, http.StatusTooManyRequests, withReason(reasonIssuerQuota, err)
+	}
+
+	validateTimer.ObserveDuration()
 
 	waitLeaf, source := l.addLeafToPool(ctx, e)
 	labels["source"] = source
@@ -194,12 +296,17 @@ func (l *Log) addChainOrPreChain(ctx context.Context, reqBody io.ReadCloser, che
 	if source == "sequencer" {
 		waitTimer.ObserveDuration()
 	}
-	if err == errPoolFull {
+	if err == ErrPoolFull || err == ErrShuttingDown || err == ErrLogClosed || err == ErrPaused {
 		return nil, http.StatusServiceUnavailable, err
 	} else if err != nil {
 		return nil, http.StatusInternalServerError, fmtErrorf("failed to sequence leaf: %w", err)
 	}
 
+	leafHash := tlog.RecordHash(seq.MerkleTreeLeaf())
+	addAccessLogAttrs(ctx, slog.String("source", source),
+		slog.String("leaf_hash", base64.StdEncoding.EncodeToString(leafHash[:])),
+		slog.Int64("leaf_index", seq.LeafIndex))
+
 	ext, err := sunlight.MarshalExtensions(sunlight.Extensions{LeafIndex: seq.LeafIndex})
 	if err != nil {
 		l.c.Log.ErrorContext(ctx, "failed to encode extensions", "err", err, "body", body)
@@ -230,8 +337,58 @@ func (l *Log) addChainOrPreChain(ctx context.Context, reqBody io.ReadCloser, che
 	return rsp, http.StatusOK, nil
 }
 
+// issuerKeyHash returns the SHA-256 hash of the SubjectPublicKeyInfo of the CA
+// that issued chain[0], skipping a precertificate signing certificate.
+func issuerKeyHash(chain []*x509.Certificate) [32]byte {
+	issuer := chain[0] // a self-signed root
+	if len(chain) > 2 && ct.IsPreIssuer(chain[1]) {
+		issuer = chain[2]
+	} else if len(chain) > 1 {
+		issuer = chain[1]
+	}
+	return sha256.Sum256(issuer.RawSubjectPublicKeyInfo)
+}
+
+// poolRetryAfter returns a random duration between one and two sequencing
+// periods, by which time the full pool will have been replaced by a new one.
+func (l *Log) poolRetryAfter() time.Duration {
+	period := time.Duration(l.seqPeriod.Load())
+	if period <= 0 {
+		period = time.Second
+	}
+	return period + time.Duration(rand.Int63n(int64(period)))
+}
+
+func (l *Log) maxBodySize() int64 {
+	if l.c.MaxBodySize > 0 {
+		return l.c.MaxBodySize
+	}
+	return 128 * 1024
+}
+
+func (l *Log) maxChainLength() int {
+	if l.c.MaxChainLength > 0 {
+		return l.c.MaxChainLength
+	}
+	return 10
+}
+
+func (l *Log) maxCertificateSize() int {
+	if l.c.MaxCertificateSize > 0 {
+		return l.c.MaxCertificateSize
+	}
+	return 64 * 1024
+}
+
+func (l *Log) maxChainSize() int {
+	if l.c.MaxChainSize > 0 {
+		return l.c.MaxChainSize
+	}
+	return 128 * 1024
+}
+
 func (l *Log) getRoots(rw http.ResponseWriter, r *http.Request) {
-	roots := l.c.Roots.RawCertificates()
+	roots := l.roots.Load().RawCertificates()
 	var res struct {
 		Certificates [][]byte `json:"certificates"`
 	}
@@ -239,9 +396,428 @@ func (l *Log) getRoots(rw http.ResponseWriter, r *http.Request) {
 	for _, r := range roots {
 		res.Certificates = append(res.Certificates, r.Raw)
 	}
+	// PEMCertPool already deduplicates by fingerprint. Sort the output so that
+	// monitors can diff it, regardless of the order of the roots file.
+	slices.SortFunc(res.Certificates, bytes.Compare)
 
 	rw.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(rw).Encode(res); err != nil {
 		l.c.Log.DebugContext(r.Context(), "failed to write get-roots response", "err", err)
 	}
 }
+
+func (l *Log) getMetadata(rw http.ResponseWriter, r *http.Request) {
+	md, err := l.Metadata()
+	if err != nil {
+		l.c.Log.ErrorContext(r.Context(), "failed to compute metadata", "err", err)
+		writeError(rw, http.StatusInternalServerError, reasonInternal, "internal server error")
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("Cache-Control", cacheControlShort)
+	if err := json.NewEncoder(rw).Encode(md); err != nil {
+		l.c.Log.DebugContext(r.Context(), "failed to write metadata response", "err", err)
+	}
+}
+
+func (l *Log) getSTH(rw http.ResponseWriter, r *http.Request) {
+	// The state snapshot is published by sequencePool only after the
+	// checkpoint is committed to the lock backend and uploaded to object
+	// storage, so this never serves a tree head that could be rolled back.
+	state := l.state.Load()
+	res := ct.GetSTHResponse{
+		TreeSize:          uint64(state.tree.N),
+		Timestamp:         uint64(state.tree.Time),
+		SHA256RootHash:    state.tree.Hash[:],
+		TreeHeadSignature: state.treeHeadSignature,
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(rw).Encode(res); err != nil {
+		l.c.Log.DebugContext(r.Context(), "failed to write get-sth response", "err", err)
+	}
+}
+
+func (l *Log) getSTHConsistency(rw http.ResponseWriter, r *http.Request) {
+	state := l.state.Load()
+	first, err := parseIntParam(r, "first")
+	if err != nil {
+		writeError(rw, http.StatusBadRequest, reasonBadParameter, err.Error())
+		return
+	}
+	second, err := parseIntParam(r, "second")
+	if err != nil {
+		writeError(rw, http.StatusBadRequest, reasonBadParameter, err.Error())
+		return
+	}
+	if first > second {
+		writeError(rw, http.StatusBadRequest, reasonBadParameter, fmt.Sprintf("first (%d) is larger than second (%d)", first, second))
+		return
+	}
+	if second > state.tree.N {
+		writeError(rw, http.StatusBadRequest, reasonBadParameter, fmt.Sprintf("second (%d) is larger than the tree size (%d)", second, state.tree.N))
+		return
+	}
+
+	// A proof from the empty tree, or from a tree to itself, is empty.
+	res := ct.GetSTHConsistencyResponse{Consistency: [][]byte{}}
+	if first > 0 && first < second {
+		proof, err := tlog.ProveTree(second, first, l.proofHashReader(r.Context(), state))
+		if err != nil {
+			l.c.Log.ErrorContext(r.Context(), "failed to compute consistency proof",
+				"first", first, "second", second, "err", err)
+			writeError(rw, http.StatusInternalServerError, reasonInternal, "failed to compute consistency proof")
+			return
+		}
+		for _, h := range proof {
+			res.Consistency = append(res.Consistency, h[:])
+		}
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(rw).Encode(res); err != nil {
+		l.c.Log.DebugContext(r.Context(), "failed to write get-sth-consistency response", "err", err)
+	}
+}
+
+func (l *Log) getProofByHash(rw http.ResponseWriter, r *http.Request) {
+	state := l.state.Load()
+	hash, err := base64.StdEncoding.DecodeString(r.URL.Query().Get("hash"))
+	if err != nil || len(hash) != tlog.HashSize {
+		writeError(rw, http.StatusBadRequest, reasonBadParameter, "invalid \"hash\" parameter")
+		return
+	}
+	treeSize, err := parseIntParam(r, "tree_size")
+	if err != nil {
+		writeError(rw, http.StatusBadRequest, reasonBadParameter, err.Error())
+		return
+	}
+	if treeSize > state.tree.N {
+		writeError(rw, http.StatusBadRequest, reasonBadParameter, fmt.Sprintf("tree_size (%d) is larger than the tree size (%d)", treeSize, state.tree.N))
+		return
+	}
+
+	idx, err := l.leafHashIndex(r.Context(), tlog.Hash(hash))
+	if err != nil {
+		l.c.Log.ErrorContext(r.Context(), "failed to look up leaf hash", "err", err)
+		writeError(rw, http.StatusInternalServerError, reasonInternal, "failed to look up leaf hash")
+		return
+	}
+	if idx < 0 || idx >= treeSize {
+		writeError(rw, http.StatusNotFound, reasonNotFound, fmt.Sprintf("leaf hash not found in tree of size %d", treeSize))
+		return
+	}
+
+	hr := l.proofHashReader(r.Context(), state)
+	// Double check the index against the tree, since the leaf_hashes table is
+	// not authenticated.
+	hashes, err := hr.ReadHashes([]int64{tlog.StoredHashIndex(0, idx)})
+	if err != nil || hashes[0] != tlog.Hash(hash) {
+		l.c.Log.ErrorContext(r.Context(), "leaf hash index doesn't match the tree",
+			"index", idx, "hash", hash, "err", err)
+		writeError(rw, http.StatusInternalServerError, reasonInternal, "failed to compute inclusion proof")
+		return
+	}
+	proof, err := tlog.ProveRecord(treeSize, idx, hr)
+	if err != nil {
+		l.c.Log.ErrorContext(r.Context(), "failed to compute inclusion proof",
+			"index", idx, "tree_size", treeSize, "err", err)
+		writeError(rw, http.StatusInternalServerError, reasonInternal, "failed to compute inclusion proof")
+		return
+	}
+	res := ct.GetProofByHashResponse{LeafIndex: idx, AuditPath: [][]byte{}}
+	for _, h := range proof {
+		res.AuditPath = append(res.AuditPath, h[:])
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(rw).Encode(res); err != nil {
+		l.c.Log.DebugContext(r.Context(), "failed to write get-proof-by-hash response", "err", err)
+	}
+}
+
+// finalForPrecertResponse is the response to a final-for-precert request. An
+// index is omitted if no such entry was logged.
+type finalForPrecertResponse struct {
+	PrecertIndex *int64 `json:"precert_index,omitempty"`
+	FinalIndex   *int64 `json:"final_index,omitempty"`
+}
+
+// getFinalForPrecert serves the indexes of the precertificate and final
+// certificate entries that share the TBSCertificate whose base64-encoded
+// SHA-256 hash is the "tbs_hash" parameter. This is not part of RFC 6962.
+func (l *Log) getFinalForPrecert(rw http.ResponseWriter, r *http.Request) {
+	state := l.state.Load()
+	hash, err := base64.StdEncoding.DecodeString(r.URL.Query().Get("tbs_hash"))
+	if err != nil || len(hash) != sha256.Size {
+		writeError(rw, http.StatusBadRequest, reasonBadParameter, "invalid \"tbs_hash\" parameter")
+		return
+	}
+	precertIdx, finalIdx, err := l.FinalForPrecert(r.Context(), [32]byte(hash))
+	if err != nil {
+		l.c.Log.ErrorContext(r.Context(), "failed to look up TBS hash", "err", err)
+		writeError(rw, http.StatusInternalServerError, reasonInternal, "failed to look up TBS hash")
+		return
+	}
+	var res finalForPrecertResponse
+	if precertIdx >= 0 && precertIdx < state.tree.N {
+		res.PrecertIndex = &precertIdx
+	}
+	if finalIdx >= 0 && finalIdx < state.tree.N {
+		res.FinalIndex = &finalIdx
+	}
+	if res.PrecertIndex == nil && res.FinalIndex == nil {
+		writeError(rw, http.StatusNotFound, reasonNotFound, fmt.Sprintf("TBS hash not found in tree of size %d", state.tree.N))
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(rw).Encode(res); err != nil {
+		l.c.Log.DebugContext(r.Context(), "failed to write final-for-precert response", "err", err)
+	}
+}
+
+func (l *Log) getEntries(rw http.ResponseWriter, r *http.Request) {
+	state := l.state.Load()
+	start, err := parseIntParam(r, "start")
+	if err != nil {
+		writeError(rw, http.StatusBadRequest, reasonBadParameter, err.Error())
+		return
+	}
+	end, err := parseIntParam(r, "end")
+	if err != nil {
+		writeError(rw, http.StatusBadRequest, reasonBadParameter, err.Error())
+		return
+	}
+	if start > end {
+		writeError(rw, http.StatusBadRequest, reasonBadParameter, fmt.Sprintf("start (%d) is larger than end (%d)", start, end))
+		return
+	}
+	if end >= state.tree.N {
+		writeError(rw, http.StatusBadRequest, reasonBadParameter, fmt.Sprintf("end (%d) is not smaller than the tree size (%d)", end, state.tree.N))
+		return
+	}
+	limit := int64(l.c.MaxGetEntries)
+	if limit <= 0 {
+		limit = sunlight.TileWidth
+	}
+	end = min(end, start+limit-1)
+
+	entries, err := l.readEntries(r.Context(), state, start, end+1)
+	if err != nil {
+		l.c.Log.ErrorContext(r.Context(), "failed to read entries", "start", start, "end", end, "err", err)
+		writeError(rw, http.StatusInternalServerError, reasonInternal, "failed to read entries")
+		return
+	}
+	res := ct.GetEntriesResponse{Entries: make([]ct.LeafEntry, 0, len(entries))}
+	for _, e := range entries {
+		extraData, err := l.extraData(r.Context(), e)
+		if err != nil {
+			l.c.Log.ErrorContext(r.Context(), "failed to encode extra_data", "index", e.LeafIndex, "err", err)
+			writeError(rw, http.StatusInternalServerError, reasonInternal, "failed to read entries")
+			return
+		}
+		res.Entries = append(res.Entries, ct.LeafEntry{
+			LeafInput: e.MerkleTreeLeaf(),
+			ExtraData: extraData,
+		})
+	}
+
+	// Responses that only span full data tiles can be cached like the tiles.
+	if end < state.tree.N/sunlight.TileWidth*sunlight.TileWidth {
+		rw.Header().Set("Cache-Control", cacheControlImmutable)
+	}
+	body, err := json.Marshal(res)
+	if err != nil {
+		l.c.Log.ErrorContext(r.Context(), "failed to encode get-entries response", "err", err)
+		writeError(rw, http.StatusInternalServerError, reasonInternal, "failed to encode entries")
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	if err := l.writeCompressible(rw, r, body, ""); err != nil {
+		l.c.Log.DebugContext(r.Context(), "failed to write get-entries response", "err", err)
+	}
+}
+
+func (l *Log) getEntryAndProof(rw http.ResponseWriter, r *http.Request) {
+	state := l.state.Load()
+	leafIndex, err := parseIntParam(r, "leaf_index")
+	if err != nil {
+		writeError(rw, http.StatusBadRequest, reasonBadParameter, err.Error())
+		return
+	}
+	treeSize, err := parseIntParam(r, "tree_size")
+	if err != nil {
+		writeError(rw, http.StatusBadRequest, reasonBadParameter, err.Error())
+		return
+	}
+	if treeSize > state.tree.N {
+		writeError(rw, http.StatusBadRequest, reasonBadParameter, fmt.Sprintf("tree_size (%d) is larger than the tree size (%d)", treeSize, state.tree.N))
+		return
+	}
+	if leafIndex >= treeSize {
+		writeError(rw, http.StatusBadRequest, reasonBadParameter, fmt.Sprintf("leaf_index (%d) is not smaller than tree_size (%d)", leafIndex, treeSize))
+		return
+	}
+
+	// The proof is computed with the tiles of the current tree, which contain
+	// all the hashes of any smaller tree.
+	proof, err := tlog.ProveRecord(treeSize, leafIndex, l.proofHashReader(r.Context(), state))
+	if err != nil {
+		l.c.Log.ErrorContext(r.Context(), "failed to compute inclusion proof",
+			"index", leafIndex, "tree_size", treeSize, "err", err)
+		writeError(rw, http.StatusInternalServerError, reasonInternal, "failed to compute inclusion proof")
+		return
+	}
+	entries, err := l.readEntries(r.Context(), state, leafIndex, leafIndex+1)
+	if err != nil {
+		l.c.Log.ErrorContext(r.Context(), "failed to read entry", "index", leafIndex, "err", err)
+		writeError(rw, http.StatusInternalServerError, reasonInternal, "failed to read entry")
+		return
+	}
+	extraData, err := l.extraData(r.Context(), entries[0])
+	if err != nil {
+		l.c.Log.ErrorContext(r.Context(), "failed to encode extra_data", "index", leafIndex, "err", err)
+		writeError(rw, http.StatusInternalServerError, reasonInternal, "failed to read entry")
+		return
+	}
+	res := ct.GetEntryAndProofResponse{
+		LeafInput: entries[0].MerkleTreeLeaf(),
+		ExtraData: extraData,
+		AuditPath: [][]byte{},
+	}
+	for _, h := range proof {
+		res.AuditPath = append(res.AuditPath, h[:])
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(rw).Encode(res); err != nil {
+		l.c.Log.DebugContext(r.Context(), "failed to write get-entry-and-proof response", "err", err)
+	}
+}
+
+// extraData returns the RFC 6962 extra_data of an entry, which is the
+// certificate_chain of a X509ChainEntry, or a whole PrecertChainEntry.
+func (l *Log) extraData(ctx context.Context, e *sunlight.LogEntry) ([]byte, error) {
+	b := &cryptobyte.Builder{}
+	if e.IsPrecert {
+		b.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) {
+			b.AddBytes(e.PreCertificate)
+		})
+	}
+	var chainErr error
+	b.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) {
+		for _, fp := range e.ChainFingerprints {
+			issuer, err := l.issuer(ctx, fp)
+			if err != nil {
+				chainErr = err
+				return
+			}
+			b.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) {
+				b.AddBytes(issuer)
+			})
+		}
+	})
+	if chainErr != nil {
+		return nil, chainErr
+	}
+	return b.Bytes()
+}
+
+func (l *Log) getCheckpoint(rw http.ResponseWriter, r *http.Request) {
+	// The published checkpoint in the state is the same that was uploaded to
+	// the backend, including witness cosignatures.
+	state := l.state.Load()
+	rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	rw.Header().Set("Cache-Control", cacheControlShort)
+	if _, err := rw.Write(state.published); err != nil {
+		l.c.Log.DebugContext(r.Context(), "failed to write checkpoint response", "err", err)
+	}
+}
+
+func (l *Log) getTile(rw http.ResponseWriter, r *http.Request) {
+	// Only serve canonical tile paths, so that requests can't reach arbitrary
+	// backend keys.
+	key := strings.TrimPrefix(r.URL.Path, "/")
+	tile, err := tlog.ParseTilePath("tile/8/" + strings.TrimPrefix(key, "tile/"))
+	if err != nil || tile.H != sunlight.TileHeight || sunlight.TilePath(tile) != key {
+		http.Error(rw, "invalid tile path", http.StatusNotFound)
+		return
+	}
+
+	var data []byte
+	if t, ok := l.state.Load().edgeTiles[tile.L]; ok && t.Tile == tile {
+		data = t.B
+	} else {
+		data, err = l.c.Backend.Fetch(r.Context(), key)
+		if errors.Is(err, fs.ErrNotExist) {
+			http.Error(rw, "tile not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			l.c.Log.WarnContext(r.Context(), "failed to fetch tile", "tile", key, "err", err)
+			http.Error(rw, "failed to fetch tile", http.StatusBadGateway)
+			return
+		}
+	}
+
+	rw.Header().Set("Content-Type", "application/octet-stream")
+	if tile.W == sunlight.TileWidth {
+		rw.Header().Set("Cache-Control", cacheControlImmutable)
+	} else {
+		rw.Header().Set("Cache-Control", cacheControlShort)
+	}
+	// Hash tiles are not compressible, and full data tiles are immutable, so
+	// their compressed form can be cached.
+	if tile.L != -1 {
+		_, err = rw.Write(data)
+	} else if tile.W == sunlight.TileWidth {
+		err = l.writeCompressible(rw, r, data, key)
+	} else {
+		err = l.writeCompressible(rw, r, data, "")
+	}
+	if err != nil {
+		l.c.Log.DebugContext(r.Context(), "failed to write tile response", "err", err)
+	}
+}
+
+func (l *Log) getIssuer(rw http.ResponseWriter, r *http.Request) {
+	var fingerprint [32]byte
+	fp := r.PathValue("fingerprint")
+	if len(fp) != hex.EncodedLen(len(fingerprint)) || strings.ToLower(fp) != fp {
+		http.Error(rw, "invalid issuer fingerprint", http.StatusNotFound)
+		return
+	}
+	if _, err := hex.Decode(fingerprint[:], []byte(fp)); err != nil {
+		http.Error(rw, "invalid issuer fingerprint", http.StatusNotFound)
+		return
+	}
+
+	issuer, err := l.issuer(r.Context(), fingerprint)
+	if errors.Is(err, fs.ErrNotExist) {
+		http.Error(rw, "issuer not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		l.c.Log.WarnContext(r.Context(), "failed to fetch issuer", "fingerprint", fp, "err", err)
+		http.Error(rw, "failed to fetch issuer", http.StatusBadGateway)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/pkix-cert")
+	rw.Header().Set("Cache-Control", cacheControlImmutable)
+	if _, err := rw.Write(issuer); err != nil {
+		l.c.Log.DebugContext(r.Context(), "failed to write issuer response", "err", err)
+	}
+}
+
+// parseIntParam parses a required non-negative integer query parameter.
+func parseIntParam(r *http.Request, name string) (int64, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return 0, fmt.Errorf("missing %q parameter", name)
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %q parameter %q", name, v)
+	}
+	return n, nil
+}
@@ -0,0 +1,288 @@
+package ctlog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sync/errgroup"
+)
+
+// LocalBackend is a Backend that stores objects as files in a directory, for
+// small and development deployments.
+//
+// Objects are written to a temporary file and renamed into place, so partial
+// objects are never visible, and both the file and its directory are synced
+// before Upload returns. Objects are stored uncompressed. UploadOptions.CreateOnly
+// is supported by hard-linking the temporary file into place instead.
+type LocalBackend struct {
+	root     string
+	duration prometheus.Summary
+	log      *slog.Logger
+}
+
+func NewLocalBackend(ctx context.Context, root string, l *slog.Logger) (*LocalBackend, error) {
+	duration := prometheus.NewSummary(
+		prometheus.SummaryOpts{
+			Name:       "local_upload_duration_seconds",
+			Help:       "Duration of local filesystem uploads and batch uploads, including syncs.",
+			Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
+			MaxAge:     1 * time.Minute,
+			AgeBuckets: 6,
+		},
+	)
+
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve local backend directory: %w", err)
+	}
+	if fi, err := os.Stat(root); err != nil {
+		return nil, fmt.Errorf("failed to open local backend directory: %w", err)
+	} else if !fi.IsDir() {
+		return nil, fmt.Errorf("local backend path %q is not a directory", root)
+	}
+
+	return &LocalBackend{
+		root:     root,
+		duration: duration,
+		log:      l,
+	}, nil
+}
+
+var _ ListDeleteBackend = &LocalBackend{}
+var _ PingBackend = &LocalBackend{}
+var _ RangeBackend = &LocalBackend{}
+var _ BatchBackend = &LocalBackend{}
+var _ CreateOnlyBackend = &LocalBackend{}
+
+func (b *LocalBackend) SupportsCreateOnly() bool { return true }
+
+// path returns the file path for key, which must be a valid slash-separated
+// relative path without "." or ".." elements.
+func (b *LocalBackend) path(key string) (string, error) {
+	if !fs.ValidPath(key) || key == "." {
+		return "", fmtErrorf("invalid key %q", key)
+	}
+	return filepath.Join(b.root, filepath.FromSlash(key)), nil
+}
+
+func (b *LocalBackend) Upload(ctx context.Context, key string, data []byte, opts *UploadOptions) error {
+	return b.BatchUpload(ctx, []Object{{Key: key, Data: data, Opts: opts}})
+}
+
+// BatchUpload writes and syncs all objects to temporary files concurrently,
+// renames them into place, and then syncs each of their directories once.
+func (b *LocalBackend) BatchUpload(ctx context.Context, objects []Object) error {
+	defer prometheus.NewTimer(b.duration).ObserveDuration()
+	paths := make([]string, len(objects))
+	temps := make([]string, len(objects))
+	defer func() {
+		for _, name := range temps {
+			if name != "" {
+				os.Remove(name) // no-op after a successful rename
+			}
+		}
+	}()
+	// Check all keys before starting any write, so that an invalid one can't
+	// return while writes are still running.
+	for i, o := range objects {
+		path, err := b.path(o.Key)
+		if err != nil {
+			return err
+		}
+		paths[i] = path
+	}
+	g := &errgroup.Group{}
+	g.SetLimit(uploadLimitFromContext(ctx).limit)
+	for i, o := range objects {
+		g.Go(func() error {
+			name, err := writeTemp(o.Key, paths[i], o.Data)
+			temps[i] = name
+			return err
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return err
+	}
+
+	dirs := make(map[string]bool)
+	for i, o := range objects {
+		if o.Opts != nil && o.Opts.CreateOnly {
+			// Unlike rename, link fails if the target exists. The temporary
+			// file is removed by the deferred cleanup.
+			if err := os.Link(temps[i], paths[i]); errors.Is(err, fs.ErrExist) {
+				return fmtErrorf("failed to link %q into place: %w", o.Key, ErrObjectExists)
+			} else if err != nil {
+				return fmtErrorf("failed to link %q into place: %w", o.Key, err)
+			}
+		} else if err := os.Rename(temps[i], paths[i]); err != nil {
+			return fmtErrorf("failed to rename %q into place: %w", o.Key, err)
+		}
+		dirs[filepath.Dir(paths[i])] = true
+	}
+	// Sync the directories too, so that the renames are durable.
+	g = &errgroup.Group{}
+	for dir := range dirs {
+		g.Go(func() error {
+			if err := syncDir(dir); err != nil {
+				return fmtErrorf("failed to sync directory %q: %w", dir, err)
+			}
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return err
+	}
+	for _, o := range objects {
+		b.log.DebugContext(ctx, "local upload", "key", o.Key, "size", len(o.Data))
+	}
+	return nil
+}
+
+// writeTemp writes and syncs data to a temporary file in the directory of
+// path, and returns its name. The name is returned even on error, if the file
+// was created, so it can be removed.
+func writeTemp(key, path string, data []byte) (string, error) {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmtErrorf("failed to create directory for %q: %w", key, err)
+	}
+	f, err := os.CreateTemp(dir, ".upload-*")
+	if err != nil {
+		return "", fmtErrorf("failed to create temporary file for %q: %w", key, err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return f.Name(), fmtErrorf("failed to write %q: %w", key, err)
+	}
+	if err := f.Chmod(0o644); err != nil {
+		f.Close()
+		return f.Name(), fmtErrorf("failed to write %q: %w", key, err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return f.Name(), fmtErrorf("failed to sync %q: %w", key, err)
+	}
+	if err := f.Close(); err != nil {
+		return f.Name(), fmtErrorf("failed to write %q: %w", key, err)
+	}
+	return f.Name(), nil
+}
+
+func syncDir(path string) error {
+	d, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	if err := d.Sync(); err != nil {
+		d.Close()
+		return err
+	}
+	return d.Close()
+}
+
+func (b *LocalBackend) Fetch(ctx context.Context, key string) ([]byte, error) {
+	path, err := b.path(key)
+	if err != nil {
+		return nil, err
+	}
+	// os.ReadFile errors for missing files wrap fs.ErrNotExist.
+	data, err := os.ReadFile(path)
+	if err != nil {
+		b.log.DebugContext(ctx, "local fetch", "key", key, "err", err)
+		return nil, fmtErrorf("failed to fetch %q: %w", key, err)
+	}
+	return data, nil
+}
+
+func (b *LocalBackend) SupportsRanges(key string) bool { return true }
+
+func (b *LocalBackend) FetchRange(ctx context.Context, key string, offset, length int64) ([]byte, error) {
+	path, err := b.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		b.log.DebugContext(ctx, "local fetch", "key", key, "err", err)
+		return nil, fmtErrorf("failed to fetch %q: %w", key, err)
+	}
+	defer f.Close()
+	data := make([]byte, length)
+	n, err := f.ReadAt(data, offset)
+	if err != nil && err != io.EOF {
+		return nil, fmtErrorf("failed to fetch range of %q: %w", key, err)
+	}
+	return data[:n], nil
+}
+
+func (b *LocalBackend) List(ctx context.Context, prefix string) ([]string, error) {
+	// Walk the deepest directory that contains all the matching keys.
+	dir, _ := path.Split(prefix)
+	var keys []string
+	err := fs.WalkDir(os.DirFS(b.root), path.Clean("./"+dir), func(key string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) {
+			return fs.SkipDir
+		}
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".") || !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		keys = append(keys, key)
+		return nil
+	})
+	if err != nil {
+		return nil, fmtErrorf("failed to list %q: %w", prefix, err)
+	}
+	return keys, nil
+}
+
+func (b *LocalBackend) Delete(ctx context.Context, key string) error {
+	path, err := b.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmtErrorf("failed to delete %q: %w", key, err)
+	}
+	b.log.DebugContext(ctx, "local delete", "key", key)
+	return nil
+}
+
+// healthKey is the object written and removed by Ping. It's outside the
+// tile/ and staging/ hierarchies, and hidden from List.
+const healthKey = "health/.ping"
+
+// Ping writes and removes a small file, to check that the directory is
+// writable.
+func (b *LocalBackend) Ping(ctx context.Context) error {
+	path, err := b.path(healthKey)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmtErrorf("failed to ping local backend: %w", err)
+	}
+	if err := os.WriteFile(path, []byte("ok"), 0644); err != nil {
+		return fmtErrorf("failed to ping local backend: %w", err)
+	}
+	if err := os.Remove(path); err != nil {
+		return fmtErrorf("failed to ping local backend: %w", err)
+	}
+	return nil
+}
+
+func (b *LocalBackend) Metrics() []prometheus.Collector {
+	return []prometheus.Collector{b.duration}
+}
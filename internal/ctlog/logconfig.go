@@ -0,0 +1,162 @@
+package ctlog
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+	"time"
+
+	"filippo.io/sunlight"
+	"golang.org/x/mod/sumdb/note"
+)
+
+// logConfigKey is the object storage key of the signed log configuration.
+const logConfigKey = "log-config"
+
+var optsLogConfig = &UploadOptions{ContentType: "text/plain; charset=utf-8"}
+
+// logConfigHeader is the first line of the log configuration note text, so
+// that it can't be confused with other notes signed by Config.WitnessKey.
+const logConfigHeader = "sunlight log config v1\n"
+
+// logConfig is the part of Config that must not change over the life of a log.
+//
+// CreateLog stores it at logConfigKey as a note signed with Config.WitnessKey,
+// with logConfigHeader followed by the JSON encoding as text, and LoadLog
+// refuses to load the log if Config doesn't match it. The accepted roots are
+// not included, since they can change.
+//
+// TileHeight is always sunlight.TileHeight, so the check can only fail for a
+// configuration that was signed by a different implementation or version.
+type logConfig struct {
+	Origin        string    `json:"origin"`
+	TileHeight    int       `json:"tile_height"`
+	NotAfterStart time.Time `json:"not_after_start"`
+	NotAfterLimit time.Time `json:"not_after_limit"`
+}
+
+func newLogConfig(c *Config) logConfig {
+	return logConfig{
+		Origin:        c.Name,
+		TileHeight:    sunlight.TileHeight,
+		NotAfterStart: c.NotAfterStart.UTC(),
+		NotAfterLimit: c.NotAfterLimit.UTC(),
+	}
+}
+
+// uploadLogConfig signs the log configuration of c, and uploads it.
+//
+// Unless force is set, it's uploaded with UploadOptions.CreateOnly if the
+// Backend supports it, and an existing log configuration is only accepted if
+// it matches c.
+func uploadLogConfig(ctx context.Context, c *Config, force bool) error {
+	text, err := json.MarshalIndent(newLogConfig(c), "", "  ")
+	if err != nil {
+		return fmt.Errorf("couldn't marshal log config: %w", err)
+	}
+	signed, err := signLogConfig(c, logConfigHeader+string(text)+"\n")
+	if err != nil {
+		return err
+	}
+	opts := optsLogConfig
+	if !force && supportsCreateOnly(c.Backend) {
+		o := *optsLogConfig
+		o.CreateOnly = true
+		opts = &o
+	}
+	err = c.Backend.Upload(ctx, logConfigKey, signed, opts)
+	if errors.Is(err, ErrObjectExists) {
+		// A retried upload, or a concurrent one for the same log.
+		b, err := c.Backend.Fetch(ctx, logConfigKey)
+		if err != nil {
+			return fmt.Errorf("couldn't fetch existing log config: %w", err)
+		}
+		if bytes.Equal(b, signed) {
+			return nil
+		}
+		if _, err := verifyLogConfig(c, b); err != nil {
+			return fmt.Errorf("a different log config is already in object storage: %w", err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("couldn't upload log config: %w", err)
+	}
+	return nil
+}
+
+// signLogConfig signs text as a note with Config.WitnessKey.
+func signLogConfig(c *Config, text string) ([]byte, error) {
+	s, err := newEd25519Signer(c.Name, c.WitnessKey)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't construct Ed25519 signer: %w", err)
+	}
+	signed, err := note.Sign(&note.Note{Text: text}, s)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't sign log config: %w", err)
+	}
+	return signed, nil
+}
+
+// checkLogConfig fetches the log configuration from object storage, verifies
+// it, checks that c matches it, and returns it. If it's missing and
+// Config.CreateMissingLogConfig is set, it uploads it instead.
+func checkLogConfig(ctx context.Context, c *Config) (logConfig, error) {
+	b, err := c.Backend.Fetch(ctx, logConfigKey)
+	if errors.Is(err, fs.ErrNotExist) && c.CreateMissingLogConfig {
+		c.Log.WarnContext(ctx, "log config missing from object storage, creating it")
+		return newLogConfig(c), uploadLogConfig(ctx, c, false)
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		return logConfig{}, errors.New("log config missing from object storage, " +
+			"set Config.CreateMissingLogConfig to create it for a log created before it was introduced")
+	}
+	if err != nil {
+		return logConfig{}, fmt.Errorf("couldn't fetch log config: %w", err)
+	}
+	return verifyLogConfig(c, b)
+}
+
+// verifyLogConfig verifies the signed log configuration b, checks that c
+// matches it, and returns it.
+func verifyLogConfig(c *Config, b []byte) (logConfig, error) {
+	vk, err := note.NewEd25519VerifierKey(c.Name, c.WitnessKey.Public().(ed25519.PublicKey))
+	if err != nil {
+		return logConfig{}, fmt.Errorf("couldn't construct verifier key: %w", err)
+	}
+	v, err := note.NewVerifier(vk)
+	if err != nil {
+		return logConfig{}, fmt.Errorf("couldn't construct Ed25519 verifier: %w", err)
+	}
+	n, err := note.Open(b, note.VerifierList(v))
+	if err != nil {
+		return logConfig{}, fmt.Errorf("couldn't verify log config signature: %w", err)
+	}
+	text, ok := strings.CutPrefix(n.Text, logConfigHeader)
+	if !ok {
+		return logConfig{}, errors.New("log config has an unknown format")
+	}
+	var stored logConfig
+	d := json.NewDecoder(strings.NewReader(text))
+	if err := d.Decode(&stored); err != nil {
+		return logConfig{}, fmt.Errorf("couldn't parse log config: %w", err)
+	}
+
+	exp := newLogConfig(c)
+	switch {
+	case stored.Origin != exp.Origin:
+		return logConfig{}, fmt.Errorf("log config has name %q, not %q", stored.Origin, exp.Origin)
+	case stored.TileHeight != exp.TileHeight:
+		return logConfig{}, fmt.Errorf("log config has tile height %d, not %d", stored.TileHeight, exp.TileHeight)
+	case !stored.NotAfterStart.Equal(exp.NotAfterStart):
+		return logConfig{}, fmt.Errorf("log config has NotAfterStart %v, not %v", stored.NotAfterStart, exp.NotAfterStart)
+	case !stored.NotAfterLimit.Equal(exp.NotAfterLimit):
+		return logConfig{}, fmt.Errorf("log config has NotAfterLimit %v, not %v", stored.NotAfterLimit, exp.NotAfterLimit)
+	}
+	return stored, nil
+}
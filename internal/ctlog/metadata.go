@@ -0,0 +1,70 @@
+package ctlog
+
+import (
+	"crypto/x509"
+	"fmt"
+	"time"
+)
+
+// Metadata describes a log for submission to CT log programs. It marshals to
+// JSON like an entry of the "tiled_logs" array of an operator in the log_list
+// v3 schema used by the Google and Apple log lists.
+type Metadata struct {
+	Description string `json:"description"`
+
+	// LogID is the SHA-256 of Key, as in the SCTs issued by the log.
+	LogID []byte `json:"log_id"`
+
+	// Key is the DER encoded SubjectPublicKeyInfo of the log.
+	Key []byte `json:"key"`
+
+	// SubmissionURL is the prefix of the RFC 6962 submission endpoints, and
+	// MonitoringURL is the prefix of the Static CT API monitoring endpoints.
+	SubmissionURL string `json:"submission_url"`
+	MonitoringURL string `json:"monitoring_url"`
+
+	// MMD is the Maximum Merge Delay, in seconds.
+	MMD int64 `json:"mmd"`
+
+	TemporalInterval TemporalInterval `json:"temporal_interval"`
+}
+
+// TemporalInterval is the range of certificate NotAfter dates accepted by a
+// log, from NotAfterStart (inclusive) to NotAfterLimit (exclusive).
+type TemporalInterval struct {
+	StartInclusive time.Time `json:"start_inclusive"`
+	EndExclusive   time.Time `json:"end_exclusive"`
+}
+
+// Metadata returns the metadata of the log. Its name and temporal interval come
+// from the log configuration in object storage.
+func (l *Log) Metadata() (*Metadata, error) {
+	spki, err := x509.MarshalPKIXPublicKey(l.c.Key.Public())
+	if err != nil {
+		return nil, fmt.Errorf("couldn't marshal public key: %w", err)
+	}
+	submissionURL := l.c.SubmissionURL
+	if submissionURL == "" {
+		submissionURL = "https://" + l.logConfig.Origin + "/"
+	}
+	monitoringURL := l.c.MonitoringURL
+	if monitoringURL == "" {
+		monitoringURL = submissionURL
+	}
+	mmd := l.c.MMD
+	if mmd == 0 {
+		mmd = 24 * time.Hour
+	}
+	return &Metadata{
+		Description:   l.logConfig.Origin,
+		LogID:         l.logID[:],
+		Key:           spki,
+		SubmissionURL: submissionURL,
+		MonitoringURL: monitoringURL,
+		MMD:           int64(mmd / time.Second),
+		TemporalInterval: TemporalInterval{
+			StartInclusive: l.logConfig.NotAfterStart,
+			EndExclusive:   l.logConfig.NotAfterLimit,
+		},
+	}, nil
+}
@@ -13,33 +13,64 @@ import (
 type metrics struct {
 	ReqCount    *prometheus.CounterVec
 	ReqInFlight *prometheus.GaugeVec
-	ReqDuration *prometheus.SummaryVec
+	ReqDuration *prometheus.HistogramVec
+	ReqPanics   *prometheus.CounterVec
 
 	SeqCount        *prometheus.CounterVec
 	SeqPoolSize     prometheus.Summary
 	SeqDuration     prometheus.Summary
 	SeqLeafSize     prometheus.Summary
 	SeqTiles        prometheus.Counter
+	SeqTileBytes    prometheus.Counter
+	SeqPhases       *prometheus.SummaryVec
 	SeqDataTileSize prometheus.Summary
+	SeqClockSkew    prometheus.Counter
+	SeqPaused       prometheus.Gauge
+	SeqEvictions    prometheus.Counter
+
+	UploadsInFlight prometheus.Gauge
+
+	PoolEntries prometheus.Gauge
+	PoolBytes   prometheus.Gauge
 
 	TreeTime prometheus.Gauge
 	TreeSize prometheus.Gauge
+	TreeAge  prometheus.GaugeFunc
+
+	TreeRepairedTiles prometheus.Counter
 
-	ConfigRoots prometheus.Gauge
-	ConfigStart prometheus.Gauge
-	ConfigEnd   prometheus.Gauge
+	ConfigRoots        prometheus.Gauge
+	ConfigRootsChanges *prometheus.CounterVec
+	ConfigStart        prometheus.Gauge
+	ConfigEnd          prometheus.Gauge
 
 	Issuers prometheus.Gauge
 
-	AddChainCount *prometheus.CounterVec
-	AddChainWait  prometheus.Summary
+	AddChainCount    *prometheus.CounterVec
+	AddChainValidate prometheus.Summary
+	AddChainWait     prometheus.Summary
+	AddChainPoolFull prometheus.Counter
+
+	IssuerQuotaExceeded      *prometheus.CounterVec
+	AddChainPolicyRejections *prometheus.CounterVec
 
 	CacheGetDuration prometheus.Summary
 	CachePutDuration prometheus.Summary
 	CachePutErrors   prometheus.Counter
+
+	GCDeletedTiles prometheus.Counter
+	GCErrors       prometheus.Counter
+
+	BackendProbeSuccess prometheus.Gauge
+
+	WitnessRequests       *prometheus.CounterVec
+	WitnessQuorumFailures prometheus.Counter
 }
 
-func initMetrics() metrics {
+// initMetrics returns the metrics of a Log. treeAge is called at collection
+// time, so that the age of the tree head is current even if rounds are failing
+// or not running at all.
+func initMetrics(treeAge func() float64) metrics {
 	return metrics{
 		ReqInFlight: prometheus.NewGaugeVec(
 			prometheus.GaugeOpts{
@@ -55,13 +86,18 @@ func initMetrics() metrics {
 			},
 			[]string{"endpoint", "code"},
 		),
-		ReqDuration: prometheus.NewSummaryVec(
-			prometheus.SummaryOpts{
-				Name:       "http_request_duration_seconds",
-				Help:       "HTTP request serving latencies in seconds, by endpoint.",
-				Objectives: map[float64]float64{0.5: 0.05, 0.75: 0.025, 0.9: 0.01, 0.99: 0.001},
-				MaxAge:     1 * time.Minute,
-				AgeBuckets: 6,
+		ReqDuration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "http_request_duration_seconds",
+				Help:    "HTTP request serving latencies in seconds, by endpoint and response code.",
+				Buckets: []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+			},
+			[]string{"endpoint", "code"},
+		),
+		ReqPanics: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "http_panics_total",
+				Help: "HTTP handler panics recovered and served as 500s, by endpoint.",
 			},
 			[]string{"endpoint"},
 		),
@@ -106,6 +142,22 @@ func initMetrics() metrics {
 				Help: "Number of tiles uploaded in successful rounds, including partials.",
 			},
 		),
+		SeqTileBytes: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "sequencing_uploaded_tiles_bytes_total",
+				Help: "Size of tiles uploaded in successful rounds, including partials.",
+			},
+		),
+		SeqPhases: prometheus.NewSummaryVec(
+			prometheus.SummaryOpts{
+				Name:       "sequencing_phase_duration_seconds",
+				Help:       "Duration of the completed phases of sequencing rounds: hash, stage, sign, lock, upload, witness, and checkpoint.",
+				Objectives: map[float64]float64{0.5: 0.05, 0.75: 0.025, 0.9: 0.01, 0.99: 0.001},
+				MaxAge:     1 * time.Minute,
+				AgeBuckets: 6,
+			},
+			[]string{"phase"},
+		),
 		SeqDataTileSize: prometheus.NewSummary(
 			prometheus.SummaryOpts{
 				Name:       "sequencing_data_tiles_bytes",
@@ -116,6 +168,34 @@ func initMetrics() metrics {
 			},
 		),
 
+		SeqClockSkew: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "sequencing_clock_skew_total",
+				Help: "Number of rounds whose timestamp was moved past the previous tree head, because the clock went backwards.",
+			},
+		),
+
+		SeqEvictions: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "sequencing_evicted_entries_total",
+				Help: "Number of pending entries evicted from the pool after repeatedly failing their rounds.",
+			},
+		),
+
+		SeqPaused: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "sequencing_paused",
+				Help: "Whether sequencing is paused by an operator, 1 if paused and 0 otherwise.",
+			},
+		),
+
+		UploadsInFlight: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "sequencing_uploads_in_flight",
+				Help: "Number of tile uploads in progress, for backends without batch uploads.",
+			},
+		),
+
 		TreeTime: prometheus.NewGauge(
 			prometheus.GaugeOpts{
 				Name: "tree_timestamp_seconds",
@@ -128,6 +208,19 @@ func initMetrics() metrics {
 				Help: "Size of the latest published tree head.",
 			},
 		),
+		TreeAge: prometheus.NewGaugeFunc(
+			prometheus.GaugeOpts{
+				Name: "tree_age_seconds",
+				Help: "Time since the timestamp of the latest published tree head.",
+			},
+			treeAge,
+		),
+		TreeRepairedTiles: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "tree_repaired_edge_tiles_total",
+				Help: "Number of missing or corrupted level 0 edge tiles recomputed from the data tile on startup.",
+			},
+		),
 
 		ConfigRoots: prometheus.NewGauge(
 			prometheus.GaugeOpts{
@@ -135,6 +228,13 @@ func initMetrics() metrics {
 				Help: "Number of accepted roots.",
 			},
 		),
+		ConfigRootsChanges: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "config_roots_changes_total",
+				Help: "Number of accepted roots added or removed by reloads, by change.",
+			},
+			[]string{"change"},
+		),
 		ConfigStart: prometheus.NewGauge(
 			prometheus.GaugeOpts{
 				Name: "config_notafter_start_timestamp_seconds",
@@ -148,6 +248,19 @@ func initMetrics() metrics {
 			},
 		),
 
+		PoolEntries: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "sequencing_current_pool_entries",
+				Help: "Number of entries in the current pool, waiting to be sequenced.",
+			},
+		),
+		PoolBytes: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "sequencing_current_pool_bytes",
+				Help: "Total size of the Merkle tree and data tile leaves of the entries in the current pool.",
+			},
+		),
+
 		Issuers: prometheus.NewGauge(
 			prometheus.GaugeOpts{
 				Name: "issuers_cache_total",
@@ -162,6 +275,15 @@ func initMetrics() metrics {
 			},
 			[]string{"error", "issuer", "root", "precert", "preissuer", "chain_len", "source", "reused"},
 		),
+		AddChainValidate: prometheus.NewSummary(
+			prometheus.SummaryOpts{
+				Name:       "addchain_validation_seconds",
+				Help:       "Duration of add-[pre-]chain parsing and validation, excluding rejected chains.",
+				Objectives: map[float64]float64{0.5: 0.05, 0.75: 0.025, 0.9: 0.01, 0.99: 0.001},
+				MaxAge:     1 * time.Minute,
+				AgeBuckets: 6,
+			},
+		),
 		AddChainWait: prometheus.NewSummary(
 			prometheus.SummaryOpts{
 				Name:       "addchain_wait_seconds",
@@ -171,6 +293,27 @@ func initMetrics() metrics {
 				AgeBuckets: 6,
 			},
 		),
+		AddChainPoolFull: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "addchain_pool_full_total",
+				Help: "Number of add-[pre-]chain requests rejected because the pool was full.",
+			},
+		),
+
+		IssuerQuotaExceeded: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "addchain_issuer_quota_exceeded_total",
+				Help: "Number of add-[pre-]chain requests rejected by issuer quotas, by issuer.",
+			},
+			[]string{"issuer"},
+		),
+		AddChainPolicyRejections: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "addchain_policy_rejections_total",
+				Help: "Number of add-[pre-]chain requests rejected by a submission policy, by policy.",
+			},
+			[]string{"policy"},
+		),
 
 		CacheGetDuration: prometheus.NewSummary(
 			prometheus.SummaryOpts{
@@ -196,9 +339,47 @@ func initMetrics() metrics {
 				Help: "Number of failed deduplication cache inserts.",
 			},
 		),
+
+		GCDeletedTiles: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "gc_deleted_partial_tiles_total",
+				Help: "Number of superseded partial tiles deleted from the backend.",
+			},
+		),
+		GCErrors: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "gc_errors_total",
+				Help: "Number of failed partial tile garbage collection runs.",
+			},
+		),
+		BackendProbeSuccess: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "backend_probe_success",
+				Help: "Whether the last backend health probe succeeded (1) or failed (0).",
+			},
+		),
+
+		WitnessRequests: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "witness_cosignature_requests_total",
+				Help: "Witness add-checkpoint requests, by witness and result (ok or error).",
+			},
+			[]string{"witness", "result"},
+		),
+		WitnessQuorumFailures: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "witness_quorum_failures_total",
+				Help: "Number of checkpoints that didn't collect the configured quorum of witness cosignatures.",
+			},
+		),
 	}
 }
 
+// treeAge returns the seconds since the timestamp of the published tree head.
+func (l *Log) treeAge() float64 {
+	return float64(timeNowUnixMilli(l.c)-l.state.Load().tree.Time) / 1000
+}
+
 func (l *Log) Metrics() []prometheus.Collector {
 	var collectors []prometheus.Collector
 	for i := 0; i < reflect.ValueOf(l.m).NumField(); i++ {
@@ -207,6 +388,27 @@ func (l *Log) Metrics() []prometheus.Collector {
 	return append(collectors, l.c.Backend.Metrics()...)
 }
 
+// phaseTimer returns a timer for a phase of a sequencing round, and records it
+// as the current phase. Its ObserveDuration must be called only if the phase
+// completes.
+func (l *Log) phaseTimer(phase string) *prometheus.Timer {
+	l.seqPhase = phase
+	return prometheus.NewTimer(l.m.SeqPhases.WithLabelValues(phase))
+}
+
+// registerMetrics registers all collectors with r, or none of them.
+func registerMetrics(r prometheus.Registerer, collectors []prometheus.Collector) error {
+	for i, c := range collectors {
+		if err := r.Register(c); err != nil {
+			for _, c := range collectors[:i] {
+				r.Unregister(c)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
 type categoryError struct {
 	category string
 	err      error
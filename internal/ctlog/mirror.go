@@ -0,0 +1,237 @@
+package ctlog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// MirrorBackend is a Backend that writes every object to multiple replicas,
+// and reads from the first one that has it.
+type MirrorBackend struct {
+	replicas  []Backend
+	quorum    int
+	failures  *prometheus.CounterVec
+	fallbacks prometheus.Counter
+	log       *slog.Logger
+}
+
+// NewMirrorBackend returns a MirrorBackend that replicates to primary and
+// mirrors. Upload succeeds only if at least quorum replicas succeed, or all of
+// them if quorum is zero.
+//
+// Only the metrics of the primary are returned by Metrics, since replicas of
+// the same type would have conflicting metric names.
+func NewMirrorBackend(primary Backend, mirrors []Backend, quorum int, l *slog.Logger) (*MirrorBackend, error) {
+	replicas := append([]Backend{primary}, mirrors...)
+	if quorum == 0 {
+		quorum = len(replicas)
+	}
+	if quorum < 0 || quorum > len(replicas) {
+		return nil, fmt.Errorf("invalid quorum %d for %d replicas", quorum, len(replicas))
+	}
+	return &MirrorBackend{
+		replicas: replicas,
+		quorum:   quorum,
+		failures: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "mirror_upload_failures_total",
+				Help: "Uploads that failed on a replica, by replica index (0 is the primary).",
+			},
+			[]string{"replica"},
+		),
+		fallbacks: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "mirror_fetch_fallbacks_total",
+				Help: "Fetches that were served by a replica other than the primary.",
+			},
+		),
+		log: l,
+	}, nil
+}
+
+var _ ListDeleteBackend = &MirrorBackend{}
+var _ PingBackend = &MirrorBackend{}
+var _ RangeBackend = &MirrorBackend{}
+var _ CreateOnlyBackend = &MirrorBackend{}
+var _ BatchBackend = &MirrorBackend{}
+
+// MirrorError is returned by MirrorBackend.Upload if fewer than the quorum of
+// replicas succeeded. It lists the replicas that are behind and need repair.
+type MirrorError struct {
+	// Key is the uploaded key, or empty for a BatchUpload.
+	Key string
+	// Behind are the indexes of the replicas that failed, where 0 is the
+	// primary, and Errs the corresponding errors.
+	Behind []int
+	Errs   []error
+}
+
+func (e *MirrorError) Error() string {
+	var replicas []string
+	for i, r := range e.Behind {
+		replicas = append(replicas, fmt.Sprintf("%d (%v)", r, e.Errs[i]))
+	}
+	if e.Key == "" {
+		return fmt.Sprintf("batch not stored on replicas %s", strings.Join(replicas, ", "))
+	}
+	return fmt.Sprintf("object %q not stored on replicas %s", e.Key, strings.Join(replicas, ", "))
+}
+
+func (e *MirrorError) Unwrap() []error { return e.Errs }
+
+func (m *MirrorBackend) Upload(ctx context.Context, key string, data []byte, opts *UploadOptions) error {
+	return m.replicate(ctx, key, func(r Backend) error {
+		return r.Upload(ctx, key, data, opts)
+	})
+}
+
+func (m *MirrorBackend) BatchUpload(ctx context.Context, objects []Object) error {
+	return m.replicate(ctx, "", func(r Backend) error {
+		return batchUpload(ctx, r, objects)
+	})
+}
+
+// replicate calls upload concurrently for each replica, and checks the quorum.
+// key is the uploaded key, or empty for a batch upload.
+func (m *MirrorBackend) replicate(ctx context.Context, key string, upload func(Backend) error) error {
+	errs := make([]error, len(m.replicas))
+	var wg sync.WaitGroup
+	for i, r := range m.replicas {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = upload(r)
+		}()
+	}
+	wg.Wait()
+
+	merr := &MirrorError{Key: key}
+	for i, err := range errs {
+		if err != nil {
+			merr.Behind = append(merr.Behind, i)
+			merr.Errs = append(merr.Errs, err)
+			m.failures.WithLabelValues(strconv.Itoa(i)).Inc()
+		}
+	}
+	if len(merr.Behind) == 0 {
+		return nil
+	}
+	if len(m.replicas)-len(merr.Behind) < m.quorum {
+		return fmtErrorf("mirrored upload failed: %w", merr)
+	}
+	// The quorum was reached, so the upload succeeded, but the lagging
+	// replicas need to be repaired out of band.
+	m.log.WarnContext(ctx, "mirrored upload failed on some replicas", "key", key,
+		"behind", merr.Behind, "err", merr)
+	return nil
+}
+
+// Fetch tries each replica in order, and returns the first successful result.
+// If all of them fail, it returns the first error that doesn't wrap
+// fs.ErrNotExist, if any.
+func (m *MirrorBackend) Fetch(ctx context.Context, key string) ([]byte, error) {
+	var firstErr error
+	for i, r := range m.replicas {
+		data, err := r.Fetch(ctx, key)
+		if err == nil {
+			if i > 0 {
+				m.fallbacks.Inc()
+				m.log.DebugContext(ctx, "fetched from mirror", "key", key, "replica", i)
+			}
+			return data, nil
+		}
+		if firstErr == nil || errors.Is(firstErr, fs.ErrNotExist) && !errors.Is(err, fs.ErrNotExist) {
+			firstErr = err
+		}
+	}
+	return nil, firstErr
+}
+
+// FetchRange is like Fetch, for a range of the object.
+func (m *MirrorBackend) FetchRange(ctx context.Context, key string, offset, length int64) ([]byte, error) {
+	var firstErr error
+	for i, r := range m.replicas {
+		data, err := fetchRange(ctx, r, key, offset, length)
+		if err == nil {
+			if i > 0 {
+				m.fallbacks.Inc()
+				m.log.DebugContext(ctx, "fetched from mirror", "key", key, "replica", i)
+			}
+			return data, nil
+		}
+		if firstErr == nil || errors.Is(firstErr, fs.ErrNotExist) && !errors.Is(err, fs.ErrNotExist) {
+			firstErr = err
+		}
+	}
+	return nil, firstErr
+}
+
+// List returns the keys listed by the first replica that succeeds.
+func (m *MirrorBackend) List(ctx context.Context, prefix string) ([]string, error) {
+	var firstErr error
+	for _, r := range m.replicas {
+		keys, err := listObjects(ctx, r, prefix)
+		if err == nil {
+			return keys, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return nil, firstErr
+}
+
+// Delete removes key from all replicas, and fails if any of them fails.
+func (m *MirrorBackend) Delete(ctx context.Context, key string) error {
+	var errs []error
+	for _, r := range m.replicas {
+		if err := deleteObject(ctx, r, key); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Ping succeeds if at least the quorum of replicas respond successfully, like
+// Upload.
+func (m *MirrorBackend) Ping(ctx context.Context) error {
+	var errs []error
+	for i, r := range m.replicas {
+		if err := pingBackend(ctx, r); err != nil {
+			errs = append(errs, fmt.Errorf("replica %d: %w", i, err))
+		}
+	}
+	if len(m.replicas)-len(errs) < m.quorum {
+		return errors.Join(errs...)
+	}
+	return nil
+}
+
+// SupportsCreateOnly reports whether the primary supports CreateOnly. Mirrors
+// that don't support it overwrite existing objects.
+// SupportsRanges reports whether every replica supports ranges of key, since
+// FetchRange might fall back to any of them.
+func (m *MirrorBackend) SupportsRanges(key string) bool {
+	for _, r := range m.replicas {
+		if !supportsRanges(r, key) {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *MirrorBackend) SupportsCreateOnly() bool {
+	return supportsCreateOnly(m.replicas[0])
+}
+
+func (m *MirrorBackend) Metrics() []prometheus.Collector {
+	return append([]prometheus.Collector{m.failures, m.fallbacks}, m.replicas[0].Metrics()...)
+}
@@ -0,0 +1,62 @@
+package ctlog
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/google/certificate-transparency-go/x509"
+)
+
+// A SubmissionPolicy decides whether to accept a submission, after its chain
+// was verified and before it's added to the pool.
+type SubmissionPolicy interface {
+	// Name identifies the policy in metrics and error messages.
+	Name() string
+
+	// Check returns an error if the submission must be rejected. chain is the
+	// verified chain, starting with the leaf and ending with a root.
+	Check(ctx context.Context, chain []*x509.Certificate, isPrecert bool) error
+}
+
+// IssuerDenylist is a SubmissionPolicy that rejects chains that include any
+// of the listed CA certificates, keyed by the SHA-256 hash of their
+// SubjectPublicKeyInfo.
+type IssuerDenylist map[[32]byte]bool
+
+func (IssuerDenylist) Name() string { return "issuer_denylist" }
+
+func (d IssuerDenylist) Check(ctx context.Context, chain []*x509.Certificate, isPrecert bool) error {
+	for _, c := range chain[1:] {
+		if h := sha256.Sum256(c.RawSubjectPublicKeyInfo); d[h] {
+			return fmt.Errorf("issuer %q (%x) is denied", c.Subject.String(), h)
+		}
+	}
+	return nil
+}
+
+// MinRSAKeySize is a SubmissionPolicy that rejects leaves with RSA keys
+// smaller than the given number of bits. Other key types are accepted.
+type MinRSAKeySize int
+
+func (MinRSAKeySize) Name() string { return "min_rsa_key_size" }
+
+func (n MinRSAKeySize) Check(ctx context.Context, chain []*x509.Certificate, isPrecert bool) error {
+	if k, ok := chain[0].PublicKey.(*rsa.PublicKey); ok && k.N.BitLen() < int(n) {
+		return fmt.Errorf("RSA key size %d is smaller than %d", k.N.BitLen(), n)
+	}
+	return nil
+}
+
+// checkPolicies applies Config.Policies in order, and returns the error of
+// the first one that rejects the submission.
+func (l *Log) checkPolicies(ctx context.Context, chain []*x509.Certificate, isPrecert bool) error {
+	for _, p := range l.c.Policies {
+		if err := p.Check(ctx, chain, isPrecert); err != nil {
+			l.m.AddChainPolicyRejections.WithLabelValues(p.Name()).Inc()
+			return withReason(reasonPolicy, fmtErrorf("rejected by policy: %s: %w", p.Name(), err))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package ctlog
+
+import (
+	"context"
+	"fmt"
+
+	"filippo.io/sunlight"
+	"golang.org/x/mod/sumdb/tlog"
+)
+
+// fetchRange calls b.FetchRange if b implements RangeBackend, and otherwise
+// fetches the whole object and returns it from offset.
+func fetchRange(ctx context.Context, b Backend, key string, offset, length int64) ([]byte, error) {
+	if rb, ok := b.(RangeBackend); ok {
+		return rb.FetchRange(ctx, key, offset, length)
+	}
+	data, err := b.Fetch(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return sliceFrom(data, offset), nil
+}
+
+// sliceFrom returns the bytes of data from offset to the end, as FetchRange
+// may when it had to read the whole object anyway, so that callers reading
+// the object in chunks don't fetch it again.
+func sliceFrom(data []byte, offset int64) []byte {
+	if offset >= int64(len(data)) {
+		return []byte{}
+	}
+	return data[offset:]
+}
+
+// supportsRanges reports whether b can read a range of key without reading
+// the whole object, which is what makes ranged reads worth it.
+func supportsRanges(b Backend, key string) bool {
+	rb, ok := b.(RangeBackend)
+	return ok && rb.SupportsRanges(key)
+}
+
+// proofHashReader returns a HashReader for the tree in state, to compute
+// proofs. It's a stateHashReader, unless Config.UnverifiedRangeProofs is set
+// and the Backend can read ranges of hash tiles, in which case it's a
+// rangeHashReader.
+func (l *Log) proofHashReader(ctx context.Context, state *logState) tlog.HashReader {
+	if l.c.UnverifiedRangeProofs && supportsRanges(l.c.Backend, "tile/0/000") {
+		return l.rangeHashReader(ctx, state)
+	}
+	return l.stateHashReader(ctx, state)
+}
+
+// rangeHashReader returns a HashReader for the tree in state like
+// stateHashReader, but that fetches from each full tile only the range of
+// hashes necessary to compute the requested ones.
+//
+// Unlike with stateHashReader, the fetched hashes are not verified against the
+// tree head, since that would require the whole tiles. A corrupted tile would
+// cause invalid proofs to be served, which clients reject, so it's only used
+// if enabled with Config.UnverifiedRangeProofs.
+func (l *Log) rangeHashReader(ctx context.Context, state *logState) tlog.HashReaderFunc {
+	return func(indexes []int64) ([]tlog.Hash, error) {
+		// Each stored hash is the root of a perfect subtree of hashes in a
+		// tile, which is the hash itself if it's at a tile level.
+		type location struct {
+			key          string // empty if the hash is in an edge tile
+			edge         []byte
+			start, count int64 // in hashes from the start of the tile
+		}
+		type span struct{ start, end int64 }
+		locs := make([]location, len(indexes))
+		spans := make(map[string]*span)
+		for i, index := range indexes {
+			level, n := tlog.SplitStoredHashIndex(index)
+			tileLevel, k := level/sunlight.TileHeight, level%sunlight.TileHeight
+			first, count := n<<k, int64(1)<<k
+			if first+count > state.tree.N>>(tileLevel*sunlight.TileHeight) {
+				return nil, fmt.Errorf("hash %d is not in the tree of size %d", index, state.tree.N)
+			}
+			tile := tlog.Tile{H: sunlight.TileHeight, L: tileLevel,
+				N: first / sunlight.TileWidth, W: sunlight.TileWidth}
+			loc := location{start: first % sunlight.TileWidth, count: count}
+			if edge, ok := state.edgeTiles[tileLevel]; ok && edge.N == tile.N {
+				loc.edge = edge.B
+				locs[i] = loc
+				continue
+			}
+			loc.key = sunlight.TilePath(tile)
+			locs[i] = loc
+			if s, ok := spans[loc.key]; ok {
+				s.start, s.end = min(s.start, loc.start), max(s.end, loc.start+loc.count)
+			} else {
+				spans[loc.key] = &span{loc.start, loc.start + loc.count}
+			}
+		}
+
+		data := make(map[string][]byte, len(spans))
+		for key, s := range spans {
+			length := (s.end - s.start) * tlog.HashSize
+			b, err := fetchRange(ctx, l.c.Backend, key, s.start*tlog.HashSize, length)
+			if err != nil {
+				return nil, fmtErrorf("couldn't fetch hashes from tile: %w", err)
+			}
+			if int64(len(b)) < length {
+				return nil, fmtErrorf("couldn't fetch hashes from tile: %s is too short", key)
+			}
+			data[key] = b[:length]
+		}
+
+		hashes := make([]tlog.Hash, len(indexes))
+		for i, loc := range locs {
+			b, start := loc.edge, loc.start
+			if loc.key != "" {
+				b, start = data[loc.key], loc.start-spans[loc.key].start
+			}
+			if int64(len(b)) < (start+loc.count)*tlog.HashSize {
+				return nil, fmt.Errorf("edge tile too short for hash %d", indexes[i])
+			}
+			hashes[i] = tileRootHash(b[start*tlog.HashSize : (start+loc.count)*tlog.HashSize])
+		}
+		return hashes, nil
+	}
+}
+
+// readEntryChunkSize is the size of the first range fetched by readEntryRange.
+const readEntryChunkSize = 16 << 10
+
+// readEntryRange reads the entry at index from a data tile, with ranged
+// fetches of doubling size, stopping as soon as the entry is parsed, which on
+// average avoids fetching half the tile.
+func (l *Log) readEntryRange(ctx context.Context, tile tlog.Tile, index int64) (*sunlight.LogEntry, error) {
+	key := sunlight.TilePath(tile)
+	var b []byte
+	var pos int // the offset in b of the next entry
+	next := tile.N * sunlight.TileWidth
+	chunk, eof := int64(readEntryChunkSize), false
+	for {
+		e, rest, err := sunlight.ReadTileLeaf(b[pos:])
+		if err == nil {
+			if e.LeafIndex != next {
+				return nil, fmt.Errorf("invalid data tile %v: leaf %d has index %d", tile, next, e.LeafIndex)
+			}
+			if next == index {
+				return e, nil
+			}
+			pos, next = len(b)-len(rest), next+1
+			continue
+		}
+		if eof {
+			return nil, fmt.Errorf("invalid data tile %v: %w", tile, err)
+		}
+		more, err := fetchRange(ctx, l.c.Backend, key, int64(len(b)), chunk)
+		if err != nil {
+			return nil, fmtErrorf("couldn't fetch data tile %v: %w", tile, err)
+		}
+		// A Backend might return the rest of the object if it can't be read
+		// partially, for example if it's compressed.
+		eof = int64(len(more)) != chunk
+		b = append(b, more...)
+		chunk *= 2
+	}
+}
@@ -0,0 +1,187 @@
+package ctlog
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+)
+
+// rateLimitClients is the number of recently seen clients tracked by the
+// submission rate limiter. Clients evicted from the LRU start again with a full
+// bucket, which is fine because an active abuser is never evicted.
+const rateLimitClients = 64 * 1024
+
+// rateLimiter is a token bucket rate limiter keyed by client address.
+type rateLimiter struct {
+	rate  float64 // tokens per second
+	burst float64
+
+	mu      sync.Mutex
+	buckets *lru.Cache[netip.Addr, *tokenBucket]
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take refills the bucket at rate tokens per second up to burst, and then
+// takes a token, if available. Otherwise, it returns how long it will take for
+// the next token to become available.
+func (b *tokenBucket) take(now time.Time, rate, burst float64) (ok bool, retryAfter time.Duration) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = min(burst, b.tokens+elapsed.Seconds()*rate)
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	buckets, err := lru.New[netip.Addr, *tokenBucket](rateLimitClients)
+	if err != nil {
+		panic(err) // only returned for a non-positive size
+	}
+	return &rateLimiter{rate: rate, burst: float64(max(burst, 1)), buckets: buckets}
+}
+
+// allow takes a token from the bucket of addr, if available. Otherwise, it
+// returns how long it will take for the next token to become available.
+func (rl *rateLimiter) allow(addr netip.Addr, now time.Time) (ok bool, retryAfter time.Duration) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	b, ok := rl.buckets.Get(addr)
+	if !ok {
+		b = &tokenBucket{tokens: rl.burst, last: now}
+		rl.buckets.Add(addr, b)
+	}
+	return b.take(now, rl.rate, rl.burst)
+}
+
+// Quota is a sustained rate of requests per second, with bursts of up to Burst
+// requests. Rate must be positive, and Burst is at least one.
+type Quota struct {
+	Rate  float64
+	Burst int
+}
+
+// issuerQuota is a token bucket shared by all submissions from an issuer, or
+// from all issuers without a specific quota.
+type issuerQuota struct {
+	rate, burst float64
+
+	mu     sync.Mutex
+	bucket tokenBucket
+}
+
+func newIssuerQuota(q Quota) *issuerQuota {
+	burst := float64(max(q.Burst, 1))
+	return &issuerQuota{rate: q.Rate, burst: burst, bucket: tokenBucket{tokens: burst}}
+}
+
+func (q *issuerQuota) allow(now time.Time) (ok bool, retryAfter time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.bucket.take(now, q.rate, q.burst)
+}
+
+// checkIssuerQuota enforces Config.IssuerQuotas and Config.DefaultIssuerQuota
+// for a submission from the issuer with the given key hash.
+func (l *Log) checkIssuerQuota(keyHash [32]byte) error {
+	q, ok := l.issuerQuotas[keyHash]
+	if !ok {
+		q = l.defaultIssuerQuota
+	}
+	if q == nil {
+		return nil
+	}
+	if ok, retryAfter := q.allow(time.Now()); !ok {
+		return retryAfterError{err: fmtErrorf("issuer quota exceeded"), after: retryAfter}
+	}
+	return nil
+}
+
+// retryAfterError is an error that is served with a Retry-After header.
+type retryAfterError struct {
+	err   error
+	after time.Duration
+}
+
+func (e retryAfterError) Error() string { return e.err.Error() }
+func (e retryAfterError) Unwrap() error { return e.err }
+
+func retryAfterSeconds(d time.Duration) string {
+	return fmt.Sprintf("%d", int(math.Ceil(d.Seconds())))
+}
+
+// rateLimit wraps a submission handler to enforce Config.RateLimit.
+func (l *Log) rateLimit(h http.HandlerFunc) http.HandlerFunc {
+	if l.limiter == nil {
+		return h
+	}
+	return func(rw http.ResponseWriter, r *http.Request) {
+		addr, err := clientIP(r, l.c.TrustedProxies)
+		if err != nil {
+			l.c.Log.WarnContext(r.Context(), "failed to determine client address", "err", err)
+			writeError(rw, http.StatusInternalServerError, reasonInternal, "failed to determine client address")
+			return
+		}
+		// IPv6 clients are limited by /64, since that's commonly what's
+		// allocated to a single client.
+		if addr.Is6() {
+			addr = netip.PrefixFrom(addr, 64).Masked().Addr()
+		}
+		if ok, retryAfter := l.limiter.allow(addr, time.Now()); !ok {
+			rw.Header().Set("Retry-After", retryAfterSeconds(retryAfter))
+			writeError(rw, http.StatusTooManyRequests, reasonRateLimited, "too many submissions from this address, please slow down")
+			return
+		}
+		h(rw, r)
+	}
+}
+
+// clientIP returns the address of the client that made r.
+//
+// If the peer address is in trusted, the X-Forwarded-For header is consulted,
+// and the right-most address not in trusted is used.
+func clientIP(r *http.Request, trusted []netip.Prefix) (netip.Addr, error) {
+	ap, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("invalid remote address %q: %w", r.RemoteAddr, err)
+	}
+	addr := ap.Addr().Unmap()
+	isTrusted := func(a netip.Addr) bool {
+		for _, p := range trusted {
+			if p.Contains(a) {
+				return true
+			}
+		}
+		return false
+	}
+	if isTrusted(addr) {
+		var hops []string
+		for _, h := range r.Header.Values("X-Forwarded-For") {
+			hops = append(hops, strings.Split(h, ",")...)
+		}
+		for i := len(hops) - 1; i >= 0; i-- {
+			a, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+			if err != nil {
+				break
+			}
+			addr = a.Unmap()
+			if !isTrusted(addr) {
+				break
+			}
+		}
+	}
+	return addr, nil
+}
@@ -0,0 +1,197 @@
+package ctlog
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"filippo.io/sunlight"
+	"golang.org/x/mod/sumdb/tlog"
+)
+
+// ErrInconsistentCheckpoint is returned, wrapped, by Mirror.Refresh if the
+// checkpoint in object storage is not an extension of the mirrored tree.
+var ErrInconsistentCheckpoint = errors.New("checkpoint is inconsistent with the mirrored tree")
+
+// Mirror is a read-only view of a log in object storage, which can be loaded
+// with just the public key, for example by auditors or standby replicas.
+//
+// It serves the tree from the last checkpoint it verified, and follows the
+// growth of the log with Refresh or Run. It has no pool and no sequencer.
+type Mirror struct {
+	// l only has c, logID, and state set, so that the read paths of Log can
+	// be reused. Its other methods must not be called.
+	l   *Log
+	key crypto.PublicKey
+}
+
+// MirrorOptions are optional settings for LoadMirror.
+type MirrorOptions struct {
+	// Log is used to log Run failures. If nil, slog.Default() is used.
+	Log *slog.Logger
+}
+
+// LoadMirror loads the log with the given name and RFC 6962 public key from
+// backend, verifying the checkpoint and the right edge tiles like LoadLog.
+// opts may be nil.
+//
+// Since the Ed25519 witness key is not known, only the RFC 6962 signature on
+// the checkpoint is verified.
+func LoadMirror(ctx context.Context, name string, pubKey crypto.PublicKey, backend Backend, opts *MirrorOptions) (*Mirror, error) {
+	pkix, err := x509.MarshalPKIXPublicKey(pubKey)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't marshal public key: %w", err)
+	}
+	logger := slog.Default()
+	if opts != nil && opts.Log != nil {
+		logger = opts.Log
+	}
+	c := &Config{Name: name, Backend: backend, Log: logger}
+	m := &Mirror{l: &Log{c: c, logID: sha256.Sum256(pkix)}, key: pubKey}
+	state, err := m.fetchState(ctx)
+	if err != nil {
+		return nil, err
+	}
+	m.l.state.Store(state)
+	return m, nil
+}
+
+// fetchState fetches and verifies the checkpoint and the right edge tiles.
+func (m *Mirror) fetchState(ctx context.Context) (*logState, error) {
+	sth, err := m.l.c.Backend.Fetch(ctx, "checkpoint")
+	if err != nil {
+		return nil, fmt.Errorf("couldn't fetch checkpoint from object storage: %w", err)
+	}
+	c, timestamp, err := openCheckpointWithKeys(m.l.c, m.key, nil, sth)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open checkpoint from object storage: %w", err)
+	}
+	edgeTiles, err := loadEdgeTiles(ctx, m.l.c, c.Tree)
+	if err != nil {
+		return nil, err
+	}
+	return &logState{tree: treeWithTimestamp{c.Tree, timestamp},
+		edgeTiles: edgeTiles, checkpoint: sth, published: sth}, nil
+}
+
+// Refresh fetches the checkpoint from object storage, and if it's newer than
+// the mirrored one, verifies that it's consistent with it and starts serving
+// its tree. If it's not consistent, Refresh returns ErrInconsistentCheckpoint
+// and keeps serving the old tree.
+//
+// Refresh can be called concurrently.
+func (m *Mirror) Refresh(ctx context.Context) error {
+	for {
+		old := m.l.state.Load()
+		state, err := m.fetchState(ctx)
+		if err != nil {
+			return err
+		}
+		switch {
+		case state.tree.N < old.tree.N:
+			return fmt.Errorf("%w: size %d is smaller than mirrored size %d",
+				ErrInconsistentCheckpoint, state.tree.N, old.tree.N)
+		case state.tree.N == old.tree.N && state.tree.Hash != old.tree.Hash:
+			return fmt.Errorf("%w: hash %v at size %d, mirrored hash is %v",
+				ErrInconsistentCheckpoint, state.tree.Hash, state.tree.N, old.tree.Hash)
+		case state.tree.Time <= old.tree.Time:
+			return nil
+		case state.tree.N > old.tree.N && old.tree.N > 0:
+			// The hashes are verified against the new tree by stateHashReader,
+			// so the proof checks that the old tree is a prefix of it.
+			proof, err := tlog.ProveTree(state.tree.N, old.tree.N, m.l.stateHashReader(ctx, state))
+			if err != nil {
+				return fmt.Errorf("couldn't compute consistency proof: %w", err)
+			}
+			if err := tlog.CheckTree(proof, state.tree.N, state.tree.Hash, old.tree.N, old.tree.Hash); err != nil {
+				return fmt.Errorf("%w: %w", ErrInconsistentCheckpoint, err)
+			}
+		}
+		// If a concurrent Refresh replaced old in the meantime, state was only
+		// checked against old, so start over from the newer mirrored tree.
+		if m.l.state.CompareAndSwap(old, state) {
+			return nil
+		}
+	}
+}
+
+// Run calls Refresh every period until ctx is cancelled, or until Refresh
+// returns ErrInconsistentCheckpoint, which Run returns. Other errors are
+// logged, and retried at the next period.
+func (m *Mirror) Run(ctx context.Context, period time.Duration) error {
+	t := time.NewTicker(period)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+		if err := m.Refresh(ctx); errors.Is(err, ErrInconsistentCheckpoint) {
+			m.l.c.Log.ErrorContext(ctx, "mirrored log is inconsistent", "err", err)
+			return err
+		} else if err != nil && ctx.Err() == nil {
+			m.l.c.Log.WarnContext(ctx, "failed to refresh mirrored log", "err", err)
+		}
+	}
+}
+
+// TreeSize returns the size of the mirrored tree.
+func (m *Mirror) TreeSize() int64 { return m.l.TreeSize() }
+
+// RootHash returns the root hash of the mirrored tree.
+func (m *Mirror) RootHash() tlog.Hash { return m.l.RootHash() }
+
+// CheckpointTime returns the timestamp of the mirrored checkpoint.
+func (m *Mirror) CheckpointTime() time.Time { return m.l.CheckpointTime() }
+
+// Checkpoint returns a copy of the mirrored checkpoint, as fetched from object
+// storage.
+func (m *Mirror) Checkpoint() []byte { return bytes.Clone(m.l.state.Load().published) }
+
+// Entries returns the entries from start (inclusive) to end (exclusive) of the
+// mirrored tree.
+func (m *Mirror) Entries(ctx context.Context, start, end int64) ([]*sunlight.LogEntry, error) {
+	return m.l.readEntries(ctx, m.l.state.Load(), start, end)
+}
+
+// InclusionProof returns the proof of inclusion of the entry at index in the
+// tree of size treeSize, which must not be larger than the mirrored tree.
+func (m *Mirror) InclusionProof(ctx context.Context, index, treeSize int64) (tlog.RecordProof, error) {
+	state := m.l.state.Load()
+	if index < 0 || index >= treeSize || treeSize > state.tree.N {
+		return nil, fmt.Errorf("invalid index %d and tree size %d for mirrored size %d",
+			index, treeSize, state.tree.N)
+	}
+	return tlog.ProveRecord(treeSize, index, m.l.proofHashReader(ctx, state))
+}
+
+// ConsistencyProof returns the proof that the tree of size first is a prefix
+// of the tree of size second, which must not be larger than the mirrored tree.
+func (m *Mirror) ConsistencyProof(ctx context.Context, first, second int64) (tlog.TreeProof, error) {
+	state := m.l.state.Load()
+	if first < 0 || first > second || second > state.tree.N {
+		return nil, fmt.Errorf("invalid sizes %d and %d for mirrored size %d",
+			first, second, state.tree.N)
+	}
+	if first == 0 || first == second {
+		return tlog.TreeProof{}, nil
+	}
+	return tlog.ProveTree(second, first, m.l.proofHashReader(ctx, state))
+}
+
+// ReadTile returns the contents of tile, which must be in the mirrored tree,
+// as a full tile or as the partial tile on its right edge.
+func (m *Mirror) ReadTile(ctx context.Context, tile tlog.Tile) ([]byte, error) {
+	state := m.l.state.Load()
+	if t, ok := state.edgeTiles[tile.L]; ok && t.Tile == tile {
+		return bytes.Clone(t.B), nil
+	}
+	return m.l.c.Backend.Fetch(ctx, sunlight.TilePath(tile))
+}
@@ -0,0 +1,239 @@
+package ctlog
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"strconv"
+	"strings"
+
+	"filippo.io/sunlight"
+	"golang.org/x/mod/sumdb/tlog"
+)
+
+// NewerTilesPolicy is what LoadLog does if it finds data tiles past the tree
+// size of the lock checkpoint.
+//
+// Tiles are uploaded only after their checkpoint is committed to the lock
+// backend, so newer tiles mean that a committed checkpoint was lost, for
+// example because the lock backend was restored from a backup. The entries in
+// them might have been returned to submitters with SCTs. Unless the policy is
+// NewerTilesFail, LoadLog also tolerates a checkpoint in object storage that is
+// newer than the lock checkpoint, for the same reason.
+//
+// Newer full data tiles are always detected. Newer partial data tiles, which
+// extend the edge data tile, are only detected if the Backend implements
+// ListDeleteBackend.
+type NewerTilesPolicy int
+
+const (
+	// NewerTilesFail makes LoadLog fail, for the operator to investigate.
+	NewerTilesFail NewerTilesPolicy = iota
+
+	// NewerTilesRollForward makes LoadLog check that the newer data tiles
+	// extend the tree of the lock checkpoint, regenerate the hash tiles of
+	// the larger tree, and commit and publish a checkpoint for it, with the
+	// current time. LoadLog fails if the newer tiles are inconsistent.
+	NewerTilesRollForward
+
+	// NewerTilesIgnore makes LoadLog delete the newer data tiles and the hash
+	// tiles derived from them, so that they are overwritten by the next
+	// rounds. Any SCTs for their entries will not be honored. If the Backend
+	// doesn't implement ListDeleteBackend, the tiles are left to be
+	// overwritten, which fails if the Backend supports CreateOnly uploads.
+	NewerTilesIgnore
+)
+
+func (p NewerTilesPolicy) String() string {
+	switch p {
+	case NewerTilesFail:
+		return "fail"
+	case NewerTilesRollForward:
+		return "roll-forward"
+	case NewerTilesIgnore:
+		return "ignore"
+	default:
+		return "NewerTilesPolicy(" + strconv.Itoa(int(p)) + ")"
+	}
+}
+
+// findNewerDataTiles returns the data tiles past tree, in order, starting with
+// the one that holds the leaf at index tree.N.
+func findNewerDataTiles(ctx context.Context, config *Config, tree tlog.Tree) ([]tileWithBytes, error) {
+	var tiles []tileWithBytes
+	for n := tree.N; ; {
+		k := n / sunlight.TileWidth
+		full := tlog.Tile{H: sunlight.TileHeight, L: -1, N: k, W: sunlight.TileWidth}
+		b, err := config.Backend.Fetch(ctx, sunlight.TilePath(full))
+		if err == nil {
+			tiles = append(tiles, tileWithBytes{full, b})
+			n = (k + 1) * sunlight.TileWidth
+			continue
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmtErrorf("couldn't fetch data tile %v: %w", full, err)
+		}
+
+		// Look for a partial data tile wider than the one for n, if any.
+		prefix := sunlight.TilePath(full) + ".p/"
+		keys, err := listObjects(ctx, config.Backend, prefix)
+		if errors.Is(err, errors.ErrUnsupported) {
+			return tiles, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		w := int(n - k*sunlight.TileWidth)
+		var widest int
+		for _, key := range keys {
+			if w, err := strconv.Atoi(strings.TrimPrefix(key, prefix)); err == nil &&
+				w > widest && w < sunlight.TileWidth {
+				widest = w
+			}
+		}
+		if widest > w {
+			partial := tlog.Tile{H: sunlight.TileHeight, L: -1, N: k, W: widest}
+			b, err := config.Backend.Fetch(ctx, sunlight.TilePath(partial))
+			if err != nil {
+				return nil, fmtErrorf("couldn't fetch data tile %v: %w", partial, err)
+			}
+			tiles = append(tiles, tileWithBytes{partial, b})
+		}
+		return tiles, nil
+	}
+}
+
+// newerTilesSize returns the size of the tree implied by the newer data tiles.
+func newerTilesSize(tiles []tileWithBytes) int64 {
+	last := tiles[len(tiles)-1]
+	return last.N*sunlight.TileWidth + int64(last.W)
+}
+
+// deleteNewerTiles deletes the data tiles past tree, and the hash tiles of the
+// larger tree they imply, for NewerTilesIgnore.
+func deleteNewerTiles(ctx context.Context, config *Config, tree tlog.Tree, dataTiles []tileWithBytes) error {
+	if _, ok := config.Backend.(ListDeleteBackend); !ok {
+		if supportsCreateOnly(config.Backend) {
+			return errors.New("can't ignore newer tiles: backend can't delete them, and they can't be overwritten")
+		}
+		config.Log.WarnContext(ctx, "backend can't delete newer tiles, leaving them to be overwritten")
+		return nil
+	}
+	var keys []string
+	for _, t := range dataTiles {
+		keys = append(keys, sunlight.TilePath(t.Tile))
+	}
+	for _, t := range tlog.NewTiles(sunlight.TileHeight, tree.N, newerTilesSize(dataTiles)) {
+		keys = append(keys, sunlight.TilePath(t))
+	}
+	for _, key := range keys {
+		if err := deleteObject(ctx, config.Backend, key); err != nil {
+			return err
+		}
+		config.Log.InfoContext(ctx, "deleted tile past the checkpoint", "key", key)
+	}
+	return nil
+}
+
+// rollForward commits and publishes a checkpoint for the tree extended with
+// the entries in dataTiles, for NewerTilesRollForward. It must be called by
+// LoadLog, before the Log is returned.
+//
+// published is the tree of the checkpoint in object storage. If it's past the
+// current tree, the larger tree must include it.
+func (l *Log) rollForward(ctx context.Context, dataTiles []tileWithBytes, published tlog.Tree) error {
+	oldSize := l.tree.N
+	edgeTiles := maps.Clone(l.edgeTiles)
+	newHashes := make(map[int64]tlog.Hash)
+	hashReader := l.hashReader(newHashes)
+	dataTileOpts, hashTileOpts := optsDataTile, optsHashTile
+	if supportsCreateOnly(l.c.Backend) {
+		dataTileOpts, hashTileOpts = optsDataTileCreateOnly, optsHashTileCreateOnly
+	}
+	var tileUploads []*uploadAction
+
+	n, timestamp := l.tree.N, l.tree.Time
+	for _, t := range dataTiles {
+		entries, err := parseDataTile(t.Tile, t.B)
+		if err != nil {
+			return err
+		}
+		start := t.N * sunlight.TileWidth
+		if n > start {
+			// The first tile extends the edge data tile of the tree.
+			if edge := l.edgeTiles[-1]; edge.N != t.N || !bytes.HasPrefix(t.B, edge.B) {
+				return fmt.Errorf("data tile %v doesn't extend the edge data tile %v", t.Tile, edge.Tile)
+			}
+		}
+		for _, e := range entries[n-start:] {
+			// Every round has a timestamp later than the previous checkpoint.
+			if e.Timestamp <= l.tree.Time || e.Timestamp < timestamp {
+				return fmt.Errorf("entry %d has timestamp %d, out of order", e.LeafIndex, e.Timestamp)
+			}
+			timestamp = e.Timestamp
+			hashes, err := tlog.StoredHashes(n, e.MerkleTreeLeaf(), hashReader)
+			if err != nil {
+				return fmtErrorf("couldn't compute new hashes for leaf %d: %w", n, err)
+			}
+			for i, h := range hashes {
+				newHashes[tlog.StoredHashIndex(0, n)+int64(i)] = h
+			}
+			n++
+		}
+		edgeTiles[-1] = t
+		opts := dataTileOpts
+		if t.W < sunlight.TileWidth {
+			opts = optsPartialDataTile
+		}
+		tileUploads = append(tileUploads, &uploadAction{sunlight.TilePath(t.Tile), t.B, opts})
+	}
+
+	for _, tile := range tlog.NewTiles(sunlight.TileHeight, oldSize, n) {
+		data, err := tlog.ReadTileData(tile, hashReader)
+		if err != nil {
+			return fmtErrorf("couldn't generate tile %v: %w", tile, err)
+		}
+		if t0, ok := edgeTiles[tile.L]; !ok || t0.N < tile.N || (t0.N == tile.N && t0.W < tile.W) {
+			edgeTiles[tile.L] = tileWithBytes{tile, data}
+		}
+		opts := hashTileOpts
+		if tile.W < sunlight.TileWidth {
+			opts = optsPartialHashTile
+		}
+		tileUploads = append(tileUploads, &uploadAction{sunlight.TilePath(tile), data, opts})
+	}
+
+	// The checkpoint must not be older than any of the entries.
+	tree, err := hashTreeHead(n, hashReader, max(timeNowUnixMilli(l.c), timestamp))
+	if err != nil {
+		return err
+	}
+	if published.N > oldSize {
+		if published.N > tree.N {
+			return fmt.Errorf("checkpoint in object storage has size %d, past the tiles size %d", published.N, tree.N)
+		}
+		h, err := tlog.TreeHash(published.N, hashReader)
+		if err != nil {
+			return fmtErrorf("couldn't compute tree hash at size %d: %w", published.N, err)
+		}
+		if h != published.Hash {
+			return fmt.Errorf("checkpoint in object storage is inconsistent with the tiles at size %d", published.N)
+		}
+	}
+	// Any existing hash tiles are checked against the regenerated ones by
+	// applyStagedUploads, if the Backend supports CreateOnly.
+	if err := l.commitTree(ctx, tree, edgeTiles, tileUploads); err != nil {
+		return err
+	}
+	if err := backfillLeafHashes(ctx, l.c, l.cacheWrite, tree.N); err != nil {
+		return fmt.Errorf("couldn't index leaf hashes: %w", err)
+	}
+	l.c.Log.WarnContext(ctx, "rolled forward to the tree implied by tiles past the checkpoint",
+		"old_tree_size", oldSize, "tree_size", tree.N, "timestamp", tree.Time)
+	l.m.TreeSize.Set(float64(tree.N))
+	l.m.TreeTime.Set(float64(tree.Time) / 1000)
+	return nil
+}
@@ -0,0 +1,142 @@
+package ctlog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Registry is a set of logs running in the same process, such as the temporal
+// shards of a log, keyed by Config.Name.
+//
+// The sequencers of the logs run together, but independently: a failing round
+// of one log, for example because of a backend outage, doesn't delay or stop
+// the rounds of the others.
+type Registry struct {
+	logs   []*Log
+	byName map[string]*Log
+}
+
+// LoadRegistry loads a log with LoadLog for each of configs.
+//
+// It fails if two configs have the same Name, or if any log fails to load, in
+// which case the logs loaded so far are closed.
+func LoadRegistry(ctx context.Context, configs []*Config) (r *Registry, err error) {
+	r = &Registry{byName: make(map[string]*Log)}
+	seen := make(map[string]bool)
+	for _, c := range configs {
+		if seen[c.Name] {
+			return nil, fmt.Errorf("duplicate log name %q", c.Name)
+		}
+		seen[c.Name] = true
+	}
+	defer func() {
+		if err != nil {
+			err = errors.Join(err, r.Close(ctx))
+			r = nil
+		}
+	}()
+	for _, c := range configs {
+		l, err := LoadLog(ctx, c)
+		if err != nil {
+			return r, fmt.Errorf("couldn't load log %q: %w", c.Name, err)
+		}
+		r.logs = append(r.logs, l)
+		r.byName[c.Name] = l
+	}
+	return r, nil
+}
+
+// Log returns the log with the given name, or nil if there is none.
+func (r *Registry) Log(name string) *Log {
+	return r.byName[name]
+}
+
+// Names returns the names of the logs, in the order of the configs passed to
+// LoadRegistry.
+func (r *Registry) Names() []string {
+	names := make([]string, 0, len(r.logs))
+	for _, l := range r.logs {
+		names = append(names, l.c.Name)
+	}
+	return names
+}
+
+// Logs returns the logs, in the order of the configs passed to LoadRegistry.
+func (r *Registry) Logs() []*Log {
+	return append([]*Log(nil), r.logs...)
+}
+
+// Metrics registers the metrics of all logs with reg, each with a "log" label
+// set to its name. It can be used instead of setting Config.Registerer.
+func (r *Registry) Metrics(reg prometheus.Registerer) error {
+	for _, l := range r.logs {
+		labeled := prometheus.WrapRegistererWith(prometheus.Labels{"log": l.c.Name}, reg)
+		if err := registerMetrics(labeled, l.Metrics()); err != nil {
+			return fmt.Errorf("couldn't register metrics of log %q: %w", l.c.Name, err)
+		}
+	}
+	return nil
+}
+
+// Handler returns an http.Handler that serves each log at its name, as a
+// schema-less URL. That is, requests for the host of the name with a path
+// under the path of the name are routed to the Handler of that log, with the
+// name path stripped. To serve the logs under other prefixes, use Server.
+func (r *Registry) Handler() http.Handler {
+	mux := http.NewServeMux()
+	for _, l := range r.logs {
+		host, path, _ := strings.Cut(l.c.Name, "/")
+		if path != "" {
+			path = "/" + path
+		}
+		mux.Handle(host+path+"/", http.StripPrefix(path, l.Handler()))
+	}
+	return mux
+}
+
+// Run runs the sequencers of all logs with RunSequencer until ctx is cancelled
+// or the logs are shut down.
+//
+// If the sequencer of a log fails fatally, that log stops sequencing, but the
+// others keep running. Run returns the fatal errors of all logs once every
+// sequencer has stopped.
+func (r *Registry) Run(ctx context.Context, period time.Duration) error {
+	errs := make([]error, len(r.logs))
+	var wg sync.WaitGroup
+	for i, l := range r.logs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := l.RunSequencer(ctx, period); err != nil &&
+				!errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) {
+				errs[i] = fmt.Errorf("sequencer for %q failed: %w", l.c.Name, err)
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// Close closes all logs concurrently with Log.Close, and returns their errors.
+func (r *Registry) Close(ctx context.Context) error {
+	errs := make([]error, len(r.logs))
+	var wg sync.WaitGroup
+	for i, l := range r.logs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := l.Close(ctx); err != nil {
+				errs[i] = fmt.Errorf("couldn't close log %q: %w", l.c.Name, err)
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
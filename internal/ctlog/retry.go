@@ -0,0 +1,175 @@
+package ctlog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"math/rand"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// RetryBackend is a Backend that retries failed Upload and Fetch calls to
+// another Backend, with exponential backoff and jitter.
+//
+// The fields must not be changed after the first call to Upload or Fetch.
+type RetryBackend struct {
+	b       Backend
+	retries *prometheus.CounterVec
+	log     *slog.Logger
+
+	// MaxAttempts is the maximum number of calls for each Upload or Fetch,
+	// including the first one. If zero, it defaults to 5.
+	MaxAttempts int
+
+	// BaseDelay is the delay before the first retry, doubled at each
+	// subsequent one up to MaxDelay. A random jitter of up to 50% is added.
+	// If zero, they default to 50ms and 2s.
+	BaseDelay, MaxDelay time.Duration
+
+	// Retryable reports whether a call that failed with err should be
+	// retried. If nil, all errors are retried except for those wrapping
+	// fs.ErrNotExist, ErrObjectExists, errors.ErrUnsupported,
+	// context.Canceled, or context.DeadlineExceeded.
+	Retryable func(err error) bool
+}
+
+func NewRetryBackend(b Backend, l *slog.Logger) *RetryBackend {
+	retries := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "backend_retries_total",
+			Help: "Backend calls retried after a transient error, by method.",
+		},
+		[]string{"method"},
+	)
+	return &RetryBackend{b: b, retries: retries, log: l}
+}
+
+var _ ListDeleteBackend = &RetryBackend{}
+var _ PingBackend = &RetryBackend{}
+var _ RangeBackend = &RetryBackend{}
+var _ CreateOnlyBackend = &RetryBackend{}
+var _ BatchBackend = &RetryBackend{}
+
+func defaultRetryable(err error) bool {
+	return !errors.Is(err, fs.ErrNotExist) &&
+		!errors.Is(err, ErrObjectExists) &&
+		!errors.Is(err, errors.ErrUnsupported) &&
+		!errors.Is(err, context.Canceled) &&
+		!errors.Is(err, context.DeadlineExceeded)
+}
+
+func (r *RetryBackend) do(ctx context.Context, method, key string, f func() error) error {
+	maxAttempts, delay, maxDelay := r.MaxAttempts, r.BaseDelay, r.MaxDelay
+	if maxAttempts <= 0 {
+		maxAttempts = 5
+	}
+	if delay <= 0 {
+		delay = 50 * time.Millisecond
+	}
+	if maxDelay <= 0 {
+		maxDelay = 2 * time.Second
+	}
+	retryable := r.Retryable
+	if retryable == nil {
+		retryable = defaultRetryable
+	}
+
+	var attempt int
+	var err error
+	for attempt = 1; ; attempt++ {
+		err = f()
+		if err == nil || !retryable(err) || attempt >= maxAttempts {
+			break
+		}
+		d := delay + time.Duration(rand.Int63n(int64(delay/2)+1))
+		// Don't wait for a retry that would start past the deadline.
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(d).After(deadline) {
+			break
+		}
+		r.log.DebugContext(ctx, "retrying backend call", "method", method,
+			"key", key, "attempt", attempt, "delay", d, "err", err)
+		r.retries.WithLabelValues(method).Inc()
+		t := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return fmtErrorf("retried backend call interrupted: %s %q after %d attempts: %w", method, key, attempt, err)
+		case <-t.C:
+		}
+		delay = min(delay*2, maxDelay)
+	}
+	if err != nil && attempt > 1 {
+		return fmtErrorf("retried backend call failed: %s %q after %d attempts: %w", method, key, attempt, err)
+	}
+	return err
+}
+
+func (r *RetryBackend) Upload(ctx context.Context, key string, data []byte, opts *UploadOptions) error {
+	return r.do(ctx, "upload", key, func() error {
+		return r.b.Upload(ctx, key, data, opts)
+	})
+}
+
+func (r *RetryBackend) BatchUpload(ctx context.Context, objects []Object) error {
+	return r.do(ctx, "batch_upload", fmt.Sprintf("%d objects", len(objects)), func() error {
+		return batchUpload(ctx, r.b, objects)
+	})
+}
+
+func (r *RetryBackend) Fetch(ctx context.Context, key string) ([]byte, error) {
+	var data []byte
+	err := r.do(ctx, "fetch", key, func() error {
+		var err error
+		data, err = r.b.Fetch(ctx, key)
+		return err
+	})
+	return data, err
+}
+
+func (r *RetryBackend) FetchRange(ctx context.Context, key string, offset, length int64) ([]byte, error) {
+	var data []byte
+	err := r.do(ctx, "fetch_range", key, func() error {
+		var err error
+		data, err = fetchRange(ctx, r.b, key, offset, length)
+		return err
+	})
+	return data, err
+}
+
+func (r *RetryBackend) SupportsRanges(key string) bool {
+	return supportsRanges(r.b, key)
+}
+
+func (r *RetryBackend) List(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	err := r.do(ctx, "list", prefix, func() error {
+		var err error
+		keys, err = listObjects(ctx, r.b, prefix)
+		return err
+	})
+	return keys, err
+}
+
+func (r *RetryBackend) Delete(ctx context.Context, key string) error {
+	return r.do(ctx, "delete", key, func() error {
+		return deleteObject(ctx, r.b, key)
+	})
+}
+
+// Ping is not retried, so that the probe reflects the current state of the
+// Backend.
+func (r *RetryBackend) Ping(ctx context.Context) error {
+	return pingBackend(ctx, r.b)
+}
+
+func (r *RetryBackend) SupportsCreateOnly() bool {
+	return supportsCreateOnly(r.b)
+}
+
+func (r *RetryBackend) Metrics() []prometheus.Collector {
+	return append([]prometheus.Collector{r.retries}, r.b.Metrics()...)
+}
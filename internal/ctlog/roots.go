@@ -0,0 +1,50 @@
+package ctlog
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+
+	"github.com/google/certificate-transparency-go/x509"
+	"github.com/google/certificate-transparency-go/x509util"
+)
+
+// SetRoots atomically replaces the accepted roots used by chain verification
+// and get-roots. Submissions that are already pooled are not affected.
+//
+// SetRoots returns an error, and leaves the roots unchanged, if roots is empty.
+func (l *Log) SetRoots(roots []*x509.Certificate) error {
+	if len(roots) == 0 {
+		return errors.New("refusing to set an empty roots pool")
+	}
+	pool := x509util.NewPEMCertPool()
+	for _, r := range roots {
+		pool.AddCert(r)
+	}
+
+	old := make(map[[32]byte]bool)
+	for _, r := range l.roots.Load().RawCertificates() {
+		old[sha256.Sum256(r.Raw)] = true
+	}
+	var added int
+	for _, r := range pool.RawCertificates() {
+		h := sha256.Sum256(r.Raw)
+		if !old[h] {
+			added++
+		}
+		delete(old, h)
+	}
+	removed := len(old)
+
+	// Cached chains are bound to the pool they were verified against, so
+	// swapping the pool invalidates them.
+	l.roots.Store(pool)
+
+	total := len(pool.RawCertificates())
+	l.m.ConfigRoots.Set(float64(total))
+	l.m.ConfigRootsChanges.WithLabelValues("added").Add(float64(added))
+	l.m.ConfigRootsChanges.WithLabelValues("removed").Add(float64(removed))
+	l.c.Log.InfoContext(context.Background(), "reloaded roots",
+		"added", added, "removed", removed, "total", total)
+	return nil
+}
@@ -7,14 +7,19 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log/slog"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/aws/retry"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
@@ -29,6 +34,20 @@ type S3Backend struct {
 	hedgeRequests prometheus.Counter
 	hedgeWins     prometheus.Counter
 	log           *slog.Logger
+
+	// ObjectLockRetention, if positive, causes objects uploaded with
+	// UploadOptions.Immutable to be protected with S3 Object Lock for that
+	// long, in ObjectLockMode, which defaults to GOVERNANCE. The bucket must
+	// have Object Lock enabled. These fields must not be changed after the
+	// first call to Upload.
+	ObjectLockRetention time.Duration
+	ObjectLockMode      types.ObjectLockMode
+
+	// ConditionalWrites, if true, causes uploads with UploadOptions.CreateOnly
+	// to be made with If-None-Match: *, so that they fail if the object
+	// already exists. The S3 service must support conditional writes. It must
+	// not be changed after the first call to Upload.
+	ConditionalWrites bool
 }
 
 func NewS3Backend(ctx context.Context, region, bucket, endpoint, keyPrefix string, l *slog.Logger) (*S3Backend, error) {
@@ -109,7 +128,14 @@ func NewS3Backend(ctx context.Context, region, bucket, endpoint, keyPrefix strin
 	}, nil
 }
 
-var _ Backend = &S3Backend{}
+var _ ListDeleteBackend = &S3Backend{}
+var _ PingBackend = &S3Backend{}
+var _ RangeBackend = &S3Backend{}
+var _ CreateOnlyBackend = &S3Backend{}
+
+func (s *S3Backend) SupportsCreateOnly() bool {
+	return s.ConditionalWrites
+}
 
 func (s *S3Backend) Upload(ctx context.Context, key string, data []byte, opts *UploadOptions) error {
 	start := time.Now()
@@ -132,8 +158,30 @@ func (s *S3Backend) Upload(ctx context.Context, key string, data []byte, opts *U
 		contentEncoding = aws.String("gzip")
 	}
 	var cacheControl *string
-	if opts != nil && opts.Immutable {
-		cacheControl = aws.String("public, max-age=604800, immutable")
+	if opts != nil && opts.CacheControl != "" {
+		cacheControl = aws.String(opts.CacheControl)
+	} else if opts != nil && opts.Immutable {
+		cacheControl = aws.String(cacheControlImmutable)
+	}
+	immutable := opts != nil && opts.Immutable
+	var lockMode types.ObjectLockMode
+	var lockUntil *time.Time
+	var checksum types.ChecksumAlgorithm
+	if immutable && s.ObjectLockRetention > 0 {
+		lockMode = s.ObjectLockMode
+		if lockMode == "" {
+			lockMode = types.ObjectLockModeGovernance
+		}
+		lockUntil = aws.Time(time.Now().Add(s.ObjectLockRetention))
+		// Object Lock requires an integrity checksum.
+		checksum = types.ChecksumAlgorithmSha256
+	}
+	var optFns []func(*s3.Options)
+	createOnly := opts != nil && opts.CreateOnly && s.ConditionalWrites
+	if createOnly {
+		optFns = append(optFns, func(o *s3.Options) {
+			o.APIOptions = append(o.APIOptions, smithyhttp.SetHeaderValue("If-None-Match", "*"))
+		})
 	}
 	putObject := func() (*s3.PutObjectOutput, error) {
 		return s.client.PutObject(ctx, &s3.PutObjectInput{
@@ -144,7 +192,11 @@ func (s *S3Backend) Upload(ctx context.Context, key string, data []byte, opts *U
 			ContentEncoding: contentEncoding,
 			ContentType:     contentType,
 			CacheControl:    cacheControl,
-		})
+
+			ObjectLockMode:            lockMode,
+			ObjectLockRetainUntilDate: lockUntil,
+			ChecksumAlgorithm:         checksum,
+		}, optFns...)
 	}
 	ctx, cancel := context.WithCancelCause(ctx)
 	hedgeErr := make(chan error, 1)
@@ -170,9 +222,16 @@ func (s *S3Backend) Upload(ctx context.Context, key string, data []byte, opts *U
 	}
 	s.log.DebugContext(ctx, "S3 PUT", "key", key, "size", len(data),
 		"compress", contentEncoding != nil, "type", *contentType,
-		"immutable", cacheControl != nil,
+		"immutable", immutable, "create_only", createOnly, "cache_control", aws.ToString(cacheControl),
 		"elapsed", time.Since(start), "err", err)
 	s.uploadSize.Observe(float64(len(data)))
+	// A hedged request might fail the precondition because the main one
+	// succeeded, or vice versa. The caller can tell by fetching the object.
+	var apiErr smithy.APIError
+	if createOnly && errors.As(err, &apiErr) && (apiErr.ErrorCode() == "PreconditionFailed" ||
+		apiErr.ErrorCode() == "ConditionalRequestConflict") {
+		return fmtErrorf("failed to upload %q to S3: %w (%w)", key, ErrObjectExists, err)
+	}
 	if err != nil {
 		return fmtErrorf("failed to upload %q to S3: %w", key, err)
 	}
@@ -186,6 +245,9 @@ func (s *S3Backend) Fetch(ctx context.Context, key string) ([]byte, error) {
 	})
 	if err != nil {
 		s.log.DebugContext(ctx, "S3 GET", "key", key, "err", err)
+		if nsk := new(types.NoSuchKey); errors.As(err, &nsk) {
+			return nil, fmtErrorf("failed to fetch %q from S3: %w (%w)", key, fs.ErrNotExist, err)
+		}
 		return nil, fmtErrorf("failed to fetch %q from S3: %w", key, err)
 	}
 	defer out.Body.Close()
@@ -205,6 +267,107 @@ func (s *S3Backend) Fetch(ctx context.Context, key string) ([]byte, error) {
 	return data, nil
 }
 
+// FetchRange fetches a range of the object. If the object is stored
+// compressed with Content-Encoding gzip, the range can't be decompressed, so
+// the whole object is fetched instead.
+func (s *S3Backend) FetchRange(ctx context.Context, key string, offset, length int64) ([]byte, error) {
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.keyPrefix + key),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)),
+	})
+	if err != nil {
+		s.log.DebugContext(ctx, "S3 GET range", "key", key, "err", err)
+		if nsk := new(types.NoSuchKey); errors.As(err, &nsk) {
+			return nil, fmtErrorf("failed to fetch %q from S3: %w (%w)", key, fs.ErrNotExist, err)
+		}
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) && apiErr.ErrorCode() == "InvalidRange" {
+			// The object ends before offset.
+			return []byte{}, nil
+		}
+		return nil, fmtErrorf("failed to fetch range of %q from S3: %w", key, err)
+	}
+	defer out.Body.Close()
+	s.log.DebugContext(ctx, "S3 GET range", "key", key,
+		"size", out.ContentLength, "encoding", out.ContentEncoding)
+	if out.ContentEncoding != nil && *out.ContentEncoding == "gzip" {
+		data, err := s.Fetch(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		return data[min(offset, int64(len(data))):], nil
+	}
+	data, err := io.ReadAll(out.Body)
+	if err != nil {
+		return nil, fmtErrorf("failed to read range of %q from S3: %w", key, err)
+	}
+	return data, nil
+}
+
+// SupportsRanges reports whether key is of a class that is never uploaded
+// with UploadOptions.Compress, since compressed objects are read whole.
+func (s *S3Backend) SupportsRanges(key string) bool {
+	class := keyClass(key)
+	return class != "data_tile" && class != "staging"
+}
+
+func (s *S3Backend) List(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	p := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(s.keyPrefix + prefix),
+	})
+	for p.HasMorePages() {
+		page, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, fmtErrorf("failed to list %q in S3: %w", prefix, err)
+		}
+		for _, obj := range page.Contents {
+			keys = append(keys, strings.TrimPrefix(aws.ToString(obj.Key), s.keyPrefix))
+		}
+	}
+	s.log.DebugContext(ctx, "S3 LIST", "prefix", prefix, "keys", len(keys))
+	return keys, nil
+}
+
+func (s *S3Backend) Delete(ctx context.Context, key string) error {
+	_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.keyPrefix + key),
+	})
+	s.log.DebugContext(ctx, "S3 DELETE", "key", key, "err", err)
+	if err != nil {
+		return fmtErrorf("failed to delete %q from S3: %w", key, err)
+	}
+	return nil
+}
+
+// Ping checks that the bucket exists and is accessible, and that an object
+// can be written and deleted under the health/ prefix.
+func (s *S3Backend) Ping(ctx context.Context) error {
+	if _, err := s.client.HeadBucket(ctx, &s3.HeadBucketInput{
+		Bucket: aws.String(s.bucket),
+	}); err != nil {
+		return fmtErrorf("failed to ping S3 bucket: %w", err)
+	}
+	key := s.keyPrefix + healthKey
+	if _, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+		Body:   strings.NewReader("ok"),
+	}); err != nil {
+		return fmtErrorf("failed to ping S3 bucket: %w", err)
+	}
+	if _, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	}); err != nil {
+		return fmtErrorf("failed to ping S3 bucket: %w", err)
+	}
+	return nil
+}
+
 func (s *S3Backend) Metrics() []prometheus.Collector {
 	return s.metrics
 }
@@ -0,0 +1,220 @@
+package ctlog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Server serves one or more logs over HTTP, each under its own path prefix,
+// and runs their sequencers.
+//
+// On shutdown, it stops accepting new submissions, waits for the pending ones
+// to be sequenced, shuts down and closes each log with Log.Close, and finally
+// shuts down the HTTP server.
+type Server struct {
+	// SequencePeriod is the interval between sequencing rounds.
+	// If zero, it defaults to one second.
+	SequencePeriod time.Duration
+
+	// ShutdownTimeout is the maximum time spent waiting for pending
+	// submissions and open connections on shutdown.
+	// If zero, it defaults to ten seconds.
+	ShutdownTimeout time.Duration
+
+	// HealthMaxFailures is the number of consecutive failed sequencing rounds
+	// of any log after which /healthz fails. If zero, it defaults to five. If
+	// negative, the check is disabled.
+	HealthMaxFailures int
+
+	// HealthMaxStaleness is the maximum age of the last successful sequencing
+	// round of any log before /healthz fails. If zero, it defaults to thirty
+	// seconds. If negative, the check is disabled.
+	HealthMaxStaleness time.Duration
+
+	// Log is used to log shutdown progress. If nil, slog.Default() is used.
+	Log *slog.Logger
+
+	logs []serverLog
+
+	// mu protects draining, and is held while adding to submissions, so that
+	// no submissions are added after draining is set.
+	mu          sync.Mutex
+	draining    bool
+	submissions sync.WaitGroup
+}
+
+type serverLog struct {
+	prefix string
+	log    *Log
+}
+
+// AddLog mounts the endpoints of l under prefix, which must not have a trailing
+// slash, like "/logs/2024h1". AddLog must be called before Handler and Serve.
+// Serve closes l before returning.
+func (s *Server) AddLog(prefix string, l *Log) {
+	s.logs = append(s.logs, serverLog{prefix, l})
+}
+
+// Handler returns an http.Handler that serves all the logs added with AddLog.
+//
+// It also serves /healthz and /readyz. /readyz fails until every log completed
+// a sequencing round, if the backend of any log fails its probe (see
+// PingBackend), and while shutting down. /healthz fails if the sequencer of any
+// log is failing or stuck, or if its backend fails its probe.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", s.healthz)
+	mux.HandleFunc("GET /readyz", s.readyz)
+	for _, sl := range s.logs {
+		mux.Handle(sl.prefix+"/", http.StripPrefix(sl.prefix, s.trackSubmissions(sl.log.Handler())))
+	}
+	return mux
+}
+
+// trackSubmissions rejects submissions while draining, and otherwise tracks
+// them in s.submissions until they are complete.
+func (s *Server) trackSubmissions(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			h.ServeHTTP(rw, r)
+			return
+		}
+		s.mu.Lock()
+		if s.draining {
+			s.mu.Unlock()
+			rw.Header().Set("Retry-After", shutdownRetryAfter())
+			writeError(rw, http.StatusServiceUnavailable, reasonShuttingDown, "server is shutting down")
+			return
+		}
+		s.submissions.Add(1)
+		s.mu.Unlock()
+		defer s.submissions.Done()
+		h.ServeHTTP(rw, r)
+	})
+}
+
+// shutdownRetryAfter returns a Retry-After value for submissions rejected while
+// shutting down, randomized so that clients don't all come back at once.
+func shutdownRetryAfter() string {
+	return fmt.Sprintf("%d", 30+rand.Intn(60))
+}
+
+// ListenAndServe listens on hs.Addr and calls Serve.
+func (s *Server) ListenAndServe(ctx context.Context, hs *http.Server) error {
+	addr := hs.Addr
+	if addr == "" {
+		addr = ":http"
+		if hs.TLSConfig != nil {
+			addr = ":https"
+		}
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ctx, hs, ln)
+}
+
+// Serve runs the sequencers of all logs, and serves hs on ln, using TLS if
+// hs.TLSConfig is set. hs.Handler is expected to route requests to Handler.
+//
+// When ctx is cancelled, Serve shuts down gracefully and returns nil. If a
+// sequencer fails fatally or hs stops serving, Serve shuts down gracefully
+// and returns the error.
+func (s *Server) Serve(ctx context.Context, hs *http.Server, ln net.Listener) error {
+	period := s.SequencePeriod
+	if period == 0 {
+		period = 1 * time.Second
+	}
+	shutdownTimeout := s.ShutdownTimeout
+	if shutdownTimeout == 0 {
+		shutdownTimeout = 10 * time.Second
+	}
+	logger := s.Log
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	// The sequencers keep running after ctx is cancelled, until the pending
+	// submissions are sequenced.
+	seqCtx, stopSequencers := context.WithCancel(context.WithoutCancel(ctx))
+	defer stopSequencers()
+	seqErr := make(chan error, len(s.logs))
+	var sequencers sync.WaitGroup
+	for _, sl := range s.logs {
+		sequencers.Add(1)
+		go func() {
+			defer sequencers.Done()
+			if err := sl.log.RunSequencer(seqCtx, period); err != nil &&
+				!errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) {
+				seqErr <- fmt.Errorf("sequencer for %q failed: %w", sl.prefix, err)
+			}
+		}()
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		if hs.TLSConfig != nil {
+			serveErr <- hs.ServeTLS(ln, "", "")
+		} else {
+			serveErr <- hs.Serve(ln)
+		}
+	}()
+
+	var err error
+	select {
+	case <-ctx.Done():
+	case err = <-seqErr:
+	case err = <-serveErr:
+	}
+	logger.InfoContext(ctx, "shutting down", "err", err)
+
+	shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), shutdownTimeout)
+	defer cancel()
+
+	s.mu.Lock()
+	s.draining = true
+	s.mu.Unlock()
+	pending := make(chan struct{})
+	go func() {
+		s.submissions.Wait()
+		close(pending)
+	}()
+	select {
+	case <-pending:
+		logger.InfoContext(shutdownCtx, "pending submissions completed")
+	case <-shutdownCtx.Done():
+		logger.WarnContext(shutdownCtx, "timed out waiting for pending submissions")
+	}
+
+	// Sequence whatever is left in the pools, such as submissions whose
+	// clients went away, stop the sequencers, and close the logs.
+	var logs sync.WaitGroup
+	for _, sl := range s.logs {
+		logs.Add(1)
+		go func() {
+			defer logs.Done()
+			if err := sl.log.Close(shutdownCtx); err != nil {
+				logger.WarnContext(shutdownCtx, "log shutdown error", "prefix", sl.prefix, "err", err)
+			}
+		}()
+	}
+	logs.Wait()
+	stopSequencers()
+	sequencers.Wait()
+
+	if shutdownErr := hs.Shutdown(shutdownCtx); shutdownErr != nil {
+		logger.WarnContext(shutdownCtx, "HTTP server shutdown error", "err", shutdownErr)
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		err = nil
+	}
+	return err
+}
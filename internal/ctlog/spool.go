@@ -0,0 +1,244 @@
+package ctlog
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// spool is an append-only file of the entries added to the current pool, so
+// that accepted submissions survive a crash and are sequenced by the next
+// LoadLog. See Config.Spool.
+//
+// Entries are appended to the file at path under poolMu, and synced outside
+// of it, so that concurrent submissions share syncs. When the current pool is
+// rotated for sequencing, the file is renamed to path+".sequencing", and it's
+// removed once the pool is sequenced.
+type spool struct {
+	path string
+	cur  *spoolFile
+}
+
+// spoolFile is one generation of the spool, holding the entries of one pool.
+type spoolFile struct {
+	mu   sync.Mutex
+	f    *os.File // nil once closed
+	name string
+}
+
+// openSpool opens the spool at path, and returns the entries left behind by a
+// previous process, if any, oldest first.
+//
+// The leftover entries of both generations are rewritten to a fresh file at
+// path, since they are going to be added to the first pool.
+func openSpool(path string) (*spool, []*PendingLogEntry, error) {
+	var entries []*PendingLogEntry
+	for _, name := range []string{path + ".sequencing", path} {
+		b, err := os.ReadFile(name)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("couldn't read spool: %w", err)
+		}
+		e, err := parseSpool(b)
+		if err != nil {
+			return nil, nil, fmt.Errorf("couldn't parse spool %q: %w", name, err)
+		}
+		entries = append(entries, e...)
+	}
+
+	var b []byte
+	for _, e := range entries {
+		b = appendSpoolRecord(b, e)
+	}
+	tmp, err := writeTemp("spool", path, b)
+	if err != nil {
+		os.Remove(tmp)
+		return nil, nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return nil, nil, fmt.Errorf("couldn't replace spool: %w", err)
+	}
+	if err := os.Remove(path + ".sequencing"); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, nil, fmt.Errorf("couldn't remove old spool: %w", err)
+	}
+	if err := syncDir(filepath.Dir(path)); err != nil {
+		return nil, nil, fmt.Errorf("couldn't sync spool directory: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return nil, nil, fmt.Errorf("couldn't open spool: %w", err)
+	}
+	return &spool{path: path, cur: &spoolFile{f: f, name: path}}, entries, nil
+}
+
+// append writes e to the current generation, and returns it so that the
+// caller can sync it. It must be called under poolMu.
+func (s *spool) append(e *PendingLogEntry) (*spoolFile, error) {
+	s.cur.mu.Lock()
+	defer s.cur.mu.Unlock()
+	if _, err := s.cur.f.Write(appendSpoolRecord(nil, e)); err != nil {
+		return nil, fmt.Errorf("couldn't write to spool: %w", err)
+	}
+	return s.cur, nil
+}
+
+// sync makes the entries appended to f durable. If f was already rotated, it
+// was synced before being closed.
+func (f *spoolFile) sync() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.f == nil {
+		return nil
+	}
+	return f.f.Sync()
+}
+
+// rotate syncs and closes the current generation, moves it out of the way, and
+// starts a new one. It must be called under poolMu, while rotating the pool.
+//
+// The returned generation must be passed to done once its pool is sequenced.
+func (s *spool) rotate() (*spoolFile, error) {
+	old := s.cur
+	old.mu.Lock()
+	defer old.mu.Unlock()
+	if err := old.f.Sync(); err != nil {
+		return nil, fmt.Errorf("couldn't sync spool: %w", err)
+	}
+	f, err := os.OpenFile(s.path+".next", os.O_WRONLY|os.O_APPEND|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create spool: %w", err)
+	}
+	// If the renames are lost to a crash, the old entries are replayed again
+	// by LoadLog, and deduplicated.
+	if err := os.Rename(s.path, s.path+".sequencing"); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("couldn't rotate spool: %w", err)
+	}
+	if err := os.Rename(s.path+".next", s.path); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("couldn't rotate spool: %w", err)
+	}
+	old.f.Close()
+	old.f, old.name = nil, s.path+".sequencing"
+	s.cur = &spoolFile{f: f, name: s.path}
+	return old, nil
+}
+
+// done removes a generation returned by rotate, once the entries of its pool
+// are either sequenced or rejected.
+func (s *spool) done(f *spoolFile) error {
+	if err := os.Remove(f.name); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("couldn't remove spool: %w", err)
+	}
+	return nil
+}
+
+func (s *spool) close() error {
+	s.cur.mu.Lock()
+	defer s.cur.mu.Unlock()
+	if s.cur.f == nil {
+		return nil
+	}
+	err := s.cur.f.Close()
+	s.cur.f = nil
+	return err
+}
+
+// appendSpoolRecord appends a record for e to b. Each record is the
+// big-endian uint32 length of the encoded entry, the entry, and the CRC-32C of
+// the entry, so that a torn write at the end of the file can be detected.
+func appendSpoolRecord(b []byte, e *PendingLogEntry) []byte {
+	c := &cryptobyte.Builder{}
+	c.AddUint8(boolToUint8(e.IsPrecert))
+	c.AddBytes(e.IssuerKeyHash[:])
+	c.AddUint24LengthPrefixed(func(c *cryptobyte.Builder) {
+		c.AddBytes(e.Certificate)
+	})
+	c.AddUint24LengthPrefixed(func(c *cryptobyte.Builder) {
+		c.AddBytes(e.PreCertificate)
+	})
+	c.AddUint24LengthPrefixed(func(c *cryptobyte.Builder) {
+		for _, issuer := range e.Issuers {
+			c.AddUint24LengthPrefixed(func(c *cryptobyte.Builder) {
+				c.AddBytes(issuer)
+			})
+		}
+	})
+	entry := c.BytesOrPanic()
+	b = binary.BigEndian.AppendUint32(b, uint32(len(entry)))
+	b = append(b, entry...)
+	return binary.BigEndian.AppendUint32(b, crc32.Checksum(entry, castagnoli))
+}
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+func boolToUint8(b bool) uint8 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// parseSpool parses the records in b. An incomplete or corrupted last record
+// is ignored, since it's the result of a crash in the middle of an append,
+// whose submission was never added to a pool.
+func parseSpool(b []byte) ([]*PendingLogEntry, error) {
+	var entries []*PendingLogEntry
+	for len(b) >= 4 {
+		n := int(binary.BigEndian.Uint32(b))
+		if len(b) < 4+n+4 {
+			break
+		}
+		entry, sum := b[4:4+n], binary.BigEndian.Uint32(b[4+n:])
+		b = b[4+n+4:]
+		if crc32.Checksum(entry, castagnoli) != sum {
+			if len(b) == 0 {
+				break
+			}
+			return nil, errors.New("corrupted record")
+		}
+		e, err := parseSpoolEntry(entry)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+func parseSpoolEntry(b []byte) (*PendingLogEntry, error) {
+	s := cryptobyte.String(b)
+	e := &PendingLogEntry{}
+	var precert uint8
+	var cert, preCert, issuers cryptobyte.String
+	if !s.ReadUint8(&precert) || precert > 1 ||
+		!s.CopyBytes(e.IssuerKeyHash[:]) ||
+		!s.ReadUint24LengthPrefixed(&cert) ||
+		!s.ReadUint24LengthPrefixed(&preCert) ||
+		!s.ReadUint24LengthPrefixed(&issuers) || !s.Empty() {
+		return nil, io.ErrUnexpectedEOF
+	}
+	e.IsPrecert = precert == 1
+	e.Certificate = cert
+	if len(preCert) > 0 {
+		e.PreCertificate = preCert
+	}
+	for !issuers.Empty() {
+		var issuer cryptobyte.String
+		if !issuers.ReadUint24LengthPrefixed(&issuer) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		e.Issuers = append(e.Issuers, issuer)
+	}
+	return e, nil
+}
@@ -0,0 +1,299 @@
+package ctlog
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"time"
+
+	"crawshaw.io/sqlite"
+	"crawshaw.io/sqlite/sqlitex"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// sqliteObjectConns is the number of connections to the SQLiteObjectBackend
+// database. Writes are serialized by SQLite, but reads can proceed in
+// parallel thanks to the WAL.
+const sqliteObjectConns = 8
+
+// SQLiteObjectBackend is a Backend that stores objects as rows of a single
+// SQLite database file, for tests, demos, and small private logs.
+//
+// Each Upload, and each BatchUpload, is a transaction. Concurrent writers wait
+// for each other for up to a busy timeout of ten seconds.
+//
+// It also provides a LockBackend that stores checkpoints in the same database,
+// so that a log can be stored in a single file.
+type SQLiteObjectBackend struct {
+	pool     *sqlitex.Pool
+	duration *prometheus.SummaryVec
+	log      *slog.Logger
+}
+
+// NewSQLiteObjectBackend opens the database at path, creating it if it doesn't
+// exist.
+func NewSQLiteObjectBackend(ctx context.Context, path string, l *slog.Logger) (*SQLiteObjectBackend, error) {
+	duration := prometheus.NewSummaryVec(
+		prometheus.SummaryOpts{
+			Name:       "sqlite_backend_request_duration_seconds",
+			Help:       "SQLite object backend request latencies, by method.",
+			Objectives: map[float64]float64{0.5: 0.05, 0.75: 0.025, 0.9: 0.01, 0.99: 0.001},
+			MaxAge:     1 * time.Minute,
+			AgeBuckets: 6,
+		},
+		[]string{"method"},
+	)
+
+	pool, err := sqlitex.Open(path, 0, sqliteObjectConns)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open SQLite object database: %w", err)
+	}
+	if err := initSQLiteObjects(ctx, pool); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("failed to initialize SQLite object database: %w", err)
+	}
+
+	return &SQLiteObjectBackend{pool: pool, duration: duration, log: l}, nil
+}
+
+// initSQLiteObjects configures every connection of pool, and creates the
+// tables if they don't exist.
+func initSQLiteObjects(ctx context.Context, pool *sqlitex.Pool) error {
+	conns := make([]*sqlite.Conn, 0, sqliteObjectConns)
+	defer func() {
+		for _, conn := range conns {
+			pool.Put(conn)
+		}
+	}()
+	for range sqliteObjectConns {
+		conn := pool.Get(ctx)
+		if conn == nil {
+			return context.Cause(ctx)
+		}
+		conns = append(conns, conn)
+		conn.SetBusyTimeout(10 * time.Second)
+		if err := sqlitex.ExecTransient(conn, "PRAGMA synchronous = FULL", nil); err != nil {
+			return err
+		}
+		if err := sqlitex.ExecTransient(conn, "PRAGMA fullfsync = TRUE", nil); err != nil {
+			return err
+		}
+	}
+	return sqlitex.ExecScript(conns[0], `
+		CREATE TABLE IF NOT EXISTS objects (
+			key TEXT PRIMARY KEY,
+			data BLOB NOT NULL,
+			created_at INTEGER NOT NULL
+		) WITHOUT ROWID;
+		CREATE TABLE IF NOT EXISTS checkpoints (
+			logID BLOB PRIMARY KEY,
+			body BLOB NOT NULL
+		) WITHOUT ROWID;`)
+}
+
+var _ ListDeleteBackend = &SQLiteObjectBackend{}
+var _ PingBackend = &SQLiteObjectBackend{}
+var _ RangeBackend = &SQLiteObjectBackend{}
+var _ BatchBackend = &SQLiteObjectBackend{}
+var _ CreateOnlyBackend = &SQLiteObjectBackend{}
+
+func (b *SQLiteObjectBackend) SupportsCreateOnly() bool { return true }
+
+// Close closes the database.
+func (b *SQLiteObjectBackend) Close() error {
+	return b.pool.Close()
+}
+
+// conn returns a connection from the pool, and a function to return it, which
+// also observes the duration of the request.
+func (b *SQLiteObjectBackend) conn(ctx context.Context, method string) (*sqlite.Conn, func(), error) {
+	start := time.Now()
+	conn := b.pool.Get(ctx)
+	if conn == nil {
+		return nil, nil, fmtErrorf("failed to get SQLite connection: %w", context.Cause(ctx))
+	}
+	return conn, func() {
+		b.pool.Put(conn)
+		b.duration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+	}, nil
+}
+
+func (b *SQLiteObjectBackend) Upload(ctx context.Context, key string, data []byte, opts *UploadOptions) error {
+	return b.BatchUpload(ctx, []Object{{Key: key, Data: data, Opts: opts}})
+}
+
+func (b *SQLiteObjectBackend) BatchUpload(ctx context.Context, objects []Object) (err error) {
+	conn, done, err := b.conn(ctx, "upload")
+	if err != nil {
+		return err
+	}
+	defer done()
+	defer sqlitex.Save(conn)(&err)
+	now := time.Now().UnixMilli()
+	for _, o := range objects {
+		query := `INSERT INTO objects (key, data, created_at) VALUES (?, ?, ?)
+			ON CONFLICT(key) DO UPDATE SET data = excluded.data`
+		if o.Opts != nil && o.Opts.CreateOnly {
+			query = `INSERT INTO objects (key, data, created_at) VALUES (?, ?, ?)
+				ON CONFLICT(key) DO NOTHING`
+		}
+		if err := sqlitex.Exec(conn, query, nil, o.Key, o.Data, now); err != nil {
+			return fmtErrorf("failed to upload %q to SQLite: %w", o.Key, err)
+		}
+		if conn.Changes() == 0 {
+			return fmtErrorf("failed to upload %q to SQLite: %w", o.Key, ErrObjectExists)
+		}
+		b.log.DebugContext(ctx, "sqlite upload", "key", o.Key, "size", len(o.Data))
+	}
+	return nil
+}
+
+func (b *SQLiteObjectBackend) Fetch(ctx context.Context, key string) ([]byte, error) {
+	return b.fetch(ctx, "fetch", key, "SELECT data FROM objects WHERE key = ?", key)
+}
+
+func (b *SQLiteObjectBackend) SupportsRanges(key string) bool { return true }
+
+func (b *SQLiteObjectBackend) FetchRange(ctx context.Context, key string, offset, length int64) ([]byte, error) {
+	// substr is 1-indexed, and returns an empty blob past the end.
+	return b.fetch(ctx, "fetch_range", key,
+		"SELECT substr(data, ?, ?) AS data FROM objects WHERE key = ?", offset+1, length, key)
+}
+
+func (b *SQLiteObjectBackend) fetch(ctx context.Context, method, key, query string, args ...any) ([]byte, error) {
+	conn, done, err := b.conn(ctx, method)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	var data []byte
+	err = sqlitex.Exec(conn, query, func(stmt *sqlite.Stmt) error {
+		data = make([]byte, stmt.GetLen("data"))
+		stmt.GetBytes("data", data)
+		return nil
+	}, args...)
+	if err != nil {
+		return nil, fmtErrorf("failed to fetch %q from SQLite: %w", key, err)
+	}
+	if data == nil {
+		return nil, fmtErrorf("failed to fetch %q from SQLite: %w", key, fs.ErrNotExist)
+	}
+	return data, nil
+}
+
+func (b *SQLiteObjectBackend) List(ctx context.Context, prefix string) ([]string, error) {
+	conn, done, err := b.conn(ctx, "list")
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	var keys []string
+	err = sqlitex.Exec(conn, "SELECT key FROM objects WHERE substr(key, 1, ?) = ? ORDER BY key",
+		func(stmt *sqlite.Stmt) error {
+			keys = append(keys, stmt.GetText("key"))
+			return nil
+		}, len(prefix), prefix)
+	if err != nil {
+		return nil, fmtErrorf("failed to list %q in SQLite: %w", prefix, err)
+	}
+	return keys, nil
+}
+
+func (b *SQLiteObjectBackend) Delete(ctx context.Context, key string) error {
+	conn, done, err := b.conn(ctx, "delete")
+	if err != nil {
+		return err
+	}
+	defer done()
+	if err := sqlitex.Exec(conn, "DELETE FROM objects WHERE key = ?", nil, key); err != nil {
+		return fmtErrorf("failed to delete %q from SQLite: %w", key, err)
+	}
+	return nil
+}
+
+// Ping checks that the database can be written, by taking and releasing the
+// write lock.
+func (b *SQLiteObjectBackend) Ping(ctx context.Context) error {
+	conn, done, err := b.conn(ctx, "ping")
+	if err != nil {
+		return err
+	}
+	defer done()
+	if err := sqlitex.ExecTransient(conn, "BEGIN IMMEDIATE", nil); err != nil {
+		return fmtErrorf("failed to ping SQLite: %w", err)
+	}
+	if err := sqlitex.ExecTransient(conn, "ROLLBACK", nil); err != nil {
+		return fmtErrorf("failed to ping SQLite: %w", err)
+	}
+	return nil
+}
+
+func (b *SQLiteObjectBackend) Metrics() []prometheus.Collector {
+	return []prometheus.Collector{b.duration}
+}
+
+// LockBackend returns a LockBackend that stores the checkpoints in the
+// database of b, replacing them with a conditional UPDATE.
+func (b *SQLiteObjectBackend) LockBackend() LockBackend {
+	return &sqliteObjectLock{b}
+}
+
+type sqliteObjectLock struct {
+	b *SQLiteObjectBackend
+}
+
+func (s *sqliteObjectLock) Fetch(ctx context.Context, logID [sha256.Size]byte) (LockedCheckpoint, error) {
+	body, err := s.b.fetch(ctx, "lock_fetch", "checkpoint",
+		"SELECT body AS data FROM checkpoints WHERE logID = ?", logID[:])
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, errors.New("checkpoint not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &sqliteCheckpoint{logID: logID, body: body}, nil
+}
+
+func (s *sqliteObjectLock) Replace(ctx context.Context, old LockedCheckpoint, new []byte) (LockedCheckpoint, error) {
+	conn, done, err := s.b.conn(ctx, "lock_replace")
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	o := old.(*sqliteCheckpoint)
+	err = sqlitex.Exec(conn, "UPDATE checkpoints SET body = ? WHERE logID = ? AND body = ?",
+		nil, new, o.logID[:], o.body)
+	if err != nil {
+		return nil, fmtErrorf("failed to update SQLite checkpoint: %w", err)
+	}
+	if conn.Changes() == 0 {
+		return nil, fmtErrorf("SQLite checkpoint not found or has changed")
+	}
+	return &sqliteCheckpoint{logID: o.logID, body: new}, nil
+}
+
+func (s *sqliteObjectLock) Create(ctx context.Context, logID [sha256.Size]byte, new []byte) error {
+	conn, done, err := s.b.conn(ctx, "lock_create")
+	if err != nil {
+		return err
+	}
+	defer done()
+	err = sqlitex.Exec(conn, `INSERT INTO checkpoints (logID, body) VALUES (?, ?)
+		ON CONFLICT(logID) DO NOTHING`, nil, logID[:], new)
+	if err != nil {
+		return fmt.Errorf("failed to create checkpoint: %w", err)
+	}
+	if conn.Changes() == 0 {
+		return errors.New("checkpoint already exists")
+	}
+	return nil
+}
+
+// Metrics returns nil, since the metrics of the database are collected by the
+// SQLiteObjectBackend.
+func (s *sqliteObjectLock) Metrics() []prometheus.Collector {
+	return nil
+}
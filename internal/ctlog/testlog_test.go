@@ -8,16 +8,26 @@ import (
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
+	stdx509 "crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
 	"encoding/pem"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log/slog"
+	"maps"
+	"math/big"
 	mathrand "math/rand"
 	"net/http"
 	"net/http/httptest"
 	"path/filepath"
 	"reflect"
+	"regexp"
+	"slices"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -65,6 +75,7 @@ func NewEmptyTestLog(t testing.TB) *TestLog {
 		Roots:         x509util.NewPEMCertPool(),
 		NotAfterStart: time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC),
 		NotAfterLimit: time.Date(2024, time.July, 1, 0, 0, 0, 0, time.UTC),
+		Clock:         monotonicTime,
 	}
 	root, err := x509.ParseCertificate(testRoot)
 	fatalIfErr(t, err)
@@ -131,7 +142,7 @@ func (tl *TestLog) CheckLog(size int64) (sthTimestamp int64) {
 
 	sth, err := tl.Config.Backend.Fetch(context.Background(), "checkpoint")
 	fatalIfErr(t, err)
-	v, err := sunlight.NewRFC6962Verifier("example.com/TestLog", tl.Config.Key.Public())
+	v, err := sunlight.NewRFC6962Verifier(tl.Config.Name, tl.Config.Key.Public())
 	fatalIfErr(t, err)
 	n, err := note.Open(sth, note.VerifierList(v))
 	fatalIfErr(t, err)
@@ -143,7 +154,7 @@ func (tl *TestLog) CheckLog(size int64) (sthTimestamp int64) {
 	c, err := sunlight.ParseCheckpoint(n.Text)
 	fatalIfErr(t, err)
 
-	if c.Origin != "example.com/TestLog" {
+	if c.Origin != tl.Config.Name {
 		t.Errorf("origin line is %q", c.Origin)
 	}
 	if c.Extension != "" {
@@ -155,7 +166,7 @@ func (tl *TestLog) CheckLog(size int64) (sthTimestamp int64) {
 		fatalIfErr(t, err)
 		sth, err := tl.Config.Lock.Fetch(context.Background(), logID)
 		fatalIfErr(t, err)
-		v, err := sunlight.NewRFC6962Verifier("example.com/TestLog", tl.Config.Key.Public())
+		v, err := sunlight.NewRFC6962Verifier(tl.Config.Name, tl.Config.Key.Public())
 		fatalIfErr(t, err)
 		n, err := note.Open(sth.Bytes(), note.VerifierList(v))
 		fatalIfErr(t, err)
@@ -273,6 +284,21 @@ func (tl *TestLog) CheckLog(size int64) (sthTimestamp int64) {
 	return
 }
 
+// Checkpoint returns the checkpoint currently in the backend.
+func (tl *TestLog) Checkpoint() sunlight.Checkpoint {
+	t := tl.t
+	t.Helper()
+	b, err := tl.Config.Backend.Fetch(context.Background(), "checkpoint")
+	fatalIfErr(t, err)
+	v, err := sunlight.NewRFC6962Verifier(tl.Config.Name, tl.Config.Key.Public())
+	fatalIfErr(t, err)
+	n, err := note.Open(b, note.VerifierList(v))
+	fatalIfErr(t, err)
+	c, err := sunlight.ParseCheckpoint(n.Text)
+	fatalIfErr(t, err)
+	return c
+}
+
 func logIDFromKey(key *ecdsa.PrivateKey) ([sha256.Size]byte, error) {
 	pkix, err := x509.MarshalPKIXPublicKey(key.Public())
 	if err != nil {
@@ -312,8 +338,13 @@ func (tl *TestLog) LogClient() *client.LogClient {
 
 func (tl *TestLog) StartSequencer() {
 	ctx, cancel := context.WithCancel(context.Background())
-	tl.t.Cleanup(cancel)
+	done := make(chan struct{})
+	tl.t.Cleanup(func() {
+		cancel()
+		<-done
+	})
 	go func() {
+		defer close(done)
 		err := tl.Log.RunSequencer(ctx, 50*time.Millisecond)
 		if err != context.Canceled {
 			tl.t.Errorf("RunSequencer returned an error: %v", err)
@@ -447,17 +478,89 @@ type MemoryBackend struct {
 
 	uploads uint64
 
+	// uploadCount and fetchCount are the calls to Upload and Fetch, by key.
+	uploadCount map[string]int
+	fetchCount  map[string]int
+
 	UploadCallback func(key string, data []byte) (apply bool, err error)
+
+	// CreateOnly makes the backend support UploadOptions.CreateOnly.
+	CreateOnly bool
+}
+
+func (b *MemoryBackend) SupportsCreateOnly() bool {
+	return b.CreateOnly
 }
 
 func NewMemoryBackend(t testing.TB) *MemoryBackend {
 	return &MemoryBackend{
 		t: t, m: make(map[string][]byte), imm: make(map[string]bool),
+		uploadCount: make(map[string]int), fetchCount: make(map[string]int),
 	}
 }
 
+// Keys returns the stored keys, sorted.
+func (b *MemoryBackend) Keys() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return slices.Sorted(maps.Keys(b.m))
+}
+
+// Uploads returns the number of Upload calls for key, including failed ones.
+func (b *MemoryBackend) Uploads(key string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.uploadCount[key]
+}
+
+// Fetches returns the number of Fetch calls for key, including failed ones.
+func (b *MemoryBackend) Fetches(key string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.fetchCount[key]
+}
+
+// Corrupt flips a bit in the first byte of the object at key, bypassing the
+// immutability checks of Upload.
+func (b *MemoryBackend) Corrupt(key string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	data := bytes.Clone(b.m[key])
+	data[0] ^= 1
+	b.m[key] = data
+}
+
+// MemoryBackendSnapshot is the stored state of a MemoryBackend.
+type MemoryBackendSnapshot struct {
+	m   map[string][]byte
+	imm map[string]bool
+}
+
+// Snapshot returns the current stored state, to be restored with Restore to
+// simulate a crash at an arbitrary point.
+func (b *MemoryBackend) Snapshot() *MemoryBackendSnapshot {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return &MemoryBackendSnapshot{m: maps.Clone(b.m), imm: maps.Clone(b.imm)}
+}
+
+// Restore replaces the stored state with a Snapshot. Call counts are kept.
+func (b *MemoryBackend) Restore(s *MemoryBackendSnapshot) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.m, b.imm = maps.Clone(s.m), maps.Clone(s.imm)
+}
+
+// isFullTile reports whether key is a full tile, which must never change.
+func isFullTile(key string) bool {
+	return strings.HasPrefix(key, "tile/") && !strings.Contains(key, ".p/")
+}
+
 func (b *MemoryBackend) Upload(ctx context.Context, key string, data []byte, opts *ctlog.UploadOptions) error {
 	atomic.AddUint64(&b.uploads, 1)
+	b.mu.Lock()
+	b.uploadCount[key]++
+	b.mu.Unlock()
 	// TODO: check key format is expected.
 	if len(data) == 0 {
 		b.t.Errorf("uploaded key %q with empty data", key)
@@ -475,11 +578,17 @@ func (b *MemoryBackend) Upload(ctx context.Context, key string, data []byte, opt
 	}
 	b.mu.Lock()
 	defer b.mu.Unlock()
+	if _, ok := b.m[key]; ok && b.CreateOnly && opts != nil && opts.CreateOnly {
+		return fmt.Errorf("%w: %q", ctlog.ErrObjectExists, key)
+	}
 	if b.imm[key] && !bytes.Equal(b.m[key], data) {
 		b.t.Errorf("immutable key %q was modified", key)
 	}
+	if old, ok := b.m[key]; ok && isFullTile(key) && !bytes.Equal(old, data) {
+		b.t.Errorf("full tile %q was overwritten with different contents", key)
+	}
 	b.m[key] = data
-	b.imm[key] = opts.Immutable
+	b.imm[key] = opts != nil && opts.Immutable
 	return finalErr
 }
 
@@ -545,15 +654,210 @@ func (b *MemoryBackend) Fetch(ctx context.Context, key string) ([]byte, error) {
 	}
 	b.mu.Lock()
 	defer b.mu.Unlock()
+	b.fetchCount[key]++
 	data, ok := b.m[key]
 	if !ok {
-		return nil, fmt.Errorf("key %q not found", key)
+		return nil, fmt.Errorf("key %q: %w", key, fs.ErrNotExist)
 	}
 	return data, nil
 }
 
+func (b *MemoryBackend) List(ctx context.Context, prefix string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var keys []string
+	for key := range b.m {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	return keys, nil
+}
+
+func (b *MemoryBackend) Delete(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.m, key)
+	delete(b.imm, key)
+	return nil
+}
+
 func (b *MemoryBackend) Metrics() []prometheus.Collector { return nil }
 
+// FaultBackend is a Backend wrapper that injects failures, delays, and panics
+// into Uploads, to test crash consistency. Uploads are counted from one, and
+// each fault is disabled when its field is zero or nil.
+//
+// BatchUpload uploads the objects sequentially, so that the upload boundaries
+// a fault can hit are deterministic, and panics happen in the calling
+// goroutine.
+type FaultBackend struct {
+	b ctlog.Backend
+
+	mu      sync.Mutex
+	uploads []string
+
+	fetches     []string
+	fetching    int
+	maxFetching int
+
+	// FailAt makes the FailAt-th Upload fail without persisting it.
+	FailAt int
+	// CrashAt makes the CrashAt-th Upload and all the following ones fail
+	// without persisting them, as if the process had died.
+	CrashAt int
+	// PanicAt makes the PanicAt-th Upload panic without persisting it.
+	PanicAt int
+	// FailKeys makes all Uploads of keys matching it fail without persisting
+	// them, or only the first FailKeysTimes if it's not zero.
+	FailKeys      *regexp.Regexp
+	FailKeysTimes int
+	// PersistKeys makes all Uploads of keys matching it fail after persisting
+	// them, like a timeout after commit.
+	PersistKeys *regexp.Regexp
+	// Delay is waited before every Upload and Fetch.
+	Delay time.Duration
+}
+
+func NewFaultBackend(b ctlog.Backend) *FaultBackend {
+	return &FaultBackend{b: b}
+}
+
+// Uploads returns the keys of all attempted Uploads, in order.
+func (f *FaultBackend) Uploads() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.uploads)
+}
+
+// Fetches returns the keys of all Fetches, in the order they started.
+func (f *FaultBackend) Fetches() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.fetches)
+}
+
+// MaxConcurrentFetches returns the highest number of Fetches that were in
+// flight at the same time.
+func (f *FaultBackend) MaxConcurrentFetches() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.maxFetching
+}
+
+// Heal disables all faults and resets the Upload count.
+func (f *FaultBackend) Heal() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.uploads = nil
+	f.FailAt, f.CrashAt, f.PanicAt, f.FailKeys, f.Delay = 0, 0, 0, nil, 0
+	f.FailKeysTimes, f.PersistKeys = 0, nil
+}
+
+var errInjected = errors.New("injected fault")
+
+func (f *FaultBackend) delay(ctx context.Context) error {
+	f.mu.Lock()
+	d := f.Delay
+	f.mu.Unlock()
+	if d == 0 {
+		return nil
+	}
+	select {
+	case <-time.After(d):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (f *FaultBackend) Upload(ctx context.Context, key string, data []byte, opts *ctlog.UploadOptions) error {
+	if err := f.delay(ctx); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	f.uploads = append(f.uploads, key)
+	n := len(f.uploads)
+	failAt, crashAt, panicAt := f.FailAt, f.CrashAt, f.PanicAt
+	failKey := f.FailKeys != nil && f.FailKeys.MatchString(key)
+	if failKey && f.FailKeysTimes > 0 {
+		f.FailKeysTimes--
+		if f.FailKeysTimes == 0 {
+			f.FailKeys = nil
+		}
+	}
+	persistKey := f.PersistKeys != nil && f.PersistKeys.MatchString(key)
+	f.mu.Unlock()
+	switch {
+	case n == panicAt:
+		panic(fmt.Sprintf("injected panic at upload %d (%q)", n, key))
+	case n == failAt:
+		return fmt.Errorf("%w: upload %d (%q)", errInjected, n, key)
+	case crashAt > 0 && n >= crashAt:
+		return fmt.Errorf("%w: crashed at upload %d (%q)", errInjected, crashAt, key)
+	case failKey:
+		return fmt.Errorf("%w: upload of %q", errInjected, key)
+	}
+	if err := f.b.Upload(ctx, key, data, opts); err != nil || !persistKey {
+		return err
+	}
+	return fmt.Errorf("%w: upload of %q after persisting it", errInjected, key)
+}
+
+func (f *FaultBackend) BatchUpload(ctx context.Context, objects []ctlog.Object) error {
+	for _, o := range objects {
+		if err := f.Upload(ctx, o.Key, o.Data, o.Opts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *FaultBackend) Fetch(ctx context.Context, key string) ([]byte, error) {
+	f.mu.Lock()
+	f.fetches = append(f.fetches, key)
+	f.fetching++
+	f.maxFetching = max(f.maxFetching, f.fetching)
+	f.mu.Unlock()
+	defer func() {
+		f.mu.Lock()
+		f.fetching--
+		f.mu.Unlock()
+	}()
+	if err := f.delay(ctx); err != nil {
+		return nil, err
+	}
+	return f.b.Fetch(ctx, key)
+}
+
+func (f *FaultBackend) Metrics() []prometheus.Collector { return f.b.Metrics() }
+
+// sequenceRecoveringPanic runs a sequencing round, and reports whether it
+// panicked.
+func sequenceRecoveringPanic(tl *TestLog) (panicked bool, err error) {
+	defer func() {
+		if recover() != nil {
+			panicked = true
+		}
+	}()
+	return false, tl.Log.Sequence()
+}
+
+// ReloadAfterFault heals f and loads a fresh Log from the state left behind by
+// the faults, as a restarted process would.
+func ReloadAfterFault(t testing.TB, tl *TestLog, f *FaultBackend) *TestLog {
+	t.Helper()
+	f.Heal()
+	return ReloadLog(t, tl)
+}
+
 type MemoryLockBackend struct {
 	t  testing.TB
 	mu sync.Mutex
@@ -631,6 +935,14 @@ func (b *MemoryLockBackend) Replace(ctx context.Context, old ctlog.LockedCheckpo
 	return &memoryLockCheckpoint{logID: oldc.logID, data: new}, finalErr
 }
 
+// Rollback replaces the checkpoint of logID with old, as if the checkpoints
+// committed since were lost, for example to a restore from backup.
+func (b *MemoryLockBackend) Rollback(logID [sha256.Size]byte, old ctlog.LockedCheckpoint) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.m[logID] = old.Bytes()
+}
+
 func failLockAndNotPersist(old ctlog.LockedCheckpoint, new []byte) (apply bool, err error) {
 	return false, errors.New("lock replace error")
 }
@@ -639,6 +951,282 @@ func failLockButPersist(old ctlog.LockedCheckpoint, new []byte) (apply bool, err
 	return true, errors.New("lock replace error")
 }
 
+type MemoryLeaseBackend struct {
+	mu     sync.Mutex
+	leases map[[sha256.Size]byte]ctlog.Lease
+}
+
+func NewMemoryLeaseBackend() *MemoryLeaseBackend {
+	return &MemoryLeaseBackend{leases: make(map[[sha256.Size]byte]ctlog.Lease)}
+}
+
+func (b *MemoryLeaseBackend) Acquire(ctx context.Context, logID [sha256.Size]byte, holder string, ttl time.Duration) (*ctlog.Lease, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	current := b.leases[logID]
+	if current.Holder != "" && time.Now().Before(current.Expiry) {
+		return nil, fmt.Errorf("%w: held by %s", ctlog.ErrLeaseHeld, current.Holder)
+	}
+	lease := ctlog.Lease{LogID: logID, Holder: holder, Token: current.Token + 1, Expiry: time.Now().Add(ttl)}
+	b.leases[logID] = lease
+	return &lease, nil
+}
+
+func (b *MemoryLeaseBackend) Renew(ctx context.Context, lease *ctlog.Lease, ttl time.Duration) (*ctlog.Lease, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if current := b.leases[lease.LogID]; current.Token != lease.Token {
+		return nil, fmt.Errorf("%w: acquired by %s", ctlog.ErrLeaseLost, current.Holder)
+	}
+	l := *lease
+	l.Expiry = time.Now().Add(ttl)
+	b.leases[lease.LogID] = l
+	return &l, nil
+}
+
+func (b *MemoryLeaseBackend) Release(ctx context.Context, lease *ctlog.Lease) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if current := b.leases[lease.LogID]; current.Token == lease.Token {
+		current.Holder = ""
+		b.leases[lease.LogID] = current
+	}
+	return nil
+}
+
+// Expire makes the current lease for logID, if any, expire immediately.
+func (b *MemoryLeaseBackend) Expire(logID [sha256.Size]byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	current := b.leases[logID]
+	current.Expiry = time.Time{}
+	b.leases[logID] = current
+}
+
+// testChain is a freshly generated chain of DER certificates, ending in a root
+// that was added to the log's accepted roots.
+// FakeWitness is a c2sp.org/tlog-witness server for the log of tl, which
+// verifies the log signature and the consistency proofs, and cosigns
+// checkpoints with a cosignature/v1 key.
+type FakeWitness struct {
+	Witness ctlog.Witness
+
+	// Hang makes requests block until they are canceled, and Fail makes them
+	// fail with a 500.
+	Hang, Fail atomic.Bool
+
+	t    testing.TB
+	key  ed25519.PrivateKey
+	v    note.Verifier
+	mu   sync.Mutex
+	tree tlog.Tree
+}
+
+func NewFakeWitness(t testing.TB, tl *TestLog, name string) *FakeWitness {
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	fatalIfErr(t, err)
+	vk, err := note.NewEd25519VerifierKey(tl.Config.Name, tl.Config.WitnessKey.Public().(ed25519.PublicKey))
+	fatalIfErr(t, err)
+	v, err := note.NewVerifier(vk)
+	fatalIfErr(t, err)
+	w := &FakeWitness{t: t, key: key, v: v}
+	srv := httptest.NewServer(http.HandlerFunc(w.addCheckpoint))
+	t.Cleanup(srv.Close)
+	w.Witness = ctlog.Witness{Name: name, Key: pub, URL: srv.URL}
+	return w
+}
+
+// Size returns the size of the latest checkpoint cosigned by the witness.
+func (w *FakeWitness) Size() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.tree.N
+}
+
+func (w *FakeWitness) addCheckpoint(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" || r.URL.Path != "/add-checkpoint" {
+		http.Error(rw, "not found", http.StatusNotFound)
+		return
+	}
+	// Read the body first, so that the server notices when the client
+	// gives up on a hanging request.
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if w.Hang.Load() {
+		<-r.Context().Done()
+		return
+	}
+	if w.Fail.Load() {
+		http.Error(rw, "witness is broken", http.StatusInternalServerError)
+		return
+	}
+	header, signed, ok := bytes.Cut(body, []byte("\n\n"))
+	if !ok {
+		http.Error(rw, "missing blank line", http.StatusBadRequest)
+		return
+	}
+	lines := strings.Split(string(header), "\n")
+	var old int64
+	if _, err := fmt.Sscanf(lines[0], "old %d", &old); err != nil {
+		http.Error(rw, "bad old line", http.StatusBadRequest)
+		return
+	}
+	var proof tlog.TreeProof
+	for _, line := range lines[1:] {
+		h, err := tlog.ParseHash(line)
+		if err != nil {
+			http.Error(rw, "bad proof line", http.StatusBadRequest)
+			return
+		}
+		proof = append(proof, h)
+	}
+	n, err := note.Open(signed, note.VerifierList(w.v))
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusForbidden)
+		return
+	}
+	c, err := sunlight.ParseCheckpoint(n.Text)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if old != w.tree.N {
+		rw.Header().Set("Content-Type", "text/x.tlog.size")
+		rw.WriteHeader(http.StatusConflict)
+		fmt.Fprintf(rw, "%d\n", w.tree.N)
+		return
+	}
+	switch {
+	case c.N < old:
+		http.Error(rw, "checkpoint is older than the latest", http.StatusBadRequest)
+		return
+	case c.N == old && c.Hash != w.tree.Hash && old > 0:
+		http.Error(rw, "checkpoint has a different hash", http.StatusConflict)
+		return
+	case c.N > old && old > 0:
+		if err := tlog.CheckTree(proof, c.N, c.Hash, old, w.tree.Hash); err != nil {
+			http.Error(rw, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+	}
+	w.tree = c.Tree
+
+	timestamp := uint64(time.Now().Unix())
+	msg := fmt.Appendf(nil, "cosignature/v1\ntime %d\n%s", timestamp, n.Text)
+	kh := sha256.Sum256(append([]byte(w.Witness.Name+"\n\x04"), w.Witness.Key...))
+	sig := append(kh[:4:4], make([]byte, 8)...)
+	binary.BigEndian.PutUint64(sig[4:], timestamp)
+	sig = append(sig, ed25519.Sign(w.key, msg)...)
+	fmt.Fprintf(rw, "— %s %s\n", w.Witness.Name, base64.StdEncoding.EncodeToString(sig))
+}
+
+type testChain struct {
+	// Leaf is a final certificate or a precertificate, depending on the
+	// argument of NewTestChain.
+	Leaf []byte
+	// Final is the final certificate matching a precertificate Leaf, with an
+	// SCT list extension and issued by Intermediate, or nil.
+	Final []byte
+	// PreIssuer is a Precertificate Signing Certificate, or nil.
+	PreIssuer    []byte
+	Intermediate []byte
+	Root         []byte
+}
+
+// Chain returns the chain in submission order.
+func (c *testChain) Chain() [][]byte {
+	chain := [][]byte{c.Leaf}
+	if c.PreIssuer != nil {
+		chain = append(chain, c.PreIssuer)
+	}
+	return append(chain, c.Intermediate, c.Root)
+}
+
+var (
+	oidPoison    = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 3}
+	oidPreIssuer = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 4}
+	oidSCTList   = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 2}
+)
+
+// NewTestChain generates a new PKI, adds its root to tl's accepted roots, and
+// returns a chain for a certificate valid for the test log's NotAfter range.
+func (tl *TestLog) NewTestChain(precert, preIssuer bool) *testChain {
+	t := tl.t
+	t.Helper()
+
+	serial := int64(0)
+	newCert := func(tmpl *stdx509.Certificate, parent *stdx509.Certificate,
+		parentKey *ecdsa.PrivateKey) (*stdx509.Certificate, *ecdsa.PrivateKey) {
+		t.Helper()
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		fatalIfErr(t, err)
+		serial++
+		tmpl.SerialNumber = big.NewInt(serial)
+		if parent == nil {
+			parent, parentKey = tmpl, key
+		}
+		der, err := stdx509.CreateCertificate(rand.Reader, tmpl, parent, key.Public(), parentKey)
+		fatalIfErr(t, err)
+		cert, err := stdx509.ParseCertificate(der)
+		fatalIfErr(t, err)
+		return cert, key
+	}
+	ca := func(name string) *stdx509.Certificate {
+		return &stdx509.Certificate{
+			Subject:               pkix.Name{CommonName: name},
+			NotBefore:             time.Date(2023, time.January, 1, 0, 0, 0, 0, time.UTC),
+			NotAfter:              time.Date(2030, time.January, 1, 0, 0, 0, 0, time.UTC),
+			BasicConstraintsValid: true,
+			IsCA:                  true,
+			KeyUsage:              stdx509.KeyUsageCertSign,
+		}
+	}
+
+	name := fmt.Sprintf("%x", mathrand.Int63())
+	root, rootKey := newCert(ca("Test Root "+name), nil, nil)
+	intermediate, intermediateKey := newCert(ca("Test Intermediate "+name), root, rootKey)
+	issuer, issuerKey := intermediate, intermediateKey
+	c := &testChain{Root: root.Raw, Intermediate: intermediate.Raw}
+	if preIssuer {
+		tmpl := ca("Test Precertificate Signing Certificate " + name)
+		tmpl.UnknownExtKeyUsage = []asn1.ObjectIdentifier{oidPreIssuer}
+		issuer, issuerKey = newCert(tmpl, intermediate, intermediateKey)
+		c.PreIssuer = issuer.Raw
+	}
+	leaf := &stdx509.Certificate{
+		Subject:     pkix.Name{CommonName: name + ".example.com"},
+		DNSNames:    []string{name + ".example.com"},
+		NotBefore:   time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:    time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC),
+		KeyUsage:    stdx509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []stdx509.ExtKeyUsage{stdx509.ExtKeyUsageServerAuth},
+	}
+	if precert {
+		leaf.ExtraExtensions = []pkix.Extension{{
+			Id: oidPoison, Critical: true, Value: asn1.NullBytes}}
+	}
+	l, _ := newCert(leaf, issuer, issuerKey)
+	c.Leaf = l.Raw
+	if precert {
+		// The SCT list contents don't matter, as logs remove it.
+		leaf.ExtraExtensions = []pkix.Extension{{Id: oidSCTList, Value: []byte{0x04, 0x02, 0x00, 0x00}}}
+		final, err := stdx509.CreateCertificate(rand.Reader, leaf, intermediate, l.PublicKey, intermediateKey)
+		fatalIfErr(t, err)
+		c.Final = final
+	}
+
+	r, err := x509.ParseCertificate(root.Raw)
+	fatalIfErr(t, err)
+	tl.Config.Roots.AddCert(r)
+	return c
+}
+
 func fatalIfErr(t testing.TB, err error) {
 	t.Helper()
 	if err != nil {
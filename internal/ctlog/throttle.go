@@ -0,0 +1,207 @@
+package ctlog
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sync/semaphore"
+)
+
+// ThrottleBudget limits the rate and concurrency of a class of Backend calls.
+type ThrottleBudget struct {
+	// Rate is the maximum number of calls per second, or zero for no limit.
+	// Up to Burst calls can be made at once after a period of inactivity. If
+	// Burst is zero, it defaults to Rate, rounded up. It's at least one.
+	Rate  float64
+	Burst int
+
+	// Concurrency is the maximum number of calls in flight, or zero for no
+	// limit.
+	Concurrency int64
+}
+
+// ThrottleBackend is a Backend that limits the calls to another Backend, to
+// stay below the request rates at which object storage starts throttling.
+//
+// Writes (Upload, BatchUpload, and Delete) and reads (Fetch, List, and Ping)
+// have separate budgets, so that a stampede of reads can't starve the
+// sequencer's uploads. Calls over budget wait for their turn, until their
+// context is done.
+type ThrottleBackend struct {
+	b      Backend
+	write  *throttle
+	read   *throttle
+	queued *prometheus.GaugeVec
+}
+
+func NewThrottleBackend(b Backend, write, read ThrottleBudget) *ThrottleBackend {
+	queued := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "backend_throttle_queued_calls",
+			Help: "Backend calls waiting for their throttling budget, by budget.",
+		},
+		[]string{"budget"},
+	)
+	return &ThrottleBackend{
+		b:      b,
+		write:  newThrottle(write, queued.WithLabelValues("write")),
+		read:   newThrottle(read, queued.WithLabelValues("read")),
+		queued: queued,
+	}
+}
+
+var _ ListDeleteBackend = &ThrottleBackend{}
+var _ PingBackend = &ThrottleBackend{}
+var _ RangeBackend = &ThrottleBackend{}
+var _ CreateOnlyBackend = &ThrottleBackend{}
+var _ BatchBackend = &ThrottleBackend{}
+
+// throttle enforces a ThrottleBudget.
+type throttle struct {
+	rate   float64
+	burst  float64
+	sem    *semaphore.Weighted
+	size   int64
+	queued prometheus.Gauge
+
+	mu     sync.Mutex
+	bucket tokenBucket
+}
+
+func newThrottle(b ThrottleBudget, queued prometheus.Gauge) *throttle {
+	t := &throttle{rate: b.Rate, burst: float64(b.Burst), size: b.Concurrency, queued: queued}
+	if t.burst == 0 {
+		t.burst = math.Ceil(b.Rate)
+	}
+	t.burst = max(t.burst, 1)
+	t.bucket.tokens = t.burst
+	t.bucket.last = time.Now()
+	if b.Concurrency > 0 {
+		t.sem = semaphore.NewWeighted(b.Concurrency)
+	}
+	return t
+}
+
+// acquire waits until n calls are allowed by the budget, and returns a function
+// to call when they are done. If ctx is done first, it returns ctx.Err().
+func (t *throttle) acquire(ctx context.Context, n int) (release func(), err error) {
+	t.queued.Inc()
+	defer t.queued.Dec()
+	for range n {
+		if err := t.wait(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if t.sem == nil {
+		return func() {}, nil
+	}
+	// A batch larger than the concurrency limit takes the whole budget.
+	weight := min(int64(n), t.size)
+	if err := t.sem.Acquire(ctx, weight); err != nil {
+		return nil, err
+	}
+	return func() { t.sem.Release(weight) }, nil
+}
+
+// wait takes a token from the rate limiting bucket, waiting for one to become
+// available if necessary.
+func (t *throttle) wait(ctx context.Context) error {
+	if t.rate <= 0 {
+		return nil
+	}
+	for {
+		t.mu.Lock()
+		ok, retryAfter := t.bucket.take(time.Now(), t.rate, t.burst)
+		t.mu.Unlock()
+		if ok {
+			return nil
+		}
+		timer := time.NewTimer(retryAfter)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (t *ThrottleBackend) Upload(ctx context.Context, key string, data []byte, opts *UploadOptions) error {
+	release, err := t.write.acquire(ctx, 1)
+	if err != nil {
+		return fmtErrorf("throttled upload of %q: %w", key, err)
+	}
+	defer release()
+	return t.b.Upload(ctx, key, data, opts)
+}
+
+// BatchUpload counts each object against the rate limit, and the whole batch
+// against the concurrency limit.
+func (t *ThrottleBackend) BatchUpload(ctx context.Context, objects []Object) error {
+	release, err := t.write.acquire(ctx, len(objects))
+	if err != nil {
+		return fmtErrorf("throttled batch upload: %w", err)
+	}
+	defer release()
+	return batchUpload(ctx, t.b, objects)
+}
+
+func (t *ThrottleBackend) Fetch(ctx context.Context, key string) ([]byte, error) {
+	release, err := t.read.acquire(ctx, 1)
+	if err != nil {
+		return nil, fmtErrorf("throttled fetch of %q: %w", key, err)
+	}
+	defer release()
+	return t.b.Fetch(ctx, key)
+}
+
+func (t *ThrottleBackend) FetchRange(ctx context.Context, key string, offset, length int64) ([]byte, error) {
+	release, err := t.read.acquire(ctx, 1)
+	if err != nil {
+		return nil, fmtErrorf("throttled fetch of %q: %w", key, err)
+	}
+	defer release()
+	return fetchRange(ctx, t.b, key, offset, length)
+}
+
+func (t *ThrottleBackend) SupportsRanges(key string) bool {
+	return supportsRanges(t.b, key)
+}
+
+func (t *ThrottleBackend) List(ctx context.Context, prefix string) ([]string, error) {
+	release, err := t.read.acquire(ctx, 1)
+	if err != nil {
+		return nil, fmtErrorf("throttled list of %q: %w", prefix, err)
+	}
+	defer release()
+	return listObjects(ctx, t.b, prefix)
+}
+
+func (t *ThrottleBackend) Delete(ctx context.Context, key string) error {
+	release, err := t.write.acquire(ctx, 1)
+	if err != nil {
+		return fmtErrorf("throttled delete of %q: %w", key, err)
+	}
+	defer release()
+	return deleteObject(ctx, t.b, key)
+}
+
+func (t *ThrottleBackend) Ping(ctx context.Context) error {
+	release, err := t.read.acquire(ctx, 1)
+	if err != nil {
+		return fmtErrorf("throttled ping: %w", err)
+	}
+	defer release()
+	return pingBackend(ctx, t.b)
+}
+
+func (t *ThrottleBackend) SupportsCreateOnly() bool {
+	return supportsCreateOnly(t.b)
+}
+
+func (t *ThrottleBackend) Metrics() []prometheus.Collector {
+	return append([]prometheus.Collector{t.queued}, t.b.Metrics()...)
+}
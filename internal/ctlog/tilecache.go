@@ -0,0 +1,171 @@
+package ctlog
+
+import (
+	"context"
+	"math"
+	"strings"
+	"sync/atomic"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sync/singleflight"
+)
+
+// TileCacheBackend is a Backend that keeps recently fetched full tiles of
+// another Backend in memory, up to a total size in bytes.
+//
+// Partial tiles, checkpoints, and any other keys are always fetched from the
+// underlying Backend, as they can change. Concurrent fetches of the same key
+// are coalesced into a single underlying Fetch.
+//
+// The returned tiles are shared, and must not be modified.
+type TileCacheBackend struct {
+	b         Backend
+	maxBytes  int64
+	bytes     atomic.Int64
+	cache     *lru.Cache[string, []byte]
+	group     singleflight.Group
+	fetches   *prometheus.CounterVec
+	sizeBytes prometheus.GaugeFunc
+}
+
+func NewTileCacheBackend(b Backend, maxBytes int64) *TileCacheBackend {
+	t := &TileCacheBackend{b: b, maxBytes: maxBytes}
+	cache, err := lru.NewWithEvict(math.MaxInt, func(key string, tile []byte) {
+		t.bytes.Add(-int64(len(tile)))
+	})
+	if err != nil {
+		panic(err) // only returned for a non-positive size
+	}
+	t.cache = cache
+	t.fetches = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "tile_cache_fetches_total",
+			Help: "Fetches of full tiles through the tile cache, by result (hit, miss, or shared).",
+		},
+		[]string{"result"},
+	)
+	t.sizeBytes = prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "tile_cache_size_bytes",
+			Help: "Total size of the full tiles in the tile cache.",
+		},
+		func() float64 { return float64(t.bytes.Load()) },
+	)
+	return t
+}
+
+var _ ListDeleteBackend = &TileCacheBackend{}
+var _ PingBackend = &TileCacheBackend{}
+var _ RangeBackend = &TileCacheBackend{}
+var _ CreateOnlyBackend = &TileCacheBackend{}
+var _ BatchBackend = &TileCacheBackend{}
+
+// isImmutableTile returns whether key is a full hash or data tile.
+func isImmutableTile(key string) bool {
+	return strings.HasPrefix(key, "tile/") && !strings.Contains(key, ".p/")
+}
+
+func (t *TileCacheBackend) Upload(ctx context.Context, key string, data []byte, opts *UploadOptions) error {
+	return t.b.Upload(ctx, key, data, opts)
+}
+
+func (t *TileCacheBackend) BatchUpload(ctx context.Context, objects []Object) error {
+	return batchUpload(ctx, t.b, objects)
+}
+
+func (t *TileCacheBackend) Fetch(ctx context.Context, key string) ([]byte, error) {
+	if !isImmutableTile(key) {
+		return t.b.Fetch(ctx, key)
+	}
+	if tile, ok := t.cache.Get(key); ok {
+		t.fetches.WithLabelValues("hit").Inc()
+		return tile, nil
+	}
+	// The underlying fetch uses the context of whichever caller started it.
+	v, err, shared := t.group.Do(key, func() (any, error) {
+		tile, err := t.b.Fetch(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		t.add(key, tile)
+		return tile, nil
+	})
+	if shared {
+		t.fetches.WithLabelValues("shared").Inc()
+	} else {
+		t.fetches.WithLabelValues("miss").Inc()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return v.([]byte), nil
+}
+
+// FetchRange serves the rest of the tile from offset from the cache, if the
+// tile is cached, and otherwise fetches only the range, without caching it.
+func (t *TileCacheBackend) FetchRange(ctx context.Context, key string, offset, length int64) ([]byte, error) {
+	if tile, ok := t.cache.Get(key); ok && isImmutableTile(key) {
+		t.fetches.WithLabelValues("hit").Inc()
+		return sliceFrom(tile, offset), nil
+	}
+	return fetchRange(ctx, t.b, key, offset, length)
+}
+
+func (t *TileCacheBackend) SupportsRanges(key string) bool {
+	return supportsRanges(t.b, key)
+}
+
+func (t *TileCacheBackend) add(key string, tile []byte) {
+	if int64(len(tile)) > t.maxBytes {
+		return
+	}
+	t.bytes.Add(int64(len(tile)))
+	if ok, _ := t.cache.ContainsOrAdd(key, tile); ok {
+		t.bytes.Add(-int64(len(tile)))
+		return
+	}
+	for t.bytes.Load() > t.maxBytes {
+		if _, _, ok := t.cache.RemoveOldest(); !ok {
+			break
+		}
+	}
+}
+
+func (t *TileCacheBackend) List(ctx context.Context, prefix string) ([]string, error) {
+	return listObjects(ctx, t.b, prefix)
+}
+
+func (t *TileCacheBackend) Delete(ctx context.Context, key string) error {
+	t.cache.Remove(key)
+	return deleteObject(ctx, t.b, key)
+}
+
+func (t *TileCacheBackend) Ping(ctx context.Context) error {
+	return pingBackend(ctx, t.b)
+}
+
+func (t *TileCacheBackend) SupportsCreateOnly() bool {
+	return supportsCreateOnly(t.b)
+}
+
+// A cacheInvalidator is a Backend that caches objects, and can be asked to
+// discard cached copies that failed verification.
+type cacheInvalidator interface {
+	Invalidate(keys ...string)
+}
+
+// Invalidate removes keys from the cache, and from the underlying Backend's
+// cache, if any.
+func (t *TileCacheBackend) Invalidate(keys ...string) {
+	for _, key := range keys {
+		t.cache.Remove(key)
+	}
+	if c, ok := t.b.(cacheInvalidator); ok {
+		c.Invalidate(keys...)
+	}
+}
+
+func (t *TileCacheBackend) Metrics() []prometheus.Collector {
+	return append([]prometheus.Collector{t.fetches, t.sizeBytes}, t.b.Metrics()...)
+}
@@ -0,0 +1,33 @@
+package ctlog
+
+import (
+	"fmt"
+	"time"
+)
+
+// roundTimestamp returns the timestamp of a round that extends a tree head
+// with timestamp treeTime, given the current time now, both in milliseconds
+// since the epoch. It's max(treeTime+1, now).
+//
+// The round timestamp is both the timestamp of the new tree head and that of
+// the SCTs of all the entries sequenced by the round, so that
+//
+//   - tree head timestamps are strictly increasing, including across restarts,
+//     since LoadLog loads treeTime from the lock checkpoint;
+//   - every SCT has the same timestamp as the first tree head that includes
+//     its entry;
+//   - every published tree head is at least as recent as every SCT issued so
+//     far, as required by RFC 6962, Section 3.5.
+//
+// If now is not after treeTime, for example because the clock was stepped
+// backwards, stepped is true. If now is more than maxSkew behind treeTime, an
+// error is returned instead, since the clock is more likely to be broken.
+func roundTimestamp(now, treeTime int64, maxSkew time.Duration) (timestamp int64, stepped bool, err error) {
+	if now > treeTime {
+		return now, false, nil
+	}
+	if treeTime-now > maxSkew.Milliseconds() {
+		return 0, false, fmt.Errorf("time did not progress! %d -> %d", treeTime, now)
+	}
+	return treeTime + 1, true, nil
+}
@@ -0,0 +1,189 @@
+package ctlog
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"strings"
+
+	"filippo.io/sunlight"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/mod/sumdb/tlog"
+)
+
+// ErrCorruptTile is returned, wrapped, by VerifyingBackend.Fetch for tiles
+// that are inconsistent with the tile they are checked against.
+var ErrCorruptTile = errors.New("corrupt tile")
+
+// VerifyingBackend is a Backend that checks fetched tiles for corruption.
+//
+// Data tiles are checked against the level 0 hash tile with the same index
+// and width, like LoadLog does for the edge tiles. Full hash tiles are checked
+// against the corresponding hash in the full tile one level up, if it exists.
+// Partial hash tiles are not checked, but they are only at the edge of the
+// tree, which is checked by LoadLog and kept in memory.
+//
+// These checks establish the consistency of the stored tiles with each other,
+// not with a tree head, and are designed to detect storage corruption. Each
+// checked Fetch causes an additional Fetch of the other tile, which should be
+// served from a cache, such as a TileCacheBackend.
+type VerifyingBackend struct {
+	b        Backend
+	failures *prometheus.CounterVec
+}
+
+func NewVerifyingBackend(b Backend) *VerifyingBackend {
+	return &VerifyingBackend{
+		b: b,
+		failures: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "tile_verification_failures_total",
+				Help: "Fetched tiles that failed verification, by key class.",
+			},
+			[]string{"class"},
+		),
+	}
+}
+
+var _ ListDeleteBackend = &VerifyingBackend{}
+var _ PingBackend = &VerifyingBackend{}
+var _ RangeBackend = &VerifyingBackend{}
+var _ CreateOnlyBackend = &VerifyingBackend{}
+var _ BatchBackend = &VerifyingBackend{}
+
+func (v *VerifyingBackend) Upload(ctx context.Context, key string, data []byte, opts *UploadOptions) error {
+	return v.b.Upload(ctx, key, data, opts)
+}
+
+func (v *VerifyingBackend) BatchUpload(ctx context.Context, objects []Object) error {
+	return batchUpload(ctx, v.b, objects)
+}
+
+func (v *VerifyingBackend) Fetch(ctx context.Context, key string) ([]byte, error) {
+	data, err := v.b.Fetch(ctx, key)
+	if err != nil || !strings.HasPrefix(key, "tile/") {
+		return data, err
+	}
+	tile, err := tlog.ParseTilePath("tile/8/" + strings.TrimPrefix(key, "tile/"))
+	if err != nil || tile.H != sunlight.TileHeight {
+		return data, nil
+	}
+	other, err := v.verify(ctx, tileWithBytes{tile, data})
+	if errors.Is(err, ErrCorruptTile) {
+		v.failures.WithLabelValues(keyClass(key)).Inc()
+		// Either tile might be the corrupt one, so drop both from any cache.
+		if c, ok := v.b.(cacheInvalidator); ok {
+			c.Invalidate(key, other)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// FetchRange fetches and verifies the whole tile, since a range can't be
+// verified, and returns it from offset.
+func (v *VerifyingBackend) FetchRange(ctx context.Context, key string, offset, length int64) ([]byte, error) {
+	data, err := v.Fetch(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return sliceFrom(data, offset), nil
+}
+
+// SupportsRanges returns false, since FetchRange always reads whole objects.
+func (v *VerifyingBackend) SupportsRanges(key string) bool {
+	return false
+}
+
+// verify checks t against another tile, and returns the key of the latter.
+func (v *VerifyingBackend) verify(ctx context.Context, t tileWithBytes) (string, error) {
+	if t.L < 0 {
+		hashTile := tileWithBytes{Tile: t.Tile}
+		hashTile.L = 0
+		var err error
+		hashTile.B, err = v.b.Fetch(ctx, hashTile.Path())
+		if err != nil {
+			return hashTile.Path(), fmtErrorf("couldn't fetch level 0 tile to verify %v: %w", t.Tile, err)
+		}
+		if len(hashTile.B) != hashTile.W*tlog.HashSize {
+			return hashTile.Path(), fmtErrorf("tile failed verification: %v has %d bytes: %w",
+				hashTile.Tile, len(hashTile.B), ErrCorruptTile)
+		}
+		if err := verifyDataTile(t, hashTile); err != nil {
+			return hashTile.Path(), fmtErrorf("tile failed verification: %w (%w)", ErrCorruptTile, err)
+		}
+		return hashTile.Path(), nil
+	}
+
+	if len(t.B) != t.W*tlog.HashSize {
+		return "", fmtErrorf("tile failed verification: %v has %d bytes: %w",
+			t.Tile, len(t.B), ErrCorruptTile)
+	}
+	if t.W != sunlight.TileWidth {
+		return "", nil
+	}
+	parent := tlog.Tile{H: t.H, L: t.L + 1, N: t.N / sunlight.TileWidth, W: sunlight.TileWidth}
+	parentKey := sunlight.TilePath(parent)
+	parentData, err := v.b.Fetch(ctx, parentKey)
+	if errors.Is(err, fs.ErrNotExist) {
+		// This tile is in the right-most group of its level.
+		return parentKey, nil
+	}
+	if err != nil {
+		return parentKey, fmtErrorf("couldn't fetch parent tile to verify %v: %w", t.Tile, err)
+	}
+	exp, err := tlog.HashFromTile(parent, parentData, tlog.StoredHashIndex(parent.L*parent.H, t.N))
+	if err != nil {
+		return parentKey, fmtErrorf("tile failed verification: %w (%w)", ErrCorruptTile, err)
+	}
+	if got := tileRootHash(t.B); got != exp {
+		return parentKey, fmtErrorf("tile failed verification: %v hashes to %v, parent hash is %v: %w",
+			t.Tile, got, exp, ErrCorruptTile)
+	}
+	return parentKey, nil
+}
+
+// tileRootHash returns the root of the perfect Merkle tree whose leaves are
+// the hashes in the full tile data.
+func tileRootHash(data []byte) tlog.Hash {
+	hashes := make([]tlog.Hash, len(data)/tlog.HashSize)
+	for i := range hashes {
+		copy(hashes[i][:], data[i*tlog.HashSize:])
+	}
+	for len(hashes) > 1 {
+		for i := range len(hashes) / 2 {
+			hashes[i] = tlog.NodeHash(hashes[2*i], hashes[2*i+1])
+		}
+		hashes = hashes[:len(hashes)/2]
+	}
+	return hashes[0]
+}
+
+func (v *VerifyingBackend) List(ctx context.Context, prefix string) ([]string, error) {
+	return listObjects(ctx, v.b, prefix)
+}
+
+func (v *VerifyingBackend) Delete(ctx context.Context, key string) error {
+	return deleteObject(ctx, v.b, key)
+}
+
+func (v *VerifyingBackend) Ping(ctx context.Context) error {
+	return pingBackend(ctx, v.b)
+}
+
+func (v *VerifyingBackend) SupportsCreateOnly() bool {
+	return supportsCreateOnly(v.b)
+}
+
+// Invalidate removes keys from the underlying Backend's cache, if any.
+func (v *VerifyingBackend) Invalidate(keys ...string) {
+	if c, ok := v.b.(cacheInvalidator); ok {
+		c.Invalidate(keys...)
+	}
+}
+
+func (v *VerifyingBackend) Metrics() []prometheus.Collector {
+	return append([]prometheus.Collector{v.failures}, v.b.Metrics()...)
+}
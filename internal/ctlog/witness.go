@@ -0,0 +1,221 @@
+package ctlog
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/mod/sumdb/tlog"
+)
+
+// Witness is a witness that cosigns the checkpoints of the log, through the
+// c2sp.org/tlog-witness protocol.
+type Witness struct {
+	// Name and Key are the name and Ed25519 public key of the witness
+	// cosignature/v1 key, per c2sp.org/tlog-cosignature.
+	Name string
+	Key  ed25519.PublicKey
+
+	// URL is the prefix of the witness API, to which "/add-checkpoint" is
+	// appended.
+	URL string
+}
+
+func witnessQuorum(c *Config) int {
+	if c.WitnessQuorum > 0 {
+		return min(c.WitnessQuorum, len(c.Witnesses))
+	}
+	return 1
+}
+
+func witnessTimeout(c *Config) time.Duration {
+	if c.WitnessTimeout > 0 {
+		return c.WitnessTimeout
+	}
+	return 5 * time.Second
+}
+
+// maxWitnessResponse is the maximum size of a witness response body.
+const maxWitnessResponse = 64 << 10
+
+// witnessClient submits checkpoints to a Witness.
+type witnessClient struct {
+	w Witness
+	// size is the size of the latest checkpoint of the log known to the
+	// witness, as far as we know. It starts at zero, and is corrected by the
+	// witness with a 409 Conflict response.
+	size int64
+}
+
+// errWitnessConflict is returned by addCheckpoint if the witness has a
+// different latest checkpoint size than the one in the request.
+type errWitnessConflict struct {
+	size int64
+}
+
+func (e errWitnessConflict) Error() string {
+	return fmt.Sprintf("witness has a checkpoint of size %d", e.size)
+}
+
+// cosign submits checkpoint, for tree, to the witness and returns the verified
+// cosignature line. r is used to compute the consistency proof from the latest
+// checkpoint known to the witness.
+func (wc *witnessClient) cosign(ctx context.Context, checkpoint []byte, tree tlog.Tree, r tlog.HashReader) ([]byte, error) {
+	// A mismatched size is corrected by the first response, so the second
+	// attempt can only conflict if the witness concurrently got a newer
+	// checkpoint from somewhere else.
+	for attempt := 0; ; attempt++ {
+		line, err := wc.addCheckpoint(ctx, checkpoint, tree, r)
+		if conflict := (errWitnessConflict{}); errors.As(err, &conflict) && attempt == 0 {
+			if conflict.size > tree.N {
+				return nil, fmtErrorf("witness has a checkpoint of size %d, larger than %d", conflict.size, tree.N)
+			}
+			wc.size = conflict.size
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		wc.size = tree.N
+		return line, nil
+	}
+}
+
+func (wc *witnessClient) addCheckpoint(ctx context.Context, checkpoint []byte, tree tlog.Tree, r tlog.HashReader) ([]byte, error) {
+	body := &bytes.Buffer{}
+	fmt.Fprintf(body, "old %d\n", wc.size)
+	if wc.size > 0 && wc.size < tree.N {
+		proof, err := tlog.ProveTree(tree.N, wc.size, r)
+		if err != nil {
+			return nil, fmtErrorf("couldn't compute consistency proof from size %d: %w", wc.size, err)
+		}
+		for _, h := range proof {
+			fmt.Fprintln(body, base64.StdEncoding.EncodeToString(h[:]))
+		}
+	}
+	body.WriteString("\n")
+	body.Write(checkpoint)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", strings.TrimSuffix(wc.w.URL, "/")+"/add-checkpoint", body)
+	if err != nil {
+		return nil, fmtErrorf("couldn't create witness request: %w", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmtErrorf("witness request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	rsp, err := io.ReadAll(io.LimitReader(resp.Body, maxWitnessResponse))
+	if err != nil {
+		return nil, fmtErrorf("couldn't read witness response: %w", err)
+	}
+	switch {
+	case resp.StatusCode == http.StatusConflict && resp.Header.Get("Content-Type") == "text/x.tlog.size":
+		size, err := strconv.ParseInt(strings.TrimSpace(string(rsp)), 10, 64)
+		if err != nil || size < 0 {
+			return nil, fmtErrorf("invalid witness conflict response %q", rsp)
+		}
+		return nil, errWitnessConflict{size}
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmtErrorf("witness returned %s: %q", resp.Status, bytes.TrimSpace(rsp))
+	}
+	return wc.findCosignature(checkpoint, rsp)
+}
+
+// findCosignature returns the line of rsp that is a valid cosignature of
+// checkpoint by the witness. Other lines are ignored.
+func (wc *witnessClient) findCosignature(checkpoint, rsp []byte) ([]byte, error) {
+	// The signed message includes the checkpoint text, up to the blank line.
+	i := bytes.Index(checkpoint, []byte("\n\n"))
+	if i < 0 {
+		return nil, fmtErrorf("malformed checkpoint")
+	}
+	text := checkpoint[:i+1]
+
+	// The key ID is the first four bytes of SHA-256(name || "\n" || 0x04 || key).
+	kh := sha256.Sum256(append([]byte(wc.w.Name+"\n\x04"), wc.w.Key...))
+	prefix := "— " + wc.w.Name + " "
+	for _, line := range strings.Split(string(rsp), "\n") {
+		sig, ok := strings.CutPrefix(line, prefix)
+		if !ok {
+			continue
+		}
+		sigBytes, err := base64.StdEncoding.DecodeString(sig)
+		if err != nil || len(sigBytes) != 4+8+ed25519.SignatureSize ||
+			!bytes.Equal(sigBytes[:4], kh[:4]) {
+			continue
+		}
+		timestamp := binary.BigEndian.Uint64(sigBytes[4:])
+		msg := fmt.Appendf(nil, "cosignature/v1\ntime %d\n%s", timestamp, text)
+		if !ed25519.Verify(wc.w.Key, msg, sigBytes[12:]) {
+			return nil, fmtErrorf("invalid cosignature from witness %q", wc.w.Name)
+		}
+		return []byte(prefix + sig + "\n"), nil
+	}
+	return nil, fmtErrorf("no cosignature from witness %q in response", wc.w.Name)
+}
+
+// cosignCheckpoint submits checkpoint to the configured witnesses, and returns
+// it with the cosignatures it collected within Config.WitnessTimeout appended.
+//
+// If fewer than the quorum of witnesses cosigned it, the failure is logged
+// and counted, and checkpoint is returned with the cosignatures it got, unless
+// Config.RequireWitnessQuorum is set, in which case an error is returned.
+func (l *Log) cosignCheckpoint(ctx context.Context, state *logState, checkpoint []byte) ([]byte, error) {
+	if len(l.witnesses) == 0 {
+		return checkpoint, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, witnessTimeout(l.c))
+	defer cancel()
+
+	// Witnesses reject the cosigning request if the consistency proof is
+	// invalid, so always compute it from verified tiles.
+	r := l.stateHashReader(ctx, state)
+	lines := make([][]byte, len(l.witnesses))
+	var wg sync.WaitGroup
+	for i, wc := range l.witnesses {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			line, err := wc.cosign(ctx, checkpoint, state.tree.Tree, r)
+			if err != nil {
+				l.c.Log.WarnContext(ctx, "failed to collect witness cosignature",
+					"witness", wc.w.Name, "err", err)
+				l.m.WitnessRequests.WithLabelValues(wc.w.Name, "error").Inc()
+				return
+			}
+			l.m.WitnessRequests.WithLabelValues(wc.w.Name, "ok").Inc()
+			lines[i] = line
+		}()
+	}
+	wg.Wait()
+
+	cosigned := bytes.Clone(checkpoint)
+	var n int
+	for _, line := range lines {
+		if line != nil {
+			cosigned = append(cosigned, line...)
+			n++
+		}
+	}
+	if quorum := witnessQuorum(l.c); n < quorum {
+		l.m.WitnessQuorumFailures.Inc()
+		if l.c.RequireWitnessQuorum {
+			return nil, fmtErrorf("got %d witness cosignatures, need %d", n, quorum)
+		}
+		l.c.Log.WarnContext(ctx, "publishing checkpoint without a witness quorum",
+			"cosignatures", n, "quorum", quorum)
+	}
+	return cosigned, nil
+}
@@ -0,0 +1,56 @@
+package ctlog
+
+import (
+	"bytes"
+	"context"
+	"errors"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// ErrObjectExists is wrapped by the errors returned by a CreateOnlyBackend
+// for uploads with UploadOptions.CreateOnly of objects that already exist.
+var ErrObjectExists = errors.New("object already exists")
+
+// errTileConflict is returned if a full tile already exists with different
+// contents, which means another instance is sequencing the log.
+var errTileConflict = errors.New("full tile already exists with different contents, another instance might be sequencing the log")
+
+// supportsCreateOnly reports whether b is a CreateOnlyBackend that supports
+// UploadOptions.CreateOnly.
+func supportsCreateOnly(b Backend) bool {
+	cb, ok := b.(CreateOnlyBackend)
+	return ok && cb.SupportsCreateOnly()
+}
+
+// uploadCheckingExisting uploads objects concurrently, like batchUpload and
+// within the same limit, but if a CreateOnly object already exists, it fetches
+// it and checks it has the same contents, failing with errTileConflict
+// otherwise.
+func uploadCheckingExisting(ctx context.Context, b Backend, objects []Object) error {
+	lim := uploadLimitFromContext(ctx)
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(lim.limit)
+	for _, o := range objects {
+		g.Go(func() error {
+			defer lim.track()()
+			err := b.Upload(gctx, o.Key, o.Data, o.Opts)
+			if !errors.Is(err, ErrObjectExists) || o.Opts == nil || !o.Opts.CreateOnly {
+				return err
+			}
+			// Don't compare against a cached copy.
+			if ci, ok := b.(cacheInvalidator); ok {
+				ci.Invalidate(o.Key)
+			}
+			existing, err := b.Fetch(gctx, o.Key)
+			if err != nil {
+				return fmtErrorf("couldn't fetch existing object %q: %w", o.Key, err)
+			}
+			if !bytes.Equal(existing, o.Data) {
+				return fmtErrorf("%w: %q", errTileConflict, o.Key)
+			}
+			return nil
+		})
+	}
+	return g.Wait()
+}
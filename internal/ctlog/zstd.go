@@ -0,0 +1,163 @@
+package ctlog
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// zstdMagic prefixes the objects stored compressed by CompressBackend. It
+// can't be confused with the start of a data tile, whose first byte is the
+// most significant byte of a millisecond timestamp, nor with a DER issuer or
+// a staging tar archive.
+var zstdMagic = []byte("\xffZS1")
+
+// compressibleClasses are the key classes (see keyClass) that CompressBackend
+// can compress. Hash tiles and checkpoints are excluded, since their consumers
+// expect their exact bytes.
+var compressibleClasses = []string{"data_tile", "staging", "issuer"}
+
+// CompressBackend is a Backend that zstd-compresses objects of selected key
+// classes before uploading them to another Backend, and decompresses them on
+// Fetch.
+//
+// Compressed objects start with a magic header, and objects without it are
+// returned unchanged, so compressed and uncompressed objects can coexist, for
+// example while migrating an existing log. Compressed objects of a class are
+// still decompressed after compression is disabled for it.
+//
+// If the tiles are served to clients directly from the underlying storage,
+// for example from a bucket through a CDN, data tiles must not be compressed,
+// as clients would receive the compressed bytes.
+type CompressBackend struct {
+	b       Backend
+	classes []string
+	enc     *zstd.Encoder
+	dec     *zstd.Decoder
+	ratio   prometheus.Summary
+}
+
+// NewCompressBackend returns a CompressBackend that compresses the objects of
+// the given key classes, which must be "data_tile", "staging", or "issuer".
+// If classes is empty, objects are only decompressed.
+func NewCompressBackend(b Backend, classes []string) (*CompressBackend, error) {
+	for _, class := range classes {
+		if !slices.Contains(compressibleClasses, class) {
+			return nil, fmt.Errorf("key class %q can't be compressed", class)
+		}
+	}
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create zstd encoder: %w", err)
+	}
+	dec, err := zstd.NewReader(nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create zstd decoder: %w", err)
+	}
+	return &CompressBackend{
+		b:       b,
+		classes: classes,
+		enc:     enc,
+		dec:     dec,
+		ratio: prometheus.NewSummary(
+			prometheus.SummaryOpts{
+				Name:       "zstd_compression_ratio",
+				Help:       "Ratio of compressed to uncompressed size of objects compressed with zstd.",
+				Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
+			},
+		),
+	}, nil
+}
+
+var _ ListDeleteBackend = &CompressBackend{}
+var _ PingBackend = &CompressBackend{}
+var _ RangeBackend = &CompressBackend{}
+var _ CreateOnlyBackend = &CompressBackend{}
+var _ BatchBackend = &CompressBackend{}
+
+func (c *CompressBackend) Upload(ctx context.Context, key string, data []byte, opts *UploadOptions) error {
+	o := c.compress(Object{Key: key, Data: data, Opts: opts})
+	return c.b.Upload(ctx, o.Key, o.Data, o.Opts)
+}
+
+func (c *CompressBackend) BatchUpload(ctx context.Context, objects []Object) error {
+	compressed := make([]Object, 0, len(objects))
+	for _, o := range objects {
+		compressed = append(compressed, c.compress(o))
+	}
+	return batchUpload(ctx, c.b, compressed)
+}
+
+// compress returns o compressed, if its key class is selected.
+func (c *CompressBackend) compress(o Object) Object {
+	if !slices.Contains(c.classes, keyClass(o.Key)) {
+		return o
+	}
+	compressed := c.enc.EncodeAll(o.Data, bytes.Clone(zstdMagic))
+	c.ratio.Observe(float64(len(compressed)) / float64(len(o.Data)))
+	// The object is already compressed, so don't ask the Backend to compress
+	// it again.
+	opts := UploadOptions{}
+	if o.Opts != nil {
+		opts = *o.Opts
+	}
+	opts.Compress = false
+	return Object{Key: o.Key, Data: compressed, Opts: &opts}
+}
+
+func (c *CompressBackend) Fetch(ctx context.Context, key string) ([]byte, error) {
+	data, err := c.b.Fetch(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(compressibleClasses, keyClass(key)) || !bytes.HasPrefix(data, zstdMagic) {
+		return data, nil
+	}
+	data, err = c.dec.DecodeAll(data[len(zstdMagic):], nil)
+	if err != nil {
+		return nil, fmtErrorf("failed to decompress %q: %w", key, err)
+	}
+	return data, nil
+}
+
+// FetchRange forwards the request for classes that are never compressed, and
+// otherwise fetches and decompresses the whole object, and returns it from
+// offset.
+func (c *CompressBackend) FetchRange(ctx context.Context, key string, offset, length int64) ([]byte, error) {
+	if !slices.Contains(compressibleClasses, keyClass(key)) {
+		return fetchRange(ctx, c.b, key, offset, length)
+	}
+	data, err := c.Fetch(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return sliceFrom(data, offset), nil
+}
+
+func (c *CompressBackend) SupportsRanges(key string) bool {
+	return !slices.Contains(compressibleClasses, keyClass(key)) && supportsRanges(c.b, key)
+}
+
+func (c *CompressBackend) List(ctx context.Context, prefix string) ([]string, error) {
+	return listObjects(ctx, c.b, prefix)
+}
+
+func (c *CompressBackend) Delete(ctx context.Context, key string) error {
+	return deleteObject(ctx, c.b, key)
+}
+
+func (c *CompressBackend) Ping(ctx context.Context) error {
+	return pingBackend(ctx, c.b)
+}
+
+func (c *CompressBackend) SupportsCreateOnly() bool {
+	return supportsCreateOnly(c.b)
+}
+
+func (c *CompressBackend) Metrics() []prometheus.Collector {
+	return append([]prometheus.Collector{c.ratio}, c.b.Metrics()...)
+}
@@ -0,0 +1,145 @@
+package sunlight
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+
+	"golang.org/x/crypto/cryptobyte"
+	cryptobyte_asn1 "golang.org/x/crypto/cryptobyte/asn1"
+)
+
+var (
+	oidExtensionAuthorityKeyId = asn1.ObjectIdentifier{2, 5, 29, 35}
+	oidExtensionSCTList        = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 2}
+)
+
+// TBSFromFinalCertificate returns the PreCert.tbs_certificate that a log
+// stores for the precertificate matching the final certificate final, which
+// can be compared with [LogEntry.Certificate] to check an embedded SCT.
+//
+// The SCT list extension, if present, is removed. If issuer is not nil, it
+// must be the certificate that issued final, and the issuer and authority key
+// identifier are set to its subject and subject key identifier, like
+// RFC 6962, Section 3.2 requires for a precertificate issued by a
+// Precertificate Signing Certificate. The rest of the TBSCertificate is
+// preserved byte for byte.
+func TBSFromFinalCertificate(final, issuer *x509.Certificate) ([]byte, error) {
+	input := cryptobyte.String(final.RawTBSCertificate)
+	var tbs cryptobyte.String
+	if !input.ReadASN1(&tbs, cryptobyte_asn1.SEQUENCE) || !input.Empty() {
+		return nil, errors.New("malformed TBSCertificate")
+	}
+
+	b := &cryptobyte.Builder{}
+	b.AddASN1(cryptobyte_asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+		// The issuer is the fourth field if the version is present (it
+		// always is for certificates with extensions), and the third if not.
+		issuerField := 2
+		for i := 0; !tbs.Empty(); i++ {
+			var field cryptobyte.String
+			var tag cryptobyte_asn1.Tag
+			if !tbs.ReadAnyASN1Element(&field, &tag) {
+				b.SetError(errors.New("malformed TBSCertificate"))
+				return
+			}
+			switch {
+			case i == 0 && tag == cryptobyte_asn1.Tag(0).Constructed().ContextSpecific():
+				issuerField = 3
+				b.AddBytes(field)
+			case i == issuerField && issuer != nil:
+				b.AddBytes(issuer.RawSubject)
+			case tag == cryptobyte_asn1.Tag(3).Constructed().ContextSpecific():
+				var explicit, exts cryptobyte.String
+				if !field.ReadASN1(&explicit, tag) || !field.Empty() ||
+					!explicit.ReadASN1(&exts, cryptobyte_asn1.SEQUENCE) || !explicit.Empty() {
+					b.SetError(errors.New("malformed TBSCertificate extensions"))
+					return
+				}
+				ext, err := precertExtensions(exts, issuer)
+				if err != nil {
+					b.SetError(err)
+					return
+				}
+				if len(ext) > 0 {
+					b.AddASN1(tag, func(b *cryptobyte.Builder) {
+						b.AddASN1(cryptobyte_asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+							b.AddBytes(ext)
+						})
+					})
+				}
+			default:
+				b.AddBytes(field)
+			}
+		}
+	})
+	return b.Bytes()
+}
+
+// precertExtensions returns the concatenated Extension elements of exts
+// without the SCT list, and with the authority key identifier replaced with the
+// subject key identifier of issuer, if not nil.
+func precertExtensions(exts cryptobyte.String, issuer *x509.Certificate) ([]byte, error) {
+	var out []byte
+	var seenSCTList, seenAKI bool
+	for !exts.Empty() {
+		var ext, extContent, critical cryptobyte.String
+		var id asn1.ObjectIdentifier
+		if !exts.ReadASN1Element(&ext, cryptobyte_asn1.SEQUENCE) {
+			return nil, errors.New("malformed TBSCertificate extensions")
+		}
+		if s := ext; !s.ReadASN1(&extContent, cryptobyte_asn1.SEQUENCE) ||
+			!extContent.ReadASN1ObjectIdentifier(&id) ||
+			extContent.PeekASN1Tag(cryptobyte_asn1.BOOLEAN) &&
+				!extContent.ReadASN1Element(&critical, cryptobyte_asn1.BOOLEAN) {
+			return nil, errors.New("malformed TBSCertificate extension")
+		}
+		switch {
+		case id.Equal(oidExtensionSCTList):
+			if seenSCTList {
+				return nil, errors.New("duplicate SCT list extension")
+			}
+			seenSCTList = true
+		case id.Equal(oidExtensionAuthorityKeyId) && issuer != nil:
+			seenAKI = true
+			if len(issuer.SubjectKeyId) == 0 {
+				continue
+			}
+			aki, err := authorityKeyIdExtension(issuer.SubjectKeyId, critical)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, aki...)
+		default:
+			out = append(out, ext...)
+		}
+	}
+	if issuer != nil && !seenAKI && len(issuer.SubjectKeyId) > 0 {
+		aki, err := authorityKeyIdExtension(issuer.SubjectKeyId, nil)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, aki...)
+	}
+	return out, nil
+}
+
+// authorityKeyIdExtension returns an authority key identifier Extension
+// with the given key identifier and, if not empty, critical element.
+func authorityKeyIdExtension(keyId []byte, critical []byte) ([]byte, error) {
+	b := &cryptobyte.Builder{}
+	b.AddASN1(cryptobyte_asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+		b.AddASN1ObjectIdentifier(oidExtensionAuthorityKeyId)
+		if len(critical) > 0 {
+			b.AddBytes(critical)
+		}
+		b.AddASN1(cryptobyte_asn1.OCTET_STRING, func(b *cryptobyte.Builder) {
+			b.AddASN1(cryptobyte_asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+				b.AddASN1(cryptobyte_asn1.Tag(0).ContextSpecific(), func(b *cryptobyte.Builder) {
+					b.AddBytes(keyId)
+				})
+			})
+		})
+	})
+	return b.Bytes()
+}